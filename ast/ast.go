@@ -3,6 +3,7 @@ package ast
 import (
 	"Hulk/token"
 	"bytes"
+	"fmt"
 	"strings"
 )
 
@@ -21,6 +22,31 @@ type Expression interface {
 	expressionNode()
 }
 
+// StatementLine returns the line a statement starts on, or 0 for anything
+// else. Lines are tracked per statement, which is as fine grained as the
+// disassembler and stack traces need to show where code came from.
+func StatementLine(node Node) int {
+	switch node := node.(type) {
+	case *LetStatement:
+		return node.Token.Line
+	case *ReturnStatement:
+		return node.Token.Line
+	case *ExpressionStatement:
+		return node.Token.Line
+	case *ThrowStatement:
+		return node.Token.Line
+	case *ImportStatement:
+		return node.Token.Line
+	case *ExportStatement:
+		return node.Token.Line
+	case *StructStatement:
+		return node.Token.Line
+	case *EnumStatement:
+		return node.Token.Line
+	}
+	return 0
+}
+
 type Program struct {
 	Statements []Statement
 }
@@ -45,6 +71,10 @@ type LetStatement struct {
 	Token token.Token //to see if its token.let only
 	Name  *Identifier //name of the variable
 	Value Expression  //value of expression that is qual to this variable for ex- let a=5+10; here 5+10 is an expression, a is for identifier, and token is for signifying token.let
+
+	//Pattern destructures the value, as in let [a, b] = arr; Name is nil
+	//then
+	Pattern Pattern
 }
 
 func (ls *LetStatement) statementNode() {}
@@ -56,8 +86,12 @@ func (ls *LetStatement) TokenLiteral() string {
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString(ls.TokenLiteral() + " ")
-	out.WriteString(ls.Name.Value)
-	out.WriteString("=")
+	if ls.Pattern != nil {
+		out.WriteString(ls.Pattern.String())
+	} else {
+		out.WriteString(ls.Name.Value)
+	}
+	out.WriteString(" = ")
 	if ls.Value != nil {
 		out.WriteString(ls.Value.String())
 	}
@@ -112,6 +146,7 @@ type Identifier struct {
 }
 
 func (i *Identifier) expressionNode() {}
+func (i *Identifier) patternNode()    {}
 
 func (i *Identifier) TokenLiteral() string {
 	return i.Token.Literal
@@ -251,7 +286,36 @@ func (bs *BlockStatement) String() string {
 type FunctionLiteral struct {
 	Token      token.Token
 	Parameters []*Identifier
-	Block      *BlockStatement
+	//Defaults holds the default value of each parameter, nil for the ones
+	//without; it is nil altogether when no parameter has one. Parameters
+	//with defaults come after the ones without.
+	Defaults []Expression
+	Rest     *Identifier //the ...rest parameter collecting extra arguments, if any
+	//Patterns holds the pattern each parameter is destructured with, nil
+	//for plain parameters; it is nil altogether when no parameter has one.
+	//A destructured parameter is named after its pattern, which no
+	//identifier in the source can be.
+	Patterns []Pattern
+	Block    *BlockStatement
+	Name     string //binding the literal is assigned to in a let statement, if any
+}
+
+// Pattern returns the pattern the i-th parameter is destructured with, nil
+// if it has none.
+func (fl *FunctionLiteral) Pattern(i int) Pattern {
+	if fl.Patterns == nil {
+		return nil
+	}
+	return fl.Patterns[i]
+}
+
+// Default returns the default value of the i-th parameter, nil if it has
+// none.
+func (fl *FunctionLiteral) Default(i int) Expression {
+	if fl.Defaults == nil {
+		return nil
+	}
+	return fl.Defaults[i]
 }
 
 func (fl *FunctionLiteral) TokenLiteral() string {
@@ -264,20 +328,29 @@ func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
 	out.WriteString(fl.TokenLiteral())
-
-	params := []string{}
-	for _, p := range fl.Parameters {
-		params = append(params, p.String())
-	}
-
-	out.WriteString("(")
-	out.WriteString(strings.Join(params, ", "))
-	out.WriteString(")")
+	out.WriteString(fl.signature(0))
 	out.WriteString(fl.Block.String())
 
 	return out.String()
 }
 
+// signature returns the parameter list of fl in parentheses, leaving out
+// the first skip parameters.
+func (fl *FunctionLiteral) signature(skip int) string {
+	params := []string{}
+	for i, p := range fl.Parameters[skip:] {
+		if def := fl.Default(skip + i); def != nil {
+			params = append(params, p.String()+" = "+def.String())
+		} else {
+			params = append(params, p.String())
+		}
+	}
+	if fl.Rest != nil {
+		params = append(params, "..."+fl.Rest.String())
+	}
+	return "(" + strings.Join(params, ", ") + ")"
+}
+
 type CallExpression struct {
 	Token     token.Token
 	Function  Expression
@@ -298,7 +371,7 @@ func (ce *CallExpression) String() string {
 	for _, a := range ce.Arguments {
 		args = append(args, a.String())
 	}
-	out.WriteString(ce.Function.TokenLiteral())
+	out.WriteString(ce.Function.String())
 	out.WriteString("(")
 	out.WriteString(strings.Join(args, ", "))
 	out.WriteString(")")
@@ -394,3 +467,478 @@ func (hl *HashLiteral) String() string {
 
 	return out.String()
 }
+
+type SliceExpression struct {
+	Token token.Token
+	Left  Expression
+	Start Expression //nil when omitted, as in arr[:2]
+	End   Expression //nil when omitted, as in arr[1:]
+	Step  Expression //nil when omitted, as in arr[1:3]
+}
+
+func (se *SliceExpression) expressionNode() {}
+
+func (se *SliceExpression) TokenLiteral() string {
+	return se.Token.Literal
+}
+
+func (se *SliceExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(se.Left.String())
+	out.WriteString("[")
+	if se.Start != nil {
+		out.WriteString(se.Start.String())
+	}
+	out.WriteString(":")
+	if se.End != nil {
+		out.WriteString(se.End.String())
+	}
+	if se.Step != nil {
+		out.WriteString(":")
+		out.WriteString(se.Step.String())
+	}
+	out.WriteString("])")
+
+	return out.String()
+}
+
+type ThrowStatement struct {
+	Token token.Token
+	Value Expression
+}
+
+func (ts *ThrowStatement) statementNode() {}
+
+func (ts *ThrowStatement) TokenLiteral() string {
+	return ts.Token.Literal
+}
+
+func (ts *ThrowStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString(ts.TokenLiteral() + " ")
+	if ts.Value != nil {
+		out.WriteString(ts.Value.String())
+	}
+	out.WriteString(";")
+	return out.String()
+}
+
+// ImportStatement binds Name to the namespace of the module in the file
+// Path names, as in import "lib/math.hk" as math;
+type ImportStatement struct {
+	Token token.Token
+	Path  string
+	Name  *Identifier
+}
+
+func (is *ImportStatement) statementNode() {}
+
+func (is *ImportStatement) TokenLiteral() string {
+	return is.Token.Literal
+}
+
+func (is *ImportStatement) String() string {
+	return fmt.Sprintf("%s %q as %s;", is.TokenLiteral(), is.Path, is.Name.Value)
+}
+
+// ExportStatement is a let, struct or enum statement at the top level of a
+// module whose names the modules importing it can read, as in
+// export let pi = 3; exactly one of Let, Struct and Enum is set.
+type ExportStatement struct {
+	Token  token.Token
+	Let    *LetStatement
+	Struct *StructStatement
+	Enum   *EnumStatement
+}
+
+func (es *ExportStatement) statementNode() {}
+
+func (es *ExportStatement) TokenLiteral() string {
+	return es.Token.Literal
+}
+
+func (es *ExportStatement) String() string {
+	if es.Struct != nil {
+		return es.TokenLiteral() + " " + es.Struct.String()
+	}
+	if es.Enum != nil {
+		return es.TokenLiteral() + " " + es.Enum.String()
+	}
+	return es.TokenLiteral() + " " + es.Let.String()
+}
+
+// Names returns the names the statement exports.
+func (es *ExportStatement) Names() []*Identifier {
+	if es.Struct != nil {
+		return []*Identifier{es.Struct.Name}
+	}
+	if es.Enum != nil {
+		return []*Identifier{es.Enum.Name}
+	}
+	if es.Let.Pattern != nil {
+		return PatternNames(es.Let.Pattern)
+	}
+	return []*Identifier{es.Let.Name}
+}
+
+// StructStatement declares a record type and binds Name to the function
+// constructing its instances, which takes the value of each field in order,
+// as in struct Point { x, y, fn norm() { self.x * self.x + self.y * self.y } }
+type StructStatement struct {
+	Token   token.Token
+	Name    *Identifier
+	Fields  []*Identifier
+	Methods []*StructMethod
+}
+
+// StructMethod is a function declared in a struct and called on one of its
+// instances. The parser adds the instance as a first parameter named self,
+// the receiver, which the source leaves implicit.
+type StructMethod struct {
+	Name     *Identifier
+	Function *FunctionLiteral
+}
+
+func (ss *StructStatement) statementNode() {}
+
+func (ss *StructStatement) TokenLiteral() string {
+	return ss.Token.Literal
+}
+
+func (ss *StructStatement) String() string {
+	members := []string{}
+	for _, f := range ss.Fields {
+		members = append(members, f.String())
+	}
+	for _, m := range ss.Methods {
+		members = append(members, "fn "+m.Name.String()+m.Function.signature(1)+m.Function.Block.String())
+	}
+	if len(members) == 0 {
+		return fmt.Sprintf("%s %s {}", ss.TokenLiteral(), ss.Name)
+	}
+	return fmt.Sprintf("%s %s { %s }", ss.TokenLiteral(), ss.Name, strings.Join(members, ", "))
+}
+
+// EnumStatement declares a tagged union and binds Name to it, as in
+// enum Shape { Circle(r), Rect(w, h), Empty }. Its variants are read as
+// members of the name: Shape.Circle(2), Shape.Empty.
+type EnumStatement struct {
+	Token    token.Token
+	Name     *Identifier
+	Variants []*EnumVariant
+}
+
+// EnumVariant is one variant of an enum and the names of the fields its
+// values carry, none for a variant written without parentheses.
+type EnumVariant struct {
+	Name   *Identifier
+	Fields []*Identifier
+}
+
+func (es *EnumStatement) statementNode() {}
+
+func (es *EnumStatement) TokenLiteral() string {
+	return es.Token.Literal
+}
+
+func (es *EnumStatement) String() string {
+	variants := make([]string, len(es.Variants))
+	for i, v := range es.Variants {
+		variants[i] = v.String()
+	}
+	if len(variants) == 0 {
+		return fmt.Sprintf("%s %s {}", es.TokenLiteral(), es.Name)
+	}
+	return fmt.Sprintf("%s %s { %s }", es.TokenLiteral(), es.Name, strings.Join(variants, ", "))
+}
+
+func (ev *EnumVariant) String() string {
+	if len(ev.Fields) == 0 {
+		return ev.Name.String()
+	}
+	fields := make([]string, len(ev.Fields))
+	for i, f := range ev.Fields {
+		fields[i] = f.String()
+	}
+	return ev.Name.String() + "(" + strings.Join(fields, ", ") + ")"
+}
+
+// MemberExpression reads a field of a struct instance or one of its
+// methods, as in p.x; a method read this way is bound to the instance.
+type MemberExpression struct {
+	Token  token.Token //the . token
+	Object Expression
+	Member *Identifier
+}
+
+func (me *MemberExpression) expressionNode() {}
+
+func (me *MemberExpression) TokenLiteral() string {
+	return me.Token.Literal
+}
+
+func (me *MemberExpression) String() string {
+	return "(" + me.Object.String() + "." + me.Member.String() + ")"
+}
+
+// AssignExpression sets a field of a struct instance, as in p.x = 3, and
+// evaluates to the value assigned. It only appears as the expression of an
+// expression statement.
+type AssignExpression struct {
+	Token  token.Token //the = token
+	Target *MemberExpression
+	Value  Expression
+}
+
+func (ae *AssignExpression) expressionNode() {}
+
+func (ae *AssignExpression) TokenLiteral() string {
+	return ae.Token.Literal
+}
+
+func (ae *AssignExpression) String() string {
+	return ae.Target.String() + " = " + ae.Value.String()
+}
+
+// TryExpression evaluates to the value of Block, or of Catch when Block
+// throws. At least one of Catch and Finally is set; Catch and CatchParam
+// are set together.
+type TryExpression struct {
+	Token      token.Token
+	Block      *BlockStatement
+	CatchParam *Identifier
+	Catch      *BlockStatement
+	Finally    *BlockStatement
+}
+
+func (te *TryExpression) expressionNode() {}
+
+func (te *TryExpression) TokenLiteral() string {
+	return te.Token.Literal
+}
+
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("try ")
+	out.WriteString(te.Block.String())
+	if te.Catch != nil {
+		out.WriteString(" catch (" + te.CatchParam.String() + ") ")
+		out.WriteString(te.Catch.String())
+	}
+	if te.Finally != nil {
+		out.WriteString(" finally ")
+		out.WriteString(te.Finally.String())
+	}
+
+	return out.String()
+}
+
+// PropagateExpression is the postfix ? operator: Value, unless Value is an
+// error value, which the enclosing function then returns straight away.
+type PropagateExpression struct {
+	Token token.Token //the ? token
+	Value Expression
+}
+
+func (pe *PropagateExpression) expressionNode() {}
+
+func (pe *PropagateExpression) TokenLiteral() string {
+	return pe.Token.Literal
+}
+
+func (pe *PropagateExpression) String() string {
+	return "(" + pe.Value.String() + "?)"
+}
+
+// SpreadExpression passes the elements of an array as separate arguments,
+// as in f(...args). It only appears among the arguments of a call.
+type SpreadExpression struct {
+	Token token.Token //the ... token
+	Value Expression
+}
+
+func (se *SpreadExpression) expressionNode() {}
+
+func (se *SpreadExpression) TokenLiteral() string {
+	return se.Token.Literal
+}
+
+func (se *SpreadExpression) String() string {
+	return "..." + se.Value.String()
+}
+
+// Pattern is what a destructuring let or parameter binds a value to: an
+// *Identifier, a *WildcardPattern, an *ArrayPattern or a *HashPattern,
+// nested as deep as needed. The arms of a match expression may use
+// *LiteralPattern as well.
+type Pattern interface {
+	Node
+	patternNode()
+}
+
+// ArrayPattern binds the elements of an array, as in [a, b, ...rest]. The
+// array must have as many elements as the pattern, or at least as many when
+// it has a rest binding, which gets the elements left over.
+type ArrayPattern struct {
+	Token    token.Token //the [ token
+	Elements []Pattern
+	Rest     *Identifier //named _ when the elements left over are ignored
+}
+
+func (ap *ArrayPattern) patternNode() {}
+
+// RestBinding returns the name the elements left over are bound to, nil if
+// they are not bound.
+func (ap *ArrayPattern) RestBinding() *Identifier {
+	if ap.Rest == nil || ap.Rest.Value == "_" {
+		return nil
+	}
+	return ap.Rest
+}
+
+func (ap *ArrayPattern) TokenLiteral() string {
+	return ap.Token.Literal
+}
+
+func (ap *ArrayPattern) String() string {
+	elements := []string{}
+	for _, el := range ap.Elements {
+		elements = append(elements, el.String())
+	}
+	if ap.Rest != nil {
+		elements = append(elements, "..."+ap.Rest.String())
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// HashPattern binds the values of string keys of a hash, as in
+// {name, age: years}. A key on its own binds the name of the key; every key
+// must be in the hash.
+type HashPattern struct {
+	Token  token.Token //the { token
+	Keys   []*Identifier
+	Values []Pattern //what the value of each key is bound to
+}
+
+func (hp *HashPattern) patternNode() {}
+
+func (hp *HashPattern) TokenLiteral() string {
+	return hp.Token.Literal
+}
+
+func (hp *HashPattern) String() string {
+	pairs := []string{}
+	for i, key := range hp.Keys {
+		if ident, ok := hp.Values[i].(*Identifier); ok && ident.Value == key.Value {
+			pairs = append(pairs, key.String())
+			continue
+		}
+		pairs = append(pairs, key.String()+": "+hp.Values[i].String())
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// PatternNames returns the identifiers a pattern binds, in source order.
+func PatternNames(pattern Pattern) []*Identifier {
+	switch pattern := pattern.(type) {
+	case *Identifier:
+		return []*Identifier{pattern}
+	case *ArrayPattern:
+		names := []*Identifier{}
+		for _, el := range pattern.Elements {
+			names = append(names, PatternNames(el)...)
+		}
+		if rest := pattern.RestBinding(); rest != nil {
+			names = append(names, rest)
+		}
+		return names
+	case *HashPattern:
+		names := []*Identifier{}
+		for _, value := range pattern.Values {
+			names = append(names, PatternNames(value)...)
+		}
+		return names
+	}
+	return nil
+}
+
+// WildcardPattern, written _, matches any value without binding it.
+type WildcardPattern struct {
+	Token token.Token
+}
+
+func (wp *WildcardPattern) patternNode() {}
+
+func (wp *WildcardPattern) TokenLiteral() string {
+	return wp.Token.Literal
+}
+
+func (wp *WildcardPattern) String() string {
+	return "_"
+}
+
+// LiteralPattern matches values equal to an integer, string or boolean
+// literal, or a negated integer literal.
+type LiteralPattern struct {
+	Token token.Token
+	Value Expression
+}
+
+func (lp *LiteralPattern) patternNode() {}
+
+func (lp *LiteralPattern) TokenLiteral() string {
+	return lp.Token.Literal
+}
+
+func (lp *LiteralPattern) String() string {
+	return lp.Value.String()
+}
+
+// MatchExpression evaluates to the body of the first arm whose pattern
+// matches its value and whose guard, if any, holds. The names in the pattern
+// are bound before the guard is evaluated.
+type MatchExpression struct {
+	Token token.Token //the match token
+	Value Expression
+	Arms  []*MatchArm
+}
+
+type MatchArm struct {
+	Pattern Pattern
+	Guard   Expression //nil when the arm has no if guard
+	Body    Expression
+}
+
+// CatchesAll reports whether the arm matches every value, which makes the
+// arms after it unreachable.
+func (ma *MatchArm) CatchesAll() bool {
+	if ma.Guard != nil {
+		return false
+	}
+	switch ma.Pattern.(type) {
+	case *Identifier, *WildcardPattern:
+		return true
+	}
+	return false
+}
+
+func (me *MatchExpression) expressionNode() {}
+
+func (me *MatchExpression) TokenLiteral() string {
+	return me.Token.Literal
+}
+
+func (me *MatchExpression) String() string {
+	arms := []string{}
+	for _, arm := range me.Arms {
+		s := arm.Pattern.String()
+		if arm.Guard != nil {
+			s += " if " + arm.Guard.String()
+		}
+		arms = append(arms, s+" => "+arm.Body.String())
+	}
+	return "match (" + me.Value.String() + ") { " + strings.Join(arms, ", ") + " }"
+}
@@ -0,0 +1,110 @@
+package ast
+
+// Inspect walks the tree below node in source order, calling visit for every
+// node. Children are skipped when visit returns false.
+func Inspect(node Node, visit func(Node) bool) {
+	if node == nil || !visit(node) {
+		return
+	}
+
+	switch node := node.(type) {
+	case *Program:
+		for _, stmt := range node.Statements {
+			Inspect(stmt, visit)
+		}
+	case *LetStatement:
+		inspectExpression(node.Value, visit)
+	case *ReturnStatement:
+		inspectExpression(node.ReturnValue, visit)
+	case *ExpressionStatement:
+		inspectExpression(node.Expression, visit)
+	case *BlockStatement:
+		for _, stmt := range node.Statements {
+			Inspect(stmt, visit)
+		}
+	case *IfExpression:
+		inspectExpression(node.Condition, visit)
+		Inspect(node.Consequence, visit)
+		if node.Alternative != nil {
+			Inspect(node.Alternative, visit)
+		}
+	case *FunctionLiteral:
+		for _, def := range node.Defaults {
+			inspectExpression(def, visit)
+		}
+		Inspect(node.Block, visit)
+	case *PrefixExpression:
+		inspectExpression(node.Right, visit)
+	case *InfixExpression:
+		inspectExpression(node.LeftExpr, visit)
+		inspectExpression(node.RightExpr, visit)
+	case *CallExpression:
+		inspectExpression(node.Function, visit)
+		for _, arg := range node.Arguments {
+			inspectExpression(arg, visit)
+		}
+	case *ArrayLiteral:
+		for _, el := range node.Elements {
+			inspectExpression(el, visit)
+		}
+	case *HashLiteral:
+		for key, value := range node.Pairs {
+			inspectExpression(key, visit)
+			inspectExpression(value, visit)
+		}
+	case *IndexExpression:
+		inspectExpression(node.Left, visit)
+		inspectExpression(node.Index, visit)
+	case *ThrowStatement:
+		inspectExpression(node.Value, visit)
+	case *ExportStatement:
+		if node.Struct != nil {
+			Inspect(node.Struct, visit)
+		} else if node.Enum != nil {
+			Inspect(node.Enum, visit)
+		} else {
+			Inspect(node.Let, visit)
+		}
+	case *StructStatement:
+		for _, m := range node.Methods {
+			Inspect(m.Function, visit)
+		}
+	case *MemberExpression:
+		inspectExpression(node.Object, visit)
+	case *AssignExpression:
+		Inspect(node.Target, visit)
+		inspectExpression(node.Value, visit)
+	case *TryExpression:
+		Inspect(node.Block, visit)
+		if node.Catch != nil {
+			Inspect(node.CatchParam, visit)
+			Inspect(node.Catch, visit)
+		}
+		if node.Finally != nil {
+			Inspect(node.Finally, visit)
+		}
+	case *PropagateExpression:
+		inspectExpression(node.Value, visit)
+	case *SpreadExpression:
+		inspectExpression(node.Value, visit)
+	case *MatchExpression:
+		inspectExpression(node.Value, visit)
+		for _, arm := range node.Arms {
+			inspectExpression(arm.Guard, visit)
+			inspectExpression(arm.Body, visit)
+		}
+	case *SliceExpression:
+		inspectExpression(node.Left, visit)
+		inspectExpression(node.Start, visit)
+		inspectExpression(node.End, visit)
+		inspectExpression(node.Step, visit)
+	}
+}
+
+// inspectExpression guards against omitted expressions, which are nil
+// interfaces rather than nil nodes.
+func inspectExpression(node Expression, visit func(Node) bool) {
+	if node != nil {
+		Inspect(node, visit)
+	}
+}
@@ -0,0 +1,297 @@
+// Package builtins holds the standard library shared by the tree-walking
+// evaluator and the VM, so both engines expose the same functions.
+package builtins
+
+import (
+	"Hulk/object"
+	"fmt"
+	"strings"
+)
+
+// Definition describes a builtin: its name, how many arguments it takes and
+// which types they may have. Arguments are checked against it before Fn
+// runs, so Fn can assert argument types without checking them again.
+type Definition struct {
+	Name string
+
+	//Params lists the accepted types for each positional argument, an empty
+	//entry accepts any type. The first MinArgs arguments are required, the
+	//rest are optional, and a Variadic builtin repeats its last entry.
+	Params   [][]object.ObjectType
+	MinArgs  int
+	Variadic bool
+
+	Fn object.BuiltinFunction
+
+	//Builtin is the object both engines hand out for this definition, built
+	//once in init so every lookup returns the same value
+	Builtin *object.BuiltIn
+}
+
+var (
+	anyType     = []object.ObjectType{}
+	arrayType   = []object.ObjectType{object.ARRAY_OBJ}
+	stringType  = []object.ObjectType{object.STRING_OBJ}
+	integerType = []object.ObjectType{object.INTEGER_OBJ}
+	hashType    = []object.ObjectType{object.HASH_OBJ}
+	enumType    = []object.ObjectType{object.ENUM_OBJ}
+	sequence    = []object.ObjectType{object.ARRAY_OBJ, object.STRING_OBJ}
+)
+
+// Builtins is ordered: the compiler refers to a builtin by its position in
+// this slice through OpGetBuiltin, so new entries go at the end.
+var Builtins = []*Definition{
+	{Name: "len", Params: [][]object.ObjectType{anyType}, MinArgs: 1, Fn: builtinLen},
+	{Name: "puts", Params: [][]object.ObjectType{anyType}, Variadic: true, Fn: builtinPuts},
+	{Name: "first", Params: [][]object.ObjectType{arrayType}, MinArgs: 1, Fn: builtinFirst},
+	{Name: "last", Params: [][]object.ObjectType{arrayType}, MinArgs: 1, Fn: builtinLast},
+	{Name: "rest", Params: [][]object.ObjectType{arrayType}, MinArgs: 1, Fn: builtinRest},
+	{Name: "push", Params: [][]object.ObjectType{arrayType, anyType}, MinArgs: 2, Fn: builtinPush},
+	{Name: "map", Params: [][]object.ObjectType{arrayType, anyType}, MinArgs: 2, Fn: builtinMap},
+	{Name: "filter", Params: [][]object.ObjectType{arrayType, anyType}, MinArgs: 2, Fn: builtinFilter},
+	{Name: "reduce", Params: [][]object.ObjectType{arrayType, anyType, anyType}, MinArgs: 2, Fn: builtinReduce},
+	{Name: "sort", Params: [][]object.ObjectType{arrayType, anyType}, MinArgs: 1, Fn: builtinSort},
+	{Name: "reverse", Params: [][]object.ObjectType{arrayType}, MinArgs: 1, Fn: builtinReverse},
+	{Name: "contains", Params: [][]object.ObjectType{sequence, anyType}, MinArgs: 2, Fn: builtinContains},
+	{Name: "index_of", Params: [][]object.ObjectType{sequence, anyType}, MinArgs: 2, Fn: builtinIndexOf},
+	{Name: "zip", Params: [][]object.ObjectType{arrayType, arrayType}, MinArgs: 2, Fn: builtinZip},
+	{Name: "range", Params: [][]object.ObjectType{integerType, integerType, integerType}, MinArgs: 1, Fn: builtinRange},
+	{Name: "flatten", Params: [][]object.ObjectType{arrayType}, MinArgs: 1, Fn: builtinFlatten},
+	{Name: "unique", Params: [][]object.ObjectType{arrayType}, MinArgs: 1, Fn: builtinUnique},
+	{Name: "concat", Params: [][]object.ObjectType{arrayType}, Variadic: true, Fn: builtinConcat},
+	{Name: "split", Params: [][]object.ObjectType{stringType, stringType}, MinArgs: 2, Fn: builtinSplit},
+	{Name: "join", Params: [][]object.ObjectType{arrayType, stringType}, MinArgs: 2, Fn: builtinJoin},
+	{Name: "trim", Params: [][]object.ObjectType{stringType}, MinArgs: 1, Fn: builtinTrim},
+	{Name: "upper", Params: [][]object.ObjectType{stringType}, MinArgs: 1, Fn: builtinUpper},
+	{Name: "lower", Params: [][]object.ObjectType{stringType}, MinArgs: 1, Fn: builtinLower},
+	{Name: "replace", Params: [][]object.ObjectType{stringType, stringType, stringType}, MinArgs: 3, Fn: builtinReplace},
+	{Name: "starts_with", Params: [][]object.ObjectType{stringType, stringType}, MinArgs: 2, Fn: builtinStartsWith},
+	{Name: "ends_with", Params: [][]object.ObjectType{stringType, stringType}, MinArgs: 2, Fn: builtinEndsWith},
+	{Name: "repeat", Params: [][]object.ObjectType{stringType, integerType}, MinArgs: 2, Fn: builtinRepeat},
+	{Name: "chars", Params: [][]object.ObjectType{stringType}, MinArgs: 1, Fn: builtinChars},
+	{Name: "format", Params: [][]object.ObjectType{stringType, anyType}, MinArgs: 1, Variadic: true, Fn: builtinFormat},
+	{Name: "sprintf", Params: [][]object.ObjectType{stringType, anyType}, MinArgs: 1, Variadic: true, Fn: builtinFormat},
+	{Name: "to_string", Params: [][]object.ObjectType{anyType}, MinArgs: 1, Fn: builtinToString},
+	{Name: "parse_int", Params: [][]object.ObjectType{stringType}, MinArgs: 1, Fn: builtinParseInt},
+	{Name: "pop", Params: [][]object.ObjectType{arrayType}, MinArgs: 1, Fn: builtinPop},
+	{Name: "insert", Params: [][]object.ObjectType{arrayType, integerType, anyType}, MinArgs: 3, Fn: builtinInsert},
+	{Name: "remove", Params: [][]object.ObjectType{arrayType, integerType}, MinArgs: 2, Fn: builtinRemove},
+	{Name: "push_mut", Params: [][]object.ObjectType{arrayType, anyType}, MinArgs: 2, Fn: builtinPushMut},
+	{Name: "pop_mut", Params: [][]object.ObjectType{arrayType}, MinArgs: 1, Fn: builtinPopMut},
+	{Name: "insert_mut", Params: [][]object.ObjectType{arrayType, integerType, anyType}, MinArgs: 3, Fn: builtinInsertMut},
+	{Name: "remove_mut", Params: [][]object.ObjectType{arrayType, integerType}, MinArgs: 2, Fn: builtinRemoveMut},
+	{Name: "json_parse", Params: [][]object.ObjectType{stringType}, MinArgs: 1, Fn: builtinJSONParse},
+	{Name: "json_stringify", Params: [][]object.ObjectType{anyType, {object.INTEGER_OBJ, object.STRING_OBJ}}, MinArgs: 1, Fn: builtinJSONStringify},
+	{Name: "error", Params: [][]object.ObjectType{anyType}, MinArgs: 1, Fn: builtinError},
+	{Name: "is_error", Params: [][]object.ObjectType{anyType}, MinArgs: 1, Fn: builtinIsError},
+	{Name: "unwrap", Params: [][]object.ObjectType{anyType}, MinArgs: 1, Fn: builtinUnwrap},
+	{Name: "keys", Params: [][]object.ObjectType{hashType}, MinArgs: 1, Fn: builtinKeys},
+	{Name: "values", Params: [][]object.ObjectType{hashType}, MinArgs: 1, Fn: builtinValues},
+	{Name: "has", Params: [][]object.ObjectType{hashType, anyType}, MinArgs: 2, Fn: builtinHas},
+	{Name: "tag_of", Params: [][]object.ObjectType{enumType}, MinArgs: 1, Fn: builtinTagOf},
+}
+
+func init() {
+	for _, def := range Builtins {
+		def := def
+		def.Builtin = &object.BuiltIn{
+			Fn: func(apply object.Applier, args ...object.Object) object.Object {
+				if err := def.CheckArgs(args); err != nil {
+					return err
+				}
+				return def.Fn(apply, args...)
+			},
+		}
+	}
+}
+
+// Lookup returns the index and definition of the builtin called name, the
+// index being the operand the compiler emits with OpGetBuiltin.
+func Lookup(name string) (int, *Definition, bool) {
+	for i, def := range Builtins {
+		if def.Name == name {
+			return i, def, true
+		}
+	}
+	return -1, nil, false
+}
+
+func GetBuiltinByName(name string) *object.BuiltIn {
+	if _, def, ok := Lookup(name); ok {
+		return def.Builtin
+	}
+	return nil
+}
+
+// MaxArgs returns the most arguments the builtin accepts, or -1 if it is variadic.
+func (def *Definition) MaxArgs() int {
+	if def.Variadic {
+		return -1
+	}
+	return len(def.Params)
+}
+
+// CheckArgs validates the number and types of args against the definition.
+func (def *Definition) CheckArgs(args []object.Object) *object.Error {
+	return def.checkArgs(args, 0)
+}
+
+// checkArgs is CheckArgs for a call whose first skip arguments were not
+// written in the argument list, as the receiver of a method call, and so
+// are left out of the count a wrong number of arguments reports.
+func (def *Definition) checkArgs(args []object.Object, skip int) *object.Error {
+	max := def.MaxArgs()
+	if len(args) < def.MinArgs || (max >= 0 && len(args) > max) {
+		return NewError("wrong number of argument. got=%d want=%s", len(args)-skip, def.arityString(skip))
+	}
+
+	for i, arg := range args {
+		accepted := def.Params[len(def.Params)-1]
+		if i < len(def.Params) {
+			accepted = def.Params[i]
+		}
+		if !acceptsType(accepted, arg.Type()) {
+			return NewError("expected %s for function %s, got=%s",
+				describeTypes(accepted), strings.ToUpper(def.Name), arg.Type())
+		}
+	}
+	return nil
+}
+
+// arityString describes how many arguments the builtin takes, not counting
+// the first skip of them.
+func (def *Definition) arityString(skip int) string {
+	min, max := def.MinArgs-skip, def.MaxArgs()
+	if min < 0 {
+		min = 0
+	}
+	switch {
+	case max < 0:
+		return fmt.Sprintf("at least %d", min)
+	case max-skip == min:
+		return fmt.Sprintf("%d", min)
+	case max-skip == min+1:
+		return fmt.Sprintf("%d or %d", min, max-skip)
+	default:
+		return fmt.Sprintf("%d to %d", min, max-skip)
+	}
+}
+
+func acceptsType(accepted []object.ObjectType, t object.ObjectType) bool {
+	if len(accepted) == 0 {
+		return true
+	}
+	for _, a := range accepted {
+		if a == t {
+			return true
+		}
+	}
+	return false
+}
+
+var typeDescriptions = map[object.ObjectType]string{
+	object.ARRAY_OBJ:   "an array",
+	object.STRING_OBJ:  "a string",
+	object.INTEGER_OBJ: "an integer",
+	object.HASH_OBJ:    "a hash",
+	object.BOOLEAN_OBJ: "a boolean",
+	object.ENUM_OBJ:    "an enum value",
+}
+
+func describeTypes(types []object.ObjectType) string {
+	descriptions := make([]string, len(types))
+	for i, t := range types {
+		desc, ok := typeDescriptions[t]
+		if !ok {
+			desc = string(t)
+		}
+		descriptions[i] = desc
+	}
+	return strings.Join(descriptions, " or ")
+}
+
+// NewError builds the error object both engines and all builtins report
+// failures with.
+func NewError(format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...)}
+}
+
+// meter returns the meter of the run a builtin is called from. Builtins
+// called without an engine, as in tests, get a nil meter, which never stops
+// them.
+func meter(apply object.Applier) *object.Meter {
+	if apply == nil {
+		return nil
+	}
+	return apply.Meter()
+}
+
+func isError(obj object.Object) bool {
+	return obj != nil && obj.Type() == object.ERROR_OBJ
+}
+
+func isTruthy(obj object.Object) bool {
+	switch obj := obj.(type) {
+	case *object.Boolean:
+		return obj.Value
+	case *object.Null:
+		return false
+	default:
+		return true
+	}
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return object.TRUE
+	}
+	return object.FALSE
+}
+
+func indexOf(elements []object.Object, target object.Object) int {
+	for i, el := range elements {
+		if object.Equals(el, target) {
+			return i
+		}
+	}
+	return -1
+}
+
+func flatten(elements []object.Object, into []object.Object) []object.Object {
+	for _, el := range elements {
+		if nested, ok := el.(*object.Array); ok {
+			into = flatten(nested.Elements, into)
+			continue
+		}
+		into = append(into, el)
+	}
+	return into
+}
+
+// compareObjects orders two integers or two strings, returning a negative
+// number, zero or a positive number like strings.Compare.
+func compareObjects(a, b object.Object) (int, *object.Error) {
+	switch a := a.(type) {
+	case *object.Integer:
+		if other, ok := b.(*object.Integer); ok {
+			switch {
+			case a.Value < other.Value:
+				return -1, nil
+			case a.Value > other.Value:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case *object.String:
+		if other, ok := b.(*object.String); ok {
+			switch {
+			case a.Value < other.Value:
+				return -1, nil
+			case a.Value > other.Value:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	return 0, NewError("cannot compare %s and %s, pass a comparator to sort", a.Type(), b.Type())
+}
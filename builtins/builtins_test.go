@@ -0,0 +1,404 @@
+package builtins
+
+import (
+	"Hulk/object"
+	"testing"
+)
+
+func callBuiltin(t *testing.T, name string, args ...object.Object) object.Object {
+	t.Helper()
+
+	builtin := GetBuiltinByName(name)
+	if builtin == nil {
+		t.Fatalf("builtin %s not registered", name)
+	}
+	return builtin.Fn(nil, args...)
+}
+
+func str(s string) *object.String {
+	return &object.String{Value: s}
+}
+
+func integer(i int64) *object.Integer {
+	return &object.Integer{Value: i}
+}
+
+func TestStringBuiltins(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []object.Object
+		expected interface{}
+	}{
+		{"split", []object.Object{str("a,b,c"), str(",")}, []string{"a", "b", "c"}},
+		{"split", []object.Object{str("abc"), str("")}, []string{"a", "b", "c"}},
+		{"join", []object.Object{&object.Array{Elements: []object.Object{str("a"), integer(1)}}, str("-")}, "a-1"},
+		{"trim", []object.Object{str("  hulk \n")}, "hulk"},
+		{"upper", []object.Object{str("hulk")}, "HULK"},
+		{"lower", []object.Object{str("HuLK")}, "hulk"},
+		{"replace", []object.Object{str("a-b-c"), str("-"), str("+")}, "a+b+c"},
+		{"contains", []object.Object{str("smash"), str("ash")}, true},
+		{"contains", []object.Object{str("smash"), str("hulk")}, false},
+		{"starts_with", []object.Object{str("smash"), str("sm")}, true},
+		{"ends_with", []object.Object{str("smash"), str("sm")}, false},
+		{"index_of", []object.Object{str("smash"), str("a")}, int64(2)},
+		{"index_of", []object.Object{str("smash"), str("z")}, int64(-1)},
+		{"repeat", []object.Object{str("ab"), integer(3)}, "ababab"},
+		{"chars", []object.Object{str("hi")}, []string{"h", "i"}},
+		{"format", []object.Object{str("%s is %d, %t"), str("x"), integer(5), object.TRUE}, "x is 5, true"},
+		{"sprintf", []object.Object{str("%05d|%v"), integer(42), &object.Array{Elements: []object.Object{integer(1)}}}, "00042|[1]"},
+		{"to_string", []object.Object{integer(-12)}, "-12"},
+		{"to_string", []object.Object{str("same")}, "same"},
+		{"parse_int", []object.Object{str(" 42 ")}, int64(42)},
+		{"parse_int", []object.Object{str("4x2")}, &object.Error{Message: `could not parse "4x2" as integer`}},
+		{"upper", []object.Object{integer(1)}, &object.Error{Message: "expected a string for function UPPER, got=INTEGER"}},
+		{"repeat", []object.Object{str("a"), integer(-1)}, &object.Error{Message: "negative count for function REPEAT: -1"}},
+		{"trim", []object.Object{}, &object.Error{Message: "wrong number of argument. got=0 want=1"}},
+	}
+
+	for _, tt := range tests {
+		result := callBuiltin(t, tt.name, tt.args...)
+
+		switch expected := tt.expected.(type) {
+		case string:
+			s, ok := result.(*object.String)
+			if !ok || s.Value != expected {
+				t.Errorf("%s: expected %q, got=%T (%v)", tt.name, expected, result, result)
+			}
+		case int64:
+			i, ok := result.(*object.Integer)
+			if !ok || i.Value != expected {
+				t.Errorf("%s: expected %d, got=%T (%v)", tt.name, expected, result, result)
+			}
+		case bool:
+			b, ok := result.(*object.Boolean)
+			if !ok || b.Value != expected {
+				t.Errorf("%s: expected %t, got=%T (%v)", tt.name, expected, result, result)
+			}
+		case []string:
+			arr, ok := result.(*object.Array)
+			if !ok || len(arr.Elements) != len(expected) {
+				t.Errorf("%s: expected %v, got=%T (%v)", tt.name, expected, result, result)
+				continue
+			}
+			for i, want := range expected {
+				if arr.Elements[i].Inspect() != want {
+					t.Errorf("%s: element %d expected %q, got=%q", tt.name, i, want, arr.Elements[i].Inspect())
+				}
+			}
+		case *object.Error:
+			errObj, ok := result.(*object.Error)
+			if !ok || errObj.Message != expected.Message {
+				t.Errorf("%s: expected error %q, got=%T (%v)", tt.name, expected.Message, result, result)
+			}
+		}
+	}
+}
+
+func TestResultBuiltins(t *testing.T) {
+	failed := callBuiltin(t, "error", str("not found"))
+	errValue, ok := failed.(*object.ErrorValue)
+	if !ok {
+		t.Fatalf("error did not return an error value. got=%T (%v)", failed, failed)
+	}
+	if errValue.Inspect() != "error(not found)" {
+		t.Errorf("wrong Inspect(). got=%q", errValue.Inspect())
+	}
+
+	if callBuiltin(t, "is_error", failed) != object.TRUE {
+		t.Errorf("is_error of an error value is not true")
+	}
+	if callBuiltin(t, "is_error", integer(1)) != object.FALSE {
+		t.Errorf("is_error of an integer is not false")
+	}
+
+	if result := callBuiltin(t, "unwrap", str("ok")); result.Inspect() != "ok" {
+		t.Errorf("unwrap of a plain value changed it. got=%v", result)
+	}
+	errObj, ok := callBuiltin(t, "unwrap", failed).(*object.Error)
+	if !ok || errObj.Message != "unwrap of error(not found)" {
+		t.Errorf("unwrap of an error value did not fail. got=%v", errObj)
+	}
+}
+
+func hash(pairs ...object.Object) *object.Hash {
+	h := &object.Hash{Pairs: map[object.HashKey]object.HashPair{}}
+	for i := 0; i < len(pairs); i += 2 {
+		key := pairs[i].(object.Hashable).HashKey()
+		h.Pairs[key] = object.HashPair{Key: pairs[i], Value: pairs[i+1]}
+	}
+	return h
+}
+
+func TestHashBuiltins(t *testing.T) {
+	h := hash(str("b"), integer(2), integer(10), str("ten"), str("a"), integer(1), integer(9), str("nine"))
+
+	if result := callBuiltin(t, "keys", h); result.Inspect() != "[9, 10, a, b]" {
+		t.Errorf("wrong keys. got=%s", result.Inspect())
+	}
+	if result := callBuiltin(t, "values", h); result.Inspect() != "[nine, ten, 1, 2]" {
+		t.Errorf("wrong values. got=%s", result.Inspect())
+	}
+	if result := callBuiltin(t, "len", h); result.Inspect() != "4" {
+		t.Errorf("wrong len. got=%s", result.Inspect())
+	}
+	if callBuiltin(t, "has", h, str("a")) != object.TRUE {
+		t.Errorf("has of a present key is not true")
+	}
+	if callBuiltin(t, "has", h, integer(1)) != object.FALSE {
+		t.Errorf("has of a missing key is not false")
+	}
+	errObj, ok := callBuiltin(t, "has", h, &object.Array{}).(*object.Error)
+	if !ok || errObj.Message != "unusable as hash key: ARRAY" {
+		t.Errorf("has of an array key did not fail. got=%v", errObj)
+	}
+}
+
+func TestGetMember(t *testing.T) {
+	variant, _ := object.NewEnumType("E", [][]string{{"A", "x"}}).Get("A")
+	enumValue, _ := variant.(*object.Variant).New([]object.Object{integer(1)})
+
+	tests := []struct {
+		obj      object.Object
+		name     string
+		expected string
+	}{
+		{str("hulk"), "upper", "<method STRING.upper>"},
+		{&object.Array{}, "map", "<method ARRAY.map>"},
+		{hash(str("name"), str("hulk")), "name", "hulk"},
+		{hash(str("keys"), integer(1)), "keys", "1"},
+		{hash(), "keys", "<method HASH.keys>"},
+		{hash(), "name", "null"},
+		{&object.Module{Name: "m.hk", Exports: map[string]object.Object{"x": integer(1)}}, "x", "1"},
+		{&object.Module{Name: "m.hk"}, "y", "module m.hk does not export y"},
+		{integer(1), "upper", "INTEGER has no method upper"},
+		{object.NewEnumType("E", [][]string{{"A", "x"}}), "A", "<variant E.A>"},
+		{enumValue, "x", "1"},
+		{enumValue, "tag_of", "<method ENUM.tag_of>"},
+		{enumValue, "y", "E.A has no field or method y"},
+	}
+
+	for _, tt := range tests {
+		member, err := GetMember(tt.obj, tt.name)
+		got := ""
+		if err != nil {
+			got = err.Error()
+		} else {
+			got = member.Inspect()
+		}
+		if got != tt.expected {
+			t.Errorf("%s of %s: expected %q, got=%q", tt.name, tt.obj.Inspect(), tt.expected, got)
+		}
+	}
+
+	member, _ := GetMember(str("a,b"), "split")
+	bound := member.(*object.BoundMethod)
+	result := bound.Method.(*object.BuiltIn).Fn(nil, bound.Receiver)
+	if errObj, ok := result.(*object.Error); !ok || errObj.Message != "wrong number of argument. got=0 want=1" {
+		t.Errorf("method arity error counted the receiver. got=%v", result)
+	}
+}
+
+func TestTagOf(t *testing.T) {
+	variant, _ := object.NewEnumType("Shape", [][]string{{"Circle", "r"}}).Get("Circle")
+	circle, _ := variant.(*object.Variant).New([]object.Object{integer(1)})
+
+	if result := callBuiltin(t, "tag_of", circle); result.Inspect() != "Circle" {
+		t.Errorf("wrong tag. got=%s", result.Inspect())
+	}
+	errObj, ok := callBuiltin(t, "tag_of", str("Circle")).(*object.Error)
+	if !ok || errObj.Message != "expected an enum value for function TAG_OF, got=STRING" {
+		t.Errorf("tag_of of a string did not fail. got=%v", errObj)
+	}
+}
+
+func TestBuiltinNamesAreUnique(t *testing.T) {
+	seen := map[string]bool{}
+	for _, def := range Builtins {
+		if seen[def.Name] {
+			t.Errorf("builtin %s registered twice", def.Name)
+		}
+		seen[def.Name] = true
+	}
+}
+
+func TestLookup(t *testing.T) {
+	for i, def := range Builtins {
+		index, found, ok := Lookup(def.Name)
+		if !ok {
+			t.Fatalf("builtin %s not found", def.Name)
+		}
+		if index != i || found != def {
+			t.Errorf("builtin %s found at %d, registered at %d", def.Name, index, i)
+		}
+		if GetBuiltinByName(def.Name) != def.Builtin {
+			t.Errorf("builtin %s returned a different object", def.Name)
+		}
+	}
+
+	if _, _, ok := Lookup("nope"); ok {
+		t.Errorf("unknown builtin found")
+	}
+}
+
+func TestCheckArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []object.Object
+		expected string
+	}{
+		{"first", []object.Object{&object.Array{}}, ""},
+		{"first", []object.Object{}, "wrong number of argument. got=0 want=1"},
+		{"first", []object.Object{integer(1)}, "expected an array for function FIRST, got=INTEGER"},
+		{"sort", []object.Object{}, "wrong number of argument. got=0 want=1 or 2"},
+		{"range", []object.Object{integer(1), integer(2), integer(3), integer(4)}, "wrong number of argument. got=4 want=1 to 3"},
+		{"range", []object.Object{integer(1), str("2")}, "expected an integer for function RANGE, got=STRING"},
+		{"format", []object.Object{}, "wrong number of argument. got=0 want=at least 1"},
+		{"format", []object.Object{str("%d %d"), integer(1), object.TRUE}, ""},
+		{"concat", []object.Object{&object.Array{}, &object.Array{}, str("x")}, "expected an array for function CONCAT, got=STRING"},
+		{"contains", []object.Object{integer(1), integer(1)}, "expected an array or a string for function CONTAINS, got=INTEGER"},
+		{"puts", []object.Object{}, ""},
+	}
+
+	for _, tt := range tests {
+		_, def, _ := Lookup(tt.name)
+		err := def.CheckArgs(tt.args)
+
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %q", tt.name, err.Message)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: expected error %q, got none", tt.name, tt.expected)
+			continue
+		}
+		if err.Message != tt.expected {
+			t.Errorf("%s: wrong error, expected=%q, got=%q", tt.name, tt.expected, err.Message)
+		}
+	}
+}
+
+func TestMaxArgs(t *testing.T) {
+	tests := map[string]int{
+		"len":    1,
+		"reduce": 3,
+		"puts":   -1,
+		"concat": -1,
+	}
+
+	for name, expected := range tests {
+		_, def, _ := Lookup(name)
+		if def.MaxArgs() != expected {
+			t.Errorf("%s: expected MaxArgs=%d, got=%d", name, expected, def.MaxArgs())
+		}
+	}
+}
+
+func TestJSONParse(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`42`, "42"},
+		{`-7`, "-7"},
+		{`"hulk"`, "hulk"},
+		{`true`, "true"},
+		{`null`, "null"},
+		{`[1, "two", [false]]`, "[1, two, [false]]"},
+		{`{"a": {"b": [1]}}`, "{a: {b: [1]}}"},
+		{`  {}  `, "{}"},
+	}
+
+	for _, tt := range tests {
+		result := callBuiltin(t, "json_parse", str(tt.input))
+		if result.Inspect() != tt.expected {
+			t.Errorf("json_parse(%q): expected %q, got=%q", tt.input, tt.expected, result.Inspect())
+		}
+	}
+
+	hash, ok := callBuiltin(t, "json_parse", str(`{"name": "hulk", "age": 3}`)).(*object.Hash)
+	if !ok {
+		t.Fatalf("json_parse of an object did not return a hash")
+	}
+	pair, ok := hash.Pairs[str("age").HashKey()]
+	if !ok || pair.Value.(*object.Integer).Value != 3 {
+		t.Errorf("hash has wrong value for age, got=%v", pair.Value)
+	}
+
+	errors := []struct {
+		input    string
+		expected string
+	}{
+		{`1.5`, "JSON number 1.5 is not an integer"},
+		{`{"a": `, "invalid JSON: unexpected EOF"},
+		{`[1] [2]`, "invalid JSON: unexpected data after top-level value"},
+	}
+
+	for _, tt := range errors {
+		result := callBuiltin(t, "json_parse", str(tt.input))
+		errObj, ok := result.(*object.Error)
+		if !ok {
+			t.Errorf("json_parse(%q): expected error, got=%T (%v)", tt.input, result, result)
+			continue
+		}
+		if errObj.Message != tt.expected {
+			t.Errorf("json_parse(%q): expected error %q, got=%q", tt.input, tt.expected, errObj.Message)
+		}
+	}
+}
+
+func TestJSONStringify(t *testing.T) {
+	hash := callBuiltin(t, "json_parse", str(`{"b": [1, null], "a": "<x>", "c": {"d": true}}`))
+
+	tests := []struct {
+		args     []object.Object
+		expected string
+	}{
+		{[]object.Object{integer(5)}, "5"},
+		{[]object.Object{str("quote \" me")}, `"quote \" me"`},
+		{[]object.Object{object.NULL}, "null"},
+		{[]object.Object{&object.Array{Elements: []object.Object{integer(1), object.FALSE}}}, "[1,false]"},
+		{[]object.Object{hash}, `{"a":"<x>","b":[1,null],"c":{"d":true}}`},
+		{[]object.Object{&object.Array{Elements: []object.Object{integer(1)}}, integer(2)}, "[\n  1\n]"},
+		{[]object.Object{&object.Array{Elements: []object.Object{integer(1)}}, str("\t")}, "[\n\t1\n]"},
+	}
+
+	for _, tt := range tests {
+		result := callBuiltin(t, "json_stringify", tt.args...)
+		s, ok := result.(*object.String)
+		if !ok {
+			t.Errorf("json_stringify: expected string, got=%T (%v)", result, result)
+			continue
+		}
+		if s.Value != tt.expected {
+			t.Errorf("json_stringify: expected %q, got=%q", tt.expected, s.Value)
+		}
+	}
+
+	intKeyed := &object.Hash{Pairs: map[object.HashKey]object.HashPair{
+		integer(1).HashKey(): {Key: integer(1), Value: integer(1)},
+	}}
+
+	errors := []struct {
+		args     []object.Object
+		expected string
+	}{
+		{[]object.Object{intKeyed}, "cannot encode hash key 1 of type INTEGER as JSON, keys must be strings"},
+		{[]object.Object{GetBuiltinByName("len")}, "cannot encode a function as JSON"},
+		{[]object.Object{integer(1), integer(-1)}, "negative indent for function JSON_STRINGIFY: -1"},
+		{[]object.Object{integer(1), object.TRUE}, "expected an integer or a string for function JSON_STRINGIFY, got=BOOLEAN"},
+	}
+
+	for _, tt := range errors {
+		result := callBuiltin(t, "json_stringify", tt.args...)
+		errObj, ok := result.(*object.Error)
+		if !ok {
+			t.Errorf("json_stringify: expected error, got=%T (%v)", result, result)
+			continue
+		}
+		if errObj.Message != tt.expected {
+			t.Errorf("json_stringify: expected error %q, got=%q", tt.expected, errObj.Message)
+		}
+	}
+}
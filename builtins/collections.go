@@ -0,0 +1,204 @@
+package builtins
+
+import (
+	"Hulk/object"
+	"sort"
+	"strings"
+)
+
+func builtinMap(apply object.Applier, args ...object.Object) object.Object {
+	arr := args[0].(*object.Array)
+	mapped := make([]object.Object, len(arr.Elements))
+	for i, el := range arr.Elements {
+		result := apply.Apply(args[1], el)
+		if isError(result) {
+			return result
+		}
+		mapped[i] = result
+	}
+	return &object.Array{Elements: mapped}
+}
+
+func builtinFilter(apply object.Applier, args ...object.Object) object.Object {
+	arr := args[0].(*object.Array)
+	kept := []object.Object{}
+	for _, el := range arr.Elements {
+		result := apply.Apply(args[1], el)
+		if isError(result) {
+			return result
+		}
+		if isTruthy(result) {
+			kept = append(kept, el)
+		}
+	}
+	return &object.Array{Elements: kept}
+}
+
+func builtinReduce(apply object.Applier, args ...object.Object) object.Object {
+	arr := args[0].(*object.Array)
+	elements := arr.Elements
+	var acc object.Object
+	if len(args) == 3 {
+		acc = args[2]
+	} else {
+		if len(elements) == 0 {
+			return NewError("reduce of empty array with no initial value")
+		}
+		acc = elements[0]
+		elements = elements[1:]
+	}
+	for _, el := range elements {
+		acc = apply.Apply(args[1], acc, el)
+		if isError(acc) {
+			return acc
+		}
+	}
+	return acc
+}
+
+func builtinSort(apply object.Applier, args ...object.Object) object.Object {
+	arr := args[0].(*object.Array)
+	sorted := make([]object.Object, len(arr.Elements))
+	copy(sorted, arr.Elements)
+
+	//the comparator reports whether its first argument goes first;
+	//sort.SliceStable cannot stop early, so the first failure is
+	//remembered and returned once it is done
+	var failure object.Object
+	less := func(a, b object.Object) bool {
+		if failure != nil {
+			return false
+		}
+		if len(args) == 2 {
+			result := apply.Apply(args[1], a, b)
+			if isError(result) {
+				failure = result
+				return false
+			}
+			return isTruthy(result)
+		}
+		result, err := compareObjects(a, b)
+		if err != nil {
+			failure = err
+			return false
+		}
+		return result < 0
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return less(sorted[i], sorted[j])
+	})
+	if failure != nil {
+		return failure
+	}
+	return &object.Array{Elements: sorted}
+}
+
+func builtinReverse(apply object.Applier, args ...object.Object) object.Object {
+	arr := args[0].(*object.Array)
+	size := len(arr.Elements)
+	reversed := make([]object.Object, size)
+	for i, el := range arr.Elements {
+		reversed[size-1-i] = el
+	}
+	return &object.Array{Elements: reversed}
+}
+
+func builtinContains(apply object.Applier, args ...object.Object) object.Object {
+	switch container := args[0].(type) {
+	case *object.Array:
+		return nativeBoolToBooleanObject(indexOf(container.Elements, args[1]) >= 0)
+	case *object.String:
+		sub, ok := args[1].(*object.String)
+		if !ok {
+			return NewError("expected a string for function CONTAINS, got=%s", args[1].Type())
+		}
+		return nativeBoolToBooleanObject(strings.Contains(container.Value, sub.Value))
+	}
+	return nil
+}
+
+func builtinIndexOf(apply object.Applier, args ...object.Object) object.Object {
+	switch container := args[0].(type) {
+	case *object.Array:
+		return object.NewInteger(int64(indexOf(container.Elements, args[1])))
+	case *object.String:
+		sub, ok := args[1].(*object.String)
+		if !ok {
+			return NewError("expected a string for function INDEX_OF, got=%s", args[1].Type())
+		}
+		return object.NewInteger(int64(strings.Index(container.Value, sub.Value)))
+	}
+	return nil
+}
+
+func builtinZip(apply object.Applier, args ...object.Object) object.Object {
+	left := args[0].(*object.Array)
+	right := args[1].(*object.Array)
+	size := len(left.Elements)
+	if len(right.Elements) < size {
+		size = len(right.Elements)
+	}
+	pairs := make([]object.Object, size)
+	for i := 0; i < size; i++ {
+		pairs[i] = &object.Array{Elements: []object.Object{left.Elements[i], right.Elements[i]}}
+	}
+	return &object.Array{Elements: pairs}
+}
+
+func builtinRange(apply object.Applier, args ...object.Object) object.Object {
+	bounds := make([]int64, len(args))
+	for i, arg := range args {
+		bounds[i] = arg.(*object.Integer).Value
+	}
+
+	start, end, step := int64(0), bounds[0], int64(1)
+	if len(bounds) > 1 {
+		start, end = bounds[0], bounds[1]
+	}
+	if len(bounds) > 2 {
+		step = bounds[2]
+	}
+	if step == 0 {
+		return NewError("range step cannot be zero")
+	}
+
+	size := int64(0)
+	if step > 0 && start < end {
+		size = (end-start-1)/step + 1
+	} else if step < 0 && start > end {
+		size = (start-end-1)/-step + 1
+	}
+	if err := meter(apply).ArrayLength(int(size)); err != nil {
+		return NewError("%s", err)
+	}
+
+	elements := []object.Object{}
+	for i := start; (step > 0 && i < end) || (step < 0 && i > end); i += step {
+		elements = append(elements, object.NewInteger(i))
+	}
+	return &object.Array{Elements: elements}
+}
+
+func builtinFlatten(apply object.Applier, args ...object.Object) object.Object {
+	arr := args[0].(*object.Array)
+	return &object.Array{Elements: flatten(arr.Elements, []object.Object{})}
+}
+
+func builtinUnique(apply object.Applier, args ...object.Object) object.Object {
+	arr := args[0].(*object.Array)
+	seen := []object.Object{}
+	for _, el := range arr.Elements {
+		if indexOf(seen, el) < 0 {
+			seen = append(seen, el)
+		}
+	}
+	return &object.Array{Elements: seen}
+}
+
+func builtinConcat(apply object.Applier, args ...object.Object) object.Object {
+	joined := []object.Object{}
+	for _, arg := range args {
+		joined = append(joined, arg.(*object.Array).Elements...)
+	}
+	return &object.Array{Elements: joined}
+}
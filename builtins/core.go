@@ -0,0 +1,165 @@
+package builtins
+
+import (
+	"Hulk/object"
+	"fmt"
+)
+
+func builtinLen(apply object.Applier, args ...object.Object) object.Object {
+	switch arg := args[0].(type) {
+	case *object.String:
+		return object.NewInteger(int64(len(arg.Value)))
+
+	case *object.Array:
+		return object.NewInteger(int64(len(arg.Elements)))
+
+	case *object.Hash:
+		return object.NewInteger(int64(len(arg.Pairs)))
+
+	default:
+		return NewError("argument to len() not supported, got %s",
+			args[0].Type())
+	}
+}
+
+func builtinPuts(apply object.Applier, args ...object.Object) object.Object {
+	for _, arg := range args {
+		fmt.Println(arg.Inspect())
+	}
+	return object.NULL
+}
+
+func builtinFirst(apply object.Applier, args ...object.Object) object.Object {
+	arr := args[0].(*object.Array)
+	if len(arr.Elements) > 0 {
+		return arr.Elements[0]
+	}
+	return object.NULL
+}
+
+func builtinLast(apply object.Applier, args ...object.Object) object.Object {
+	arr := args[0].(*object.Array)
+	size := len(arr.Elements)
+	if size > 0 {
+		return arr.Elements[size-1]
+	}
+	return object.NULL
+}
+
+func builtinRest(apply object.Applier, args ...object.Object) object.Object {
+	arr := args[0].(*object.Array)
+	size := len(arr.Elements)
+	if size == 0 {
+		return &object.Array{Elements: []object.Object{}}
+	}
+	newEles := make([]object.Object, size-1)
+	copy(newEles, arr.Elements[1:size])
+	return &object.Array{Elements: newEles}
+}
+
+// push, pop, insert and remove leave their argument untouched and return a
+// new array; the *_mut variants below change the array they are given.
+
+func builtinPush(apply object.Applier, args ...object.Object) object.Object {
+	arr := args[0].(*object.Array)
+	size := len(arr.Elements)
+	newEles := make([]object.Object, size+1)
+	copy(newEles, arr.Elements)
+	newEles[size] = args[1]
+	return &object.Array{Elements: newEles}
+}
+
+func builtinPop(apply object.Applier, args ...object.Object) object.Object {
+	arr := args[0].(*object.Array)
+	size := len(arr.Elements)
+	if size == 0 {
+		return &object.Array{Elements: []object.Object{}}
+	}
+	newEles := make([]object.Object, size-1)
+	copy(newEles, arr.Elements[:size-1])
+	return &object.Array{Elements: newEles}
+}
+
+func builtinInsert(apply object.Applier, args ...object.Object) object.Object {
+	arr := args[0].(*object.Array)
+	idx, err := arrayPosition(arr, args[1].(*object.Integer).Value, true)
+	if err != nil {
+		return err
+	}
+	newEles := make([]object.Object, 0, len(arr.Elements)+1)
+	newEles = append(newEles, arr.Elements[:idx]...)
+	newEles = append(newEles, args[2])
+	newEles = append(newEles, arr.Elements[idx:]...)
+	return &object.Array{Elements: newEles}
+}
+
+func builtinRemove(apply object.Applier, args ...object.Object) object.Object {
+	arr := args[0].(*object.Array)
+	idx, err := arrayPosition(arr, args[1].(*object.Integer).Value, false)
+	if err != nil {
+		return err
+	}
+	newEles := make([]object.Object, 0, len(arr.Elements)-1)
+	newEles = append(newEles, arr.Elements[:idx]...)
+	newEles = append(newEles, arr.Elements[idx+1:]...)
+	return &object.Array{Elements: newEles}
+}
+
+func builtinPushMut(apply object.Applier, args ...object.Object) object.Object {
+	arr := args[0].(*object.Array)
+	arr.Elements = append(arr.Elements, args[1])
+	return arr
+}
+
+func builtinPopMut(apply object.Applier, args ...object.Object) object.Object {
+	arr := args[0].(*object.Array)
+	size := len(arr.Elements)
+	if size == 0 {
+		return object.NULL
+	}
+	popped := arr.Elements[size-1]
+	arr.Elements = arr.Elements[:size-1]
+	return popped
+}
+
+func builtinInsertMut(apply object.Applier, args ...object.Object) object.Object {
+	arr := args[0].(*object.Array)
+	idx, err := arrayPosition(arr, args[1].(*object.Integer).Value, true)
+	if err != nil {
+		return err
+	}
+	arr.Elements = append(arr.Elements, nil)
+	copy(arr.Elements[idx+1:], arr.Elements[idx:])
+	arr.Elements[idx] = args[2]
+	return arr
+}
+
+func builtinRemoveMut(apply object.Applier, args ...object.Object) object.Object {
+	arr := args[0].(*object.Array)
+	idx, err := arrayPosition(arr, args[1].(*object.Integer).Value, false)
+	if err != nil {
+		return err
+	}
+	removed := arr.Elements[idx]
+	arr.Elements = append(arr.Elements[:idx], arr.Elements[idx+1:]...)
+	return removed
+}
+
+// arrayPosition resolves idx against arr, counting negative positions from
+// the end. Inserting may also target the position just past the last element.
+func arrayPosition(arr *object.Array, idx int64, inserting bool) (int, *object.Error) {
+	size := int64(len(arr.Elements))
+	pos := idx
+	if pos < 0 {
+		pos += size
+	}
+
+	max := size - 1
+	if inserting {
+		max = size
+	}
+	if pos < 0 || pos > max {
+		return 0, NewError("index %d out of range for array of length %d", idx, size)
+	}
+	return int(pos), nil
+}
@@ -0,0 +1,9 @@
+package builtins
+
+import "Hulk/object"
+
+// builtinTagOf returns the name of the variant an enum value was made with,
+// so code can branch on it: match (tag_of(shape)) { "Circle" => ... }.
+func builtinTagOf(apply object.Applier, args ...object.Object) object.Object {
+	return &object.String{Value: args[0].(*object.EnumValue).Tag()}
+}
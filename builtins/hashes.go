@@ -0,0 +1,57 @@
+package builtins
+
+import (
+	"Hulk/object"
+	"sort"
+)
+
+// keys and values list a hash in the order of its keys, so the result does
+// not depend on how the map happens to be iterated.
+
+func builtinKeys(apply object.Applier, args ...object.Object) object.Object {
+	pairs := sortedPairs(args[0].(*object.Hash))
+	keys := make([]object.Object, len(pairs))
+	for i, pair := range pairs {
+		keys[i] = pair.Key
+	}
+	return &object.Array{Elements: keys}
+}
+
+func builtinValues(apply object.Applier, args ...object.Object) object.Object {
+	pairs := sortedPairs(args[0].(*object.Hash))
+	values := make([]object.Object, len(pairs))
+	for i, pair := range pairs {
+		values[i] = pair.Value
+	}
+	return &object.Array{Elements: values}
+}
+
+func builtinHas(apply object.Applier, args ...object.Object) object.Object {
+	hash := args[0].(*object.Hash)
+	key, ok := object.HashKeyOf(args[1])
+	if !ok {
+		return NewError("unusable as hash key: %s", args[1].Type())
+	}
+	_, ok = hash.Pairs[key]
+	return nativeBoolToBooleanObject(ok)
+}
+
+// sortedPairs returns the pairs of hash ordered by key: integers and
+// strings in their natural order, keys of different types by type name.
+func sortedPairs(hash *object.Hash) []object.HashPair {
+	pairs := make([]object.HashPair, 0, len(hash.Pairs))
+	for _, pair := range hash.Pairs {
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		a, b := pairs[i].Key, pairs[j].Key
+		if a.Type() != b.Type() {
+			return a.Type() < b.Type()
+		}
+		if cmp, err := compareObjects(a, b); err == nil {
+			return cmp < 0
+		}
+		return a.Inspect() < b.Inspect()
+	})
+	return pairs
+}
@@ -0,0 +1,147 @@
+package builtins
+
+import (
+	"Hulk/object"
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// JSON values map onto Hulk values as follows: objects become hashes with
+// string keys, arrays become arrays, integral numbers become integers and
+// true, false and null become the boolean and null singletons. Hulk has no
+// floating point type yet, so fractional numbers are rejected.
+
+func builtinJSONParse(apply object.Applier, args ...object.Object) object.Object {
+	input := args[0].(*object.String).Value
+
+	decoder := json.NewDecoder(strings.NewReader(input))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return NewError("invalid JSON: %s", err)
+	}
+	if decoder.More() {
+		return NewError("invalid JSON: unexpected data after top-level value")
+	}
+
+	obj, err := fromJSON(value)
+	if err != nil {
+		return err
+	}
+	return obj
+}
+
+func builtinJSONStringify(apply object.Applier, args ...object.Object) object.Object {
+	value, err := toJSON(args[0])
+	if err != nil {
+		return err
+	}
+
+	indent := ""
+	if len(args) == 2 {
+		switch arg := args[1].(type) {
+		case *object.Integer:
+			if arg.Value < 0 {
+				return NewError("negative indent for function JSON_STRINGIFY: %d", arg.Value)
+			}
+			indent = strings.Repeat(" ", int(arg.Value))
+		case *object.String:
+			indent = arg.Value
+		}
+	}
+
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", indent)
+	if encodeErr := encoder.Encode(value); encodeErr != nil {
+		return NewError("could not encode JSON: %s", encodeErr)
+	}
+	return &object.String{Value: strings.TrimSuffix(out.String(), "\n")}
+}
+
+func fromJSON(value interface{}) (object.Object, *object.Error) {
+	switch value := value.(type) {
+	case nil:
+		return object.NULL, nil
+	case bool:
+		return nativeBoolToBooleanObject(value), nil
+	case string:
+		return &object.String{Value: value}, nil
+	case json.Number:
+		integer, err := value.Int64()
+		if err != nil {
+			return nil, NewError("JSON number %s is not an integer", value)
+		}
+		return object.NewInteger(integer), nil
+	case []interface{}:
+		elements := make([]object.Object, len(value))
+		for i, el := range value {
+			obj, err := fromJSON(el)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = obj
+		}
+		return &object.Array{Elements: elements}, nil
+	case map[string]interface{}:
+		pairs := make(map[object.HashKey]object.HashPair, len(value))
+		for k, v := range value {
+			key := &object.String{Value: k}
+			obj, err := fromJSON(v)
+			if err != nil {
+				return nil, err
+			}
+			pairs[key.HashKey()] = object.HashPair{Key: key, Value: obj}
+		}
+		return &object.Hash{Pairs: pairs}, nil
+	default:
+		return nil, NewError("unsupported JSON value %v", value)
+	}
+}
+
+// toJSON converts obj into the values encoding/json expects. Hashes become
+// maps, which encoding/json writes with their keys sorted.
+func toJSON(obj object.Object) (interface{}, *object.Error) {
+	switch obj := obj.(type) {
+	case *object.Null:
+		return nil, nil
+	case *object.Boolean:
+		return obj.Value, nil
+	case *object.Integer:
+		return obj.Value, nil
+	case *object.String:
+		return obj.Value, nil
+	case *object.Array:
+		elements := make([]interface{}, len(obj.Elements))
+		for i, el := range obj.Elements {
+			value, err := toJSON(el)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = value
+		}
+		return elements, nil
+	case *object.Hash:
+		fields := make(map[string]interface{}, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			key, ok := pair.Key.(*object.String)
+			if !ok {
+				return nil, NewError("cannot encode hash key %s of type %s as JSON, keys must be strings",
+					pair.Key.Inspect(), pair.Key.Type())
+			}
+			value, err := toJSON(pair.Value)
+			if err != nil {
+				return nil, err
+			}
+			fields[key.Value] = value
+		}
+		return fields, nil
+	case *object.Function, *object.Closure, *object.BuiltIn:
+		return nil, NewError("cannot encode a function as JSON")
+	default:
+		return nil, NewError("cannot encode %s as JSON", obj.Type())
+	}
+}
@@ -0,0 +1,81 @@
+package builtins
+
+import (
+	"Hulk/object"
+	"fmt"
+)
+
+// methodNames lists, for each type, the builtins that can be called as
+// methods of its values: "abc".upper() calls upper("abc").
+var methodNames = map[object.ObjectType][]string{
+	object.STRING_OBJ: {"len", "upper", "lower", "trim", "split", "replace", "starts_with", "ends_with",
+		"repeat", "chars", "contains", "index_of", "format", "parse_int", "to_string"},
+	object.ARRAY_OBJ: {"len", "first", "last", "rest", "push", "pop", "insert", "remove", "push_mut",
+		"pop_mut", "insert_mut", "remove_mut", "map", "filter", "reduce", "sort", "reverse", "contains",
+		"index_of", "zip", "flatten", "unique", "concat", "join", "to_string"},
+	object.HASH_OBJ:        {"len", "keys", "values", "has", "to_string"},
+	object.INTEGER_OBJ:     {"to_string"},
+	object.BOOLEAN_OBJ:     {"to_string"},
+	object.ERROR_VALUE_OBJ: {"unwrap", "is_error"},
+	object.ENUM_OBJ:        {"tag_of", "to_string"},
+}
+
+// methods holds the builtin each method name calls, per type, built in init
+// from methodNames.
+var methods = map[object.ObjectType]map[string]*object.BuiltIn{}
+
+func init() {
+	for t, names := range methodNames {
+		methods[t] = map[string]*object.BuiltIn{}
+		for _, name := range names {
+			_, def, ok := Lookup(name)
+			if !ok {
+				panic("method of " + string(t) + " is not a builtin: " + name)
+			}
+			methods[t][name] = &object.BuiltIn{
+				Fn: func(apply object.Applier, args ...object.Object) object.Object {
+					if err := def.checkArgs(args, 1); err != nil {
+						return err
+					}
+					return def.Fn(apply, args...)
+				},
+			}
+		}
+	}
+}
+
+// GetMember returns what obj.name reads: a field or method of a struct, a
+// variant of an enum, an export of a module, a field of an enum value, the
+// value of a hash under the string name, or else the builtin method called
+// name bound to obj. Reading a key a hash does not have is null, as
+// indexing it is.
+func GetMember(obj object.Object, name string) (object.Object, error) {
+	switch obj := obj.(type) {
+	case *object.Struct:
+		return obj.Get(name)
+	case *object.EnumType:
+		return obj.Get(name)
+	case *object.Module:
+		return obj.Get(&object.String{Value: name})
+	case *object.EnumValue:
+		if value, ok := obj.Field(name); ok {
+			return value, nil
+		}
+	case *object.Hash:
+		key := &object.String{Value: name}
+		if pair, ok := obj.Pairs[key.HashKey()]; ok {
+			return pair.Value, nil
+		}
+	}
+
+	if method, ok := methods[obj.Type()][name]; ok {
+		return &object.BoundMethod{Name: string(obj.Type()) + "." + name, Receiver: obj, Method: method}, nil
+	}
+	switch obj := obj.(type) {
+	case *object.Hash:
+		return object.NULL, nil
+	case *object.EnumValue:
+		return nil, fmt.Errorf("%s.%s has no field or method %s", obj.Variant.Enum.Name, obj.Tag(), name)
+	}
+	return nil, fmt.Errorf("%s has no method %s", obj.Type(), name)
+}
@@ -0,0 +1,28 @@
+package builtins
+
+import "Hulk/object"
+
+// Error values are the recoverable counterpart of thrown errors: a function
+// returns error(...) instead of a result, and its caller checks for it with
+// is_error, insists on a result with unwrap, or hands the error on to its own
+// caller with the ? operator.
+
+func builtinError(apply object.Applier, args ...object.Object) object.Object {
+	return &object.ErrorValue{Value: args[0]}
+}
+
+func builtinIsError(apply object.Applier, args ...object.Object) object.Object {
+	if _, ok := args[0].(*object.ErrorValue); ok {
+		return object.TRUE
+	}
+	return object.FALSE
+}
+
+// builtinUnwrap returns its argument, unless it is an error value, which it
+// turns into a runtime error.
+func builtinUnwrap(apply object.Applier, args ...object.Object) object.Object {
+	if errValue, ok := args[0].(*object.ErrorValue); ok {
+		return NewError("unwrap of %s", errValue.Inspect())
+	}
+	return args[0]
+}
@@ -0,0 +1,134 @@
+package builtins
+
+import (
+	"Hulk/object"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+func builtinSplit(apply object.Applier, args ...object.Object) object.Object {
+	strs := stringValues(args)
+	parts := strings.Split(strs[0], strs[1])
+	return stringsToArray(parts)
+}
+
+func builtinJoin(apply object.Applier, args ...object.Object) object.Object {
+	arr := args[0].(*object.Array)
+	sep := args[1].(*object.String)
+	parts := make([]string, len(arr.Elements))
+	for i, el := range arr.Elements {
+		parts[i] = el.Inspect()
+	}
+	return &object.String{Value: strings.Join(parts, sep.Value)}
+}
+
+func builtinTrim(apply object.Applier, args ...object.Object) object.Object {
+	strs := stringValues(args)
+	return &object.String{Value: strings.TrimSpace(strs[0])}
+}
+
+func builtinUpper(apply object.Applier, args ...object.Object) object.Object {
+	strs := stringValues(args)
+	return &object.String{Value: strings.ToUpper(strs[0])}
+}
+
+func builtinLower(apply object.Applier, args ...object.Object) object.Object {
+	strs := stringValues(args)
+	return &object.String{Value: strings.ToLower(strs[0])}
+}
+
+func builtinReplace(apply object.Applier, args ...object.Object) object.Object {
+	strs := stringValues(args)
+	return &object.String{Value: strings.ReplaceAll(strs[0], strs[1], strs[2])}
+}
+
+func builtinStartsWith(apply object.Applier, args ...object.Object) object.Object {
+	strs := stringValues(args)
+	return nativeBoolToBooleanObject(strings.HasPrefix(strs[0], strs[1]))
+}
+
+func builtinEndsWith(apply object.Applier, args ...object.Object) object.Object {
+	strs := stringValues(args)
+	return nativeBoolToBooleanObject(strings.HasSuffix(strs[0], strs[1]))
+}
+
+func builtinRepeat(apply object.Applier, args ...object.Object) object.Object {
+	str := args[0].(*object.String)
+	count := args[1].(*object.Integer)
+	if count.Value < 0 {
+		return NewError("negative count for function REPEAT: %d", count.Value)
+	}
+	//a repeat can ask for far more memory than there is, check the size
+	//of the result before building it
+	if len(str.Value) > 0 {
+		size := int64(math.MaxInt)
+		if count.Value < size/int64(len(str.Value)) {
+			size = count.Value * int64(len(str.Value))
+		}
+		if err := meter(apply).StringLength(int(size)); err != nil {
+			return NewError("%s", err)
+		}
+	}
+	return &object.String{Value: strings.Repeat(str.Value, int(count.Value))}
+}
+
+func builtinChars(apply object.Applier, args ...object.Object) object.Object {
+	strs := stringValues(args)
+	return stringsToArray(strings.Split(strs[0], ""))
+}
+
+// builtinFormat backs both format and sprintf. It takes Go's fmt verbs:
+// integers, strings and booleans are passed through as native values so
+// %d, %s, %t and widths work, anything else is formatted as its Inspect().
+func builtinFormat(apply object.Applier, args ...object.Object) object.Object {
+	layout := args[0].(*object.String)
+	values := make([]interface{}, len(args)-1)
+	for i, arg := range args[1:] {
+		switch arg := arg.(type) {
+		case *object.Integer:
+			values[i] = arg.Value
+		case *object.String:
+			values[i] = arg.Value
+		case *object.Boolean:
+			values[i] = arg.Value
+		default:
+			values[i] = arg.Inspect()
+		}
+	}
+	return &object.String{Value: fmt.Sprintf(layout.Value, values...)}
+}
+
+func builtinToString(apply object.Applier, args ...object.Object) object.Object {
+	if str, ok := args[0].(*object.String); ok {
+		return str
+	}
+	return &object.String{Value: args[0].Inspect()}
+}
+
+func builtinParseInt(apply object.Applier, args ...object.Object) object.Object {
+	strs := stringValues(args)
+	value, err := strconv.ParseInt(strings.TrimSpace(strs[0]), 10, 64)
+	if err != nil {
+		return NewError("could not parse %q as integer", strs[0])
+	}
+	return object.NewInteger(value)
+}
+
+// stringValues unwraps arguments already checked to be strings.
+func stringValues(args []object.Object) []string {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		strs[i] = arg.(*object.String).Value
+	}
+	return strs
+}
+
+func stringsToArray(strs []string) *object.Array {
+	elements := make([]object.Object, len(strs))
+	for i, s := range strs {
+		elements[i] = &object.String{Value: s}
+	}
+	return &object.Array{Elements: elements}
+}
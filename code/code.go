@@ -13,6 +13,64 @@ type Opcode byte
 const (
 	OpConstant Opcode = iota
 	OpAdd
+	OpMinus
+	OpNull
+	OpArray
+	OpIndex
+	OpSlice
+	OpPop
+	OpSub
+	OpMul
+	OpDiv
+	OpTrue
+	OpFalse
+	OpEqual
+	OpNotEqual
+	OpGreaterThan
+	OpBang
+	OpJumpNotTruthy
+	OpJump
+	OpGetGlobal
+	OpSetGlobal
+	OpHash
+	OpCall
+	OpReturnValue
+	OpReturn
+	OpGetLocal
+	OpSetLocal
+	OpGetBuiltin
+	OpClosure
+	OpGetFree
+	OpCurrentClosure
+
+	//specialized and fused instructions, only emitted for optimized code
+	OpAddInt
+	OpSubInt
+	OpGreaterThanInt
+	OpConstantAdd
+	OpConstantSub
+	OpGetLocalGetLocalAdd
+	OpJumpNotGreater
+	OpJumpNotEqual
+
+	OpTailCall
+	OpThrow
+	OpJumpNotError
+	OpJumpArgGiven
+	OpCallSpread
+	OpDestructureArray
+	OpDestructureHash
+	OpMatchEqual
+	OpMatchArray
+	OpMatchHash
+	OpNoMatch
+	OpImport
+	OpModule
+	OpStruct
+	OpMethod
+	OpGetMember
+	OpSetMember
+	OpEnum
 )
 
 type Definition struct {
@@ -21,8 +79,113 @@ type Definition struct {
 }
 
 var definitions = map[Opcode]*Definition{
-	OpConstant: {"OpConstant", []int{2}},
-	OpAdd:      {"OpAdd", []int{}},
+	OpConstant:       {"OpConstant", []int{2}},
+	OpAdd:            {"OpAdd", []int{}},
+	OpMinus:          {"OpMinus", []int{}},
+	OpNull:           {"OpNull", []int{}},
+	OpArray:          {"OpArray", []int{2}},
+	OpIndex:          {"OpIndex", []int{}},
+	OpSlice:          {"OpSlice", []int{}},
+	OpPop:            {"OpPop", []int{}},
+	OpSub:            {"OpSub", []int{}},
+	OpMul:            {"OpMul", []int{}},
+	OpDiv:            {"OpDiv", []int{}},
+	OpTrue:           {"OpTrue", []int{}},
+	OpFalse:          {"OpFalse", []int{}},
+	OpEqual:          {"OpEqual", []int{}},
+	OpNotEqual:       {"OpNotEqual", []int{}},
+	OpGreaterThan:    {"OpGreaterThan", []int{}},
+	OpBang:           {"OpBang", []int{}},
+	OpJumpNotTruthy:  {"OpJumpNotTruthy", []int{2}},
+	OpJump:           {"OpJump", []int{2}},
+	OpGetGlobal:      {"OpGetGlobal", []int{2}},
+	OpSetGlobal:      {"OpSetGlobal", []int{2}},
+	OpHash:           {"OpHash", []int{2}},
+	OpCall:           {"OpCall", []int{1}},
+	OpReturnValue:    {"OpReturnValue", []int{}},
+	OpReturn:         {"OpReturn", []int{}},
+	OpGetLocal:       {"OpGetLocal", []int{1}},
+	OpSetLocal:       {"OpSetLocal", []int{1}},
+	OpGetBuiltin:     {"OpGetBuiltin", []int{1}},
+	OpClosure:        {"OpClosure", []int{2, 1}}, //constant index of the function, number of free variables
+	OpGetFree:        {"OpGetFree", []int{1}},
+	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+
+	//OpAdd, OpSub and OpGreaterThan for operands that are most likely integers
+	OpAddInt:         {"OpAddInt", []int{}},
+	OpSubInt:         {"OpSubInt", []int{}},
+	OpGreaterThanInt: {"OpGreaterThanInt", []int{}},
+	//OpConstant followed by OpAdd or OpSub
+	OpConstantAdd: {"OpConstantAdd", []int{2}},
+	OpConstantSub: {"OpConstantSub", []int{2}},
+	//two OpGetLocal followed by OpAdd
+	OpGetLocalGetLocalAdd: {"OpGetLocalGetLocalAdd", []int{1, 1}},
+	//OpGreaterThan or OpEqual followed by OpJumpNotTruthy
+	OpJumpNotGreater: {"OpJumpNotGreater", []int{2}},
+	OpJumpNotEqual:   {"OpJumpNotEqual", []int{2}},
+
+	//OpCall whose result is returned straight away, the callee takes over the
+	//frame of the caller
+	OpTailCall: {"OpTailCall", []int{1}},
+
+	//throws the value on top of the stack, see handlers.go
+	OpThrow: {"OpThrow", []int{}},
+
+	//jumps unless the value on top of the stack, which stays there, is an
+	//error value; the ? operator returns it otherwise
+	OpJumpNotError: {"OpJumpNotError", []int{2}},
+
+	//jumps if the call into the running function passed an argument for
+	//the parameter, skipping the code that sets its default value
+	OpJumpArgGiven: {"OpJumpArgGiven", []int{2, 1}}, //target, parameter index
+	//OpCall whose arguments are the elements of the arrays on the stack
+	OpCallSpread: {"OpCallSpread", []int{1}}, //number of arrays
+
+	//replace the value on top of the stack with the parts a pattern binds,
+	//the first one on top; see object.DestructureArray and DestructureHash
+	OpDestructureArray: {"OpDestructureArray", []int{1, 1}}, //number of elements, 1 with a rest binding
+	OpDestructureHash:  {"OpDestructureHash", []int{1}},     //number of keys, pushed above the hash
+
+	//replace the value on top of the stack, along with the literal or keys
+	//above it, with whether it matches a pattern; unlike the opcodes above
+	//they never fail
+	OpMatchEqual: {"OpMatchEqual", []int{}},
+	OpMatchArray: {"OpMatchArray", []int{1, 1}}, //number of elements, 1 with a rest binding
+	OpMatchHash:  {"OpMatchHash", []int{1}},     //number of keys, pushed above the hash
+	//fails with the value on top of the stack, which no arm matched
+	OpNoMatch: {"OpNoMatch", []int{}},
+
+	//pushes the module in the global and jumps once the module has run,
+	//pushes a closure of its function to be called otherwise
+	OpImport: {"OpImport", []int{2, 2, 2}}, //target, global, constant index of the module function
+	//replaces the names and values of the exports on the stack with a module
+	OpModule: {"OpModule", []int{2, 2}}, //constant index of the module file, number of exports
+
+	//replaces the names of the fields on the stack with a struct type
+	OpStruct: {"OpStruct", []int{2, 2}}, //constant index of the struct name, number of fields
+	//adds the closure on top of the stack to the methods of the struct type
+	//below it, taking both off the stack
+	OpMethod: {"OpMethod", []int{2}}, //constant index of the method name
+	//replaces the value on top of the stack with one of its members, see
+	//builtins.GetMember
+	OpGetMember: {"OpGetMember", []int{2}}, //constant index of the member name
+	//assigns the value on top of the stack to a field of the instance below
+	//it, leaving only the value
+	OpSetMember: {"OpSetMember", []int{2}}, //constant index of the field name
+
+	//replaces the variants on the stack, each an array of its name and the
+	//names of its fields, with an enum type
+	OpEnum: {"OpEnum", []int{2, 2}}, //constant index of the enum name, number of variants
+}
+
+// IsJump reports whether op jumps, in which case its first operand is the
+// offset of the target.
+func IsJump(op Opcode) bool {
+	switch op {
+	case OpJump, OpJumpNotTruthy, OpJumpNotGreater, OpJumpNotEqual, OpJumpNotError, OpJumpArgGiven, OpImport:
+		return true
+	}
+	return false
 }
 
 func Lookup(op byte) (*Definition, error) {
@@ -55,6 +218,8 @@ func Make(op Opcode, operands ...int) []byte {
 		switch width {
 		case 2:
 			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
 		}
 		offset += width
 	}
@@ -69,7 +234,8 @@ func (ins Instructions) String() string {
 	for i < len(ins) {
 		def, err := Lookup(ins[i])
 		if err != nil {
-			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			fmt.Fprintf(&out, "%04d ERROR: %s\n", i, err)
+			i++
 			continue
 		}
 		operands, read := ReadOperands(def, ins[i+1:])
@@ -89,6 +255,8 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 		switch width {
 		case 2:
 			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
 		}
 		offset += width
 	}
@@ -100,6 +268,10 @@ func ReadUint16(ins Instructions) uint16 {
 	return binary.BigEndian.Uint16(ins)
 }
 
+func ReadUint8(ins Instructions) uint8 {
+	return uint8(ins[0])
+}
+
 func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
 	operandCount := len(def.OperandWidths)
 
@@ -112,6 +284,8 @@ func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
 		return def.Name
 	case 1:
 		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
 	}
 
 	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
@@ -10,6 +10,9 @@ func TestMake(t *testing.T) {
 	}{
 		{OpConstant, []int{65534}, []byte{byte(OpConstant), 255, 254}},
 		{OpAdd, []int{}, []byte{byte(OpAdd)}},
+		{OpArray, []int{3}, []byte{byte(OpArray), 0, 3}},
+		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
+		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
 	}
 
 	for _, tt := range tests {
@@ -32,11 +35,15 @@ func TestInstructionsString(t *testing.T) {
 		Make(OpAdd),
 		Make(OpConstant, 2),
 		Make(OpConstant, 65535),
+		Make(OpGetLocal, 1),
+		Make(OpClosure, 65535, 255),
 	}
 
 	expected := `0000 OpAdd
 0001 OpConstant 2
 0004 OpConstant 65535
+0007 OpGetLocal 1
+0009 OpClosure 65535 255
 `
 
 	concatted := Instructions{}
@@ -57,6 +64,8 @@ func TestReadOperands(t *testing.T) {
 		bytesRead int
 	}{
 		{OpConstant, []int{65535}, 2},
+		{OpGetLocal, []int{255}, 1},
+		{OpClosure, []int{65535, 255}, 3},
 	}
 
 	for _, tt := range tests {
@@ -80,3 +89,50 @@ func TestReadOperands(t *testing.T) {
 		}
 	}
 }
+
+func TestInstructionsStringUndefinedOpcode(t *testing.T) {
+	concatted := Instructions{}
+	concatted = append(concatted, Make(OpAdd)...)
+	concatted = append(concatted, 255)
+	concatted = append(concatted, Make(OpConstant, 1)...)
+
+	expected := `0000 OpAdd
+0001 ERROR: Opcode 255 undefined
+0002 OpConstant 1
+`
+
+	if concatted.String() != expected {
+		t.Fatalf("instructions wrongly formatted. \nwant=%q\ngot=%q", expected, concatted.String())
+	}
+}
+
+func TestPositionTableLineFor(t *testing.T) {
+	table := PositionTable{
+		{Offset: 0, Line: 1},
+		{Offset: 4, Line: 2},
+		{Offset: 9, Line: 3},
+		{Offset: 9, Line: 5},
+	}
+
+	tests := []struct {
+		offset int
+		line   int
+	}{
+		{0, 1},
+		{3, 1},
+		{4, 2},
+		{8, 2},
+		{9, 5},
+		{20, 5},
+	}
+
+	for _, tt := range tests {
+		if got := table.LineFor(tt.offset); got != tt.line {
+			t.Errorf("wrong line for offset %d. want=%d, got=%d", tt.offset, tt.line, got)
+		}
+	}
+
+	if got := (PositionTable{}).LineFor(3); got != 0 {
+		t.Errorf("empty table should not map offsets, got=%d", got)
+	}
+}
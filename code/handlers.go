@@ -0,0 +1,30 @@
+package code
+
+// Handler catches the errors raised by the instructions from Start up to,
+// but not including, End. The VM cuts the stack back to Depth values above
+// the locals of the frame, pushes the error and carries on at Target.
+//
+// A catch clause gets the error as the hash it binds. A finally block gets
+// the error itself, which it throws again with OpThrow once it has run.
+type Handler struct {
+	Start   int
+	End     int
+	Target  int
+	Depth   int
+	Finally bool
+}
+
+// HandlerTable lists the handlers of a function, innermost first, so the
+// first one covering an instruction is the one that catches its errors.
+type HandlerTable []Handler
+
+// HandlerFor returns the handler catching errors raised by the instruction
+// at offset, if any.
+func (ht HandlerTable) HandlerFor(offset int) (Handler, bool) {
+	for _, h := range ht {
+		if h.Start <= offset && offset < h.End {
+			return h, true
+		}
+	}
+	return Handler{}, false
+}
@@ -0,0 +1,26 @@
+package code
+
+// SourcePosition marks the instruction starting at Offset as the first one
+// compiled from Line. It covers every instruction up to the next position.
+type SourcePosition struct {
+	Offset int
+	Line   int
+}
+
+// PositionTable maps instruction offsets back to source lines. Entries are
+// ordered by Offset; several entries may share an offset when the compiler
+// threw away the instructions in between, and the last one wins.
+type PositionTable []SourcePosition
+
+// LineFor returns the source line of the instruction at offset, or 0 when
+// the table does not cover it.
+func (pt PositionTable) LineFor(offset int) int {
+	line := 0
+	for _, pos := range pt {
+		if pos.Offset > offset {
+			break
+		}
+		line = pos.Line
+	}
+	return line
+}
@@ -0,0 +1,113 @@
+package compiler
+
+import (
+	"Hulk/ast"
+	"Hulk/code"
+)
+
+// compileParameters emits the start of a function, which sets every
+// parameter the caller passed no argument for to its default value:
+//
+//	OpJumpArgGiven next, i
+//	default value of parameter i
+//	OpSetLocal i
+//	next:
+//
+// and destructures the parameters with a pattern, each right after it is
+// set so that the defaults after it can use the names it binds.
+func (c *Compiler) compileParameters(node *ast.FunctionLiteral) error {
+	for i := range node.Parameters {
+		if def := node.Default(i); def != nil {
+			jumpPos := c.emit(code.OpJumpArgGiven, 9999, i)
+			err := c.compileDefault(node, i)
+			if err != nil {
+				return err
+			}
+			c.emit(code.OpSetLocal, i)
+			c.replaceInstruction(jumpPos, code.Make(code.OpJumpArgGiven, len(c.currentInstructions()), i))
+		}
+
+		if pattern := node.Pattern(i); pattern != nil {
+			c.emit(code.OpGetLocal, i)
+			c.compilePattern(pattern, c.symbolTable.Define)
+		}
+	}
+	return nil
+}
+
+// compileDefault compiles the default value of parameter i. As in the
+// evaluator, it sees the parameters before i but not i and the ones after
+// it, which are not set yet: their names refer to what they shadow.
+func (c *Compiler) compileDefault(node *ast.FunctionLiteral, i int) error {
+	later := node.Parameters[i:]
+	if node.Rest != nil {
+		later = append(later[:len(later):len(later)], node.Rest)
+	}
+
+	hidden := make([]Symbol, len(later))
+	for j, p := range later {
+		hidden[j] = c.symbolTable.store[p.Value]
+		delete(c.symbolTable.store, p.Value)
+	}
+	defer func() {
+		for j, p := range later {
+			c.symbolTable.store[p.Value] = hidden[j]
+		}
+	}()
+
+	return c.Compile(node.Default(i))
+}
+
+func numDefaults(node *ast.FunctionLiteral) int {
+	n := 0
+	for i := range node.Parameters {
+		if node.Default(i) != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func hasSpread(args []ast.Expression) bool {
+	for _, a := range args {
+		if _, ok := a.(*ast.SpreadExpression); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// compileSpreadCall compiles the arguments of a call with spread arguments
+// as arrays, runs of plain arguments being gathered into one, and calls
+// with OpCallSpread, which passes on their elements.
+func (c *Compiler) compileSpreadCall(args []ast.Expression) error {
+	arrays, plain := 0, 0
+	for _, a := range args {
+		spread, ok := a.(*ast.SpreadExpression)
+		if !ok {
+			err := c.Compile(a)
+			if err != nil {
+				return err
+			}
+			plain++
+			continue
+		}
+
+		if plain > 0 {
+			c.emit(code.OpArray, plain)
+			arrays, plain = arrays+1, 0
+		}
+		err := c.Compile(spread.Value)
+		if err != nil {
+			return err
+		}
+		arrays++
+	}
+	if plain > 0 {
+		c.emit(code.OpArray, plain)
+		arrays++
+	}
+
+	c.emit(code.OpCallSpread, arrays)
+	return nil
+}
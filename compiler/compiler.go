@@ -2,27 +2,102 @@ package compiler
 
 import (
 	"Hulk/ast"
+	"Hulk/builtins"
 	"Hulk/code"
 	"Hulk/object"
+	"Hulk/optimizer"
 	"fmt"
+	"sort"
 )
 
+type EmittedInstruction struct {
+	Opcode   code.Opcode
+	Position int
+}
+
+type CompilationScope struct {
+	instructions        code.Instructions
+	lastInstruction     EmittedInstruction
+	previousInstruction EmittedInstruction
+	positions           code.PositionTable
+
+	trys     []*tryBlock      //try expressions being compiled, innermost last
+	handlers []pendingHandler //handlers of the try expressions compiled so far
+}
+
 type Compiler struct {
-	instructions code.Instructions
-	constants    []object.Object
+	constants []object.Object
+	//pool index of every string constant, equal literals share one entry
+	strings map[string]int
+
+	symbolTable *SymbolTable
+
+	scopes     []CompilationScope
+	scopeIndex int
+
+	line int //source line of the statement being compiled
+
+	matchDepth int //match expressions being compiled, see matchSubject
+
+	imports *imports //nil unless imports are enabled, see modules.go
+	file    string   //file of the code being compiled, empty for a program read from nowhere
+	module  string   //file of the module being compiled, empty for the program itself
+
+	optimize bool
 }
 
 func New() *Compiler {
+	mainScope := CompilationScope{
+		instructions:        code.Instructions{},
+		lastInstruction:     EmittedInstruction{},
+		previousInstruction: EmittedInstruction{},
+	}
+
 	return &Compiler{
-		instructions: code.Instructions{},
-		constants:    []object.Object{},
+		constants:   []object.Object{},
+		strings:     map[string]int{},
+		symbolTable: builtinSymbols(),
+		scopes:      []CompilationScope{mainScope},
+		scopeIndex:  0,
+	}
+}
+
+// builtinSymbols returns a symbol table defining nothing but the builtins.
+func builtinSymbols() *SymbolTable {
+	symbolTable := NewSymbolTable()
+	for i, v := range builtins.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
 	}
+	return symbolTable
+}
+
+// NewWithState keeps globals and constants alive across compilations, which
+// the REPL needs so that a binding made on one line is visible on the next.
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
+	compiler := New()
+	compiler.symbolTable = s
+	compiler.constants = constants
+	for i, constant := range constants {
+		if str, ok := constant.(*object.String); ok {
+			compiler.strings[str.Value] = i
+		}
+	}
+	return compiler
 }
 
 func (c *Compiler) Compile(node ast.Node) error {
+	if line := ast.StatementLine(node); line > 0 {
+		outer := c.line
+		c.line = line
+		defer func() { c.line = outer }()
+	}
+
 	switch node := node.(type) {
 
 	case *ast.Program:
+		if c.optimize {
+			optimizer.Optimize(node)
+		}
 		for _, s := range node.Statements {
 			err := c.Compile(s)
 			if err != nil {
@@ -35,8 +110,135 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if err != nil {
 			return err
 		}
+		c.emit(code.OpPop)
+
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			err := c.Compile(s)
+			if err != nil {
+				return err
+			}
+		}
+
+	case *ast.LetStatement:
+		if node.Pattern != nil {
+			err := c.Compile(node.Value)
+			if err != nil {
+				return err
+			}
+			c.compilePattern(node.Pattern, c.symbolTable.Define)
+			return nil
+		}
+
+		//the value is compiled first so that it sees the binding the let
+		//shadows; a function calling itself finds its own name through
+		//DefineFunctionName instead
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		c.setSymbol(c.symbolTable.Define(node.Name.Value))
+
+	case *ast.ReturnStatement:
+		err := c.Compile(node.ReturnValue)
+		if err != nil {
+			return err
+		}
+		err = c.emitReturn()
+		if err != nil {
+			return err
+		}
+
+	case *ast.ImportStatement:
+		return c.compileImport(node)
+
+	case *ast.ExportStatement:
+		if node.Struct != nil {
+			return c.Compile(node.Struct)
+		}
+		if node.Enum != nil {
+			return c.Compile(node.Enum)
+		}
+		return c.Compile(node.Let)
+
+	case *ast.StructStatement:
+		return c.compileStruct(node)
+
+	case *ast.EnumStatement:
+		return c.compileEnum(node)
+
+	case *ast.MemberExpression:
+		err := c.Compile(node.Object)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpGetMember, c.stringConstant(node.Member.Value))
+
+	case *ast.AssignExpression:
+		err := c.Compile(node.Target.Object)
+		if err != nil {
+			return err
+		}
+		err = c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpSetMember, c.stringConstant(node.Target.Member.Value))
+
+	case *ast.ThrowStatement:
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+
+	case *ast.TryExpression:
+		err := c.compileTry(node)
+		if err != nil {
+			return err
+		}
+
+	case *ast.PropagateExpression:
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		jumpPos := c.emit(code.OpJumpNotError, 9999)
+		err = c.emitReturn()
+		if err != nil {
+			return err
+		}
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(node.Value)
+		if !ok {
+			return fmt.Errorf("undefined variable %s", node.Value)
+		}
+		c.loadSymbol(symbol)
 
 	case *ast.InfixExpression:
+		if c.optimize {
+			if folded, ok := optimizer.Fold(node); ok {
+				return c.Compile(folded)
+			}
+		}
+
+		//a < b is compiled as b > a so that one comparison opcode covers both
+		if node.Operator == "<" {
+			err := c.Compile(node.RightExpr)
+			if err != nil {
+				return err
+			}
+
+			err = c.Compile(node.LeftExpr)
+			if err != nil {
+				return err
+			}
+			c.emit(c.integerOp(node, code.OpGreaterThan))
+			return nil
+		}
+
 		err := c.Compile(node.LeftExpr)
 		if err != nil {
 			return err
@@ -49,19 +251,294 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		switch node.Operator {
 		case "+":
-			c.emit(code.OpAdd)
+			c.emit(c.integerOp(node, code.OpAdd))
+		case "-":
+			c.emit(c.integerOp(node, code.OpSub))
+		case "*":
+			c.emit(code.OpMul)
+		case "/":
+			c.emit(code.OpDiv)
+		case ">":
+			c.emit(c.integerOp(node, code.OpGreaterThan))
+		case "==":
+			c.emit(code.OpEqual)
+		case "!=":
+			c.emit(code.OpNotEqual)
+		default:
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+
+	case *ast.PrefixExpression:
+		if c.optimize {
+			if folded, ok := optimizer.Fold(node); ok {
+				return c.Compile(folded)
+			}
+		}
+
+		err := c.Compile(node.Right)
+		if err != nil {
+			return err
+		}
+
+		switch node.Operator {
+		case "-":
+			c.emit(code.OpMinus)
+		case "!":
+			c.emit(code.OpBang)
 		default:
 			return fmt.Errorf("unknown operator %s", node.Operator)
 		}
 
+	case *ast.IfExpression:
+		err := c.Compile(node.Condition)
+		if err != nil {
+			return err
+		}
+
+		//emit an `OpJumpNotTruthy` with a bogus value, patched below
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+		err = c.Compile(node.Consequence)
+		if err != nil {
+			return err
+		}
+		c.keepBlockValue()
+
+		//emit an `OpJump` with a bogus value, patched below
+		jumpPos := c.emit(code.OpJump, 9999)
+
+		afterConsequencePos := len(c.currentInstructions())
+		c.changeOperand(jumpNotTruthyPos, afterConsequencePos)
+
+		if node.Alternative == nil {
+			c.emit(code.OpNull)
+		} else {
+			err := c.Compile(node.Alternative)
+			if err != nil {
+				return err
+			}
+			c.keepBlockValue()
+		}
+
+		afterAlternativePos := len(c.currentInstructions())
+		c.changeOperand(jumpPos, afterAlternativePos)
+
 	case *ast.IntegerLiteral:
-		integer := &object.Integer{Value: node.Value}
+		integer := object.NewInteger(node.Value)
 		c.emit(code.OpConstant, c.addConstant(integer))
+
+	case *ast.BooleanExpression:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+
+	case *ast.StringLiteral:
+		c.emit(code.OpConstant, c.stringConstant(node.Value))
+
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			err := c.Compile(el)
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpArray, len(node.Elements))
+
+	case *ast.HashLiteral:
+		keys := []ast.Expression{}
+		for k := range node.Pairs {
+			keys = append(keys, k)
+		}
+
+		//map iteration order is random, sort the keys so the emitted
+		//instructions are deterministic
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+
+		for _, k := range keys {
+			err := c.Compile(k)
+			if err != nil {
+				return err
+			}
+			err = c.Compile(node.Pairs[k])
+			if err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpHash, len(node.Pairs)*2)
+
+	case *ast.IndexExpression:
+		err := c.Compile(node.Left)
+		if err != nil {
+			return err
+		}
+
+		err = c.Compile(node.Index)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpIndex)
+
+	case *ast.SliceExpression:
+		err := c.Compile(node.Left)
+		if err != nil {
+			return err
+		}
+
+		//omitted bounds are pushed as null so OpSlice always finds four operands
+		for _, bound := range []ast.Expression{node.Start, node.End, node.Step} {
+			if bound == nil {
+				c.emit(code.OpNull)
+				continue
+			}
+			err := c.Compile(bound)
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpSlice)
+
+	case *ast.FunctionLiteral:
+		c.enterScope()
+
+		if node.Name != "" {
+			c.symbolTable.DefineFunctionName(node.Name)
+		}
+
+		for _, p := range node.Parameters {
+			c.symbolTable.Define(p.Value)
+		}
+		if node.Rest != nil {
+			c.symbolTable.Define(node.Rest.Value)
+		}
+
+		err := c.compileParameters(node)
+		if err != nil {
+			return err
+		}
+
+		err = c.Compile(node.Block)
+		if err != nil {
+			return err
+		}
+
+		if c.lastInstructionIs(code.OpPop) {
+			c.replaceLastPopWithReturn()
+		}
+		if !c.lastInstructionIs(code.OpReturnValue) {
+			c.emit(code.OpReturn)
+		}
+
+		freeSymbols := c.symbolTable.FreeSymbols
+		numLocals := c.symbolTable.numDefinitions
+		positions := c.scopes[c.scopeIndex].positions
+		pending := c.scopes[c.scopeIndex].handlers
+		instructions := c.leaveScope()
+		handlers := resolveHandlers(instructions, pending)
+		markTailCalls(instructions, handlers)
+		if c.optimize {
+			instructions, positions, handlers = peephole(instructions, positions, handlers, false)
+		}
+
+		for _, s := range freeSymbols {
+			c.loadSymbol(s)
+		}
+
+		compiledFn := &object.CompiledFunction{
+			Instructions:  instructions,
+			NumLocals:     numLocals,
+			NumParameters: len(node.Parameters),
+			NumDefaults:   numDefaults(node),
+			Variadic:      node.Rest != nil,
+			Positions:     positions,
+			Handlers:      handlers,
+			Name:          node.Name,
+			Module:        c.module,
+		}
+
+		fnIndex := c.addConstant(compiledFn)
+		c.emit(code.OpClosure, fnIndex, len(freeSymbols))
+
+	case *ast.MatchExpression:
+		return c.compileMatch(node)
+
+	case *ast.CallExpression:
+		err := c.Compile(node.Function)
+		if err != nil {
+			return err
+		}
+
+		if hasSpread(node.Arguments) {
+			return c.compileSpreadCall(node.Arguments)
+		}
+
+		for _, a := range node.Arguments {
+			err := c.Compile(a)
+			if err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpCall, len(node.Arguments))
 	}
 
 	return nil
 }
 
+// emitReturn returns the value on top of the stack from the function,
+// running the finally blocks of the try expressions it leaves first.
+func (c *Compiler) emitReturn() error {
+	if len(c.scopes[c.scopeIndex].trys) == 0 {
+		c.emit(code.OpReturnValue)
+		return nil
+	}
+	reprotect, err := c.leaveTrys()
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpReturnValue)
+	reprotect()
+	return nil
+}
+
+func (c *Compiler) setSymbol(s Symbol) {
+	if s.Scope == GlobalScope {
+		c.emit(code.OpSetGlobal, s.Index)
+	} else {
+		c.emit(code.OpSetLocal, s.Index)
+	}
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case BuiltinScope:
+		c.emit(code.OpGetBuiltin, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
+
+// stringConstant returns the index of the constant holding s, adding it
+// the first time s is used.
+func (c *Compiler) stringConstant(s string) int {
+	index, ok := c.strings[s]
+	if !ok {
+		index = c.addConstant(&object.String{Value: s})
+		c.strings[s] = index
+	}
+	return index
+}
+
 func (c *Compiler) addConstant(obj object.Object) int {
 	c.constants = append(c.constants, obj)
 	return len(c.constants) - 1
@@ -71,23 +548,143 @@ func (c *Compiler) addConstant(obj object.Object) int {
 func (c *Compiler) emit(op code.Opcode, operands ...int) int {
 	ins := code.Make(op, operands...)
 	pos := c.addInstruction(ins)
+
+	c.setLastInstruction(op, pos)
+	c.addPosition(pos)
+
 	return pos
 }
 
 func (c *Compiler) addInstruction(ins []byte) int {
-	posNewInstruction := len(c.instructions)
-	c.instructions = append(c.instructions, ins...)
+	posNewInstruction := len(c.currentInstructions())
+	updatedInstructions := append(c.currentInstructions(), ins...)
+
+	c.scopes[c.scopeIndex].instructions = updatedInstructions
+
 	return posNewInstruction
 }
 
+// addPosition records that the instruction at pos starts the current line,
+// unless the previous instruction already came from it.
+func (c *Compiler) addPosition(pos int) {
+	if c.line == 0 {
+		return
+	}
+	positions := c.scopes[c.scopeIndex].positions
+	if len(positions) > 0 && positions[len(positions)-1].Line == c.line {
+		return
+	}
+	c.scopes[c.scopeIndex].positions = append(positions, code.SourcePosition{Offset: pos, Line: c.line})
+}
+
+func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
+	previous := c.scopes[c.scopeIndex].lastInstruction
+	last := EmittedInstruction{Opcode: op, Position: pos}
+
+	c.scopes[c.scopeIndex].previousInstruction = previous
+	c.scopes[c.scopeIndex].lastInstruction = last
+}
+
+// keepBlockValue leaves the value of the block just compiled on the stack:
+// the value of its last expression, or null if the block is empty or ends
+// in a statement other than a return.
+func (c *Compiler) keepBlockValue() {
+	switch {
+	case c.lastInstructionIs(code.OpPop):
+		c.removeLastPop()
+	case !c.lastInstructionIs(code.OpReturnValue) && !c.lastInstructionIs(code.OpReturn):
+		c.emit(code.OpNull)
+	}
+}
+
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
+}
+
+func (c *Compiler) removeLastPop() {
+	last := c.scopes[c.scopeIndex].lastInstruction
+	previous := c.scopes[c.scopeIndex].previousInstruction
+
+	old := c.currentInstructions()
+	new := old[:last.Position]
+
+	c.scopes[c.scopeIndex].instructions = new
+	c.scopes[c.scopeIndex].lastInstruction = previous
+}
+
+func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
+
+	for i := 0; i < len(newInstruction); i++ {
+		ins[pos+i] = newInstruction[i]
+	}
+}
+
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	op := code.Opcode(c.currentInstructions()[opPos])
+	newInstruction := code.Make(op, operand)
+
+	c.replaceInstruction(opPos, newInstruction)
+}
+
+func (c *Compiler) replaceLastPopWithReturn() {
+	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
+	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
+
+	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+func (c *Compiler) enterScope() {
+	scope := CompilationScope{
+		instructions:        code.Instructions{},
+		lastInstruction:     EmittedInstruction{},
+		previousInstruction: EmittedInstruction{},
+	}
+	c.scopes = append(c.scopes, scope)
+	c.scopeIndex++
+
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() code.Instructions {
+	instructions := c.currentInstructions()
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+
+	c.symbolTable = c.symbolTable.Outer
+
+	return instructions
+}
+
 func (c *Compiler) Bytecode() *Bytecode {
+	instructions := c.currentInstructions()
+	positions := c.scopes[c.scopeIndex].positions
+	handlers := resolveHandlers(instructions, c.scopes[c.scopeIndex].handlers)
+	if c.optimize {
+		instructions, positions, handlers = peephole(instructions, positions, handlers, true)
+	}
+
 	return &Bytecode{
-		Instructions: c.instructions,
+		Instructions: instructions,
 		Constants:    c.constants,
+		Positions:    positions,
+		Handlers:     handlers,
 	}
 }
 
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	Positions    code.PositionTable //source lines of Instructions
+	Handlers     code.HandlerTable  //try expressions of the main program
+	Debug        *DebugInfo         //nil unless the caller attached it, see serialize.go
 }
@@ -4,10 +4,14 @@ import (
 	"Hulk/ast"
 	"Hulk/code"
 	"Hulk/lexer"
+	"Hulk/loader"
 	"Hulk/object"
 	"Hulk/parser"
 	"fmt"
+	"io/fs"
+	"reflect"
 	"testing"
+	"testing/fstest"
 )
 
 type compilerTestCase struct {
@@ -31,6 +35,7 @@ func TestIntegerArithmetic(t *testing.T) {
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
 			},
 		},
 	}
@@ -102,6 +107,23 @@ func testConstants(t *testing.T, expected []interface{}, actual []object.Object)
 			if err != nil {
 				return fmt.Errorf("constant %d - testIntegerObject failed: %s", i, err)
 			}
+
+		case string:
+			err := testStringObject(constant, actual[i])
+			if err != nil {
+				return fmt.Errorf("constant %d - testStringObject failed: %s", i, err)
+			}
+
+		case []code.Instructions:
+			fn, ok := actual[i].(*object.CompiledFunction)
+			if !ok {
+				return fmt.Errorf("constant %d - not a function: %T", i, actual[i])
+			}
+
+			err := testInstructions(constant, fn.Instructions)
+			if err != nil {
+				return fmt.Errorf("constant %d - testInstructions failed: %s", i, err)
+			}
 		}
 	}
 	return nil
@@ -119,3 +141,909 @@ func testIntegerObject(expected int64, actual object.Object) error {
 	}
 	return nil
 }
+
+func testStringObject(expected string, actual object.Object) error {
+	result, ok := actual.(*object.String)
+	if !ok {
+		return fmt.Errorf("object is not String. got=%T (%+v)", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%q, want=%q", result.Value, expected)
+	}
+	return nil
+}
+
+func TestArrayLiterals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "[]",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "[1, 2, 3]",
+			expectedConstants: []interface{}{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpArray, 3),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestIndexExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "[1, 2][-1]",
+			expectedConstants: []interface{}{1, 2, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpArray, 2),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpMinus),
+				code.Make(code.OpIndex),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestSliceExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `"hulk"[1:3]`,
+			expectedConstants: []interface{}{"hulk", 1, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpNull),
+				code.Make(code.OpSlice),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "[1][::2]",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpNull),
+				code.Make(code.OpNull),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSlice),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "if (true) { 10 }; 3333;",
+			expectedConstants: []interface{}{10, 3333},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 10),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpJump, 11),
+				// 0010
+				code.Make(code.OpNull),
+				// 0011
+				code.Make(code.OpPop),
+				// 0012
+				code.Make(code.OpConstant, 1),
+				// 0015
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			//a branch without a value leaves null
+			input:             "if (true) { } else { let a = 1; }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 8),
+				// 0004
+				code.Make(code.OpNull),
+				// 0005
+				code.Make(code.OpJump, 15),
+				// 0008
+				code.Make(code.OpConstant, 0),
+				// 0011
+				code.Make(code.OpSetGlobal, 0),
+				// 0014
+				code.Make(code.OpNull),
+				// 0015
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestGlobalLetStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let one = 1; one < 2;",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpGreaterThan),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestFunctions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "fn(a) { let b = a * 2; b }",
+			expectedConstants: []interface{}{
+				2,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpMul),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "fn(a) { fn(b) { a + b } }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "let countDown = fn(x) { countDown(x - 1); };",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpCurrentClosure),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSub),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestBuiltins(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "map([], len)",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, 6),
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpGetBuiltin, 0),
+				code.Make(code.OpCall, 2),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestStringConstantsShared(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `"a"; "b"; fn() { "a" }`,
+			expectedConstants: []interface{}{"a", "b", []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpReturnValue),
+			}},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+
+	//a second compiler, like the next line of the REPL, reuses the constant
+	first := New()
+	first.Compile(parse(`"hulk"`))
+	second := NewWithState(first.symbolTable, first.Bytecode().Constants)
+	second.Compile(parse(`"hulk"`))
+	if len(second.constants) != 1 {
+		t.Errorf("string constant not shared. got=%v", second.constants)
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "fn(f) { if (f) { f() } else { return f(f); } }",
+			expectedConstants: []interface{}{[]code.Instructions{
+				// 0000
+				code.Make(code.OpGetLocal, 0),
+				// 0002
+				code.Make(code.OpJumpNotTruthy, 12),
+				// 0005
+				code.Make(code.OpGetLocal, 0),
+				// 0007
+				code.Make(code.OpTailCall, 0),
+				// 0009
+				code.Make(code.OpJump, 19),
+				// 0012
+				code.Make(code.OpGetLocal, 0),
+				// 0014
+				code.Make(code.OpGetLocal, 0),
+				// 0016
+				code.Make(code.OpTailCall, 1),
+				// 0018
+				code.Make(code.OpReturnValue),
+				// 0019
+				code.Make(code.OpReturnValue),
+			}},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			//calls whose result is used, or dropped, are no tail calls
+			input: "fn(f) { f(); [f()] }",
+			expectedConstants: []interface{}{[]code.Instructions{
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpReturnValue),
+			}},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestTryExpressions(t *testing.T) {
+	tests := []struct {
+		compilerTestCase
+		expectedHandlers code.HandlerTable
+	}{
+		{
+			compilerTestCase{
+				input:             "try { 1 } catch (e) { 2 }",
+				expectedConstants: []interface{}{1, 2},
+				expectedInstructions: []code.Instructions{
+					// 0000
+					code.Make(code.OpConstant, 0),
+					// 0003
+					code.Make(code.OpJump, 12),
+					// 0006
+					code.Make(code.OpSetGlobal, 0),
+					// 0009
+					code.Make(code.OpConstant, 1),
+					// 0012
+					code.Make(code.OpPop),
+				},
+			},
+			code.HandlerTable{{Start: 0, End: 3, Target: 6, Depth: 0}},
+		},
+		{
+			//the finally block runs on the way out, and again before the
+			//error it was entered with is thrown on
+			compilerTestCase{
+				input:             "try { throw 1 } finally { 2 }",
+				expectedConstants: []interface{}{1, 2, 2},
+				expectedInstructions: []code.Instructions{
+					// 0000
+					code.Make(code.OpConstant, 0),
+					// 0003
+					code.Make(code.OpThrow),
+					// 0004
+					code.Make(code.OpNull),
+					// 0005
+					code.Make(code.OpJump, 8),
+					// 0008
+					code.Make(code.OpConstant, 1),
+					// 0011
+					code.Make(code.OpPop),
+					// 0012
+					code.Make(code.OpJump, 20),
+					// 0015
+					code.Make(code.OpConstant, 2),
+					// 0018
+					code.Make(code.OpPop),
+					// 0019
+					code.Make(code.OpThrow),
+					// 0020
+					code.Make(code.OpPop),
+				},
+			},
+			code.HandlerTable{{Start: 0, End: 5, Target: 15, Depth: 0, Finally: true}},
+		},
+		{
+			//the handler cuts the stack back to the 1 waiting for the addition
+			compilerTestCase{
+				input:             "1 + try { 2 } catch (e) { 3 }",
+				expectedConstants: []interface{}{1, 2, 3},
+				expectedInstructions: []code.Instructions{
+					// 0000
+					code.Make(code.OpConstant, 0),
+					// 0003
+					code.Make(code.OpConstant, 1),
+					// 0006
+					code.Make(code.OpJump, 15),
+					// 0009
+					code.Make(code.OpSetGlobal, 0),
+					// 0012
+					code.Make(code.OpConstant, 2),
+					// 0015
+					code.Make(code.OpAdd),
+					// 0016
+					code.Make(code.OpPop),
+				},
+			},
+			code.HandlerTable{{Start: 3, End: 6, Target: 9, Depth: 1}},
+		},
+	}
+
+	for _, tt := range tests {
+		runCompilerTests(t, []compilerTestCase{tt.compilerTestCase})
+
+		compiler := New()
+		err := compiler.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		handlers := compiler.Bytecode().Handlers
+		if !reflect.DeepEqual(handlers, tt.expectedHandlers) {
+			t.Errorf("%q: wrong handlers.\nwant=%+v\ngot=%+v", tt.input, tt.expectedHandlers, handlers)
+		}
+	}
+
+	//a return leaving a try runs its finally block first, and the code
+	//after it is protected again
+	compiler := New()
+	err := compiler.Compile(parse("fn(a) { try { return 1 } finally { a } }"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	fn := compiler.Bytecode().Constants[1].(*object.CompiledFunction)
+	expected := []code.Instructions{
+		// 0000
+		code.Make(code.OpConstant, 0),
+		// 0003
+		code.Make(code.OpGetLocal, 0),
+		// 0005
+		code.Make(code.OpPop),
+		// 0006
+		code.Make(code.OpReturnValue),
+		// 0007
+		code.Make(code.OpNull),
+		// 0008
+		code.Make(code.OpJump, 11),
+		// 0011
+		code.Make(code.OpGetLocal, 0),
+		// 0013
+		code.Make(code.OpPop),
+		// 0014
+		code.Make(code.OpJump, 21),
+		// 0017
+		code.Make(code.OpGetLocal, 0),
+		// 0019
+		code.Make(code.OpPop),
+		// 0020
+		code.Make(code.OpThrow),
+		// 0021
+		code.Make(code.OpReturnValue),
+	}
+	if err := testInstructions(expected, fn.Instructions); err != nil {
+		t.Errorf("testInstructions failed: %s", err)
+	}
+	handlers := code.HandlerTable{
+		{Start: 0, End: 3, Target: 17, Finally: true},
+		{Start: 7, End: 8, Target: 17, Finally: true},
+	}
+	if !reflect.DeepEqual(fn.Handlers, handlers) {
+		t.Errorf("wrong handlers.\nwant=%+v\ngot=%+v", handlers, fn.Handlers)
+	}
+}
+
+func TestPropagateExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "fn(x) { x? }",
+			expectedConstants: []interface{}{[]code.Instructions{
+				// 0000
+				code.Make(code.OpGetLocal, 0),
+				// 0002
+				code.Make(code.OpJumpNotError, 6),
+				// 0005
+				code.Make(code.OpReturnValue),
+				// 0006
+				code.Make(code.OpReturnValue),
+			}},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestFunctionArguments(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "fn(a, b = 2) { b }",
+			expectedConstants: []interface{}{
+				2,
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpJumpArgGiven, 9, 1),
+					// 0004
+					code.Make(code.OpConstant, 0),
+					// 0007
+					code.Make(code.OpSetLocal, 1),
+					// 0009
+					code.Make(code.OpGetLocal, 1),
+					// 0011
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn(a, ...rest) { rest }",
+			expectedConstants: []interface{}{[]code.Instructions{
+				code.Make(code.OpGetLocal, 1),
+				code.Make(code.OpReturnValue),
+			}},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "len(1, ...[2])",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpCallSpread, 2),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestDestructuring(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let [a, ...b] = [1];",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpDestructureArray, 1, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpSetGlobal, 1),
+			},
+		},
+		{
+			input:             `let {x} = {"x": 1};`,
+			expectedConstants: []interface{}{"x", 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpHash, 2),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpDestructureHash, 1),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+		{
+			input: "fn([a, b]) { b }",
+			expectedConstants: []interface{}{[]code.Instructions{
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpDestructureArray, 2, 0),
+				code.Make(code.OpSetLocal, 1),
+				code.Make(code.OpSetLocal, 2),
+				code.Make(code.OpGetLocal, 2),
+				code.Make(code.OpReturnValue),
+			}},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestMatchExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "match (1) { 1 => 2, _ => 3 }",
+			expectedConstants: []interface{}{1, 1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpSetGlobal, 0),
+				// 0006
+				code.Make(code.OpGetGlobal, 0),
+				// 0009
+				code.Make(code.OpConstant, 1),
+				// 0012
+				code.Make(code.OpMatchEqual),
+				// 0013
+				code.Make(code.OpJumpNotTruthy, 26),
+				// 0016
+				code.Make(code.OpGetGlobal, 0),
+				// 0019
+				code.Make(code.OpPop),
+				// 0020
+				code.Make(code.OpConstant, 2),
+				// 0023
+				code.Make(code.OpJump, 40),
+				// 0026
+				code.Make(code.OpGetGlobal, 0),
+				// 0029
+				code.Make(code.OpPop),
+				// 0030
+				code.Make(code.OpConstant, 3),
+				// 0033
+				code.Make(code.OpJump, 40),
+				// 0036
+				code.Make(code.OpGetGlobal, 0),
+				// 0039
+				code.Make(code.OpNoMatch),
+				// 0040
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "match ([]) { [x] => x }",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpMatchArray, 1, 0),
+				code.Make(code.OpJumpNotTruthy, 30),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpDestructureArray, 1, 0),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpJump, 34),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpNoMatch),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func compileImporting(input string, files fstest.MapFS) (*Bytecode, error) {
+	modules := loader.New(".")
+	modules.ReadFile = func(name string) ([]byte, error) { return fs.ReadFile(files, name) }
+
+	compiler := New()
+	compiler.EnableImports(modules, "main.hk")
+	err := compiler.Compile(parse(input))
+	if err != nil {
+		return nil, err
+	}
+	return compiler.Bytecode(), nil
+}
+
+func TestStructs(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "struct P { x, fn get() { self.x } }; let p = P(1); p.x = 2; p.get()",
+			expectedConstants: []interface{}{
+				"x",
+				"P",
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetMember, 0),
+					code.Make(code.OpReturnValue),
+				},
+				"get",
+				1,
+				2,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpStruct, 1, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpMethod, 3),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 4),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpConstant, 5),
+				code.Make(code.OpSetMember, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpGetMember, 3),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			//the method closes over the local binding of the type, which is
+			//set before the closure is made
+			input: "fn() { struct N { v, fn next() { N(self.v + 1) } } }",
+			expectedConstants: []interface{}{
+				"v",
+				"N",
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetMember, 0),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpAdd),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+				"next",
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpStruct, 1, 1),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpClosure, 3, 1),
+					code.Make(code.OpMethod, 4),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 5, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestEnums(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "enum E { A(x, y), B }; E.A(1, 2) == E.B",
+			expectedConstants: []interface{}{
+				"A",
+				"x",
+				"y",
+				"B",
+				"E",
+				1,
+				2,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpArray, 3),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpEnum, 4, 2),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpGetMember, 0),
+				code.Make(code.OpConstant, 5),
+				code.Make(code.OpConstant, 6),
+				code.Make(code.OpCall, 2),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpGetMember, 3),
+				code.Make(code.OpEqual),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestImports(t *testing.T) {
+	files := fstest.MapFS{"lib/one.hk": {Data: []byte(`export let a = 1;`)}}
+	bytecode, err := compileImporting(`import "lib/one.hk" as one; import "./lib/one.hk" as again; one`, files)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	//the module is compiled once, both imports share its function and global
+	expectedConstants := []interface{}{
+		1,
+		"a",
+		"lib/one.hk",
+		[]code.Instructions{
+			code.Make(code.OpConstant, 0),
+			code.Make(code.OpSetLocal, 0),
+			code.Make(code.OpConstant, 1),
+			code.Make(code.OpGetLocal, 0),
+			code.Make(code.OpModule, 2, 1),
+			code.Make(code.OpReturnValue),
+		},
+	}
+	expectedInstructions := []code.Instructions{
+		// 0000
+		code.Make(code.OpImport, 15, 0, 3),
+		// 0007
+		code.Make(code.OpCall, 0),
+		// 0009
+		code.Make(code.OpSetGlobal, 0),
+		// 0012
+		code.Make(code.OpGetGlobal, 0),
+		// 0015
+		code.Make(code.OpSetGlobal, 1),
+		// 0018
+		code.Make(code.OpImport, 33, 0, 3),
+		// 0025
+		code.Make(code.OpCall, 0),
+		// 0027
+		code.Make(code.OpSetGlobal, 0),
+		// 0030
+		code.Make(code.OpGetGlobal, 0),
+		// 0033
+		code.Make(code.OpSetGlobal, 2),
+		// 0036
+		code.Make(code.OpGetGlobal, 1),
+		// 0039
+		code.Make(code.OpPop),
+	}
+
+	err = testInstructions(expectedInstructions, bytecode.Instructions)
+	if err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+	err = testConstants(t, expectedConstants, bytecode.Constants)
+	if err != nil {
+		t.Fatalf("testConstants failed: %s", err)
+	}
+	if fn := bytecode.Constants[3].(*object.CompiledFunction); fn.Module != "lib/one.hk" || fn.Name != "<module lib/one.hk>" {
+		t.Errorf("wrong module function. got Module=%q, Name=%q", fn.Module, fn.Name)
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	files := fstest.MapFS{
+		"lib/bad.hk":    {Data: []byte(`let = 1;`)},
+		"lib/secret.hk": {Data: []byte(`export let get = fn() { secret };`)},
+		"lib/uses.hk":   {Data: []byte(`import "./secret.hk" as s;`)},
+		"lib/nested.hk": {Data: []byte(`if (true) { export let a = 1; }`)},
+		"lib/early.hk":  {Data: []byte(`let x = error("no")?;`)},
+		"cycle/a.hk":    {Data: []byte(`import "./b.hk" as b;`)},
+		"cycle/b.hk":    {Data: []byte(`import "./a.hk" as a;`)},
+		"cycle/main.hk": {Data: []byte(`import "main.hk" as m;`)},
+		"main.hk":       {Data: []byte(`import "cycle/main.hk" as m;`)},
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`import "nope.hk" as n;`, `cannot find module "nope.hk"`},
+		{`import "lib/bad.hk" as b;`, "lib/bad.hk: parser errors:\n\texpected next token to be IDENTIFIER, got = instead\n\tno prefix function found for ="},
+		{`let secret = 1; import "lib/secret.hk" as s;`, "lib/secret.hk: undefined variable secret"},
+		{`import "lib/uses.hk" as u;`, "lib/secret.hk: undefined variable secret"},
+		{`import "lib/nested.hk" as n;`, "lib/nested.hk: parser errors:\n\texport is only allowed at the top level of a module"},
+		{`import "lib/early.hk" as e;`, "lib/early.hk: line 1: ? outside of a function"},
+		{`import "cycle/a.hk" as a;`, "cycle/b.hk: import cycle: cycle/a.hk -> cycle/b.hk -> cycle/a.hk"},
+		{`import "cycle/main.hk" as m;`, "cycle/main.hk: import cycle: main.hk -> cycle/main.hk -> main.hk"},
+	}
+
+	for _, tt := range tests {
+		_, err := compileImporting(tt.input, files)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%q: wrong error.\nwant=%q\ngot=%v", tt.input, tt.expected, err)
+		}
+	}
+
+	err := New().Compile(parse(`import "lib/one.hk" as one;`))
+	if err == nil || err.Error() != `cannot import "lib/one.hk", imports are not enabled` {
+		t.Errorf("wrong error without imports enabled. got=%v", err)
+	}
+}
@@ -0,0 +1,184 @@
+package compiler
+
+import (
+	"Hulk/code"
+	"Hulk/object"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Disassemble writes a listing of the main program followed by every
+// function in the constant pool. Constant operands are shown with their
+// values, jump and handler targets as labels, and when the bytecode carries
+// debug info each run of instructions is preceded by the source line it came
+// from. Functions with try expressions end with their handler table.
+func (b *Bytecode) Disassemble(w io.Writer) {
+	var source []string
+	modules := map[string][]string{}
+	if b.Debug != nil {
+		source = strings.Split(b.Debug.Source, "\n")
+		for file, text := range b.Debug.Modules {
+			modules[file] = strings.Split(text, "\n")
+		}
+	}
+
+	fmt.Fprintln(w, "main:")
+	b.disassembleFunction(w, b.Instructions, b.Positions, b.Handlers, source)
+
+	for i, constant := range b.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+		params := fmt.Sprintf("parameters=%d", fn.NumParameters)
+		if fn.NumDefaults > 0 {
+			params += fmt.Sprintf(", defaults=%d", fn.NumDefaults)
+		}
+		if fn.Variadic {
+			params += ", variadic"
+		}
+		if fn.Module == "" {
+			fmt.Fprintf(w, "\nfn#%d (%s, locals=%d):\n", i, params, fn.NumLocals)
+			b.disassembleFunction(w, fn.Instructions, fn.Positions, fn.Handlers, source)
+			continue
+		}
+		fmt.Fprintf(w, "\nfn#%d (%s, locals=%d, module %s):\n", i, params, fn.NumLocals, fn.Module)
+		b.disassembleFunction(w, fn.Instructions, fn.Positions, fn.Handlers, modules[fn.Module])
+	}
+}
+
+func (b *Bytecode) disassembleFunction(w io.Writer, ins code.Instructions, positions code.PositionTable, handlers code.HandlerTable, source []string) {
+	labels := jumpLabels(ins, handlers)
+	lastLine := 0
+
+	i := 0
+	for i < len(ins) {
+		if line := positions.LineFor(i); line != lastLine && line > 0 && line <= len(source) {
+			fmt.Fprintf(w, "%6d| %s\n", line, strings.TrimSpace(source[line-1]))
+			lastLine = line
+		}
+		if label, ok := labels[i]; ok {
+			fmt.Fprintf(w, "%s:\n", label)
+		}
+
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(w, "  %04d ERROR: %s\n", i, err)
+			i++
+			continue
+		}
+
+		width := 0
+		for _, w := range def.OperandWidths {
+			width += w
+		}
+		if i+1+width > len(ins) {
+			fmt.Fprintf(w, "  %04d ERROR: %s is missing its operands\n", i, def.Name)
+			return
+		}
+
+		operands, read := code.ReadOperands(def, ins[i+1:])
+		fmt.Fprintf(w, "  %04d %s\n", i, b.fmtInstruction(def, code.Opcode(ins[i]), operands, labels))
+		i += 1 + read
+	}
+
+	//a jump past the last instruction still needs its label printed
+	if label, ok := labels[len(ins)]; ok {
+		fmt.Fprintf(w, "%s:\n", label)
+	}
+
+	if len(handlers) > 0 {
+		fmt.Fprintln(w, "handlers:")
+	}
+	for _, h := range handlers {
+		kind := "catch"
+		if h.Finally {
+			kind = "finally"
+		}
+		fmt.Fprintf(w, "  %04d-%04d %s %s depth=%d\n", h.Start, h.End, kind, labels[h.Target], h.Depth)
+	}
+}
+
+func (b *Bytecode) fmtInstruction(def *code.Definition, op code.Opcode, operands []int, labels map[int]string) string {
+	if code.IsJump(op) {
+		parts := []string{def.Name, labels[operands[0]]}
+		for _, operand := range operands[1:] {
+			parts = append(parts, fmt.Sprint(operand))
+		}
+		return strings.Join(parts, " ")
+	}
+
+	switch op {
+	case code.OpConstant, code.OpConstantAdd, code.OpConstantSub, code.OpMethod, code.OpGetMember, code.OpSetMember:
+		return fmt.Sprintf("%s %d ; %s", def.Name, operands[0], b.describeConstant(operands[0]))
+	case code.OpClosure, code.OpStruct, code.OpEnum:
+		return fmt.Sprintf("%s %d %d ; %s", def.Name, operands[0], operands[1], b.describeConstant(operands[0]))
+	}
+
+	parts := []string{def.Name}
+	for _, operand := range operands {
+		parts = append(parts, fmt.Sprint(operand))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (b *Bytecode) describeConstant(index int) string {
+	if index >= len(b.Constants) {
+		return "<missing constant>"
+	}
+
+	switch constant := b.Constants[index].(type) {
+	case *object.String:
+		return fmt.Sprintf("%q", constant.Value)
+	case *object.CompiledFunction:
+		return fmt.Sprintf("fn#%d", index)
+	default:
+		return constant.Inspect()
+	}
+}
+
+// jumpLabels names every jump and handler target L1, L2, ... in the order
+// they appear.
+func jumpLabels(ins code.Instructions, handlers code.HandlerTable) map[int]string {
+	targets := []int{}
+	seen := map[int]bool{}
+	for _, h := range handlers {
+		if !seen[h.Target] {
+			seen[h.Target] = true
+			targets = append(targets, h.Target)
+		}
+	}
+
+	i := 0
+	for i < len(ins) {
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			i++
+			continue
+		}
+		width := 0
+		for _, w := range def.OperandWidths {
+			width += w
+		}
+		if i+1+width > len(ins) {
+			break
+		}
+
+		operands, read := code.ReadOperands(def, ins[i+1:])
+		op := code.Opcode(ins[i])
+		if code.IsJump(op) && !seen[operands[0]] {
+			seen[operands[0]] = true
+			targets = append(targets, operands[0])
+		}
+		i += 1 + read
+	}
+
+	sort.Ints(targets)
+	labels := make(map[int]string, len(targets))
+	for n, target := range targets {
+		labels[target] = fmt.Sprintf("L%d", n+1)
+	}
+	return labels
+}
@@ -0,0 +1,143 @@
+package compiler
+
+import (
+	"Hulk/code"
+	"Hulk/object"
+	"bytes"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestSourcePositions(t *testing.T) {
+	input := `let a = 1;
+let f = fn(x) {
+  let y = x;
+
+  y * a
+};
+f(2)`
+
+	comp := New()
+	err := comp.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := comp.Bytecode()
+
+	//main: OpConstant, OpSetGlobal | OpClosure, OpSetGlobal | OpGetGlobal, ...
+	expectedMain := code.PositionTable{{Offset: 0, Line: 1}, {Offset: 6, Line: 2}, {Offset: 13, Line: 7}}
+	if !reflect.DeepEqual(bytecode.Positions, expectedMain) {
+		t.Fatalf("wrong main positions. want=%v, got=%v", expectedMain, bytecode.Positions)
+	}
+
+	fn := bytecode.Constants[1].(*object.CompiledFunction)
+	//OpGetLocal, OpSetLocal | OpGetLocal, OpGetGlobal, OpMul, OpReturnValue
+	expectedFn := code.PositionTable{{Offset: 0, Line: 3}, {Offset: 4, Line: 5}}
+	if !reflect.DeepEqual(fn.Positions, expectedFn) {
+		t.Fatalf("wrong function positions. want=%v, got=%v", expectedFn, fn.Positions)
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	input := `let s = "hi";
+if (true) { s } else { fn() { 1 } };`
+
+	comp := New()
+	err := comp.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := comp.Bytecode()
+
+	expected := `main:
+     1| let s = "hi";
+  0000 OpConstant 0 ; "hi"
+  0003 OpSetGlobal 0
+     2| if (true) { s } else { fn() { 1 } };
+  0006 OpTrue
+  0007 OpJumpNotTruthy L1
+  0010 OpGetGlobal 0
+  0013 OpJump L2
+L1:
+  0016 OpClosure 2 0 ; fn#2
+L2:
+  0020 OpPop
+
+fn#2 (parameters=0, locals=0):
+     2| if (true) { s } else { fn() { 1 } };
+  0000 OpConstant 1 ; 1
+  0003 OpReturnValue
+`
+
+	var out bytes.Buffer
+	bytecode.Debug = &DebugInfo{SourceName: "test.hk", Source: input}
+	bytecode.Disassemble(&out)
+	if out.String() != expected {
+		t.Fatalf("wrong listing.\nwant=\n%s\ngot=\n%s", expected, out.String())
+	}
+
+	//without debug info the listing has no source lines
+	out.Reset()
+	bytecode.Debug = nil
+	bytecode.Disassemble(&out)
+	if bytes.Contains(out.Bytes(), []byte("|")) {
+		t.Fatalf("expected no source lines, got=\n%s", out.String())
+	}
+
+	//undefined opcodes are reported and skipped instead of looping forever
+	out.Reset()
+	broken := &Bytecode{Instructions: code.Instructions{255, byte(code.OpPop), byte(code.OpJump)}}
+	broken.Disassemble(&out)
+	expectedBroken := `main:
+  0000 ERROR: Opcode 255 undefined
+  0001 OpPop
+  0002 ERROR: OpJump is missing its operands
+`
+	if out.String() != expectedBroken {
+		t.Fatalf("wrong listing.\nwant=\n%s\ngot=\n%s", expectedBroken, out.String())
+	}
+}
+
+func TestDisassembleModules(t *testing.T) {
+	module := "let b = 2;\nexport let a = fn() { b };"
+	bytecode, err := compileImporting(`import "lib/one.hk" as one;`, fstest.MapFS{"lib/one.hk": {Data: []byte(module)}})
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	expected := `main:
+     1| import "lib/one.hk" as one;
+  0000 OpImport L1 0 4
+  0007 OpCall 0
+  0009 OpSetGlobal 0
+  0012 OpGetGlobal 0
+L1:
+  0015 OpSetGlobal 1
+
+fn#1 (parameters=0, locals=0, module lib/one.hk):
+     2| export let a = fn() { b };
+  0000 OpGetFree 0
+  0002 OpReturnValue
+
+fn#4 (parameters=0, locals=2, module lib/one.hk):
+     1| let b = 2;
+  0000 OpConstant 0 ; 2
+  0003 OpSetLocal 0
+     2| export let a = fn() { b };
+  0005 OpGetLocal 0
+  0007 OpClosure 1 1 ; fn#1
+  0011 OpSetLocal 1
+  0013 OpConstant 2 ; "a"
+  0016 OpGetLocal 1
+  0018 OpModule 3 1
+  0023 OpReturnValue
+`
+
+	var out bytes.Buffer
+	bytecode.Debug = &DebugInfo{SourceName: "main.hk", Source: `import "lib/one.hk" as one;`, Modules: map[string]string{"lib/one.hk": module}}
+	bytecode.Disassemble(&out)
+	if out.String() != expected {
+		t.Fatalf("wrong listing.\nwant=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
@@ -0,0 +1,22 @@
+package compiler
+
+import (
+	"Hulk/ast"
+	"Hulk/code"
+)
+
+// compileEnum binds the name of the enum to its type, built from an array
+// for each variant holding its name and the names of its fields.
+func (c *Compiler) compileEnum(node *ast.EnumStatement) error {
+	symbol := c.symbolTable.Define(node.Name.Value)
+	for _, variant := range node.Variants {
+		c.emit(code.OpConstant, c.stringConstant(variant.Name.Value))
+		for _, field := range variant.Fields {
+			c.emit(code.OpConstant, c.stringConstant(field.Value))
+		}
+		c.emit(code.OpArray, len(variant.Fields)+1)
+	}
+	c.emit(code.OpEnum, c.stringConstant(node.Name.Value), len(node.Variants))
+	c.setSymbol(symbol)
+	return nil
+}
@@ -0,0 +1,303 @@
+package compiler
+
+import (
+	"Hulk/ast"
+	"Hulk/code"
+)
+
+// A try expression compiles to
+//
+//	try block                 protected, errors go to the catch clause
+//	OpJump end
+//	catch:  set the catch parameter
+//	catch block               protected when there is a finally block
+//	end:    finally block
+//	OpJump done
+//	finally: finally block    errors in the protected code go here
+//	OpThrow                   throw the error again
+//	done:
+//
+// The protected instructions are recorded in the handler table of the
+// function rather than set up by instructions, so code that does not throw
+// pays nothing for being inside a try. A return from inside a try runs the
+// finally blocks it leaves right before it returns.
+
+// tryBlock is a try expression being compiled.
+type tryBlock struct {
+	node  *ast.TryExpression
+	start int //the stack depth here is the depth of all its handlers
+
+	protecting bool
+	from       int      //start of the instructions being protected
+	runs       [][2]int //instructions protected so far, from start to end
+}
+
+func (t *tryBlock) protect(pos int) {
+	t.protecting = true
+	t.from = pos
+}
+
+func (t *tryBlock) unprotect(pos int) {
+	if !t.protecting {
+		return
+	}
+	if pos > t.from {
+		t.runs = append(t.runs, [2]int{t.from, pos})
+	}
+	t.protecting = false
+}
+
+// take returns the instructions protected so far and starts over.
+func (t *tryBlock) take() [][2]int {
+	runs := t.runs
+	t.runs = nil
+	return runs
+}
+
+// pendingHandler is a handler whose depth is only known once the function
+// it is in has been compiled.
+type pendingHandler struct {
+	code.Handler
+	tryStart int
+}
+
+func (c *Compiler) compileTry(node *ast.TryExpression) error {
+	t := &tryBlock{node: node, start: len(c.currentInstructions())}
+	c.scopes[c.scopeIndex].trys = append(c.scopes[c.scopeIndex].trys, t)
+
+	t.protect(len(c.currentInstructions()))
+	err := c.compileBlockValue(node.Block)
+	if err != nil {
+		return err
+	}
+	t.unprotect(len(c.currentInstructions()))
+	unhandled := t.take()
+
+	jumpPos := c.emit(code.OpJump, 9999)
+
+	if node.Catch != nil {
+		c.addHandlers(t, unhandled, false)
+		c.setSymbol(c.symbolTable.Define(node.CatchParam.Value))
+
+		if node.Finally != nil {
+			t.protect(len(c.currentInstructions()))
+		}
+		err := c.compileBlockValue(node.Catch)
+		if err != nil {
+			return err
+		}
+		t.unprotect(len(c.currentInstructions()))
+		unhandled = t.take()
+	}
+
+	trys := c.scopes[c.scopeIndex].trys
+	c.scopes[c.scopeIndex].trys = trys[:len(trys)-1]
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	if node.Finally == nil {
+		return nil
+	}
+
+	err = c.Compile(node.Finally)
+	if err != nil {
+		return err
+	}
+	jumpPos = c.emit(code.OpJump, 9999)
+
+	c.addHandlers(t, unhandled, true)
+	err = c.Compile(node.Finally)
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpThrow)
+
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+// addHandlers sends the errors raised by runs of instructions of t to the
+// next instruction emitted.
+func (c *Compiler) addHandlers(t *tryBlock, runs [][2]int, finally bool) {
+	target := len(c.currentInstructions())
+	for _, run := range runs {
+		handler := code.Handler{Start: run[0], End: run[1], Target: target, Finally: finally}
+		c.scopes[c.scopeIndex].handlers = append(c.scopes[c.scopeIndex].handlers, pendingHandler{handler, t.start})
+	}
+}
+
+// compileBlockValue compiles a block whose value is used, which is null when
+// the block does not end in an expression.
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	err := c.Compile(block)
+	if err != nil {
+		return err
+	}
+
+	if c.lastInstructionIs(code.OpPop) && len(block.Statements) > 0 {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
+	return nil
+}
+
+// leaveTrys is called by a return from inside try expressions, with the
+// return value on the stack. It runs the finally blocks of the try
+// expressions being left, innermost first, each one protected only by the
+// try expressions around it. The returned function protects the code after
+// the return again.
+func (c *Compiler) leaveTrys() (func(), error) {
+	trys := c.scopes[c.scopeIndex].trys
+
+	left := []*tryBlock{}
+	for i := len(trys) - 1; i >= 0; i-- {
+		t := trys[i]
+		if t.protecting {
+			t.unprotect(len(c.currentInstructions()))
+			left = append(left, t)
+		}
+		if t.node.Finally == nil {
+			continue
+		}
+
+		//a return in the finally block only leaves the try expressions
+		//around this one
+		c.scopes[c.scopeIndex].trys = trys[:i]
+		err := c.Compile(t.node.Finally)
+		c.scopes[c.scopeIndex].trys = trys
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return func() {
+		for _, t := range left {
+			t.protect(len(c.currentInstructions()))
+		}
+	}, nil
+}
+
+// resolveHandlers works out the stack depth of every handler, which is the
+// depth at the start of its try expression, by following the instructions
+// from the start of the function and from every handler.
+func resolveHandlers(ins code.Instructions, pending []pendingHandler) code.HandlerTable {
+	if len(pending) == 0 {
+		return nil
+	}
+
+	depths := map[int]int{0: 0}
+	work := []int{0}
+	seeded := make([]bool, len(pending))
+
+	for len(work) > 0 {
+		for len(work) > 0 {
+			offset := work[len(work)-1]
+			work = work[:len(work)-1]
+			work = followDepths(ins, offset, depths, work)
+		}
+
+		//a handler only runs once its try expression has been reached
+		for i, p := range pending {
+			depth, ok := depths[p.tryStart]
+			if seeded[i] || !ok {
+				continue
+			}
+			seeded[i] = true
+			if _, ok := depths[p.Target]; !ok {
+				depths[p.Target] = depth + 1
+				work = append(work, p.Target)
+			}
+		}
+	}
+
+	table := make(code.HandlerTable, len(pending))
+	for i, p := range pending {
+		table[i] = p.Handler
+		table[i].Depth = depths[p.tryStart]
+	}
+	return table
+}
+
+// followDepths records the stack depth before each instruction from offset
+// on, until the code ends or reaches instructions already visited. Jump
+// targets seen on the way are added to work.
+func followDepths(ins code.Instructions, offset int, depths map[int]int, work []int) []int {
+	depth := depths[offset]
+	for offset < len(ins) {
+		def, err := code.Lookup(ins[offset])
+		if err != nil {
+			return work
+		}
+		operands, read := code.ReadOperands(def, ins[offset+1:])
+		op := code.Opcode(ins[offset])
+
+		effect, ends := stackEffect(op, operands)
+		depth += effect
+		if code.IsJump(op) {
+			if _, ok := depths[operands[0]]; !ok {
+				depths[operands[0]] = depth
+				work = append(work, operands[0])
+			}
+		}
+		if ends || op == code.OpJump {
+			return work
+		}
+
+		offset += 1 + read
+		if _, ok := depths[offset]; ok {
+			return work
+		}
+		depths[offset] = depth
+	}
+	return work
+}
+
+// stackEffect returns how many values op adds to the stack, negative when it
+// takes them away, and whether it ends the code running in the frame.
+func stackEffect(op code.Opcode, operands []int) (int, bool) {
+	switch op {
+	case code.OpConstant, code.OpNull, code.OpTrue, code.OpFalse, code.OpGetGlobal,
+		code.OpGetLocal, code.OpGetBuiltin, code.OpGetFree, code.OpCurrentClosure,
+		code.OpGetLocalGetLocalAdd:
+		return 1, false
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpEqual, code.OpNotEqual,
+		code.OpGreaterThan, code.OpIndex, code.OpPop, code.OpSetGlobal, code.OpSetLocal,
+		code.OpJumpNotTruthy, code.OpAddInt, code.OpSubInt, code.OpGreaterThanInt:
+		return -1, false
+	case code.OpJumpNotGreater, code.OpJumpNotEqual:
+		return -2, false
+	case code.OpSlice:
+		return -3, false
+	case code.OpArray, code.OpHash:
+		return 1 - operands[0], false
+	case code.OpClosure:
+		return 1 - operands[1], false
+	case code.OpCall, code.OpCallSpread:
+		return -operands[0], false
+	case code.OpDestructureArray:
+		return operands[0] + operands[1] - 1, false
+	case code.OpDestructureHash:
+		return -1, false
+	case code.OpMatchEqual:
+		return -1, false
+	case code.OpMatchArray:
+		return 0, false
+	case code.OpMatchHash:
+		return -operands[0], false
+	case code.OpImport:
+		return 1, false
+	case code.OpModule:
+		return 1 - 2*operands[1], false
+	case code.OpStruct, code.OpEnum:
+		return 1 - operands[1], false
+	case code.OpMethod:
+		return -2, false
+	case code.OpSetMember:
+		return -1, false
+	case code.OpReturnValue, code.OpReturn, code.OpTailCall, code.OpThrow, code.OpNoMatch:
+		return 0, true
+	}
+	//OpMinus, OpBang, OpJump, OpJumpNotError, OpJumpArgGiven, OpConstantAdd,
+	//OpConstantSub and OpGetMember
+	return 0, false
+}
@@ -0,0 +1,174 @@
+package compiler
+
+import (
+	"Hulk/ast"
+	"Hulk/code"
+	"Hulk/loader"
+	"Hulk/object"
+	"Hulk/optimizer"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Every module imported by a program is compiled into it once, as a
+// function without parameters whose locals are the bindings of the module
+// and which returns the module. The module is kept in a hidden global, so
+// it only runs the first time it is imported. An import compiles to
+//
+//	OpImport loaded, global, fn    push the module, or a closure of fn
+//	OpCall 0                       run the module
+//	OpSetGlobal global
+//	OpGetGlobal global
+//	loaded: bind the module to its name
+//
+// Since all modules are compiled along with the program, the bytecode does
+// not need the files of the modules to run.
+
+// imports is what a compiler that can import modules knows about them.
+type imports struct {
+	loader  *loader.Loader
+	globals *SymbolTable
+
+	//constant index of the function of every module compiled so far
+	modules map[string]int
+	//files of the modules being compiled, each imported by the one before it
+	loading []string
+}
+
+// moduleError is an error in the code of a module, which is reported along
+// with its file.
+type moduleError struct {
+	file string
+	err  error
+}
+
+func (e *moduleError) Error() string {
+	return fmt.Sprintf("%s: %s", e.file, e.err)
+}
+
+// EnableImports lets the program compiled, which was read from file or
+// from nowhere when file is empty, import modules found through l. It must
+// be called while the compiler is at the top level of the program, after
+// NewWithState if that is used.
+func (c *Compiler) EnableImports(l *loader.Loader, file string) {
+	c.imports = &imports{loader: l, globals: c.symbolTable, modules: map[string]int{}}
+	if file != "" {
+		//a module importing the program back is a cycle too
+		c.imports.loading = []string{filepath.Clean(file)}
+	}
+	c.file = file
+}
+
+func (c *Compiler) compileImport(node *ast.ImportStatement) error {
+	if c.imports == nil {
+		return fmt.Errorf("cannot import %q, imports are not enabled", node.Path)
+	}
+	file, program, err := c.imports.loader.Load(node.Path, c.file)
+	if err != nil {
+		return err
+	}
+	fnIndex, err := c.compileModule(file, program)
+	if err != nil {
+		return err
+	}
+
+	//a REPL compiles each line on its own, but all lines share the globals
+	name := "[module " + file + "]"
+	global, ok := c.imports.globals.store[name]
+	if !ok {
+		global = c.imports.globals.Define(name)
+	}
+
+	importPos := c.emit(code.OpImport, 9999, global.Index, fnIndex)
+	c.emit(code.OpCall, 0)
+	c.emit(code.OpSetGlobal, global.Index)
+	c.emit(code.OpGetGlobal, global.Index)
+	c.replaceInstruction(importPos, code.Make(code.OpImport, len(c.currentInstructions()), global.Index, fnIndex))
+
+	c.setSymbol(c.symbolTable.Define(node.Name.Value))
+	return nil
+}
+
+// compileModule compiles the module in file, unless it already was, and
+// returns the constant index of its function.
+func (c *Compiler) compileModule(file string, program *ast.Program) (int, error) {
+	if index, ok := c.imports.modules[file]; ok {
+		return index, nil
+	}
+	for i, loading := range c.imports.loading {
+		if loading == file {
+			return 0, fmt.Errorf("import cycle: %s", strings.Join(append(c.imports.loading[i:], file), " -> "))
+		}
+	}
+
+	c.imports.loading = append(c.imports.loading, file)
+	defer func() { c.imports.loading = c.imports.loading[:len(c.imports.loading)-1] }()
+
+	if c.optimize {
+		optimizer.Optimize(program)
+	}
+
+	//the module sees the builtins, but nothing of the code importing it
+	outerTable, outerFile, outerModule, outerLine, outerDepth := c.symbolTable, c.file, c.module, c.line, c.matchDepth
+	c.enterScope()
+	c.symbolTable = NewEnclosedSymbolTable(builtinSymbols())
+	c.file, c.module, c.line, c.matchDepth = file, file, 0, 0
+
+	exports := []string{}
+	var err error
+	for _, s := range program.Statements {
+		err = c.Compile(s)
+		if err != nil {
+			break
+		}
+		if export, ok := s.(*ast.ExportStatement); ok {
+			for _, name := range export.Names() {
+				exports = append(exports, name.Value)
+			}
+		}
+	}
+
+	if err == nil {
+		for _, name := range exports {
+			c.emit(code.OpConstant, c.stringConstant(name))
+			symbol, _ := c.symbolTable.Resolve(name)
+			c.loadSymbol(symbol)
+		}
+		c.emit(code.OpModule, c.stringConstant(file), len(exports))
+		c.emit(code.OpReturnValue)
+	}
+
+	numLocals := c.symbolTable.numDefinitions
+	positions := c.scopes[c.scopeIndex].positions
+	pending := c.scopes[c.scopeIndex].handlers
+	instructions := c.leaveScope()
+	c.symbolTable, c.file, c.module, c.line, c.matchDepth = outerTable, outerFile, outerModule, outerLine, outerDepth
+
+	if err != nil {
+		var inner *moduleError
+		if errors.As(err, &inner) {
+			return 0, err
+		}
+		return 0, &moduleError{file: file, err: err}
+	}
+
+	handlers := resolveHandlers(instructions, pending)
+	markTailCalls(instructions, handlers)
+	if c.optimize {
+		instructions, positions, handlers = peephole(instructions, positions, handlers, false)
+	}
+
+	fn := &object.CompiledFunction{
+		Instructions: instructions,
+		NumLocals:    numLocals,
+		Positions:    positions,
+		Handlers:     handlers,
+		Name:         object.ModuleFunction(file),
+		Module:       file,
+	}
+	index := c.addConstant(fn)
+	c.imports.modules[file] = index
+	return index, nil
+}
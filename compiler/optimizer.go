@@ -0,0 +1,299 @@
+package compiler
+
+import (
+	"Hulk/ast"
+	"Hulk/code"
+)
+
+// EnableOptimizations runs the AST optimizer over programs before compiling
+// them, folds constants while compiling and runs a peephole pass over the
+// instructions of every function and the main program. Optimized bytecode
+// produces the same results as unoptimized bytecode, it just gets there in
+// fewer instructions.
+func (c *Compiler) EnableOptimizations() {
+	c.optimize = true
+}
+
+// integerOps maps the opcodes that have a variant specialized for integers
+// to that variant.
+var integerOps = map[code.Opcode]code.Opcode{
+	code.OpAdd:         code.OpAddInt,
+	code.OpSub:         code.OpSubInt,
+	code.OpGreaterThan: code.OpGreaterThanInt,
+}
+
+// integerOp picks the opcode for an infix expression. When optimizing and
+// one side is known to be an integer, the other one almost certainly is too,
+// so the integer variant is used. The VM falls back to the generic operation
+// when the guess is wrong.
+func (c *Compiler) integerOp(node *ast.InfixExpression, op code.Opcode) code.Opcode {
+	specialized, ok := integerOps[op]
+	if ok && c.optimize && (knownInteger(node.LeftExpr) || knownInteger(node.RightExpr)) {
+		return specialized
+	}
+	return op
+}
+
+// knownInteger reports whether node evaluates to an integer whenever it
+// evaluates without an error.
+func knownInteger(node ast.Expression) bool {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		return true
+	case *ast.PrefixExpression:
+		return node.Operator == "-"
+	case *ast.InfixExpression:
+		switch node.Operator {
+		case "+", "-", "*", "/":
+			return knownInteger(node.LeftExpr) || knownInteger(node.RightExpr)
+		}
+	}
+	return false
+}
+
+// superinstruction replaces a sequence of instructions matching pattern by
+// a single op taking all of their operands. Each element of pattern lists
+// the opcodes accepted at that position.
+type superinstruction struct {
+	pattern [][]code.Opcode
+	op      code.Opcode
+}
+
+var superinstructions = []superinstruction{
+	{[][]code.Opcode{{code.OpGetLocal}, {code.OpGetLocal}, {code.OpAdd, code.OpAddInt}}, code.OpGetLocalGetLocalAdd},
+	{[][]code.Opcode{{code.OpConstant}, {code.OpAdd, code.OpAddInt}}, code.OpConstantAdd},
+	{[][]code.Opcode{{code.OpConstant}, {code.OpSub, code.OpSubInt}}, code.OpConstantSub},
+	{[][]code.Opcode{{code.OpGreaterThan, code.OpGreaterThanInt}, {code.OpJumpNotTruthy}}, code.OpJumpNotGreater},
+	{[][]code.Opcode{{code.OpEqual}, {code.OpJumpNotTruthy}}, code.OpJumpNotEqual},
+}
+
+type peepholeInstruction struct {
+	op       code.Opcode
+	operands []int
+	offset   int
+	removed  bool
+}
+
+// pushes lists the instructions that only push a value, so following one of
+// them with OpPop does nothing at all
+var pushes = map[code.Opcode]bool{
+	code.OpConstant:       true,
+	code.OpTrue:           true,
+	code.OpFalse:          true,
+	code.OpNull:           true,
+	code.OpGetGlobal:      true,
+	code.OpGetLocal:       true,
+	code.OpGetFree:        true,
+	code.OpGetBuiltin:     true,
+	code.OpCurrentClosure: true,
+}
+
+// peephole rewrites the instructions of one function:
+//
+//   - jumps that land on an OpJump go straight to its target
+//   - code after OpJump, OpReturn, OpReturnValue, OpThrow or OpNoMatch that
+//     nothing jumps to is dropped
+//   - an OpJump to the very next instruction is dropped
+//   - a push immediately popped again is dropped
+//   - common sequences are fused into superinstructions
+//
+// The main program keeps its final OpPop, since that is where the REPL and
+// the tests pick up the value of the last expression. Jump operands, the
+// position table and the handler table are moved to the new offsets. The
+// bounds and targets of handlers count as jump targets, so no code a handler
+// leads to is dropped and no superinstruction straddles a try.
+func peephole(ins code.Instructions, positions code.PositionTable, handlers code.HandlerTable, keepFinalPop bool) (code.Instructions, code.PositionTable, code.HandlerTable) {
+	list := []*peepholeInstruction{}
+	at := map[int]*peepholeInstruction{}
+
+	for i := 0; i < len(ins); {
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			//the compiler never emits unknown opcodes, leave such code untouched
+			return ins, positions, handlers
+		}
+		operands, read := code.ReadOperands(def, ins[i+1:])
+		in := &peepholeInstruction{op: code.Opcode(ins[i]), operands: operands, offset: i}
+		list = append(list, in)
+		at[i] = in
+		i += 1 + read
+	}
+
+	for _, in := range list {
+		if !code.IsJump(in.op) {
+			continue
+		}
+		//bounded by the number of instructions in case jumps form a cycle
+		for n := 0; n < len(list); n++ {
+			next, ok := at[in.operands[0]]
+			if !ok || next.op != code.OpJump || next == in {
+				break
+			}
+			in.operands[0] = next.operands[0]
+		}
+	}
+
+	nextLive := func(i int) int {
+		for i++; i < len(list) && list[i].removed; i++ {
+		}
+		return i
+	}
+
+	for changed := true; changed; {
+		changed = false
+
+		targets := jumpTargets(list, handlers)
+
+		for i, in := range list {
+			if in.removed {
+				continue
+			}
+			j := nextLive(i)
+
+			switch {
+			case in.op == code.OpJump || in.op == code.OpReturn || in.op == code.OpReturnValue || in.op == code.OpThrow || in.op == code.OpNoMatch:
+				for ; j < len(list) && !targets[list[j].offset]; j = nextLive(j) {
+					list[j].removed = true
+					changed = true
+				}
+				if in.op == code.OpJump && (j == len(list) && in.operands[0] == len(ins) ||
+					j < len(list) && in.operands[0] == list[j].offset) {
+					in.removed = true
+					changed = true
+				}
+
+			case pushes[in.op] && j < len(list) && list[j].op == code.OpPop:
+				pop := list[j]
+				if targets[in.offset] || targets[pop.offset] {
+					continue
+				}
+				if keepFinalPop && nextLive(j) == len(list) {
+					continue
+				}
+				in.removed = true
+				pop.removed = true
+				changed = true
+			}
+		}
+	}
+
+	fuse(list, jumpTargets(list, handlers), nextLive)
+
+	//removed instructions hand their offset on to the next live one
+	newOffsets := map[int]int{}
+	pending := []int{}
+	out := code.Instructions{}
+	for _, in := range list {
+		pending = append(pending, in.offset)
+		if in.removed {
+			continue
+		}
+		for _, offset := range pending {
+			newOffsets[offset] = len(out)
+		}
+		pending = pending[:0]
+		out = append(out, code.Make(in.op, in.operands...)...)
+	}
+	pending = append(pending, len(ins))
+	for _, offset := range pending {
+		newOffsets[offset] = len(out)
+	}
+
+	for i := 0; i < len(out); {
+		op := code.Opcode(out[i])
+		def, _ := code.Lookup(out[i])
+		operands, read := code.ReadOperands(def, out[i+1:])
+		if code.IsJump(op) {
+			operands[0] = newOffsets[operands[0]]
+			copy(out[i:], code.Make(op, operands...))
+		}
+		i += 1 + read
+	}
+
+	var newPositions code.PositionTable
+	for _, pos := range positions {
+		offset := newOffsets[pos.Offset]
+		if n := len(newPositions); n > 0 && newPositions[n-1].Offset == offset {
+			newPositions = newPositions[:n-1]
+		}
+		if offset == len(out) {
+			continue
+		}
+		if n := len(newPositions); n > 0 && newPositions[n-1].Line == pos.Line {
+			continue
+		}
+		newPositions = append(newPositions, code.SourcePosition{Offset: offset, Line: pos.Line})
+	}
+
+	var newHandlers code.HandlerTable
+	for _, h := range handlers {
+		h.Start, h.End, h.Target = newOffsets[h.Start], newOffsets[h.End], newOffsets[h.Target]
+		newHandlers = append(newHandlers, h)
+	}
+
+	return out, newPositions, newHandlers
+}
+
+// jumpTargets returns the offsets execution may jump to, counting the
+// bounds of the handlers as well as their targets.
+func jumpTargets(list []*peepholeInstruction, handlers code.HandlerTable) map[int]bool {
+	targets := map[int]bool{}
+	for _, in := range list {
+		if !in.removed && code.IsJump(in.op) {
+			targets[in.operands[0]] = true
+		}
+	}
+	for _, h := range handlers {
+		targets[h.Start] = true
+		targets[h.End] = true
+		targets[h.Target] = true
+	}
+	return targets
+}
+
+// fuse replaces the sequences listed in superinstructions. Only the first
+// instruction of a sequence may be a jump target, since the others are gone
+// once it is fused.
+func fuse(list []*peepholeInstruction, targets map[int]bool, nextLive func(int) int) {
+	for i := 0; i < len(list); i = nextLive(i) {
+		if list[i].removed {
+			continue
+		}
+		for _, super := range superinstructions {
+			matched, ok := match(list, i, super.pattern, targets, nextLive)
+			if !ok {
+				continue
+			}
+
+			operands := []int{}
+			for _, in := range matched {
+				operands = append(operands, in.operands...)
+				in.removed = true
+			}
+			first := matched[0]
+			first.op, first.operands, first.removed = super.op, operands, false
+			break
+		}
+	}
+}
+
+func match(list []*peepholeInstruction, i int, pattern [][]code.Opcode, targets map[int]bool, nextLive func(int) int) ([]*peepholeInstruction, bool) {
+	matched := []*peepholeInstruction{}
+	for n, accepted := range pattern {
+		if i >= len(list) || n > 0 && targets[list[i].offset] || !contains(accepted, list[i].op) {
+			return nil, false
+		}
+		matched = append(matched, list[i])
+		i = nextLive(i)
+	}
+	return matched, true
+}
+
+func contains(ops []code.Opcode, op code.Opcode) bool {
+	for _, o := range ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
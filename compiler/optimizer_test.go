@@ -0,0 +1,261 @@
+package compiler
+
+import (
+	"Hulk/code"
+	"reflect"
+	"testing"
+)
+
+func runOptimizedCompilerTests(t *testing.T, tests []compilerTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		compiler := New()
+		compiler.EnableOptimizations()
+		err := compiler.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := compiler.Bytecode()
+
+		err = testInstructions(tt.expectedInstructions, bytecode.Instructions)
+		if err != nil {
+			t.Fatalf("%s: testInstructions failed: %s", tt.input, err)
+		}
+
+		err = testConstants(t, tt.expectedConstants, bytecode.Constants)
+		if err != nil {
+			t.Fatalf("%s: testConstants failed: %s", tt.input, err)
+		}
+	}
+}
+
+func TestConstantFolding(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 + 2 * 3",
+			expectedConstants: []interface{}{7},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"a" + "b" + "c"`,
+			expectedConstants: []interface{}{"abc"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "-(2 - 5) < 4 == !false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			//the VM fails on these at runtime, so they must not be folded
+			input:             `1 / 0; "a" == "a"`,
+			expectedConstants: []interface{}{1, 0, "a"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDiv),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn(x) { x * (3 + 4) }",
+			expectedConstants: []interface{}{7, []code.Instructions{
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpMul),
+				code.Make(code.OpReturnValue),
+			}},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runOptimizedCompilerTests(t, tests)
+}
+
+func TestPeephole(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			//pushes that are popped straight away disappear, except the last one
+			input:             "len; 2; len",
+			expectedConstants: []interface{}{2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn(a) { a; 2; a }",
+			expectedConstants: []interface{}{2, []code.Instructions{
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpReturnValue),
+			}},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			//nothing runs after a return
+			input: "fn() { return 1; 2; 3 }",
+			expectedConstants: []interface{}{1, 2, 3, []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpReturnValue),
+			}},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			//the inner if jumps to the outer if's OpJump, which is threaded
+			input:             "if (len) { if (first) { 1 } } else { 2 }; 3",
+			expectedConstants: []interface{}{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpGetBuiltin, 0),
+				// 0002
+				code.Make(code.OpJumpNotTruthy, 20),
+				// 0005
+				code.Make(code.OpGetBuiltin, 2),
+				// 0007
+				code.Make(code.OpJumpNotTruthy, 16),
+				// 0010
+				code.Make(code.OpConstant, 0),
+				// 0013
+				code.Make(code.OpJump, 23),
+				// 0016
+				code.Make(code.OpNull),
+				// 0017
+				code.Make(code.OpJump, 23),
+				// 0020
+				code.Make(code.OpConstant, 1),
+				// 0023
+				code.Make(code.OpPop),
+				// 0024
+				code.Make(code.OpConstant, 2),
+				// 0027
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runOptimizedCompilerTests(t, tests)
+}
+
+func TestPeepholePositions(t *testing.T) {
+	input := `let a = len;
+a;
+fn() {
+  return a;
+  a
+}`
+
+	compiler := New()
+	compiler.EnableOptimizations()
+	err := compiler.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	//line 2 is gone entirely, line 3 moves up to take its place
+	expected := code.PositionTable{{Offset: 0, Line: 1}, {Offset: 5, Line: 3}}
+	if !reflect.DeepEqual(bytecode.Positions, expected) {
+		t.Fatalf("wrong positions. want=%v, got=%v", expected, bytecode.Positions)
+	}
+}
+
+func TestSuperinstructions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "fn(n) { if (n < 2) { n } else { n - 1 } }",
+			expectedConstants: []interface{}{2, 1, []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpGetLocal, 0),
+				// 0005
+				code.Make(code.OpJumpNotGreater, 13),
+				// 0008
+				code.Make(code.OpGetLocal, 0),
+				// 0010
+				code.Make(code.OpJump, 18),
+				// 0013
+				code.Make(code.OpGetLocal, 0),
+				// 0015
+				code.Make(code.OpConstantSub, 1),
+				// 0018
+				code.Make(code.OpReturnValue),
+			}},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn(a, b) { if (a == b) { a + b } else { 1 + a } }",
+			expectedConstants: []interface{}{1, []code.Instructions{
+				// 0000
+				code.Make(code.OpGetLocal, 0),
+				// 0002
+				code.Make(code.OpGetLocal, 1),
+				// 0004
+				code.Make(code.OpJumpNotEqual, 13),
+				// 0007
+				code.Make(code.OpGetLocalGetLocalAdd, 0, 1),
+				// 0010
+				code.Make(code.OpJump, 19),
+				// 0013
+				code.Make(code.OpConstant, 0),
+				// 0016
+				code.Make(code.OpGetLocal, 0),
+				// 0018
+				code.Make(code.OpAddInt),
+				// 0019
+				code.Make(code.OpReturnValue),
+			}},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			//the OpGetLocal is a jump target, so it can not be fused away
+			input: `fn(a) { (if (a) { a } else { 2 }) + a }`,
+			expectedConstants: []interface{}{2, []code.Instructions{
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpJumpNotTruthy, 10),
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpJump, 13),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpAdd),
+				code.Make(code.OpReturnValue),
+			}},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runOptimizedCompilerTests(t, tests)
+}
@@ -0,0 +1,226 @@
+package compiler
+
+import (
+	"Hulk/ast"
+	"Hulk/code"
+	"Hulk/object"
+	"fmt"
+)
+
+// compilePattern binds the value on top of the stack to pattern, taking it
+// off the stack, with define giving the variable of each name. An array
+// pattern compiles to
+//
+//	OpDestructureArray n, rest   elements on the stack, the first on top
+//	bind element 0
+//	...
+//	bind element n-1
+//	bind the rest
+//
+// and a hash pattern to its keys as constants followed by
+// OpDestructureHash, which leaves their values the same way.
+func (c *Compiler) compilePattern(pattern ast.Pattern, define func(name string) Symbol) {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		c.setSymbol(define(pattern.Value))
+
+	case *ast.WildcardPattern, *ast.LiteralPattern:
+		c.emit(code.OpPop)
+
+	case *ast.ArrayPattern:
+		rest := 0
+		if pattern.Rest != nil {
+			rest = 1
+		}
+		c.emit(code.OpDestructureArray, len(pattern.Elements), rest)
+		for _, el := range pattern.Elements {
+			c.compilePattern(el, define)
+		}
+		if name := pattern.RestBinding(); name != nil {
+			c.setSymbol(define(name.Value))
+		} else if pattern.Rest != nil {
+			c.emit(code.OpPop)
+		}
+
+	case *ast.HashPattern:
+		for _, key := range pattern.Keys {
+			c.emit(code.OpConstant, c.stringConstant(key.Value))
+		}
+		c.emit(code.OpDestructureHash, len(pattern.Keys))
+		for _, value := range pattern.Values {
+			c.compilePattern(value, define)
+		}
+	}
+}
+
+// compileMatch compiles a match expression to
+//
+//	value, set the subject
+//	arm 0:  tests of the pattern, each OpJumpNotTruthy next
+//	        subject, bind the pattern for the guard
+//	        guard, OpJumpNotTruthy next
+//	        subject, bind the pattern
+//	        body, OpJump end
+//	next:   arm 1 ...
+//	        subject, OpNoMatch
+//	end:
+//
+// The subject is a variable no source can name holding the value, which
+// every test loads again.
+func (c *Compiler) compileMatch(node *ast.MatchExpression) error {
+	err := c.Compile(node.Value)
+	if err != nil {
+		return err
+	}
+	subject := c.matchSubject()
+	c.setSymbol(subject)
+
+	c.matchDepth++
+	defer func() { c.matchDepth-- }()
+
+	ends := []int{}
+	for _, arm := range node.Arms {
+		fails := []int{}
+		err := c.compileMatchTest(arm.Pattern, subject, nil, &fails)
+		if err != nil {
+			return err
+		}
+		if arm.Guard != nil {
+			err := c.compileGuard(arm, subject)
+			if err != nil {
+				return err
+			}
+			fails = append(fails, c.emit(code.OpJumpNotTruthy, 9999))
+		}
+		c.loadSymbol(subject)
+		c.compilePattern(arm.Pattern, c.matchBinding)
+
+		err = c.Compile(arm.Body)
+		if err != nil {
+			return err
+		}
+		ends = append(ends, c.emit(code.OpJump, 9999))
+
+		for _, pos := range fails {
+			c.changeOperand(pos, len(c.currentInstructions()))
+		}
+	}
+
+	c.loadSymbol(subject)
+	c.emit(code.OpNoMatch)
+
+	for _, pos := range ends {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+	return nil
+}
+
+// compileGuard compiles the guard of arm with the names of its pattern bound
+// to variables of their own, which the guard reads and the rest of the
+// program cannot, so that an arm whose guard fails changes no variable.
+func (c *Compiler) compileGuard(arm *ast.MatchArm, subject Symbol) error {
+	shadowed := map[string]Symbol{}
+	for _, name := range ast.PatternNames(arm.Pattern) {
+		if symbol, ok := c.symbolTable.store[name.Value]; ok {
+			shadowed[name.Value] = symbol
+		}
+	}
+	defer func() {
+		for _, name := range ast.PatternNames(arm.Pattern) {
+			if symbol, ok := shadowed[name.Value]; ok {
+				c.symbolTable.store[name.Value] = symbol
+			} else {
+				delete(c.symbolTable.store, name.Value)
+			}
+		}
+	}()
+
+	c.loadSymbol(subject)
+	c.compilePattern(arm.Pattern, c.symbolTable.Define)
+	return c.Compile(arm.Guard)
+}
+
+// matchBinding returns the variable a match arm binds name to. A name that
+// is already a variable of the current scope is bound to that variable,
+// which only changes once the arm is chosen, as in the evaluator; the
+// arms after it still read the variable.
+func (c *Compiler) matchBinding(name string) Symbol {
+	symbol, ok := c.symbolTable.store[name]
+	if ok && (symbol.Scope == GlobalScope || symbol.Scope == LocalScope) {
+		return symbol
+	}
+	return c.symbolTable.Define(name)
+}
+
+// matchSubject returns the variable holding the value of a match
+// expression. Match expressions nested in one another each get their own,
+// those that follow one another share it.
+func (c *Compiler) matchSubject() Symbol {
+	name := fmt.Sprintf("[match %d]", c.matchDepth)
+	if symbol, ok := c.symbolTable.store[name]; ok {
+		return symbol
+	}
+	return c.symbolTable.Define(name)
+}
+
+// compileMatchTest emits the tests of pattern on the part of the subject
+// path leads to, an array index or a hash key per step. Each test jumps away
+// when it fails; their positions are added to fails to be patched.
+func (c *Compiler) compileMatchTest(pattern ast.Pattern, subject Symbol, path []object.Object, fails *[]int) error {
+	switch pattern := pattern.(type) {
+	case *ast.LiteralPattern:
+		c.loadPath(subject, path)
+		err := c.Compile(pattern.Value)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpMatchEqual)
+		*fails = append(*fails, c.emit(code.OpJumpNotTruthy, 9999))
+
+	case *ast.ArrayPattern:
+		rest := 0
+		if pattern.Rest != nil {
+			rest = 1
+		}
+		c.loadPath(subject, path)
+		c.emit(code.OpMatchArray, len(pattern.Elements), rest)
+		*fails = append(*fails, c.emit(code.OpJumpNotTruthy, 9999))
+
+		for i, el := range pattern.Elements {
+			err := c.compileMatchTest(el, subject, append(path[:len(path):len(path)], object.NewInteger(int64(i))), fails)
+			if err != nil {
+				return err
+			}
+		}
+
+	case *ast.HashPattern:
+		c.loadPath(subject, path)
+		for _, key := range pattern.Keys {
+			c.emit(code.OpConstant, c.stringConstant(key.Value))
+		}
+		c.emit(code.OpMatchHash, len(pattern.Keys))
+		*fails = append(*fails, c.emit(code.OpJumpNotTruthy, 9999))
+
+		for i, value := range pattern.Values {
+			key := &object.String{Value: pattern.Keys[i].Value}
+			err := c.compileMatchTest(value, subject, append(path[:len(path):len(path)], key), fails)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Compiler) loadPath(subject Symbol, path []object.Object) {
+	c.loadSymbol(subject)
+	for _, step := range path {
+		switch step := step.(type) {
+		case *object.String:
+			c.emit(code.OpConstant, c.stringConstant(step.Value))
+		default:
+			c.emit(code.OpConstant, c.addConstant(step))
+		}
+		c.emit(code.OpIndex)
+	}
+}
@@ -0,0 +1,382 @@
+package compiler
+
+import (
+	"Hulk/code"
+	"Hulk/object"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+// A compiled file is laid out as
+//
+//	magic    "HKBC"
+//	version  uint16
+//	flags    uint16, FlagDebugInfo when a debug section follows the code
+//	length   uint32, size of the payload
+//	payload  constants, instructions, handlers and the optional debug section
+//	checksum uint32, CRC-32 (IEEE) of the payload
+//
+// A function constant holds its number of locals, parameters and parameters
+// with a default value as uint32, a byte that is 1 when it has a rest
+// parameter, then its instructions and handler table.
+//
+// Handler tables follow the instructions of each function and of the main
+// program: a count, then start, end, target and depth as uint32 and a byte
+// that is 1 for finally handlers.
+//
+// The debug section holds the source name and text, the number of modules
+// followed by the file and source text of each one, ordered by file, the
+// position table of the main program and then the position table, name and
+// module of each function constant, in constant pool order.
+//
+// All integers are big endian, like instruction operands. Builtins are
+// referenced by their index in the builtins registry, which only ever grows
+// at the end, so older files keep running on newer interpreters. New opcodes
+// are added at the end for the same reason.
+
+const (
+	FormatVersion uint16 = 6
+
+	FlagDebugInfo uint16 = 1 << 0
+)
+
+var magic = [4]byte{'H', 'K', 'B', 'C'}
+
+// constant pool entry tags
+const (
+	tagInteger byte = iota + 1
+	tagString
+	tagFunction
+)
+
+var ErrBadMagic = errors.New("not a Hulk bytecode file")
+
+// DebugInfo carries what a compiled file needs to point back at its source.
+type DebugInfo struct {
+	SourceName string
+	Source     string
+	Modules    map[string]string //source text of the modules imported, by file
+}
+
+// Encode writes the bytecode in the versioned binary format described above.
+func (b *Bytecode) Encode(w io.Writer) error {
+	var payload bytes.Buffer
+
+	writeUint32(&payload, uint32(len(b.Constants)))
+	for i, constant := range b.Constants {
+		err := writeConstant(&payload, constant)
+		if err != nil {
+			return fmt.Errorf("constant %d: %s", i, err)
+		}
+	}
+
+	writeBytes(&payload, b.Instructions)
+	writeHandlers(&payload, b.Handlers)
+
+	var flags uint16
+	if b.Debug != nil {
+		flags |= FlagDebugInfo
+		writeBytes(&payload, []byte(b.Debug.SourceName))
+		writeBytes(&payload, []byte(b.Debug.Source))
+		files := make([]string, 0, len(b.Debug.Modules))
+		for file := range b.Debug.Modules {
+			files = append(files, file)
+		}
+		sort.Strings(files)
+		writeUint32(&payload, uint32(len(files)))
+		for _, file := range files {
+			writeBytes(&payload, []byte(file))
+			writeBytes(&payload, []byte(b.Debug.Modules[file]))
+		}
+		writePositions(&payload, b.Positions)
+		for _, constant := range b.Constants {
+			if fn, ok := constant.(*object.CompiledFunction); ok {
+				writePositions(&payload, fn.Positions)
+				writeBytes(&payload, []byte(fn.Name))
+				writeBytes(&payload, []byte(fn.Module))
+			}
+		}
+	}
+
+	var header bytes.Buffer
+	header.Write(magic[:])
+	binary.Write(&header, binary.BigEndian, FormatVersion)
+	binary.Write(&header, binary.BigEndian, flags)
+	writeUint32(&header, uint32(payload.Len()))
+
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return err
+	}
+	if _, err := w.Write(payload.Bytes()); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.BigEndian, crc32.ChecksumIEEE(payload.Bytes()))
+}
+
+// Decode reads bytecode written by Encode, verifying its version and
+// checksum, and then the code itself, see verify. The counts and lengths in
+// the file are never trusted to size a buffer before the bytes they cover
+// have been read.
+func Decode(r io.Reader) (*Bytecode, error) {
+	var fileMagic [4]byte
+	if _, err := io.ReadFull(r, fileMagic[:]); err != nil || fileMagic != magic {
+		return nil, ErrBadMagic
+	}
+
+	var header struct {
+		Version uint16
+		Flags   uint16
+		Length  uint32
+	}
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, fmt.Errorf("reading header: %s", err)
+	}
+	if header.Version != FormatVersion {
+		return nil, fmt.Errorf("unsupported bytecode version %d, want %d", header.Version, FormatVersion)
+	}
+
+	payload, err := io.ReadAll(io.LimitReader(r, int64(header.Length)))
+	if err != nil {
+		return nil, fmt.Errorf("reading payload: %s", err)
+	}
+	if len(payload) != int(header.Length) {
+		return nil, fmt.Errorf("reading payload: %s", io.ErrUnexpectedEOF)
+	}
+	var checksum uint32
+	if err := binary.Read(r, binary.BigEndian, &checksum); err != nil {
+		return nil, fmt.Errorf("reading checksum: %s", err)
+	}
+	if checksum != crc32.ChecksumIEEE(payload) {
+		return nil, fmt.Errorf("checksum mismatch, the file is corrupted")
+	}
+
+	d := &decoder{buf: payload}
+
+	numConstants := d.count(1)
+	constants := make([]object.Object, 0, numConstants)
+	for i := uint32(0); i < numConstants && d.err == nil; i++ {
+		constants = append(constants, d.constant())
+	}
+
+	bytecode := &Bytecode{
+		Constants:    constants,
+		Instructions: code.Instructions(d.bytes()),
+		Handlers:     d.handlers(),
+	}
+
+	if header.Flags&FlagDebugInfo != 0 {
+		bytecode.Debug = &DebugInfo{
+			SourceName: string(d.bytes()),
+			Source:     string(d.bytes()),
+		}
+		numModules := d.count(8)
+		if numModules > 0 {
+			bytecode.Debug.Modules = map[string]string{}
+		}
+		for i := uint32(0); i < numModules && d.err == nil; i++ {
+			file := string(d.bytes())
+			bytecode.Debug.Modules[file] = string(d.bytes())
+		}
+		bytecode.Positions = d.positions()
+		for _, constant := range constants {
+			if fn, ok := constant.(*object.CompiledFunction); ok {
+				fn.Positions = d.positions()
+				fn.Name = string(d.bytes())
+				fn.Module = string(d.bytes())
+			}
+		}
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(d.buf) != 0 {
+		return nil, fmt.Errorf("%d unexpected bytes after bytecode", len(d.buf))
+	}
+	if err := bytecode.verify(); err != nil {
+		return nil, fmt.Errorf("invalid bytecode: %s", err)
+	}
+	return bytecode, nil
+}
+
+func writeConstant(buf *bytes.Buffer, constant object.Object) error {
+	switch constant := constant.(type) {
+	case *object.Integer:
+		buf.WriteByte(tagInteger)
+		binary.Write(buf, binary.BigEndian, constant.Value)
+	case *object.String:
+		buf.WriteByte(tagString)
+		writeBytes(buf, []byte(constant.Value))
+	case *object.CompiledFunction:
+		buf.WriteByte(tagFunction)
+		writeUint32(buf, uint32(constant.NumLocals))
+		writeUint32(buf, uint32(constant.NumParameters))
+		writeUint32(buf, uint32(constant.NumDefaults))
+		if constant.Variadic {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+		writeBytes(buf, constant.Instructions)
+		writeHandlers(buf, constant.Handlers)
+	default:
+		return fmt.Errorf("cannot serialize constant of type %s", constant.Type())
+	}
+	return nil
+}
+
+func writeUint32(buf *bytes.Buffer, n uint32) {
+	binary.Write(buf, binary.BigEndian, n)
+}
+
+func writeBytes(buf *bytes.Buffer, b []byte) {
+	writeUint32(buf, uint32(len(b)))
+	buf.Write(b)
+}
+
+func writePositions(buf *bytes.Buffer, positions code.PositionTable) {
+	writeUint32(buf, uint32(len(positions)))
+	for _, pos := range positions {
+		writeUint32(buf, uint32(pos.Offset))
+		writeUint32(buf, uint32(pos.Line))
+	}
+}
+
+func writeHandlers(buf *bytes.Buffer, handlers code.HandlerTable) {
+	writeUint32(buf, uint32(len(handlers)))
+	for _, h := range handlers {
+		writeUint32(buf, uint32(h.Start))
+		writeUint32(buf, uint32(h.End))
+		writeUint32(buf, uint32(h.Target))
+		writeUint32(buf, uint32(h.Depth))
+		if h.Finally {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	}
+}
+
+// decoder reads from a payload whose checksum already matched. The first
+// failure is kept in err and turns every later read into a no-op.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > len(d.buf) {
+		d.err = fmt.Errorf("unexpected end of bytecode")
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) uint32() uint32 {
+	b := d.take(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+// count reads the number of entries of a list whose entries take at least
+// size bytes each, failing if the rest of the payload cannot hold them.
+func (d *decoder) count(size int) uint32 {
+	n := d.uint32()
+	if d.err == nil && uint64(n)*uint64(size) > uint64(len(d.buf)) {
+		d.err = fmt.Errorf("%d entries do not fit in the %d bytes left", n, len(d.buf))
+		return 0
+	}
+	return n
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uint32()
+	b := d.take(int(n))
+	if b == nil {
+		return nil
+	}
+	out := make([]byte, n)
+	copy(out, b)
+	return out
+}
+
+func (d *decoder) positions() code.PositionTable {
+	n := d.count(8)
+	if d.err != nil {
+		return nil
+	}
+	positions := code.PositionTable{}
+	for i := uint32(0); i < n && d.err == nil; i++ {
+		offset := d.uint32()
+		line := d.uint32()
+		positions = append(positions, code.SourcePosition{Offset: int(offset), Line: int(line)})
+	}
+	return positions
+}
+
+func (d *decoder) handlers() code.HandlerTable {
+	n := d.count(17)
+	if d.err != nil || n == 0 {
+		return nil
+	}
+	handlers := code.HandlerTable{}
+	for i := uint32(0); i < n && d.err == nil; i++ {
+		h := code.Handler{
+			Start:  int(d.uint32()),
+			End:    int(d.uint32()),
+			Target: int(d.uint32()),
+			Depth:  int(d.uint32()),
+		}
+		finally := d.take(1)
+		h.Finally = finally != nil && finally[0] == 1
+		handlers = append(handlers, h)
+	}
+	return handlers
+}
+
+func (d *decoder) constant() object.Object {
+	tag := d.take(1)
+	if tag == nil {
+		return nil
+	}
+
+	switch tag[0] {
+	case tagInteger:
+		b := d.take(8)
+		if b == nil {
+			return nil
+		}
+		return object.NewInteger(int64(binary.BigEndian.Uint64(b)))
+	case tagString:
+		return &object.String{Value: string(d.bytes())}
+	case tagFunction:
+		numLocals := d.uint32()
+		numParameters := d.uint32()
+		numDefaults := d.uint32()
+		variadic := d.take(1)
+		instructions := d.bytes()
+		return &object.CompiledFunction{
+			NumLocals:     int(numLocals),
+			NumParameters: int(numParameters),
+			NumDefaults:   int(numDefaults),
+			Variadic:      variadic != nil && variadic[0] == 1,
+			Instructions:  instructions,
+			Handlers:      d.handlers(),
+		}
+	default:
+		d.err = fmt.Errorf("unknown constant tag %d", tag[0])
+		return nil
+	}
+}
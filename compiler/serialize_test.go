@@ -0,0 +1,273 @@
+package compiler
+
+import (
+	"Hulk/code"
+	"Hulk/object"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func encodeProgram(t *testing.T, input string, debug *DebugInfo) ([]byte, *Bytecode) {
+	t.Helper()
+
+	comp := New()
+	err := comp.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := comp.Bytecode()
+	bytecode.Debug = debug
+
+	var buf bytes.Buffer
+	err = bytecode.Encode(&buf)
+	if err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	return buf.Bytes(), bytecode
+}
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	input := `
+	let greeting = "hello";
+	let adder = fn(a) { fn(b) { a + b + -9000000000 } };
+	let result = adder(1)(2);
+	if (result > 0) { [1, 2, 3][1:] } else { {"k": greeting} };
+	`
+	tests := []*DebugInfo{
+		nil,
+		{SourceName: "main.hk", Source: input},
+		{SourceName: "main.hk", Source: input, Modules: map[string]string{"lib/b.hk": "export let b = 2;", "lib/a.hk": "export let a = 1;"}},
+	}
+
+	for _, debug := range tests {
+		data, original := encodeProgram(t, input, debug)
+
+		decoded, err := Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("decode error: %s", err)
+		}
+
+		if !bytes.Equal(decoded.Instructions, original.Instructions) {
+			t.Fatalf("wrong instructions.\nwant=%q\ngot=%q", original.Instructions, decoded.Instructions)
+		}
+		if len(decoded.Constants) != len(original.Constants) {
+			t.Fatalf("wrong number of constants. got=%d, want=%d",
+				len(decoded.Constants), len(original.Constants))
+		}
+		for i, want := range original.Constants {
+			got := decoded.Constants[i]
+			if wantFn, ok := want.(*object.CompiledFunction); ok {
+				gotFn, ok := got.(*object.CompiledFunction)
+				if !ok {
+					t.Fatalf("constant %d - not a function: %T", i, got)
+				}
+				if !bytes.Equal(gotFn.Instructions, wantFn.Instructions) ||
+					gotFn.NumLocals != wantFn.NumLocals ||
+					gotFn.NumParameters != wantFn.NumParameters {
+					t.Fatalf("constant %d - wrong function. got=%+v, want=%+v", i, gotFn, wantFn)
+				}
+				continue
+			}
+			if !object.Equals(got, want) {
+				t.Fatalf("constant %d - got=%s, want=%s", i, got.Inspect(), want.Inspect())
+			}
+		}
+
+		if debug == nil {
+			if decoded.Debug != nil {
+				t.Fatalf("expected no debug info, got=%+v", decoded.Debug)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(decoded.Debug, debug) {
+			t.Fatalf("wrong debug info. got=%+v, want=%+v", decoded.Debug, debug)
+		}
+		if !reflect.DeepEqual(decoded.Positions, original.Positions) {
+			t.Fatalf("wrong positions. got=%v, want=%v", decoded.Positions, original.Positions)
+		}
+		for i, constant := range original.Constants {
+			if fn, ok := constant.(*object.CompiledFunction); ok {
+				got := decoded.Constants[i].(*object.CompiledFunction).Positions
+				if !reflect.DeepEqual(got, fn.Positions) {
+					t.Fatalf("constant %d - wrong positions. got=%v, want=%v", i, got, fn.Positions)
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	data, _ := encodeProgram(t, `let a = "some text"; a`, nil)
+
+	corrupt := func(edit func(b []byte) []byte) []byte {
+		b := make([]byte, len(data))
+		copy(b, data)
+		return edit(b)
+	}
+
+	tests := []struct {
+		data     []byte
+		expected string
+	}{
+		{[]byte("let a = 1;"), "not a Hulk bytecode file"},
+		{corrupt(func(b []byte) []byte { b[5] = 99; return b }), "unsupported bytecode version 99, want 6"},
+		{corrupt(func(b []byte) []byte { b[len(b)-6] ^= 0xff; return b }), "checksum mismatch"},
+		{corrupt(func(b []byte) []byte { return b[:len(b)-2] }), "reading checksum"},
+		{data[:20], "reading payload"},
+		{data[:3], "not a Hulk bytecode file"},
+		{data[:7], "reading header"},
+	}
+
+	for _, tt := range tests {
+		_, err := Decode(bytes.NewReader(tt.data))
+		if err == nil {
+			t.Fatalf("expected an error containing %q, got none", tt.expected)
+		}
+		if !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("wrong error. want to contain %q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+// wrapPayload frames payload the way Encode does, with a valid header and
+// checksum, so Decode gets past them and reads what payload declares.
+func wrapPayload(payload []byte, length uint32) []byte {
+	var buf bytes.Buffer
+	buf.Write(magic[:])
+	binary.Write(&buf, binary.BigEndian, FormatVersion)
+	binary.Write(&buf, binary.BigEndian, uint16(0))
+	binary.Write(&buf, binary.BigEndian, length)
+	buf.Write(payload)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(payload))
+	return buf.Bytes()
+}
+
+func TestDecodeOversizedCounts(t *testing.T) {
+	count := func(n uint32, rest ...byte) []byte {
+		b := binary.BigEndian.AppendUint32(nil, n)
+		return append(b, rest...)
+	}
+
+	tests := []struct {
+		data     []byte
+		expected string
+	}{
+		{wrapPayload(count(0), 0xffffffff)[:40], "reading payload"},
+		{wrapPayload(count(0xffffffff), 4), "entries do not fit"},
+		{wrapPayload(count(1, append([]byte{tagString}, count(0xffffffff)...)...), 9), "unexpected end of bytecode"},
+		{wrapPayload(append(count(0), append(count(0), count(0xffffffff)...)...), 12), "entries do not fit"},
+		{wrapPayload(count(1, append([]byte{tagFunction}, make([]byte, 13)...)...), 18), "unexpected end of bytecode"},
+	}
+
+	for _, tt := range tests {
+		_, err := Decode(bytes.NewReader(tt.data))
+		if err == nil {
+			t.Fatalf("expected an error containing %q, got none", tt.expected)
+		}
+		if !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("wrong error. want to contain %q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestDecodeInvalidCode(t *testing.T) {
+	concat := func(ins ...[]byte) code.Instructions {
+		out := code.Instructions{}
+		for _, i := range ins {
+			out = append(out, i...)
+		}
+		return out
+	}
+	function := func(numLocals int, ins ...[]byte) *object.CompiledFunction {
+		return &object.CompiledFunction{Instructions: concat(ins...), NumLocals: numLocals}
+	}
+
+	tests := []struct {
+		bytecode *Bytecode
+		expected string
+	}{
+		{&Bytecode{Instructions: concat(code.Make(code.OpConstant, 5), code.Make(code.OpPop))},
+			"main program: constant 5 out of range"},
+		{&Bytecode{Instructions: code.Instructions{255}},
+			"Opcode 255 undefined at 0"},
+		{&Bytecode{Instructions: code.Make(code.OpConstant, 0)[:2], Constants: []object.Object{object.NewInteger(1)}},
+			"operands of OpConstant cut off at 0"},
+		{&Bytecode{Instructions: concat(code.Make(code.OpGetLocal, 0), code.Make(code.OpPop))},
+			"local 0 out of range, the function has 0"},
+		{&Bytecode{Instructions: concat(code.Make(code.OpGetBuiltin, 250), code.Make(code.OpPop))},
+			"builtin 250 out of range"},
+		{&Bytecode{Instructions: concat(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)), Constants: []object.Object{&object.String{Value: "f"}}},
+			"constant 0 is not a function"},
+		{&Bytecode{Instructions: concat(code.Make(code.OpTrue), code.Make(code.OpGetMember, 0)), Constants: []object.Object{object.NewInteger(1)}},
+			"constant 0 is not a name"},
+		{&Bytecode{Instructions: concat(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+			Constants: []object.Object{function(0, code.Make(code.OpGetFree, 0), code.Make(code.OpReturnValue))}},
+			"function 0: free variable 0 out of range, the function has 0"},
+		{&Bytecode{Instructions: concat(code.Make(code.OpJump, 1), code.Make(code.OpNull))},
+			"jump to 1 at 0 does not point at an instruction"},
+		{&Bytecode{Instructions: code.Make(code.OpNull), Handlers: code.HandlerTable{{Start: 0, End: 1, Target: 9}}},
+			"handler 0-1 with target 9 does not point at instructions"},
+		{&Bytecode{Instructions: code.Make(code.OpPop)},
+			"OpPop at 0 takes more values than the stack holds"},
+		{&Bytecode{Instructions: code.Make(code.OpCall, 0)},
+			"OpCall at 0 takes more values than the stack holds"},
+		{&Bytecode{Instructions: concat(code.Make(code.OpNull), code.Make(code.OpThrow), code.Make(code.OpPop)),
+			Handlers: code.HandlerTable{{Start: 0, End: 2, Target: 2, Depth: 5}}},
+			"handler at 2 restores 5 values, only 0 at 0"},
+		{&Bytecode{Instructions: concat(code.Make(code.OpNull), code.Make(code.OpReturnValue))},
+			"main program: OpReturnValue at 1 outside of a function"},
+		{&Bytecode{Instructions: concat(code.Make(code.OpConstant, 0), code.Make(code.OpSubInt), code.Make(code.OpPop)),
+			Constants: []object.Object{object.NewInteger(1)}},
+			"OpSubInt at 3 takes more values than the stack holds"},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		err := tt.bytecode.Encode(&buf)
+		if err != nil {
+			t.Fatalf("encode error: %s", err)
+		}
+
+		_, err = Decode(&buf)
+		if err == nil {
+			t.Errorf("expected an error containing %q, got none", tt.expected)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("wrong error. want to contain %q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, input := range []string{
+		`let a = "some text"; a`,
+		`let f = fn(x, y = 2, ...r) { let g = fn() { x + y }; try { g() } catch (e) { e } }; f(1)`,
+		`struct P { x, fn get() { self.x } }; enum E { A(v), B }; match (P(1).get()) { 1 => E.A(1), _ => E.B }`,
+	} {
+		comp := New()
+		if err := comp.Compile(parse(input)); err != nil {
+			f.Fatalf("compiler error: %s", err)
+		}
+		var buf bytes.Buffer
+		if err := comp.Bytecode().Encode(&buf); err != nil {
+			f.Fatalf("encode error: %s", err)
+		}
+		f.Add(buf.Bytes())
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		bytecode, err := Decode(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		//whatever decodes must pass verification
+		if err := bytecode.verify(); err != nil {
+			t.Fatalf("decoded bytecode fails verification: %s", err)
+		}
+	})
+}
@@ -0,0 +1,29 @@
+package compiler
+
+import (
+	"Hulk/ast"
+	"Hulk/code"
+)
+
+// compileStruct binds the name of the struct to its type, built from the
+// names of its fields, and then adds the methods to the type. A method that
+// constructs instances closes over the name, so the name has to be bound
+// before the closure is made.
+func (c *Compiler) compileStruct(node *ast.StructStatement) error {
+	symbol := c.symbolTable.Define(node.Name.Value)
+	for _, field := range node.Fields {
+		c.emit(code.OpConstant, c.stringConstant(field.Value))
+	}
+	c.emit(code.OpStruct, c.stringConstant(node.Name.Value), len(node.Fields))
+	c.setSymbol(symbol)
+
+	for _, method := range node.Methods {
+		c.loadSymbol(symbol)
+		err := c.Compile(method.Function)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpMethod, c.stringConstant(method.Name.Value))
+	}
+	return nil
+}
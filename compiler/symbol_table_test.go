@@ -0,0 +1,57 @@
+package compiler
+
+import "testing"
+
+func TestResolveNestedScopes(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	firstLocal := NewEnclosedSymbolTable(global)
+	firstLocal.Define("b")
+
+	secondLocal := NewEnclosedSymbolTable(firstLocal)
+	secondLocal.Define("c")
+
+	expected := map[string]Symbol{
+		"a": {Name: "a", Scope: GlobalScope, Index: 0},
+		"b": {Name: "b", Scope: FreeScope, Index: 0},
+		"c": {Name: "c", Scope: LocalScope, Index: 0},
+	}
+
+	for name, want := range expected {
+		got, ok := secondLocal.Resolve(name)
+		if !ok {
+			t.Errorf("name %s not resolvable", name)
+			continue
+		}
+		if got != want {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", name, want, got)
+		}
+	}
+
+	if len(secondLocal.FreeSymbols) != 1 || secondLocal.FreeSymbols[0].Name != "b" {
+		t.Errorf("wrong free symbols, got=%+v", secondLocal.FreeSymbols)
+	}
+
+	if _, ok := secondLocal.Resolve("d"); ok {
+		t.Errorf("name d resolved, but was never defined")
+	}
+}
+
+func TestDefineBuiltinAndFunctionName(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineBuiltin(3, "first")
+
+	local := NewEnclosedSymbolTable(global)
+	local.DefineFunctionName("f")
+
+	builtin, ok := local.Resolve("first")
+	if !ok || builtin.Scope != BuiltinScope || builtin.Index != 3 {
+		t.Errorf("builtin resolved wrongly, got=%+v", builtin)
+	}
+
+	fn, ok := local.Resolve("f")
+	if !ok || fn.Scope != FunctionScope {
+		t.Errorf("function name resolved wrongly, got=%+v", fn)
+	}
+}
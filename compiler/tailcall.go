@@ -0,0 +1,43 @@
+package compiler
+
+import "Hulk/code"
+
+// markTailCalls turns every OpCall of a function whose result is returned
+// right away into an OpTailCall, so that the VM can reuse the frame of the
+// caller. That is the case when the call is followed by OpReturnValue, or by
+// jumps leading to one, like at the end of an if branch. Both opcodes have
+// the same width, so nothing moves. Calls inside a try expression are left
+// alone, since the frame catching their errors has to stay.
+func markTailCalls(ins code.Instructions, handlers code.HandlerTable) {
+	for i := 0; i < len(ins); {
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			return
+		}
+		_, read := code.ReadOperands(def, ins[i+1:])
+		next := i + 1 + read
+
+		_, protected := handlers.HandlerFor(i)
+		if code.Opcode(ins[i]) == code.OpCall && !protected && returnsAt(ins, next) {
+			ins[i] = byte(code.OpTailCall)
+		}
+		i = next
+	}
+}
+
+// returnsAt reports whether execution starting at offset reaches an
+// OpReturnValue without doing anything else.
+func returnsAt(ins code.Instructions, offset int) bool {
+	//bounded in case the jumps form a cycle
+	for n := 0; n < len(ins) && offset < len(ins); n++ {
+		switch code.Opcode(ins[offset]) {
+		case code.OpReturnValue:
+			return true
+		case code.OpJump:
+			offset = int(code.ReadUint16(ins[offset+1:]))
+		default:
+			return false
+		}
+	}
+	return false
+}
@@ -0,0 +1,259 @@
+package compiler
+
+import (
+	"Hulk/builtins"
+	"Hulk/code"
+	"Hulk/object"
+	"fmt"
+)
+
+// verify checks bytecode read from a file before the VM runs it: every
+// opcode must be defined and complete, every operand must point inside the
+// constant pool, the builtins, the locals and free variables of its
+// function or at an instruction, and no instruction may take more values
+// than the stack holds. Global indexes need no check, any uint16 operand is
+// inside the globals of the VM. Only the number of values on the stack is
+// followed, not their types; the VM reports a value of the wrong type where
+// the compiler would have put a name or a struct as a runtime error.
+func (b *Bytecode) verify() error {
+	//a function runs with the free variables of the closure made of it,
+	//so it can only read as many as the smallest closure made has
+	numFree := map[int]int{}
+	main := &object.CompiledFunction{Instructions: b.Instructions, Handlers: b.Handlers}
+	if err := b.verifyClosures(main.Instructions, numFree); err != nil {
+		return fmt.Errorf("main program: %s", err)
+	}
+	for i, constant := range b.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			if err := b.verifyClosures(fn.Instructions, numFree); err != nil {
+				return fmt.Errorf("function %d: %s", i, err)
+			}
+		}
+	}
+
+	err := walkInstructions(main.Instructions, func(offset int, op code.Opcode, operands []int) error {
+		switch op {
+		case code.OpReturnValue, code.OpReturn, code.OpTailCall:
+			return fmt.Errorf("%s at %d outside of a function", definition(op).Name, offset)
+		}
+		return nil
+	})
+	if err == nil {
+		err = b.verifyFunction(main, 0)
+	}
+	if err != nil {
+		return fmt.Errorf("main program: %s", err)
+	}
+	for i, constant := range b.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			if err := b.verifyFunction(fn, numFree[i]); err != nil {
+				return fmt.Errorf("function %d: %s", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// verifyClosures checks the function constants ins makes closures of and
+// records in numFree the fewest free variables each one is given.
+func (b *Bytecode) verifyClosures(ins code.Instructions, numFree map[int]int) error {
+	return walkInstructions(ins, func(offset int, op code.Opcode, operands []int) error {
+		var index, free int
+		switch op {
+		case code.OpClosure:
+			index, free = operands[0], operands[1]
+		case code.OpImport:
+			index = operands[2]
+		default:
+			return nil
+		}
+		if index >= len(b.Constants) {
+			return fmt.Errorf("constant %d out of range at %d", index, offset)
+		}
+		if _, ok := b.Constants[index].(*object.CompiledFunction); !ok {
+			return fmt.Errorf("constant %d is not a function at %d", index, offset)
+		}
+		if n, ok := numFree[index]; !ok || free < n {
+			numFree[index] = free
+		}
+		return nil
+	})
+}
+
+func (b *Bytecode) verifyFunction(fn *object.CompiledFunction, numFree int) error {
+	params := fn.NumParameters
+	if fn.Variadic {
+		params++
+	}
+	if fn.NumDefaults > fn.NumParameters || params > fn.NumLocals {
+		return fmt.Errorf("%d parameters and %d defaults do not fit %d locals", params, fn.NumDefaults, fn.NumLocals)
+	}
+
+	starts := map[int]bool{len(fn.Instructions): true}
+	jumps := map[int]int{}
+	err := walkInstructions(fn.Instructions, func(offset int, op code.Opcode, operands []int) error {
+		starts[offset] = true
+		if code.IsJump(op) {
+			jumps[offset] = operands[0]
+		}
+		return b.verifyOperands(op, operands, fn.NumLocals, numFree)
+	})
+	if err != nil {
+		return err
+	}
+
+	for offset, target := range jumps {
+		if !starts[target] {
+			return fmt.Errorf("jump to %d at %d does not point at an instruction", target, offset)
+		}
+	}
+	for _, h := range fn.Handlers {
+		if !starts[h.Start] || !starts[h.End] || h.Start > h.End || !starts[h.Target] || h.Target == len(fn.Instructions) {
+			return fmt.Errorf("handler %d-%d with target %d does not point at instructions", h.Start, h.End, h.Target)
+		}
+	}
+	return verifyStack(fn.Instructions, fn.Handlers)
+}
+
+func (b *Bytecode) verifyOperands(op code.Opcode, operands []int, numLocals, numFree int) error {
+	switch op {
+	case code.OpConstant, code.OpConstantAdd, code.OpConstantSub:
+		if operands[0] >= len(b.Constants) {
+			return fmt.Errorf("constant %d out of range", operands[0])
+		}
+	case code.OpModule, code.OpStruct, code.OpEnum, code.OpMethod, code.OpGetMember, code.OpSetMember:
+		if operands[0] >= len(b.Constants) {
+			return fmt.Errorf("constant %d out of range", operands[0])
+		}
+		if _, ok := b.Constants[operands[0]].(*object.String); !ok {
+			return fmt.Errorf("constant %d is not a name", operands[0])
+		}
+	case code.OpGetLocal, code.OpSetLocal, code.OpGetLocalGetLocalAdd:
+		for _, local := range operands {
+			if local >= numLocals {
+				return fmt.Errorf("local %d out of range, the function has %d", local, numLocals)
+			}
+		}
+	case code.OpGetFree:
+		if operands[0] >= numFree {
+			return fmt.Errorf("free variable %d out of range, the function has %d", operands[0], numFree)
+		}
+	case code.OpGetBuiltin:
+		if operands[0] >= len(builtins.Builtins) {
+			return fmt.Errorf("builtin %d out of range", operands[0])
+		}
+	}
+	return nil
+}
+
+func definition(op code.Opcode) *code.Definition {
+	def, _ := code.Lookup(byte(op))
+	return def
+}
+
+// walkInstructions calls visit with each instruction of ins, failing on an
+// undefined opcode or one whose operands are cut off.
+func walkInstructions(ins code.Instructions, visit func(offset int, op code.Opcode, operands []int) error) error {
+	for offset := 0; offset < len(ins); {
+		def, err := code.Lookup(ins[offset])
+		if err != nil {
+			return fmt.Errorf("%s at %d", err, offset)
+		}
+		width := 0
+		for _, w := range def.OperandWidths {
+			width += w
+		}
+		if offset+1+width > len(ins) {
+			return fmt.Errorf("operands of %s cut off at %d", def.Name, offset)
+		}
+		operands, read := code.ReadOperands(def, ins[offset+1:])
+		err = visit(offset, code.Opcode(ins[offset]), operands)
+		if err != nil {
+			return err
+		}
+		offset += 1 + read
+	}
+	return nil
+}
+
+// verifyStack follows every path through ins, and into its handlers, with
+// the number of values on the stack above the locals, and fails if an
+// instruction takes more than that or a handler would restore a stack
+// deeper than the one of an instruction it covers.
+func verifyStack(ins code.Instructions, handlers code.HandlerTable) error {
+	depths := map[int]int{0: 0}
+	work := []int{0}
+	for len(work) > 0 {
+		offset := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		depth := depths[offset]
+		for _, h := range handlers {
+			if offset < h.Start || offset >= h.End {
+				continue
+			}
+			if depth < h.Depth {
+				return fmt.Errorf("handler at %d restores %d values, only %d at %d", h.Target, h.Depth, depth, offset)
+			}
+			if _, ok := depths[h.Target]; !ok {
+				depths[h.Target] = h.Depth + 1
+				work = append(work, h.Target)
+			}
+		}
+		if offset == len(ins) {
+			continue
+		}
+
+		def, _ := code.Lookup(ins[offset])
+		operands, read := code.ReadOperands(def, ins[offset+1:])
+		op := code.Opcode(ins[offset])
+		if depth < stackInputs(op, operands) {
+			return fmt.Errorf("%s at %d takes more values than the stack holds", def.Name, offset)
+		}
+
+		effect, ends := stackEffect(op, operands)
+		depth += effect
+		next := []int{}
+		if code.IsJump(op) {
+			next = append(next, operands[0])
+		}
+		if !ends && op != code.OpJump {
+			next = append(next, offset+1+read)
+		}
+		for _, target := range next {
+			if _, ok := depths[target]; !ok {
+				depths[target] = depth
+				work = append(work, target)
+			}
+		}
+	}
+	return nil
+}
+
+// stackInputs returns how many values op reads off the stack.
+func stackInputs(op code.Opcode, operands []int) int {
+	switch op {
+	case code.OpMinus, code.OpBang, code.OpJumpNotError, code.OpConstantAdd, code.OpConstantSub,
+		code.OpGetMember, code.OpMatchArray, code.OpDestructureArray, code.OpReturnValue, code.OpThrow,
+		code.OpNoMatch:
+		return 1
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpEqual, code.OpNotEqual, code.OpGreaterThan,
+		code.OpAddInt, code.OpSubInt, code.OpGreaterThanInt, code.OpIndex, code.OpJumpNotGreater,
+		code.OpJumpNotEqual, code.OpSetMember, code.OpMethod, code.OpMatchEqual:
+		return 2
+	case code.OpSlice:
+		return 4
+	case code.OpArray, code.OpHash, code.OpStruct, code.OpEnum:
+		return operands[len(operands)-1]
+	case code.OpClosure:
+		return operands[1]
+	case code.OpModule:
+		return 2 * operands[1]
+	case code.OpCall, code.OpTailCall, code.OpCallSpread, code.OpDestructureHash, code.OpMatchHash:
+		return operands[0] + 1
+	}
+	if effect, _ := stackEffect(op, operands); effect < 0 {
+		return -effect
+	}
+	return 0
+}
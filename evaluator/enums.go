@@ -0,0 +1,28 @@
+package evaluator
+
+import (
+	"Hulk/ast"
+	"Hulk/object"
+)
+
+// evalEnumStatement binds the name of the enum to its type, whose members
+// are its variants.
+func evalEnumStatement(node *ast.EnumStatement, env *object.Environment) object.Object {
+	enumType := object.NewEnumType(node.Name.Value, variantNames(node))
+	env.Set(node.Name.Value, enumType)
+	return enumType
+}
+
+// variantNames lists each variant of node as its name followed by the
+// names of its fields, as object.NewEnumType takes them.
+func variantNames(node *ast.EnumStatement) [][]string {
+	variants := make([][]string, len(node.Variants))
+	for i, v := range node.Variants {
+		names := []string{v.Name.Value}
+		for _, field := range v.Fields {
+			names = append(names, field.Value)
+		}
+		variants[i] = names
+	}
+	return variants
+}
@@ -2,17 +2,37 @@ package evaluator
 
 import (
 	"Hulk/ast"
+	"Hulk/builtins"
 	"Hulk/object"
-	"fmt"
+	"context"
 )
 
 var (
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
-	NULL  = &object.Null{}
+	TRUE  = object.TRUE
+	FALSE = object.FALSE
+	NULL  = object.NULL
 )
 
+// EvalContext evaluates node like Eval, but stops it with an
+// *object.LimitError as soon as it goes over limits or ctx is done.
+func EvalContext(ctx context.Context, node ast.Node, env *object.Environment, limits object.Limits) (object.Object, error) {
+	outer := env.Meter()
+	meter := object.NewMeter(ctx, limits)
+	env.SetMeter(meter)
+	defer env.SetMeter(outer)
+
+	result := Eval(node, env)
+	if err := meter.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	if err := env.Meter().Step(); err != nil {
+		return NewError("%s", err)
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
 		return evalStatements(node, env)
@@ -21,7 +41,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return Eval(node.Expression, env)
 
 	case *ast.IntegerLiteral:
-		return &object.Integer{Value: node.Value}
+		return object.NewInteger(node.Value)
 
 	case *ast.BooleanExpression:
 		return returnNativeBooleanObject(node.Value, env)
@@ -65,24 +85,57 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(val) {
 			return val
 		}
+		if node.Pattern != nil {
+			if err := bindPattern(node.Pattern, val, env); err != nil {
+				return err
+			}
+			return val
+		}
 		env.Set(node.Name.Value, val)
 		return val
 
+	case *ast.ImportStatement:
+		return evalImportStatement(node, env)
+
+	case *ast.ExportStatement:
+		if node.Struct != nil {
+			return Eval(node.Struct, env)
+		}
+		if node.Enum != nil {
+			return Eval(node.Enum, env)
+		}
+		return Eval(node.Let, env)
+
+	case *ast.StructStatement:
+		return evalStructStatement(node, env)
+
+	case *ast.EnumStatement:
+		return evalEnumStatement(node, env)
+
+	case *ast.MemberExpression:
+		return evalMemberExpression(node, env)
+
+	case *ast.AssignExpression:
+		return evalAssignExpression(node, env)
+
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Block
-		return &object.Function{Parameters: params, Body: body, Env: env}
+		return track(env, &object.Function{Name: node.Name, Parameters: params, Defaults: node.Defaults, Rest: node.Rest, Patterns: node.Patterns, Body: body, Env: env})
+
+	case *ast.MatchExpression:
+		return evalMatchExpression(node, env, false)
 
 	case *ast.CallExpression:
 		function := Eval(node.Function, env)
 		if isError(function) {
 			return function
 		}
-		args := evalExpressions(node.Arguments, env)
+		args := evalArguments(node.Arguments, env)
 		if len(args) == 1 && isError(args[0]) {
 			return args[0]
 		}
-		return applyFunction(function, args)
+		return applyFunction(env.Meter(), function, args...)
 
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
@@ -92,7 +145,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if len(elements) == 1 && isError(elements[0]) {
 			return elements[0]
 		}
-		return &object.Array{Elements: elements}
+		return track(env, &object.Array{Elements: elements})
 
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
@@ -105,9 +158,34 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 		return evalIndexExpression(left, index)
 
+	case *ast.SliceExpression:
+		return evalSliceExpression(node, env)
+
 	case *ast.HashLiteral:
 		return evalHashLiteral(node, env)
 
+	case *ast.ThrowStatement:
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		return object.NewThrown(val)
+
+	case *ast.TryExpression:
+		return evalTryExpression(node, env)
+
+	case *ast.PropagateExpression:
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		//the error value is returned from the enclosing function, which
+		//the expressions around this one pass on like an error
+		if _, ok := val.(*object.ErrorValue); ok {
+			return &object.ReturnValue{Value: val}
+		}
+		return val
+
 	}
 	return nil
 }
@@ -122,6 +200,8 @@ func evalStatements(program *ast.Program, env *object.Environment) object.Object
 		case *object.ReturnValue:
 			return obj.Value
 		case *object.Error:
+			obj.AtLine(ast.StatementLine(stmt))
+			obj.LeaveFunction(object.MainFunction)
 			return obj
 		}
 	}
@@ -135,10 +215,15 @@ func evalBlockStatements(block *ast.BlockStatement, e *object.Environment) objec
 		obj = Eval(stmt, e)
 		if obj != nil {
 			if obj.Type() == object.RETURN_VALUE_OBJ || obj.Type() == object.ERROR_OBJ {
+				traceError(obj, stmt)
 				return obj
 			}
 		}
 	}
+	//an empty block is null, as in the VM
+	if obj == nil {
+		return NULL
+	}
 	return obj
 }
 
@@ -154,7 +239,7 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
 		return val
 	}
 
-	if builtin, ok := builtins[node.Value]; ok {
+	if builtin := builtins.GetBuiltinByName(node.Value); builtin != nil {
 		return builtin
 	}
 	return NewError("Identifier not found: " + node.Value)
@@ -190,7 +275,7 @@ func returnMinusOperatorExpression(right object.Object, env *object.Environment)
 	}
 
 	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
+	return object.NewInteger(-value)
 }
 
 func evalInfixObject(left object.Object, op string, right object.Object, env *object.Environment) object.Object {
@@ -201,6 +286,10 @@ func evalInfixObject(left object.Object, op string, right object.Object, env *ob
 		return evalInfixStringExpression(left, op, right, env)
 	case left.Type() != right.Type():
 		return NewError("type mismatch: %s %s %s", left.Type(), op, right.Type())
+	case left.Type() == object.ENUM_OBJ && op == "==":
+		return returnNativeBooleanObject(object.Equals(left, right), env)
+	case left.Type() == object.ENUM_OBJ && op == "!=":
+		return returnNativeBooleanObject(!object.Equals(left, right), env)
 	case op == "==":
 		return returnNativeBooleanObject(left == right, env)
 	case op == "!=":
@@ -215,13 +304,16 @@ func evalInfixIntegerExpression(left object.Object, op string, right object.Obje
 	rightVal := right.(*object.Integer).Value
 	switch op {
 	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
+		return object.NewInteger(leftVal + rightVal)
 	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
+		return object.NewInteger(leftVal - rightVal)
 	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
+		return object.NewInteger(leftVal * rightVal)
 	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
+		if rightVal == 0 {
+			return NewError("division by zero")
+		}
+		return object.NewInteger(leftVal / rightVal)
 	case ">":
 		return returnNativeBooleanObject(leftVal > rightVal, env)
 	case "<":
@@ -263,13 +355,24 @@ func isTruthy(condition object.Object) bool {
 	}
 }
 
+// traceError records stmt as where obj, if it is an error, happened in the
+// function being run.
+func traceError(obj object.Object, stmt ast.Statement) {
+	if err, ok := obj.(*object.Error); ok {
+		err.AtLine(ast.StatementLine(stmt))
+	}
+}
+
 func NewError(format string, a ...interface{}) *object.Error {
-	return &object.Error{Message: fmt.Sprintf(format, a...)}
+	return builtins.NewError(format, a...)
 }
 
+// isError reports whether obj cuts the evaluation of the expression it is
+// part of short: an error, or a value on its way out of the function, as
+// made by the ? operator or by a return inside an if or try expression.
 func isError(obj object.Object) bool {
 	if obj != nil {
-		return obj.Type() == object.ERROR_OBJ
+		return obj.Type() == object.ERROR_OBJ || obj.Type() == object.RETURN_VALUE_OBJ
 	}
 	return false
 }
@@ -287,20 +390,62 @@ func evalExpressions(args []ast.Expression, env *object.Environment) []object.Ob
 	return result
 }
 
+// evalArguments evaluates the arguments of a call, expanding the elements of
+// spread arguments in place.
+func evalArguments(args []ast.Expression, env *object.Environment) []object.Object {
+	var result []object.Object
+
+	for _, e := range args {
+		spread, ok := e.(*ast.SpreadExpression)
+		if !ok {
+			evaluated := Eval(e, env)
+			if isError(evaluated) {
+				return []object.Object{evaluated}
+			}
+			result = append(result, evaluated)
+			continue
+		}
+
+		evaluated := Eval(spread.Value, env)
+		if isError(evaluated) {
+			return []object.Object{evaluated}
+		}
+		arr, ok := evaluated.(*object.Array)
+		if !ok {
+			return []object.Object{NewError("spread argument must be an array, got %s", evaluated.Type())}
+		}
+		result = append(result, arr.Elements...)
+	}
+	return result
+}
+
 func evalInfixStringExpression(left object.Object, op string, right object.Object, env *object.Environment) object.Object {
-	if op != "+" {
+	switch op {
+	case "==":
+		return returnNativeBooleanObject(object.Equals(left, right), env)
+	case "!=":
+		return returnNativeBooleanObject(!object.Equals(left, right), env)
+	case "+":
+		leftVal := left.(*object.String).Value
+		rightVal := right.(*object.String).Value
+		return track(env, &object.String{Value: leftVal + rightVal})
+	default:
 		return NewError("unknown operator: %s %s %s", left.Type(), op, right.Type())
 	}
-	leftVal := left.(*object.String).Value
-	rightVal := right.(*object.String).Value
-
-	return &object.String{Value: leftVal + rightVal}
 }
 
 func evalIndexExpression(left object.Object, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.HASH_OBJ:
+		return evalHashIndexExpression(left, index)
+	case left.Type() == object.MODULE_OBJ:
+		value, err := left.(*object.Module).Get(index)
+		if err != nil {
+			return NewError("%s", err)
+		}
+		return value
 	default:
 		return NewError("index operator not supported: %s", left.Type())
 	}
@@ -318,6 +463,72 @@ func evalArrayIndexExpression(left object.Object, index object.Object) object.Ob
 
 }
 
+func evalSliceExpression(se *ast.SliceExpression, env *object.Environment) object.Object {
+	left := Eval(se.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	bounds := []object.Object{NULL, NULL, NULL}
+	for i, node := range []ast.Expression{se.Start, se.End, se.Step} {
+		if node == nil {
+			continue
+		}
+		bound := Eval(node, env)
+		if isError(bound) {
+			return bound
+		}
+		bounds[i] = bound
+	}
+
+	result, err := object.Slice(left, bounds[0], bounds[1], bounds[2])
+	if err != nil {
+		return NewError("%s", err)
+	}
+	return track(env, result)
+}
+
+// evalTryExpression runs the try block and, if it fails, the catch block
+// with the error bound to the catch parameter. The finally block runs last
+// whatever happened; a return or an error from it wins over the outcome of
+// the other blocks. Errors from going over the limits of the evaluation
+// cannot be caught.
+func evalTryExpression(te *ast.TryExpression, env *object.Environment) object.Object {
+	result := evalBlockStatements(te.Block, env)
+
+	if err, ok := result.(*object.Error); ok && te.Catch != nil && env.Meter().Err() == nil {
+		env.Set(te.CatchParam.Value, track(env, err.Caught(err.Left())))
+		result = evalBlockStatements(te.Catch, env)
+	}
+
+	if te.Finally != nil {
+		final := evalBlockStatements(te.Finally, env)
+		if isError(final) || final != nil && final.Type() == object.RETURN_VALUE_OBJ {
+			return final
+		}
+	}
+
+	if result == nil {
+		return NULL
+	}
+	return result
+}
+
+func evalHashIndexExpression(hash object.Object, index object.Object) object.Object {
+	hashObj := hash.(*object.Hash)
+
+	key, ok := object.HashKeyOf(index)
+	if !ok {
+		return NewError("unusable as hashkey: %s", index.Type())
+	}
+
+	pair, ok := hashObj.Pairs[key]
+	if !ok {
+		return NULL
+	}
+	return pair.Value
+}
+
 func evalHashLiteral(hl *ast.HashLiteral, env *object.Environment) object.Object {
 	pairs := make(map[object.HashKey]object.HashPair)
 
@@ -326,7 +537,7 @@ func evalHashLiteral(hl *ast.HashLiteral, env *object.Environment) object.Object
 		if isError(key) {
 			return key
 		}
-		hashkey, ok := key.(object.Hashable)
+		hashed, ok := object.HashKeyOf(key)
 		if !ok {
 			return NewError("unusable as hashkey: %s", key.Type())
 		}
@@ -336,33 +547,140 @@ func evalHashLiteral(hl *ast.HashLiteral, env *object.Environment) object.Object
 			return value
 		}
 
-		hashed := hashkey.HashKey()
 		pairs[hashed] = object.HashPair{Key: key, Value: value}
 	}
-	return &object.Hash{Pairs: pairs}
+	return track(env, &object.Hash{Pairs: pairs})
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
-	switch fn := fn.(type) {
-	case *object.Function:
-		extendedEnv := extendedFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
-		return unwrapReturnValue(evaluated)
-	case *object.BuiltIn:
-		return fn.Fn(args...)
-	default:
-		return NewError("not a function: %s", fn.Type())
+// track counts a value the evaluation has just created against its limits.
+func track(env *object.Environment, obj object.Object) object.Object {
+	if err := env.Meter().Track(obj); err != nil {
+		return NewError("%s", err)
+	}
+	return obj
+}
+
+// applier is the object.Applier the evaluator hands to builtins.
+type applier struct {
+	meter *object.Meter
+}
+
+func (a applier) Apply(fn object.Object, args ...object.Object) object.Object {
+	return applyFunction(a.meter, fn, args...)
+}
+
+func (a applier) Meter() *object.Meter {
+	return a.meter
+}
+
+// applyFunction calls fn on behalf of the evaluation reporting to meter.
+// The meter is passed in rather than taken from the environment of fn,
+// which may have been created by an earlier evaluation.
+func applyFunction(meter *object.Meter, fn object.Object, args ...object.Object) object.Object {
+	if err := meter.Enter(); err != nil {
+		return NewError("%s", err)
+	}
+	defer meter.Leave()
+
+	for {
+		switch function := fn.(type) {
+		case *object.Function:
+			//a call with the wrong number of arguments fails in the caller
+			if err := function.Arity().Check(len(args)); err != nil {
+				return err
+			}
+			extendedEnv, evaluated := extendedFunctionEnv(function, args, meter)
+			if evaluated == nil {
+				evaluated = evalBody(function.Body, extendedEnv, true)
+			}
+			evaluated = unwrapReturnValue(evaluated)
+
+			//a call in tail position is made here instead of in the body
+			if call, ok := evaluated.(*tailCall); ok {
+				fn, args = call.fn, call.args
+				continue
+			}
+			if err, ok := evaluated.(*object.Error); ok {
+				err.LeaveFunction(function.Name)
+			}
+			return evaluated
+		case *object.BoundMethod:
+			if err := function.Arity().Check(len(args)); err != nil {
+				return err
+			}
+			fn, args = function.Method, append([]object.Object{function.Receiver}, args...)
+		case *object.StructType:
+			instance, err := function.New(args)
+			if err != nil {
+				return err
+			}
+			if err := meter.Track(instance); err != nil {
+				return NewError("%s", err)
+			}
+			return instance
+		case *object.Variant:
+			value, err := function.New(args)
+			if err != nil {
+				return err
+			}
+			if err := meter.Track(value); err != nil {
+				return NewError("%s", err)
+			}
+			return value
+		case *object.BuiltIn:
+			result := function.Fn(applier{meter}, args...)
+			if err := meter.Track(result); err != nil {
+				return NewError("%s", err)
+			}
+			return result
+		default:
+			return NewError("not a function: %s", fn.Type())
+		}
 	}
 }
 
-func extendedFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
+// extendedFunctionEnv binds the parameters of fn to args, which must be as
+// many as fn takes. Parameters left without an argument get their default
+// value, evaluated in the new environment so that it can use the parameters
+// before it. Destructured parameters bind the names in their pattern as
+// well. If evaluating a default or destructuring fails, or a default
+// returns, the result is returned along with the environment.
+func extendedFunctionEnv(fn *object.Function, args []object.Object, meter *object.Meter) (*object.Environment, object.Object) {
 	env := object.NewEnclosedEnvironment(fn.Env)
+	env.SetMeter(meter)
 
 	for paramIdx, param := range fn.Parameters {
-		env.Set(param.Value, args[paramIdx])
+		var val object.Object
+		if paramIdx < len(args) {
+			val = args[paramIdx]
+		} else {
+			val = Eval(fn.Defaults[paramIdx], env)
+			if isError(val) {
+				return env, val
+			}
+		}
+		env.Set(param.Value, val)
+
+		if fn.Patterns != nil && fn.Patterns[paramIdx] != nil {
+			if err := bindPattern(fn.Patterns[paramIdx], val, env); err != nil {
+				return env, err
+			}
+		}
+	}
+
+	if fn.Rest != nil {
+		rest := []object.Object{}
+		if len(args) > len(fn.Parameters) {
+			rest = append(rest, args[len(fn.Parameters):]...)
+		}
+		val := track(env, &object.Array{Elements: rest})
+		if isError(val) {
+			return env, val
+		}
+		env.Set(fn.Rest.Value, val)
 	}
 
-	return env
+	return env, nil
 }
 
 func unwrapReturnValue(obj object.Object) object.Object {
@@ -2,9 +2,16 @@ package evaluator
 
 import (
 	"Hulk/lexer"
+	"Hulk/loader"
 	"Hulk/object"
+	"Hulk/optimizer"
 	"Hulk/parser"
+	"context"
+	"errors"
+	"io/fs"
+	"reflect"
 	"testing"
+	"testing/fstest"
 )
 
 func testEval(input string) object.Object {
@@ -271,6 +278,22 @@ func TestFunctionObjects(t *testing.T) {
 	}
 }
 
+func TestFunctionObjectParameters(t *testing.T) {
+	evaluated := testEval("fn(a, b = a + 1, ...rest) { a }")
+
+	fnObj, ok := evaluated.(*object.Function)
+	if !ok {
+		t.Fatalf("object not Function, got=%T (%v)", evaluated, evaluated)
+	}
+	arity := fnObj.Arity()
+	if arity != (object.Arity{Required: 1, Optional: 1, Variadic: true}) {
+		t.Fatalf("wrong arity, got=%+v", arity)
+	}
+	if fnObj.Inspect() != "fn(a, b = (a + 1), ...rest) {\na\n}" {
+		t.Fatalf("wrong Inspect, got=%q", fnObj.Inspect())
+	}
+}
+
 func TestFunctionApplication(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -289,6 +312,500 @@ func TestFunctionApplication(t *testing.T) {
 	}
 }
 
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{`let sum = fn(arr, i, acc) { if (i == len(arr)) { acc } else { sum(arr, i + 1, acc + arr[i]) } };
+		sum(range(100000), 0, 0)`, 4999950000},
+		{`let count = fn(n) { if (n == 0) { return 0; } return count(n - 1); }; count(100000)`, 0},
+		{`let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } };
+		let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } };
+		if (even(100001)) { 1 } else { 2 }`, 2},
+		{`let down = fn(n) { if (n > 0) { return down(n - 1); } 7 }; down(100000)`, 7},
+		//calls that are not in tail position still run in order
+		{`let f = fn(n) { len([n]); n * 2 }; f(3)`, 6},
+		{`let last = fn(arr) { reduce(arr, fn(acc, x) { x }) }; last(range(5))`, 4},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestStackTraces(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []object.StackFrame
+	}{
+		{"let inner = fn(x) {\n  x + true\n};\nlet outer = fn(x) {\n  let y = inner(x);\n  y\n};\nouter(1);",
+			[]object.StackFrame{{Function: "inner", Line: 2}, {Function: "outer", Line: 5}, {Function: object.MainFunction, Line: 8}}},
+		{"let f = fn(x) {\n  if (x > 0) {\n    -true\n  }\n};\nlet y = f(1);",
+			[]object.StackFrame{{Function: "f", Line: 3}, {Function: object.MainFunction, Line: 6}}},
+		//the anonymous callback is called by map, which has no frame of its own
+		{"map([1, 2],\n  fn(x) { len(x) });",
+			[]object.StackFrame{{Line: 2}, {Function: object.MainFunction, Line: 1}}},
+		//a tail call replaces the frame of its caller
+		{"let f = fn() { [1][true] };\nlet g = fn() { f() };\n1;\ng()",
+			[]object.StackFrame{{Function: "f", Line: 1}, {Function: object.MainFunction, Line: 4}}},
+		{"struct P {\n  x,\n  fn bad() {\n    len(self.x)\n  }\n}\nP(1).bad();",
+			[]object.StackFrame{{Function: "P.bad", Line: 4}, {Function: object.MainFunction, Line: 7}}},
+	}
+
+	for _, tt := range tests {
+		errObj, ok := testEval(tt.input).(*object.Error)
+		if !ok {
+			t.Errorf("%q: expected an error", tt.input)
+			continue
+		}
+		if !reflect.DeepEqual(errObj.Stack, tt.expected) {
+			t.Errorf("%q: wrong stack.\nwant=%v\ngot=%v", tt.input, tt.expected, errObj.Stack)
+		}
+	}
+}
+
+func TestExceptions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`try { throw "oops" } catch (e) { [e["type"], e["message"], e["value"]] }`, "[Error, oops, oops]"},
+		{`try { throw {"type": "ValueError", "message": "bad", "code": 3} } catch (e) { [e["type"], e["message"], e["code"]] }`, "[ValueError, bad, 3]"},
+		{`try { [1][true] } catch (e) { e["type"] }`, "RuntimeError"},
+		{`try { len(1) } catch (e) { e["message"] }`, "argument to len() not supported, got INTEGER"},
+		{"let f = fn(x) { if (x > 1) { throw x } x };\nlet g = fn(x) { f(x) + 1 };\ntry { g(5) } catch (e) { e[\"stack\"] }", "[f (line 1), g (line 2)]"},
+		{`try { 1 } catch (e) { 2 }`, "1"},
+		{`try { } catch (e) { 2 }`, "null"},
+		{`try { 1 } finally { 2 }`, "1"},
+		{`1 + try { 2 + [1][true] } catch (e) { 10 }`, "11"},
+		{`[1, 2, try { throw 3 } catch (e) { e["value"] }, 4]`, "[1, 2, 3, 4]"},
+		{`let f = fn(a, b) { a + b }; f(1, try { throw 2 } catch (e) { e["value"] })`, "3"},
+		{`let f = fn(a) { let b = 2; a + b + try { throw 1 } catch (e) { b } }; f(1)`, "5"},
+		{`let f = fn() { try { return 1 } finally { 2 } }; f()`, "1"},
+		{`let f = fn() { try { return 1 } finally { return 2 } }; f()`, "2"},
+		{`let f = fn() { try { throw "a" } catch (e) { return 1 } finally { return 2 } }; f()`, "2"},
+		{`let f = fn() { try { try { return 1 } finally { 2 } } finally { return 3 } }; f()`, "3"},
+		{`try { try { throw "a" } finally { 1 } } catch (e) { e["message"] }`, "a"},
+		{`try { try { throw "a" } catch (e) { throw e["message"] + "b" } } catch (e) { e["message"] }`, "ab"},
+		{`try { try { throw "a" } finally { throw "b" } } catch (e) { e["message"] }`, "b"},
+		{`let f = fn(n) { if (n == 0) { throw "bottom" } 1 + f(n - 1) }; try { f(50) } catch (e) { len(e["stack"]) }`, "51"},
+		{`let f = fn(n) { try { if (n == 0) { throw n } f(n - 1) } catch (e) { e["value"] + 1 } }; f(3)`, "1"},
+		{`try { map([1, 2], fn(x) { throw x }) } catch (e) { e["value"] }`, "1"},
+		{`map([1, 2], fn(x) { try { throw x } catch (e) { e["value"] * 10 } })`, "[10, 20]"},
+		{`let e = 1; try { throw 2 } catch (e) { 3 }; e["value"]`, "2"},
+	}
+
+	for _, tt := range tests {
+		result := testEval(tt.input)
+		if result.Inspect() != tt.expected {
+			t.Errorf("%q: want=%s, got=%s", tt.input, tt.expected, result.Inspect())
+		}
+	}
+
+	//uncaught errors keep their type and the stack from where they were thrown
+	errObj, ok := testEval("let f = fn() {\n  throw {\"type\": \"ValueError\", \"message\": \"bad\"}\n};\ntry { f() } finally { 1 }").(*object.Error)
+	if !ok {
+		t.Fatalf("expected an error")
+	}
+	want := "ValueError: bad\n\tat f (line 2)\n\tat <main> (line 4)"
+	if errObj.Traceback() != want {
+		t.Errorf("wrong traceback.\nwant=%q\ngot=%q", want, errObj.Traceback())
+	}
+}
+
+func TestErrorValues(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let e = error("bad"); [is_error(e), is_error(1), is_error("bad")]`, "[true, false, false]"},
+		{`error({"code": 404})`, "error({code: 404})"},
+		{`unwrap(5)`, "5"},
+		{`try { unwrap(error("bad")) } catch (e) { e["message"] }`, "unwrap of error(bad)"},
+		{`let f = fn(x) { x? + 1 }; [f(1), f(error("bad"))]`, "[2, error(bad)]"},
+		{`let f = fn(x) { let y = x?; y * 2 }; [f(2), f(error("bad"))]`, "[4, error(bad)]"},
+		{`let f = fn(a, b) { [a?, b?] }; [f(1, 2), f(error("a"), error("b")), f(1, error("b"))]`, "[[1, 2], error(a), error(b)]"},
+		{`let inner = fn(x) { if (x > 0) { x } else { error("negative") } };
+		let outer = fn(x) { inner(x)? * 10 };
+		[outer(2), outer(-2)]`, "[20, error(negative)]"},
+		{`let f = fn(x) { try { x? } finally { 0 }; 1 }; [f(2), f(error("bad"))]`, "[1, error(bad)]"},
+		{`let f = fn(x) { try { x? } catch (e) { 2 } }; f(error("bad"))`, "error(bad)"},
+		{`map([1, error("bad"), 3], fn(x) { x? * 2 })`, "[2, error(bad), 6]"},
+		{`let f = fn(x) { let y = if (x) { return 1 } else { 2 }; y + 10 }; [f(true), f(false)]`, "[1, 12]"},
+		{`let x = error("top")?; 5`, "error(top)"},
+	}
+
+	for _, tt := range tests {
+		result := testEval(tt.input)
+		if result.Inspect() != tt.expected {
+			t.Errorf("%q: want=%s, got=%s", tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestFunctionArguments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let f = fn(a, b) { a + b }; try { f(1) } catch (e) { e["message"] }`, "wrong number of arguments: want=2, got=1"},
+		{`let f = fn(a) { a }; try { f(1, 2) } catch (e) { e["message"] }`, "wrong number of arguments: want=1, got=2"},
+		{`let f = fn(a, b = 2) { a }; try { f() } catch (e) { e["message"] }`, "wrong number of arguments: want=1 to 2, got=0"},
+		{`let f = fn(a, ...rest) { a }; try { f() } catch (e) { e["message"] }`, "wrong number of arguments: want=at least 1, got=0"},
+		{`let f = fn(a, b = 10) { a + b }; [f(1), f(1, 2)]`, "[11, 3]"},
+		{`let f = fn(a, b = a * 2, c = a + b) { [a, b, c] }; [f(1), f(1, 5), f(1, 5, 0)]`, "[[1, 2, 3], [1, 5, 6], [1, 5, 0]]"},
+		{`let n = 5; let f = fn(a = n) { a }; f()`, "5"},
+		{`let y = 5; let f = fn(x = y, y = 1) { [x, y] }; [f(), f(2)]`, "[[5, 1], [2, 1]]"},
+		{`let a = 7; let f = fn(a = a) { a }; [f(), f(2)]`, "[7, 2]"},
+		{`let f = fn(a = error("none")?) { a }; [f(1), f()]`, "[1, error(none)]"},
+		{`let f = fn(a, ...rest) { [a, rest] }; [f(1), f(1, 2, 3)]`, "[[1, []], [1, [2, 3]]]"},
+		{`let f = fn(a, b = 2, ...rest) { [a, b, rest] }; [f(1), f(1, 3), f(1, 3, 4, 5)]`, "[[1, 2, []], [1, 3, []], [1, 3, [4, 5]]]"},
+		{`let f = fn(...xs) { len(xs) }; let xs = [1, 2, 3]; [f(...xs), f(0, ...xs, 4), f(...xs, ...xs), f(...[])]`, "[3, 5, 6, 0]"},
+		{`let add = fn(a, b) { a + b }; add(...[1, 2])`, "3"},
+		{`len(...[[1, 2, 3]])`, "3"},
+		{`let f = fn(a) { a }; try { f(...5) } catch (e) { e["message"] }`, "spread argument must be an array, got INTEGER"},
+		{`let sum = fn(acc, ...xs) { if (len(xs) == 0) { acc } else { sum(acc + xs[0], ...rest(xs)) } }; sum(0, 1, 2, 3, 4)`, "10"},
+		{`let count = fn(n, acc = 0) { if (n == 0) { acc } else { count(n - 1, acc + 1) } }; count(500)`, "500"},
+		{`let count = fn(n, ...xs) { if (n == 0) { len(xs) } else { count(n - 1, n, n) } }; count(500)`, "2"},
+	}
+
+	for _, tt := range tests {
+		result := testEval(tt.input)
+		if result.Inspect() != tt.expected {
+			t.Errorf("%q: want=%s, got=%s", tt.input, tt.expected, result.Inspect())
+		}
+	}
+
+	//a default cannot read the parameters after it, which are not set yet
+	result := testEval("fn(x = y, y = 1) { x }()")
+	if result.Inspect() != "ERROR: Identifier not found: y" {
+		t.Errorf("wrong result for a default reading a later parameter. got=%s", result.Inspect())
+	}
+}
+
+func TestDestructuring(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let [a, b] = [1, 2]; a + b`, "3"},
+		{`let [] = []; 1`, "1"},
+		{`let [first, ...rest] = [1, 2, 3]; [first, rest]`, "[1, [2, 3]]"},
+		{`let [x, ...xs] = [1]; xs`, "[]"},
+		{`let {name, age} = {"name": "hulk", "age": 30}; [name, age]`, "[hulk, 30]"},
+		{`let {name: n, tags: [t, ...ts]} = {"name": "a", "tags": [1, 2, 3]}; [n, t, ts]`, "[a, 1, [2, 3]]"},
+		{`let [[a, b], {c}] = [[1, 2], {"c": 3}]; a + b + c`, "6"},
+		{`let f = fn() { let [a, b] = [1, 2]; let {c} = {"c": a + b}; c * 10 }; f()`, "30"},
+		{`let swap = fn([a, b]) { [b, a] }; swap([1, 2])`, "[2, 1]"},
+		{`let f = fn({x, y}, [z] = [x + y]) { [x, y, z] }; [f({"x": 1, "y": 2}), f({"x": 1, "y": 2}, [0])]`, "[[1, 2, 3], [1, 2, 0]]"},
+		{`let f = fn(n, [a, ...more]) { if (len(more) == 0) { n + a } else { f(n + a, more) } }; f(0, [1, 2, 3, 4])`, "10"},
+		{`let adder = fn({by}) { fn(x) { x + by } }; adder({"by": 2})(3)`, "5"},
+		{`try { let [a, b] = [1]; a } catch (e) { e["message"] }`, "array pattern wants 2 elements, got 1"},
+		{`try { let [a, ...b] = []; a } catch (e) { e["message"] }`, "array pattern wants at least 1 elements, got 0"},
+		{`try { let [a] = 5; a } catch (e) { e["message"] }`, "cannot destructure INTEGER with an array pattern"},
+		{`try { let {a} = [1]; a } catch (e) { e["message"] }`, "cannot destructure ARRAY with a hash pattern"},
+		{`try { let {a} = {"b": 1}; a } catch (e) { e["message"] }`, `hash pattern key "a" not found`},
+		{`let f = fn([a]) { a }; try { f([1, 2]) } catch (e) { e["message"] }`, "array pattern wants 1 elements, got 2"},
+	}
+
+	for _, tt := range tests {
+		result := testEval(tt.input)
+		if result.Inspect() != tt.expected {
+			t.Errorf("%q: want=%s, got=%s", tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestMatchExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`match (2) { 1 => "one", 2 => "two", _ => "many" }`, "two"},
+		{`match (7) { 1 => "one", 2 => "two", _ => "many" }`, "many"},
+		{`match ("b") { "a" => 1, "b" => 2, _ => 3 }`, "2"},
+		{`match (-1) { -1 => "minus one", _ => "other" }`, "minus one"},
+		{`match (true) { false => 0, true => 1 }`, "1"},
+		{`match ("1") { 1 => "int", _ => "string" }`, "string"},
+		{`match (5) { n => n * 2 }`, "10"},
+		{`match ([1, 2, 3]) { [] => "empty", [x] => x, [x, ...rest] => rest }`, "[2, 3]"},
+		{`match ([]) { [] => "empty", [x, ...rest] => rest }`, "empty"},
+		{`match ([1, [2, 3]]) { [1, [a, b]] => a + b, _ => 0 }`, "5"},
+		{`match ([1, 2]) { [_, _, _, ..._] => "long", [_, _] => "pair", _ => "other" }`, "pair"},
+		{`match ([1, 2]) { [_, _, ..._] => "two or more", _ => "other" }`, "two or more"},
+		{`match ({"kind": "circle", "r": 2}) { {kind: "square", side} => side * side, {kind: "circle", r} => 3 * r * r }`, "12"},
+		{`match ({"a": 1}) { {b} => b, {a} => a }`, "1"},
+		{`match (5) { n if n > 10 => "big", n if n > 0 => "small", _ => "other" }`, "small"},
+		{`match ([3, 1]) { [a, b] if a < b => "up", [a, b] => "down" }`, "down"},
+		{`match (5) { "5" => 0, [a] => a, {a} => a, _ => 1 }`, "1"},
+		{`let size = fn(xs) { match (xs) { [] => 0, [_, ...rest] => 1 + size(rest) } }; size([1, 2, 3])`, "3"},
+		{`let count = fn(n, acc) { match (n) { 0 => acc, _ => count(n - 1, acc + 1) } }; count(2000, 0)`, "2000"},
+		{`let f = fn(x) { match (x) { [a] => match (a) { 0 => "zero", _ => "one" }, _ => "none" } }; [f([0]), f([1]), f(2)]`, "[zero, one, none]"},
+		{`let x = 1; let y = match (5) { x => x }; [x, y]`, "[5, 5]"},
+		{`let x = 10; let r = match (5) { x if x > 100 => 1, _ => x }; [r, x]`, "[10, 10]"},
+		{`let f = fn() { let y = 1; match (2) { y if false => 0, _ => y } }; f()`, "1"},
+		{`let x = 10; let r = match ([1]) { [x, y] => 1, _ => x }; [r, x]`, "[10, 10]"},
+		{`let x = 1; let r = match (5) { x if x > 1 => x, _ => 0 }; [r, x]`, "[5, 5]"},
+		{`let f = fn(x) { match (x) { 1 => error("early")?, _ => 0 }; "late" }; [f(1), f(2)]`, "[error(early), late]"},
+		{`try { match (3) { 1 => 1, 2 => 2 } } catch (e) { e["message"] }`, "no match arm matches 3"},
+		{`try { match ([1]) { [a] if a > 1 => a } } catch (e) { e["message"] }`, "no match arm matches [1]"},
+	}
+
+	for _, tt := range tests {
+		result := testEval(tt.input)
+		if result.Inspect() != tt.expected {
+			t.Errorf("%q: want=%s, got=%s", tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestStructs(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`struct Point { x, y }; Point(1, 2)`, "Point{x: 1, y: 2}"},
+		{`struct Point { x, y }; Point`, "<struct Point>"},
+		{`struct Empty {}; Empty()`, "Empty{}"},
+		{`struct Point { x, y }; let p = Point(1, 2); p.x + p.y`, "3"},
+		{`struct Pair { a, b }; Pair("x", [1, Pair(2, 3)])`, "Pair{a: x, b: [1, Pair{a: 2, b: 3}]}"},
+		{`struct Point { x, y }; let p = Point(1, 2); let q = p; p.x = 10; let old = q.x; p.x = 20; [old, q]`, "[10, Point{x: 20, y: 2}]"},
+		{`struct Point { x, y }; let p = Point(1, 2); let set = fn(v) { p.y = v }; set(5)`, "5"},
+		{`struct Point { x, y, fn norm() { self.x * self.x + self.y * self.y } }; Point(3, 4).norm()`, "25"},
+		{`struct Point { x, y, fn add(o) { Point(self.x + o.x, self.y + o.y) } }; Point(1, 2).add(Point(10, 20))`, "Point{x: 11, y: 22}"},
+		{`struct Counter { n, fn inc(by = 1) { self.n = self.n + by; self } }; let c = Counter(0); c.inc().inc(5).n`, "6"},
+		{`struct Box { v, fn get() { self.v } }; let get = Box(7).get; [get, get()]`, "[<method Box.get>, 7]"},
+		{`struct Box { v, fn get() { self.v } }; map([Box(1), Box(2)], fn(b) { b.get() })`, "[1, 2]"},
+		{`struct List { items, fn all(...more) { [self.items, more] } }; List(1).all(2, 3)`, "[1, [2, 3]]"},
+		{`let make = fn(v) { struct Node { v, fn double() { Node(self.v * 2) } }; Node(v).double() }; make(4)`, "Node{v: 8}"},
+		{`struct Node { v, next, fn sum() { match (self.next) { false => self.v, n => self.v + n.sum() } } }; Node(1, Node(2, Node(3, false))).sum()`, "6"},
+		{`struct C { n, fn down() { if (self.n == 0) { "done" } else { self.n = self.n - 1; self.down() } } }; C(2000).down()`, "done"},
+		{`try { struct P { x }; P(1).y } catch (e) { e["message"] }`, "P has no field or method y"},
+		{`try { struct P { x }; let p = P(1); p.y = 2 } catch (e) { e["message"] }`, "P has no field y"},
+		{`try { struct P { x, fn f() { 1 } }; let p = P(1); p.f = 2 } catch (e) { e["message"] }`, "cannot assign to method f of P"},
+		{`try { struct P { x, y }; P(1) } catch (e) { e["message"] }`, "wrong number of arguments: want=2, got=1"},
+		{`try { struct P { x, fn f(a) { a } }; P(1).f() } catch (e) { e["message"] }`, "wrong number of arguments: want=1, got=0"},
+		{`try { 5.x } catch (e) { e["message"] }`, "INTEGER has no method x"},
+		{`try { let h = {}; h.x = 1 } catch (e) { e["message"] }`, "cannot assign x of HASH, only structs have fields"},
+	}
+
+	for _, tt := range tests {
+		result := testEval(tt.input)
+		if result.Inspect() != tt.expected {
+			t.Errorf("%q: want=%s, got=%s", tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestMethods(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"abc".upper()`, "ABC"},
+		{`"a,b,c".split(",").reverse().join("-")`, "c-b-a"},
+		{`[3, 1, 2].map(fn(x) { x * 2 }).sort()`, "[2, 4, 6]"},
+		{`[1, 2, 3, 4].filter(fn(x) { x > 2 }).reduce(fn(a, b) { a + b }, 0)`, "7"},
+		{`let s = "hulk"; [s.len(), [1, 2].len(), {"a": 1}.len()]`, "[4, 2, 1]"},
+		{`5.to_string().repeat(2)`, "55"},
+		{`"%d smash".format(3)`, "3 smash"},
+		{`let h = {"b": 2, "a": 1, "c": 3}; [h.keys(), h.values()]`, "[[a, b, c], [1, 2, 3]]"},
+		{`let h = {"name": "hulk"}; [h.name, h.has("name"), h.has("age")]`, "[hulk, true, false]"},
+		{`let h = {"keys": 1}; h.keys`, "1"},
+		{`{"a": 1}.missing`, "null"},
+		{`let arr = [1]; arr.push_mut(2); arr`, "[1, 2]"},
+		{`let upper = "abc".upper; upper()`, "ABC"},
+		{`"abc".upper`, "<method STRING.upper>"},
+		{`map(["a", "b"], fn(s) { s.upper() })`, "[A, B]"},
+		{`try { "abc".upper(1) } catch (e) { e["message"] }`, "wrong number of argument. got=1 want=0"},
+		{`try { [1].sort(1, 2) } catch (e) { e["message"] }`, "wrong number of argument. got=2 want=0 or 1"},
+		{`try { [1].join(1) } catch (e) { e["message"] }`, "expected a string for function JOIN, got=INTEGER"},
+		{`try { "abc".nope() } catch (e) { e["message"] }`, "STRING has no method nope"},
+		{`try { true.upper() } catch (e) { e["message"] }`, "BOOLEAN has no method upper"},
+	}
+
+	for _, tt := range tests {
+		result := testEval(tt.input)
+		if result.Inspect() != tt.expected {
+			t.Errorf("%q: want=%s, got=%s", tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestEnums(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`enum Shape { Circle(r), Rect(w, h), Empty }; Shape`, "<enum Shape>"},
+		{`enum Shape { Circle(r), Rect(w, h), Empty }; [Shape.Circle, Shape.Empty, Shape.Rect(2, 3)]`, "[<variant Shape.Circle>, Shape.Empty, Shape.Rect(2, 3)]"},
+		{`enum Shape { Circle(r), Rect(w, h) }; let s = Shape.Rect(2, 3); s.w * s.h`, "6"},
+		{`enum Shape { Circle(r), Empty }; [tag_of(Shape.Circle(1)), Shape.Empty.tag_of()]`, "[Circle, Empty]"},
+		{`enum Shape { Circle(r), Empty }; [Shape.Circle(1) == Shape.Circle(1), Shape.Circle(1) == Shape.Circle(2), Shape.Empty == Shape.Empty, Shape.Empty != Shape.Circle(1)]`, "[true, false, true, true]"},
+		{`enum A { X }; enum B { X }; A.X == B.X`, "false"},
+		{`enum Opt { Some(value), None }; let h = {Opt.Some(1): "one", Opt.None: "none"}; [h[Opt.Some(1)], h[Opt.None], h[Opt.Some(2)]]`, "[one, none, null]"},
+		{`try { enum E { A(x) }; {E.A([1]): 1} } catch (e) { e["message"] }`, "unusable as hashkey: ENUM"},
+		{`let mk = fn() { enum E { A }; E.A }; let h = {mk(): 1}; [h[mk()], mk() == mk()]`, "[null, false]"},
+		{`enum Opt { Some(value), None }; let s = Opt.Some; map([1, 2], s)`, "[Opt.Some(1), Opt.Some(2)]"},
+		{`enum Shape { Circle(r), Rect(w, h), Empty };
+		  let area = fn(s) { match (tag_of(s)) { "Circle" => 3 * s.r * s.r, "Rect" => s.w * s.h, _ => 0 } };
+		  map([Shape.Circle(2), Shape.Rect(2, 3), Shape.Empty], area)`, "[12, 6, 0]"},
+		{`let f = fn() { enum Dir { Up, Down }; Dir.Down }; f()`, "Dir.Down"},
+		{`try { enum E { A }; E.B } catch (e) { e["message"] }`, "enum E has no variant B"},
+		{`try { enum E { A(x) }; E.A(1).y } catch (e) { e["message"] }`, "E.A has no field or method y"},
+		{`try { enum E { A(x) }; E.A() } catch (e) { e["message"] }`, "wrong number of arguments: want=1, got=0"},
+		{`try { tag_of(1) } catch (e) { e["message"] }`, "expected an enum value for function TAG_OF, got=INTEGER"},
+	}
+
+	for _, tt := range tests {
+		result := testEval(tt.input)
+		if result.Inspect() != tt.expected {
+			t.Errorf("%q: want=%s, got=%s", tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
+// testModules are the files the programs of TestImports can import.
+var testModules = fstest.MapFS{
+	"lib/math.hk":    {Data: []byte(`import "./helpers.hk" as h; export let square = fn(x) { h["times"](x, x) }; export let [one, two] = [1, 2]; let hidden = 3;`)},
+	"lib/helpers.hk": {Data: []byte(`export let times = fn(a, b) { a * b };`)},
+	"lib/x.hk":       {Data: []byte(`let x = 2; export let get = fn() { x };`)},
+	"lib/broken.hk":  {Data: []byte(`export let x = 1; let y = len(x);`)},
+	"lib/bad.hk":     {Data: []byte(`export let = 1;`)},
+	"lib/return.hk":  {Data: []byte(`let f = fn() { return 1 }; return 2;`)},
+	"cycle/a.hk":     {Data: []byte(`import "./b.hk" as b; export let a = 1;`)},
+	"cycle/b.hk":     {Data: []byte(`import "./a.hk" as a; export let b = 2;`)},
+	"vendor/pkg.hk":  {Data: []byte(`export let name = "vendored";`)},
+	"lib/shapes.hk":  {Data: []byte(`export struct Square { side, fn area() { self.side * self.side } }; export enum Kind { Flat, Solid(faces) }`)},
+}
+
+func testEvalImporting(input string) object.Object {
+	modules := loader.New(".", "vendor")
+	modules.ReadFile = func(name string) ([]byte, error) { return fs.ReadFile(testModules, name) }
+
+	env := object.NewEnvironment()
+	env.SetImporter(NewImporter(modules, ""))
+	return Eval(parser.New(lexer.New(input)).ParseProgram(), env)
+}
+
+func TestImports(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`import "lib/math.hk" as m; m["square"](4) + m["two"]`, "18"},
+		{`import "lib/math.hk" as m; m`, "<module lib/math.hk>"},
+		{`import "lib/math.hk" as m; m.square(m.two)`, "4"},
+		{`import "lib/math.hk" as a; import "./lib/math.hk" as b; import "lib/helpers.hk" as h; [a == b, h == a]`, "[true, false]"},
+		{`let f = fn() { import "lib/helpers.hk" as h; h["times"](3, 3) }; f() + f()`, "18"},
+		{`import "pkg.hk" as p; p["name"]`, "vendored"},
+		{`import "lib/shapes.hk" as s; let sq = s["Square"](3); [sq, sq.area()]`, "[Square{side: 3}, 9]"},
+		{`import "lib/shapes.hk" as s; [s.Kind.Flat, s.Kind.Solid(6)]`, "[Kind.Flat, Kind.Solid(6)]"},
+		{`let x = 1; import "lib/x.hk" as m; [x, m["get"]()]`, "[1, 2]"},
+		{`import "lib/math.hk" as m; try { m["hidden"] } catch (e) { e["message"] }`, "module lib/math.hk does not export hidden"},
+		{`import "lib/math.hk" as m; try { m[1] } catch (e) { e["message"] }`, "module exports are looked up by name, got INTEGER"},
+		{`import "lib/math.hk" as m; try { m.hidden } catch (e) { e["message"] }`, "module lib/math.hk does not export hidden"},
+		{`try { import "lib/broken.hk" as b; b } catch (e) { e["message"] }`, "argument to len() not supported, got INTEGER"},
+		{`try { import "nope.hk" as n; n } catch (e) { e["message"] }`, `cannot find module "nope.hk"`},
+		{`try { import "cycle/a.hk" as a; a } catch (e) { e["message"] }`, "import cycle: cycle/a.hk -> cycle/b.hk -> cycle/a.hk"},
+		{`try { import "lib/bad.hk" as b; b } catch (e) { e["message"] }`, "lib/bad.hk: parser errors:\n\texpected next token to be IDENTIFIER, got = instead\n\tno prefix function found for ="},
+		{`try { import "lib/return.hk" as r; r } catch (e) { e["message"] }`, "lib/return.hk: line 1: return outside of a function"},
+	}
+
+	for _, tt := range tests {
+		result := testEvalImporting(tt.input)
+		if result.Inspect() != tt.expected {
+			t.Errorf("%q: want=%s, got=%s", tt.input, tt.expected, result.Inspect())
+		}
+	}
+
+	//the top level of a module shows up in stack traces
+	errObj, ok := testEvalImporting("let a = 1;\nimport \"lib/broken.hk\" as b;").(*object.Error)
+	if !ok {
+		t.Fatalf("expected an error")
+	}
+	want := "argument to len() not supported, got INTEGER\n\tat <module lib/broken.hk> (line 1)\n\tat <main> (line 2)"
+	if errObj.Traceback() != want {
+		t.Errorf("wrong traceback.\nwant=%q\ngot=%q", want, errObj.Traceback())
+	}
+
+	result := testEval(`import "lib/math.hk" as m;`)
+	if result.Inspect() != `ERROR: cannot import "lib/math.hk", imports are not enabled` {
+		t.Errorf("wrong error without an importer. got=%s", result.Inspect())
+	}
+}
+
+func TestLimits(t *testing.T) {
+	loop := `let loop = fn(n) { loop(n + 1) }; loop(0)`
+	deep := `let deep = fn(n) { 1 + deep(n + 1) }; deep(0)`
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		input  string
+		ctx    context.Context
+		limits object.Limits
+		limit  string
+	}{
+		{loop, context.Background(), object.Limits{MaxSteps: 10000}, "steps"},
+		{loop, cancelled, object.Limits{}, "context"},
+		{deep, context.Background(), object.Limits{MaxCallDepth: 100}, "call depth"},
+		{`map(range(100), fn(x) { [x] })`, context.Background(), object.Limits{MaxAllocations: 50}, "allocations"},
+		{`let s = "ab"; s + s + s`, context.Background(), object.Limits{MaxStringLength: 4}, "string length"},
+		{`repeat("ab", 1000000000000)`, context.Background(), object.Limits{MaxStringLength: 1000}, "string length"},
+		{`range(1000000000000)`, context.Background(), object.Limits{MaxArrayLength: 1000}, "array length"},
+		{`[1, 2, 3][0:2]`, context.Background(), object.Limits{MaxArrayLength: 1}, "array length"},
+		{`try { ` + loop + ` } catch (e) { 0 }`, context.Background(), object.Limits{MaxSteps: 10000}, "steps"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		_, err := EvalContext(tt.ctx, program, object.NewEnvironment(), tt.limits)
+
+		var limitErr *object.LimitError
+		if !errors.As(err, &limitErr) {
+			t.Errorf("%q: expected a limit error, got=%v", tt.input, err)
+			continue
+		}
+		if limitErr.Limit != tt.limit {
+			t.Errorf("%q: wrong limit. want=%q, got=%q", tt.input, tt.limit, limitErr.Limit)
+		}
+	}
+
+	//tail calls do not count against the call depth, and programs within
+	//their limits run as usual
+	input := `let f = fn(n) { if (n == 0) { 5 } else { f(n - 1) } }; f(1000)`
+	program := parser.New(lexer.New(input)).ParseProgram()
+	result, err := EvalContext(context.Background(), program, object.NewEnvironment(), object.Limits{MaxCallDepth: 10, MaxSteps: 100000})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	testIntegerObject(t, result, 5)
+}
+
+func TestDivisionByZero(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"10 / 0", "ERROR: division by zero"},
+		{`try { 1 / (2 - 2) } catch (e) { e["message"] }`, "division by zero"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		result, err := EvalContext(context.Background(), program, object.NewEnvironment(), object.Limits{MaxSteps: 1000})
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %s", tt.input, err)
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("%q: wrong result. want=%q, got=%q", tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
 func TestStringLiteral(t *testing.T) {
 	input := `"input string"`
 	evaluated := testEval(input)
@@ -315,6 +832,23 @@ func TestStringConcat(t *testing.T) {
 	}
 }
 
+func TestStringComparison(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{`"a" == "a"`, true},
+		{`"a" == "b"`, false},
+		{`"a" != "b"`, true},
+		{`let s = "a"; s + "b" == "ab"`, true},
+		{`let s = "a"; s + "b" != "ab"`, false},
+	}
+
+	for _, tt := range tests {
+		testBooleanObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
 func TestBuiltInFunctions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -325,6 +859,9 @@ func TestBuiltInFunctions(t *testing.T) {
 		{`len("Hello World")`, 11},
 		{`len(1)`, "argument to len() not supported, got INTEGER"},
 		{`len("one","two")`, "wrong number of argument. got=2 want=1"},
+		{`first(1)`, "expected an array for function FIRST, got=INTEGER"},
+		{`push([])`, "wrong number of argument. got=1 want=2"},
+		{`index_of(1, 1)`, "expected an array or a string for function INDEX_OF, got=INTEGER"},
 	}
 
 	for _, tt := range tests {
@@ -456,3 +993,284 @@ func TestHashLiterals(t *testing.T) {
 		testIntegerObject(t, pair.Value, expectedValue)
 	}
 }
+
+func TestSliceExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"[1,2,3,4,5][1:3]", []int64{2, 3}},
+		{"[1,2,3,4,5][:2]", []int64{1, 2}},
+		{"[1,2,3,4,5][3:]", []int64{4, 5}},
+		{"[1,2,3,4,5][:]", []int64{1, 2, 3, 4, 5}},
+		{"[1,2,3,4,5][-2:]", []int64{4, 5}},
+		{"[1,2,3,4,5][:-2]", []int64{1, 2, 3}},
+		{"[1,2,3,4,5][::2]", []int64{1, 3, 5}},
+		{"[1,2,3,4,5][::-1]", []int64{5, 4, 3, 2, 1}},
+		{"[1,2,3,4,5][3:0:-1]", []int64{4, 3, 2}},
+		{"[1,2,3,4,5][10:]", []int64{}},
+		{"[1,2,3,4,5][-10:2]", []int64{1, 2}},
+		{"let a=[1,2,3];let n=2;a[:n]", []int64{1, 2}},
+		{`"hello"[1:3]`, "el"},
+		{`"hello"[:-1]`, "hell"},
+		{`"hello"[::-1]`, "olleh"},
+		{`"hello"[5:]`, ""},
+		{"[1,2,3][::0]", errorMessage("slice step cannot be zero")},
+		{`[1,2,3]["a":]`, errorMessage("slice start must be INTEGER, got STRING")},
+		{"5[1:2]", errorMessage("slice operator not supported: INTEGER")},
+	}
+
+	for _, tt := range tests {
+		testExpectedObject(t, tt.input, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestCollectionBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"map([1, 2, 3], fn(x) { x * 2 })", []int64{2, 4, 6}},
+		{"map([], fn(x) { x })", []int64{}},
+		{"filter([1, 2, 3, 4], fn(x) { x > 2 })", []int64{3, 4}},
+		{"reduce([1, 2, 3, 4], fn(acc, x) { acc + x })", 10},
+		{"reduce([], fn(acc, x) { acc + x }, 7)", 7},
+		{"sort([3, 1, 2])", []int64{1, 2, 3}},
+		{"sort([3, 1, 2], fn(a, b) { a > b })", []int64{3, 2, 1}},
+		{`len(sort(["b", "a"]))`, 2},
+		{"reverse([1, 2, 3])", []int64{3, 2, 1}},
+		{"contains([1, 2, 3], 2)", true},
+		{`contains([1, "two"], "two")`, true},
+		{"contains([[1]], [1])", true},
+		{"contains([1, 2, 3], 4)", false},
+		{"index_of([1, 2, 3], 3)", 2},
+		{"index_of([1, 2, 3], 4)", -1},
+		{"zip([1, 2, 3], [4, 5])[1]", []int64{2, 5}},
+		{"range(4)", []int64{0, 1, 2, 3}},
+		{"range(2, 5)", []int64{2, 3, 4}},
+		{"range(5, 0, -2)", []int64{5, 3, 1}},
+		{"flatten([1, [2, [3, [4]]], []])", []int64{1, 2, 3, 4}},
+		{"unique([1, 2, 1, 3, 2])", []int64{1, 2, 3}},
+		{"concat([1], [], [2, 3])", []int64{1, 2, 3}},
+		{"concat()", []int64{}},
+		{"map([[1], [2]], first)", []int64{1, 2}},
+		{"let k = 10; map([1, 2], fn(x) { x + k })", []int64{11, 12}},
+		{"map([1], 5)", errorMessage("not a function: INTEGER")},
+		{"map([1], fn(x) { x + true })", errorMessage("type mismatch: INTEGER + BOOLEAN")},
+		{"filter(1, fn(x) { x })", errorMessage("expected an array for function FILTER, got=INTEGER")},
+		{"reduce([], fn(a, b) { a })", errorMessage("reduce of empty array with no initial value")},
+		{`sort([1, "a"])`, errorMessage("cannot compare STRING and INTEGER, pass a comparator to sort")},
+		{"sort([2, 1], fn(a, b) { a + true })", errorMessage("type mismatch: INTEGER + BOOLEAN")},
+		{"range(1, 5, 0)", errorMessage("range step cannot be zero")},
+		{"range()", errorMessage("wrong number of argument. got=0 want=1 to 3")},
+		{"sort([1], fn(a, b) { a }, 3)", errorMessage("wrong number of argument. got=3 want=1 or 2")},
+		{`range(1, "5")`, errorMessage("expected an integer for function RANGE, got=STRING")},
+		{"concat([1], 2)", errorMessage("expected an array for function CONCAT, got=INTEGER")},
+	}
+
+	for _, tt := range tests {
+		testExpectedObject(t, tt.input, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestStringBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`join(map(split("a,b,c", ","), upper), "-")`, "A-B-C"},
+		{`lower("HuLK")`, "hulk"},
+		{`starts_with("smash", "sm")`, true},
+		{`ends_with("smash", "sm")`, false},
+		{`sprintf("%s has %d chars", "hulk", len("hulk"))`, "hulk has 4 chars"},
+		{`to_string([1, "a"])`, "[1, a]"},
+		{`parse_int("12") * 2`, 24},
+		{`parse_int("twelve")`, errorMessage(`could not parse "twelve" as integer`)},
+		{`split("a", 1)`, errorMessage("expected a string for function SPLIT, got=INTEGER")},
+		{`contains("abc", 1)`, errorMessage("expected a string for function CONTAINS, got=INTEGER")},
+	}
+
+	for _, tt := range tests {
+		testExpectedObject(t, tt.input, testEval(tt.input), tt.expected)
+	}
+}
+
+type errorMessage string
+
+// testExpectedObject checks evaluated against an expectation written as a
+// Go value: int, bool, string, []int64 for arrays of integers, errorMessage
+// for errors and nil for NULL.
+func testExpectedObject(t *testing.T, input string, evaluated object.Object, expected interface{}) {
+	t.Helper()
+
+	switch expected := expected.(type) {
+	case int:
+		testIntegerObject(t, evaluated, int64(expected))
+	case bool:
+		testBooleanObject(t, evaluated, expected)
+	case nil:
+		testNullObject(t, evaluated)
+	case string:
+		str, ok := evaluated.(*object.String)
+		if !ok {
+			t.Errorf("object is not String for %q, got=%T (%v)", input, evaluated, evaluated)
+			return
+		}
+		if str.Value != expected {
+			t.Errorf("string got wrong value for %q, expected=%q, got=%q", input, expected, str.Value)
+		}
+	case []int64:
+		arr, ok := evaluated.(*object.Array)
+		if !ok {
+			t.Errorf("object is not Array for %q, got=%T (%v)", input, evaluated, evaluated)
+			return
+		}
+		if len(arr.Elements) != len(expected) {
+			t.Errorf("wrong number of elements for %q, expected=%d, got=%d", input, len(expected), len(arr.Elements))
+			return
+		}
+		for i, el := range expected {
+			testIntegerObject(t, arr.Elements[i], el)
+		}
+	case errorMessage:
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned for %q, got=%T(%v)", input, evaluated, evaluated)
+			return
+		}
+		if errObj.Message != string(expected) {
+			t.Errorf("wrong error message for %q, expected=%q, got=%q", input, expected, errObj.Message)
+		}
+	}
+}
+
+func TestArrayBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"first([1, 2])", 1},
+		{"first([])", nil},
+		{"last([1, 2])", 2},
+		{"last([])", nil},
+		{"rest([1, 2, 3])", []int64{2, 3}},
+		{"rest([1])", []int64{}},
+		{"rest([])", []int64{}},
+		{"push([1, 2], 3)", []int64{1, 2, 3}},
+		{"push([], 1)", []int64{1}},
+		{"let a = [1, 2]; push(a, 3); a", []int64{1, 2}},
+		{"pop([1, 2, 3])", []int64{1, 2}},
+		{"pop([])", []int64{}},
+		{"let a = [1, 2]; pop(a); a", []int64{1, 2}},
+		{"insert([1, 3], 1, 2)", []int64{1, 2, 3}},
+		{"insert([1, 2], 2, 3)", []int64{1, 2, 3}},
+		{"insert([2, 3], 0, 1)", []int64{1, 2, 3}},
+		{"insert([1, 3], -1, 2)", []int64{1, 2, 3}},
+		{"insert([], 0, 1)", []int64{1}},
+		{"let a = [1, 3]; insert(a, 1, 2); a", []int64{1, 3}},
+		{"remove([1, 2, 3], 1)", []int64{1, 3}},
+		{"remove([1, 2, 3], -1)", []int64{1, 2}},
+		{"let a = [1, 2]; remove(a, 0); a", []int64{1, 2}},
+		{"let a = [1, 2]; push_mut(a, 3); a", []int64{1, 2, 3}},
+		{"push_mut([], 1)", []int64{1}},
+		{"let a = [1, 2]; pop_mut(a)", 2},
+		{"let a = [1, 2]; pop_mut(a); a", []int64{1}},
+		{"pop_mut([])", nil},
+		{"let a = [1, 3]; insert_mut(a, 1, 2); a", []int64{1, 2, 3}},
+		{"let a = [1, 2]; insert_mut(a, 2, 3)", []int64{1, 2, 3}},
+		{"let a = [1, 2, 3]; remove_mut(a, 0)", 1},
+		{"let a = [1, 2, 3]; remove_mut(a, -1); a", []int64{1, 2}},
+		{"let a = [1, 2]; let b = push(a, 3); push_mut(b, 4); a", []int64{1, 2}},
+		{"insert([1], 2, 0)", errorMessage("index 2 out of range for array of length 1")},
+		{"insert([1], -2, 0)", errorMessage("index -2 out of range for array of length 1")},
+		{"remove([1], 1)", errorMessage("index 1 out of range for array of length 1")},
+		{"remove([], 0)", errorMessage("index 0 out of range for array of length 0")},
+		{"remove_mut([], -1)", errorMessage("index -1 out of range for array of length 0")},
+		{"insert_mut([1], 5, 0)", errorMessage("index 5 out of range for array of length 1")},
+		{"first()", errorMessage("wrong number of argument. got=0 want=1")},
+		{"last([1], [2])", errorMessage("wrong number of argument. got=2 want=1")},
+		{"rest(1)", errorMessage("expected an array for function REST, got=INTEGER")},
+		{"push(1, 1)", errorMessage("expected an array for function PUSH, got=INTEGER")},
+		{"push([1])", errorMessage("wrong number of argument. got=1 want=2")},
+		{`pop("a")`, errorMessage("expected an array for function POP, got=STRING")},
+		{`insert([1], "0", 1)`, errorMessage("expected an integer for function INSERT, got=STRING")},
+		{"insert([1], 0)", errorMessage("wrong number of argument. got=2 want=3")},
+		{"remove([1])", errorMessage("wrong number of argument. got=1 want=2")},
+		{"push_mut(1, 1)", errorMessage("expected an array for function PUSH_MUT, got=INTEGER")},
+		{"pop_mut()", errorMessage("wrong number of argument. got=0 want=1")},
+		{"insert_mut([1], true, 1)", errorMessage("expected an integer for function INSERT_MUT, got=BOOLEAN")},
+		{"remove_mut({}, 0)", errorMessage("expected an array for function REMOVE_MUT, got=HASH")},
+	}
+
+	for _, tt := range tests {
+		testExpectedObject(t, tt.input, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestJSONBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`json_parse("[1, 2, 3]")`, []int64{1, 2, 3}},
+		{`json_parse("{\"port\": 8080}")["port"]`, 8080},
+		{`json_stringify({"a": [1, true, "x"]})`, `{"a":[1,true,"x"]}`},
+		{`json_stringify(json_parse("{\"b\":1,\"a\":null}"))`, `{"a":null,"b":1}`},
+		{`json_stringify([1], 1)`, "[\n 1\n]"},
+		{`json_stringify({"f": fn(x) { x }})`, errorMessage("cannot encode a function as JSON")},
+		{`json_stringify({1: 2})`, errorMessage("cannot encode hash key 1 of type INTEGER as JSON, keys must be strings")},
+		{`json_parse("3.14")`, errorMessage("JSON number 3.14 is not an integer")},
+	}
+
+	for _, tt := range tests {
+		testExpectedObject(t, tt.input, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestOptimizedMatchesUnoptimized(t *testing.T) {
+	inputs := []string{
+		"1 + 2 * 3 - 4 / 2",
+		`"foo" + "bar"`,
+		`let s = "a"; s == s`,
+		"let a = 2; let b = a * 3; b + a",
+		"let a = 1; let a = a + 1; a",
+		"let f = fn() { a }; let a = 1; f()",
+		"let a = 1; let f = fn(a) { a * 10 }; f(5) + a",
+		"let add = fn(x, y) { x + y }; let z = 4; add(z, 3)",
+		"let sq = fn(x) { x * x }; sq(sq(3))",
+		"let pick = fn(x, y) { [y, x][0] }; pick(1, 2)",
+		"let f = fn(x) { 1 }; f(unknown)",
+		"let fib = fn(n) { if (n < 2) { return n; } fib(n - 1) + fib(n - 2) }; fib(10)",
+		"if (1 > 2) { 10 } else { 20 }",
+		"if (false) { 10 }",
+		"if (false) { 10 }; 5",
+		"if (true) { let x = 3; x * 2 }",
+		"let x = if (true) { 1 } else { 2 }; x",
+		"if (c) { let a = 1; } a",
+		"let unused = [1, 2]; let h = {1: fn() { 2 }}; 7",
+		"let a = 5;",
+		"let f = fn() { let a = 1; return a + 1; 3 }; f()",
+		"let g = fn(x) { if (true) { return x; } 0 }; g(9)",
+		`let t = true; let name = "n"; if (t) { name + "!" }`,
+		"let a = 3; map([1, 2], fn(x) { x * a })",
+	}
+
+	for _, input := range inputs {
+		want := testEval(input)
+
+		p := parser.New(lexer.New(input))
+		program := optimizer.Optimize(p.ParseProgram())
+		got := Eval(program, object.NewEnvironment())
+
+		if inspect(got) != inspect(want) {
+			t.Errorf("%s: results differ. unoptimized=%s, optimized=%s", input, inspect(want), inspect(got))
+		}
+	}
+}
+
+func inspect(obj object.Object) string {
+	if obj == nil {
+		return "nil"
+	}
+	return obj.Inspect()
+}
@@ -0,0 +1,89 @@
+package evaluator
+
+import (
+	"Hulk/ast"
+	"Hulk/loader"
+	"Hulk/object"
+	"path/filepath"
+	"strings"
+)
+
+// NewImporter returns the importer for a program read from file, empty
+// when it was not read from one. The modules it imports, and those they
+// import in turn, are found through l and run once each.
+func NewImporter(l *loader.Loader, file string) object.Importer {
+	modules := &modules{loader: l, loaded: map[string]*object.Module{}}
+	if file != "" {
+		//a module importing the program back is a cycle too
+		modules.loading = []string{filepath.Clean(file)}
+	}
+	return &importer{modules: modules, file: file}
+}
+
+// modules are the modules loaded by one program, by file.
+type modules struct {
+	loader *loader.Loader
+	loaded map[string]*object.Module
+	//files of the modules being run, each imported by the one before it
+	loading []string
+}
+
+// importer loads the modules imported by the module in file.
+type importer struct {
+	*modules
+	file string
+}
+
+func (i *importer) Import(path string, from *object.Environment) object.Object {
+	file, program, err := i.loader.Load(path, i.file)
+	if err != nil {
+		return NewError("%s", err)
+	}
+	if module, ok := i.loaded[file]; ok {
+		return module
+	}
+	for j, loading := range i.loading {
+		if loading == file {
+			return NewError("import cycle: %s", strings.Join(append(i.loading[j:], file), " -> "))
+		}
+	}
+
+	i.loading = append(i.loading, file)
+	defer func() { i.loading = i.loading[:len(i.loading)-1] }()
+
+	env := object.NewEnvironment()
+	env.SetMeter(from.Meter())
+	env.SetImporter(&importer{modules: i.modules, file: file})
+
+	module := &object.Module{Name: file, Exports: map[string]object.Object{}}
+	for _, stmt := range program.Statements {
+		result := Eval(stmt, env)
+		if err, ok := result.(*object.Error); ok {
+			err.AtLine(ast.StatementLine(stmt))
+			err.LeaveFunction(object.ModuleFunction(file))
+			return err
+		}
+
+		if export, ok := stmt.(*ast.ExportStatement); ok {
+			for _, name := range export.Names() {
+				module.Exports[name.Value], _ = env.Get(name.Value)
+			}
+		}
+	}
+
+	i.loaded[file] = module
+	return module
+}
+
+func evalImportStatement(node *ast.ImportStatement, env *object.Environment) object.Object {
+	importer := env.Importer()
+	if importer == nil {
+		return NewError("cannot import %q, imports are not enabled", node.Path)
+	}
+	module := importer.Import(node.Path, env)
+	if isError(module) {
+		return module
+	}
+	env.Set(node.Name.Value, module)
+	return module
+}
@@ -0,0 +1,127 @@
+package evaluator
+
+import (
+	"Hulk/ast"
+	"Hulk/object"
+)
+
+// bindPattern binds the names in pattern to the parts of val they stand
+// for. It returns an error object when val does not have the shape of the
+// pattern, nil otherwise. Literal patterns are not checked, match
+// expressions bind a pattern only once matchesPattern has.
+func bindPattern(pattern ast.Pattern, val object.Object, env *object.Environment) object.Object {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		env.Set(pattern.Value, val)
+
+	case *ast.ArrayPattern:
+		values, err := object.DestructureArray(val, len(pattern.Elements), pattern.Rest != nil)
+		if err != nil {
+			return NewError("%s", err)
+		}
+		for i, el := range pattern.Elements {
+			if err := bindPattern(el, values[i], env); err != nil {
+				return err
+			}
+		}
+		if name := pattern.RestBinding(); name != nil {
+			rest := track(env, values[len(pattern.Elements)])
+			if isError(rest) {
+				return rest
+			}
+			env.Set(name.Value, rest)
+		}
+
+	case *ast.HashPattern:
+		keys := make([]string, len(pattern.Keys))
+		for i, key := range pattern.Keys {
+			keys[i] = key.Value
+		}
+		values, err := object.DestructureHash(val, keys)
+		if err != nil {
+			return NewError("%s", err)
+		}
+		for i, value := range pattern.Values {
+			if err := bindPattern(value, values[i], env); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// matchesPattern reports whether val has the shape of pattern and equals
+// its literals, without binding anything.
+func matchesPattern(pattern ast.Pattern, val object.Object, env *object.Environment) bool {
+	switch pattern := pattern.(type) {
+	case *ast.LiteralPattern:
+		return object.Equals(Eval(pattern.Value, env), val)
+
+	case *ast.ArrayPattern:
+		arr, ok := val.(*object.Array)
+		if !ok {
+			return false
+		}
+		n := len(pattern.Elements)
+		if len(arr.Elements) < n || pattern.Rest == nil && len(arr.Elements) != n {
+			return false
+		}
+		for i, el := range pattern.Elements {
+			if !matchesPattern(el, arr.Elements[i], env) {
+				return false
+			}
+		}
+		return true
+
+	case *ast.HashPattern:
+		hash, ok := val.(*object.Hash)
+		if !ok {
+			return false
+		}
+		for i, key := range pattern.Keys {
+			pair, ok := hash.Pairs[(&object.String{Value: key.Value}).HashKey()]
+			if !ok || !matchesPattern(pattern.Values[i], pair.Value, env) {
+				return false
+			}
+		}
+		return true
+	}
+
+	//names and _ match anything
+	return true
+}
+
+// evalMatchExpression evaluates the body of the first arm matching the value
+// of me, as the value of the function when tail is set.
+func evalMatchExpression(me *ast.MatchExpression, env *object.Environment, tail bool) object.Object {
+	val := Eval(me.Value, env)
+	if isError(val) {
+		return val
+	}
+
+	for _, arm := range me.Arms {
+		if !matchesPattern(arm.Pattern, val, env) {
+			continue
+		}
+		if arm.Guard != nil {
+			//the guard sees the bindings of the arm in a scope of its own,
+			//so that an arm whose guard fails changes no variable
+			guardEnv := object.NewEnclosedEnvironment(env)
+			if err := bindPattern(arm.Pattern, val, guardEnv); err != nil {
+				return err
+			}
+			guard := Eval(arm.Guard, guardEnv)
+			if isError(guard) {
+				return guard
+			}
+			if !isTruthy(guard) {
+				continue
+			}
+		}
+		if err := bindPattern(arm.Pattern, val, env); err != nil {
+			return err
+		}
+		return evalTail(arm.Body, env, tail)
+	}
+	return NewError("no match arm matches %s", val.Inspect())
+}
@@ -0,0 +1,54 @@
+package evaluator
+
+import (
+	"Hulk/ast"
+	"Hulk/builtins"
+	"Hulk/object"
+)
+
+// evalStructStatement binds the name of the struct to its type before
+// making its methods, which can then construct instances of it.
+func evalStructStatement(node *ast.StructStatement, env *object.Environment) object.Object {
+	fields := make([]string, len(node.Fields))
+	for i, field := range node.Fields {
+		fields[i] = field.Value
+	}
+	structType := &object.StructType{Name: node.Name.Value, Fields: fields, Methods: map[string]object.Object{}}
+	env.Set(node.Name.Value, structType)
+
+	for _, method := range node.Methods {
+		fn := Eval(method.Function, env)
+		if isError(fn) {
+			return fn
+		}
+		structType.Methods[method.Name.Value] = fn
+	}
+	return structType
+}
+
+func evalMemberExpression(node *ast.MemberExpression, env *object.Environment) object.Object {
+	obj := Eval(node.Object, env)
+	if isError(obj) {
+		return obj
+	}
+	member, err := builtins.GetMember(obj, node.Member.Value)
+	if err != nil {
+		return NewError("%s", err)
+	}
+	return member
+}
+
+func evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	obj := Eval(node.Target.Object, env)
+	if isError(obj) {
+		return obj
+	}
+	value := Eval(node.Value, env)
+	if isError(value) {
+		return value
+	}
+	if err := object.SetMember(obj, node.Target.Member.Value, value); err != nil {
+		return NewError("%s", err)
+	}
+	return value
+}
@@ -0,0 +1,110 @@
+package evaluator
+
+import (
+	"Hulk/ast"
+	"Hulk/object"
+)
+
+// tailCall is what a function body evaluates to when it ends in a call.
+// Rather than making the call from inside the body, which would nest one Go
+// call per Hulk call, the body hands the callee and its arguments back to
+// applyFunction, which makes the call in a loop. Recursion in tail position
+// then runs in constant Go stack, however deep it goes.
+//
+// A tailCall never leaves applyFunction: it only appears as the result of
+// evalBody.
+type tailCall struct {
+	fn   object.Object
+	args []object.Object
+}
+
+func (tc *tailCall) Type() object.ObjectType {
+	return "TAIL_CALL"
+}
+
+func (tc *tailCall) Inspect() string {
+	return "tail call"
+}
+
+// evalBody evaluates the statements of a function body, or of an if branch
+// inside one. Return statements are always in tail position; the value of
+// the last statement is when tail is set, that is when it is the value of
+// the whole function.
+func evalBody(block *ast.BlockStatement, env *object.Environment, tail bool) object.Object {
+	var obj object.Object
+
+	for i, stmt := range block.Statements {
+		last := i == len(block.Statements)-1
+
+		switch stmt := stmt.(type) {
+		case *ast.ReturnStatement:
+			val := evalTail(stmt.ReturnValue, env, true)
+			if isError(val) {
+				traceError(val, stmt)
+				return val
+			}
+			return &object.ReturnValue{Value: val}
+
+		case *ast.ExpressionStatement:
+			obj = evalTail(stmt.Expression, env, tail && last)
+
+		default:
+			obj = Eval(stmt, env)
+		}
+
+		if obj != nil {
+			if obj.Type() == object.RETURN_VALUE_OBJ || obj.Type() == object.ERROR_OBJ {
+				traceError(obj, stmt)
+				return obj
+			}
+		}
+	}
+	if obj == nil {
+		return NULL
+	}
+	return obj
+}
+
+// evalTail evaluates an expression statement of a function body. Calls in
+// tail position are returned as a tailCall, and if and match expressions
+// are looked into since their branches may hold return statements or tail
+// calls.
+func evalTail(node ast.Expression, env *object.Environment, tail bool) object.Object {
+	switch node := node.(type) {
+	case *ast.CallExpression:
+		if !tail {
+			break
+		}
+		function := Eval(node.Function, env)
+		if isError(function) {
+			return function
+		}
+		args := evalArguments(node.Arguments, env)
+		if len(args) == 1 && isError(args[0]) {
+			return args[0]
+		}
+		//only calls to Hulk functions are handed back, anything else runs
+		//right away so that its errors show up in the frame of the caller
+		if _, ok := function.(*object.Function); !ok {
+			return applyFunction(env.Meter(), function, args...)
+		}
+		return &tailCall{fn: function, args: args}
+
+	case *ast.IfExpression:
+		condition := Eval(node.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+		if isTruthy(condition) {
+			return evalBody(node.Consequence, env, tail)
+		} else if node.Alternative != nil {
+			return evalBody(node.Alternative, env, tail)
+		}
+		return NULL
+
+	case *ast.MatchExpression:
+		return evalMatchExpression(node, env, tail)
+	}
+
+	return Eval(node, env)
+}
@@ -2,6 +2,7 @@ package lexer
 
 import (
 	"Hulk/token"
+	"bytes"
 )
 
 type Lexer struct {
@@ -9,16 +10,20 @@ type Lexer struct {
 	position     int  //current position in input(points to current char)
 	readPosition int  //cuurent reading position in input
 	ch           byte //current char under examination
+	line         int  //line of the current char
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	l := &Lexer{input: input, line: 1}
 	// fmt.Println(l.input)
 	l.readChar()
 	return l
 }
 
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+	}
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -37,16 +42,25 @@ func (l *Lexer) peekChar() byte {
 }
 
 func (l *Lexer) NextToken() token.Token {
-	var tok token.Token
-	// fmt.Println("l.ch=", string(l.ch), " l.readPos=", l.readPosition)
 	l.skipWhitespace()
-	// fmt.Println("l.ch=", string(l.ch), " l.readPos=", l.readPosition)
+	line := l.line
+	tok := l.readToken()
+	tok.Line = line
+	return tok
+}
+
+func (l *Lexer) readToken() token.Token {
+	var tok token.Token
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
 			ch := l.ch
 			l.readChar()
 			tok = token.Token{Type: token.EQUALS, Literal: string(ch) + string(l.ch)}
+		} else if l.peekChar() == '>' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.ARROW, Literal: string(ch) + string(l.ch)}
 		} else {
 			tok = newToken(token.ASSIGN, l.ch)
 		}
@@ -78,6 +92,8 @@ func (l *Lexer) NextToken() token.Token {
 		}
 	case '*':
 		tok = newToken(token.ASTERISK, l.ch)
+	case '?':
+		tok = newToken(token.QUESTION, l.ch)
 	case '/':
 		tok = newToken(token.SLASH, l.ch)
 	case '+':
@@ -88,6 +104,14 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.GT, l.ch)
 	case ':':
 		tok = newToken(token.COLON, l.ch)
+	case '.':
+		if l.peekChar() == '.' && l.readPosition+1 < len(l.input) && l.input[l.readPosition+1] == '.' {
+			l.readChar()
+			l.readChar()
+			tok = token.Token{Type: token.ELLIPSIS, Literal: "..."}
+		} else {
+			tok = newToken(token.DOT, l.ch)
+		}
 	case '"':
 		tok.Type = token.STRING
 		tok.Literal = l.readString()
@@ -150,13 +174,31 @@ func (l *Lexer) skipWhitespace() {
 }
 
 func (l *Lexer) readString() string {
-	position := l.position + 1
+	var out bytes.Buffer
 
 	for {
 		l.readChar()
 		if l.ch == '"' || l.ch == 0 {
 			break
 		}
+		//a backslash escapes the next character, so strings can hold quotes
+		if l.ch == '\\' {
+			l.readChar()
+			switch l.ch {
+			case 'n':
+				out.WriteByte('\n')
+			case 't':
+				out.WriteByte('\t')
+			case 'r':
+				out.WriteByte('\r')
+			case 0:
+				return out.String()
+			default:
+				out.WriteByte(l.ch)
+			}
+			continue
+		}
+		out.WriteByte(l.ch)
 	}
-	return l.input[position:l.position]
+	return out.String()
 }
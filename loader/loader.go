@@ -0,0 +1,148 @@
+// Package loader finds the files import statements name and parses them.
+// The evaluator loads modules as their imports run, the compiler loads them
+// all while it compiles the program importing them; both go through a
+// Loader so that they agree on which file an import means.
+package loader
+
+import (
+	"Hulk/ast"
+	"Hulk/lexer"
+	"Hulk/parser"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PathVariable is the environment variable holding the search path, a list
+// of directories in the format of the PATH variable of the system.
+const PathVariable = "HULKPATH"
+
+// EnvSearchPath returns the directories listed by PathVariable.
+func EnvSearchPath() []string {
+	return filepath.SplitList(os.Getenv(PathVariable))
+}
+
+// Loader resolves import paths to files and parses each file once.
+//
+// A path starting with ./ or ../ is relative to the directory of the
+// module importing it. Any other relative path is looked up in each
+// directory of the search path in turn.
+type Loader struct {
+	SearchPath []string
+
+	//ReadFile reads a module, os.ReadFile unless set
+	ReadFile func(name string) ([]byte, error)
+	//Warn, when set, is called with the parser warnings of every module
+	Warn func(file, warning string)
+
+	programs map[string]*ast.Program
+	sources  map[string]string
+}
+
+// New returns a loader looking up imports in the directories of searchPath.
+func New(searchPath ...string) *Loader {
+	return &Loader{SearchPath: searchPath, programs: map[string]*ast.Program{}, sources: map[string]string{}}
+}
+
+// Sources returns the source text of every module loaded so far, by file.
+func (l *Loader) Sources() map[string]string {
+	return l.sources
+}
+
+// Load returns the file the import of path made by the module in from
+// names, and its parsed program. from is empty for a program that was not
+// read from a file, whose relative imports are relative to the working
+// directory.
+func (l *Loader) Load(path, from string) (string, *ast.Program, error) {
+	file, source, err := l.find(path, from)
+	if err != nil {
+		return "", nil, err
+	}
+	if program, ok := l.programs[file]; ok {
+		return file, program, nil
+	}
+
+	p := parser.New(lexer.New(string(source)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return "", nil, fmt.Errorf("%s: parser errors:\n\t%s", file, strings.Join(p.Errors(), "\n\t"))
+	}
+	if err := checkTopLevel(program); err != nil {
+		return "", nil, fmt.Errorf("%s: %s", file, err)
+	}
+	if l.Warn != nil {
+		for _, warning := range p.Warnings() {
+			l.Warn(file, warning)
+		}
+	}
+
+	l.programs[file] = program
+	l.sources[file] = string(source)
+	return file, program, nil
+}
+
+// find resolves path and reads the file it names.
+func (l *Loader) find(path, from string) (string, []byte, error) {
+	if path == "" {
+		return "", nil, fmt.Errorf("cannot import an empty path")
+	}
+
+	var candidates []string
+	switch {
+	case filepath.IsAbs(path):
+		candidates = []string{path}
+	case path == "." || path == ".." || strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../"):
+		candidates = []string{filepath.Join(filepath.Dir(from), path)}
+	default:
+		for _, dir := range l.SearchPath {
+			candidates = append(candidates, filepath.Join(dir, path))
+		}
+	}
+
+	for _, file := range candidates {
+		file = filepath.Clean(file)
+		if _, ok := l.programs[file]; ok {
+			return file, nil, nil
+		}
+		source, err := l.read(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		return file, source, nil
+	}
+	return "", nil, fmt.Errorf("cannot find module %q", path)
+}
+
+func (l *Loader) read(file string) ([]byte, error) {
+	if l.ReadFile != nil {
+		return l.ReadFile(file)
+	}
+	return os.ReadFile(file)
+}
+
+// checkTopLevel rejects a return from the top level of a module, explicit
+// or through ?, since a module evaluates to its exports and not to a value.
+func checkTopLevel(program *ast.Program) error {
+	var err error
+	ast.Inspect(program, func(n ast.Node) bool {
+		if err != nil {
+			return false
+		}
+		switch n := n.(type) {
+		case *ast.ReturnStatement:
+			err = fmt.Errorf("line %d: return outside of a function", n.Token.Line)
+		case *ast.PropagateExpression:
+			err = fmt.Errorf("line %d: ? outside of a function", n.Token.Line)
+		case *ast.FunctionLiteral:
+			return false
+		}
+		return true
+	})
+	return err
+}
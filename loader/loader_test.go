@@ -0,0 +1,87 @@
+package loader
+
+import (
+	"io/fs"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func testLoader(searchPath ...string) *Loader {
+	files := fstest.MapFS{
+		"app/main.hk":      {Data: []byte(`import "./lib/a.hk" as a;`)},
+		"app/lib/a.hk":     {Data: []byte(`export let a = 1;`)},
+		"app/lib/b.hk":     {Data: []byte(`export let b = 2;`)},
+		"first/shared.hk":  {Data: []byte(`export let from = "first";`)},
+		"second/shared.hk": {Data: []byte(`export let from = "second";`)},
+		"second/only.hk":   {Data: []byte(`export let only = 1;`)},
+	}
+	l := New(searchPath...)
+	l.ReadFile = func(name string) ([]byte, error) { return fs.ReadFile(files, name) }
+	return l
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		path     string
+		from     string
+		expected string
+	}{
+		{"./lib/a.hk", "app/main.hk", "app/lib/a.hk"},
+		{"./b.hk", "app/lib/a.hk", "app/lib/b.hk"},
+		{"../main.hk", "app/lib/a.hk", "app/main.hk"},
+		{"./app/main.hk", "", "app/main.hk"},
+		{"shared.hk", "app/main.hk", "first/shared.hk"},
+		{"only.hk", "app/main.hk", "second/only.hk"},
+		{"lib/a.hk", "", "app/lib/a.hk"},
+	}
+
+	l := testLoader("first", "second", "app")
+	for _, tt := range tests {
+		file, _, err := l.Load(tt.path, tt.from)
+		if err != nil {
+			t.Errorf("%q from %q: %s", tt.path, tt.from, err)
+			continue
+		}
+		if file != filepath.FromSlash(tt.expected) {
+			t.Errorf("%q from %q: want=%s, got=%s", tt.path, tt.from, tt.expected, file)
+		}
+	}
+}
+
+func TestLoadParsesOnce(t *testing.T) {
+	l := testLoader("app")
+	_, first, err := l.Load("lib/a.hk", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, second, err := l.Load("./a.hk", "app/lib/b.hk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Errorf("a module loaded twice was parsed twice")
+	}
+	if l.Sources()[filepath.FromSlash("app/lib/a.hk")] != `export let a = 1;` {
+		t.Errorf("wrong sources. got=%v", l.Sources())
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"", "cannot import an empty path"},
+		{"missing.hk", `cannot find module "missing.hk"`},
+		{"./first/shared.hk", `cannot find module "./first/shared.hk"`},
+	}
+
+	l := testLoader("first")
+	for _, tt := range tests {
+		_, _, err := l.Load(tt.path, "app/main.hk")
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%q: want=%q, got=%v", tt.path, tt.expected, err)
+		}
+	}
+}
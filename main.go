@@ -1,13 +1,63 @@
 package main
 
 import (
+	"Hulk/ast"
+	"Hulk/compiler"
+	"Hulk/evaluator"
+	"Hulk/lexer"
+	"Hulk/loader"
+	"Hulk/object"
+	"Hulk/optimizer"
+	"Hulk/parser"
+	"Hulk/regvm"
 	"Hulk/repl"
+	"Hulk/vm"
+	"bytes"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"os/user"
+	"path/filepath"
+	"strings"
 )
 
+const usage = `usage:
+  hulk                              start the REPL
+  hulk compile [-O] [-strip] [-path dirs] file.hk [-o file.hkc]
+  hulk run [-O] [-eval] [-profile] [-backend stack|register] [-path dirs] file.hk|file.hkc
+  hulk disasm [-O] [-backend stack|register] [-path dirs] file.hk|file.hkc
+
+Imports starting with ./ or ../ are relative to the importing file, others
+are looked up in the directory of the program and then in -path, which
+defaults to $HULKPATH.
+`
+
 func main() {
+	if len(os.Args) < 2 {
+		startRepl()
+		return
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "compile":
+		err = compileCommand(os.Args[2:])
+	case "run":
+		err = runCommand(os.Args[2:])
+	case "disasm":
+		err = disasmCommand(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func startRepl() {
 	user, err := user.Current()
 	if err != nil {
 		panic(err)
@@ -16,3 +66,286 @@ func main() {
 	fmt.Printf("Feel free to type in Commands\n")
 	repl.Start(os.Stdin, os.Stdout)
 }
+
+// options holds the flags shared by the commands that compile source code.
+type options struct {
+	optimize   bool
+	backend    string
+	searchPath string
+}
+
+func (o *options) register(flags *flag.FlagSet) {
+	flags.BoolVar(&o.optimize, "O", false, "optimize the compiled bytecode")
+	flags.StringVar(&o.backend, "backend", "stack", "the VM to compile for, stack or register")
+	flags.StringVar(&o.searchPath, "path", os.Getenv(loader.PathVariable), "directories to look up imports in, separated like $PATH")
+}
+
+// loader returns the loader of the modules imported by the program in file,
+// which looks in the directory of the program before the search path.
+func (o *options) loader(file string) *loader.Loader {
+	l := loader.New(append([]string{filepath.Dir(file)}, filepath.SplitList(o.searchPath)...)...)
+	l.Warn = func(file, warning string) {
+		fmt.Fprintf(os.Stderr, "%s: warning: %s\n", file, warning)
+	}
+	return l
+}
+
+// useRegisterVM reports whether the register VM was picked. Only source files
+// can run on it, the binary format holds stack bytecode.
+func (o *options) useRegisterVM() (bool, error) {
+	switch o.backend {
+	case "stack":
+		return false, nil
+	case "register":
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown backend %q, want stack or register", o.backend)
+	}
+}
+
+// fileArg parses args for a command that takes a single file, accepting
+// flags both before and after the file name.
+func fileArg(flags *flag.FlagSet, args []string) (string, error) {
+	flags.Parse(args)
+	if flags.NArg() < 1 {
+		return "", fmt.Errorf("%s: missing input file\n%s", flags.Name(), usage)
+	}
+	file := flags.Arg(0)
+	flags.Parse(flags.Args()[1:])
+	if flags.NArg() > 0 {
+		return "", fmt.Errorf("%s: unexpected arguments %v\n%s", flags.Name(), flags.Args(), usage)
+	}
+	return file, nil
+}
+
+func compileCommand(args []string) error {
+	var opts options
+	flags := flag.NewFlagSet("compile", flag.ExitOnError)
+	output := flags.String("o", "", "output file, defaults to the input with a .hkc extension")
+	strip := flags.Bool("strip", false, "leave the debug info out of the output")
+	opts.register(flags)
+	input, err := fileArg(flags, args)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		*output = strings.TrimSuffix(input, filepath.Ext(input)) + ".hkc"
+	}
+
+	register, err := opts.useRegisterVM()
+	if err != nil {
+		return err
+	}
+	if register {
+		return fmt.Errorf("compile: the register backend has no binary format, use run -backend register")
+	}
+
+	bytecode, err := compileFile(input, opts)
+	if err != nil {
+		return err
+	}
+	if *strip {
+		bytecode.Debug = nil
+	}
+
+	var buf bytes.Buffer
+	err = bytecode.Encode(&buf)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(*output, buf.Bytes(), 0644)
+}
+
+func runCommand(args []string) error {
+	var opts options
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	useEvaluator := flags.Bool("eval", false, "run source with the tree-walking evaluator instead of the VM")
+	profile := flags.Bool("profile", false, "report the most frequent opcode pairs of the stack VM")
+	opts.register(flags)
+	file, err := fileArg(flags, args)
+	if err != nil {
+		return err
+	}
+	if *useEvaluator {
+		return evalFile(file, opts)
+	}
+
+	register, err := opts.useRegisterVM()
+	if err != nil {
+		return err
+	}
+	if register {
+		bytecode, err := compileRegisterFile(file, opts)
+		if err != nil {
+			return err
+		}
+		err = regvm.New(bytecode).Run()
+		if err != nil {
+			return runtimeError(err)
+		}
+		return nil
+	}
+
+	bytecode, err := loadBytecode(file, opts)
+	if err != nil {
+		return err
+	}
+
+	machine := vm.New(bytecode)
+	if *profile {
+		machine.EnableProfiling()
+		defer machine.WriteProfile(os.Stderr, 20)
+	}
+	err = machine.Run()
+	if err != nil {
+		return runtimeError(err)
+	}
+	return nil
+}
+
+// runtimeError reports an error a program stopped with, followed by its Hulk
+// stack trace when it has one.
+func runtimeError(err error) error {
+	var errObj *object.Error
+	if errors.As(err, &errObj) {
+		return fmt.Errorf("runtime error: %s", errObj.Traceback())
+	}
+	return fmt.Errorf("runtime error: %s", err)
+}
+
+func evalFile(path string, opts options) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	program, err := parseSource(path, string(data))
+	if err != nil {
+		return err
+	}
+	if opts.optimize {
+		optimizer.Optimize(program)
+	}
+
+	env := object.NewEnvironment()
+	env.SetImporter(evaluator.NewImporter(opts.loader(path), path))
+	result := evaluator.Eval(program, env)
+	if errObj, ok := result.(*object.Error); ok {
+		return runtimeError(errObj)
+	}
+	return nil
+}
+
+func disasmCommand(args []string) error {
+	var opts options
+	flags := flag.NewFlagSet("disasm", flag.ExitOnError)
+	opts.register(flags)
+	file, err := fileArg(flags, args)
+	if err != nil {
+		return err
+	}
+
+	register, err := opts.useRegisterVM()
+	if err != nil {
+		return err
+	}
+	if register {
+		bytecode, err := compileRegisterFile(file, opts)
+		if err != nil {
+			return err
+		}
+		bytecode.Disassemble(os.Stdout)
+		return nil
+	}
+
+	bytecode, err := loadBytecode(file, opts)
+	if err != nil {
+		return err
+	}
+	bytecode.Disassemble(os.Stdout)
+	return nil
+}
+
+// loadBytecode compiles source files and decodes compiled ones, telling them
+// apart by their magic header rather than their extension. Compiled files
+// are used as they are, opts only affect compiling source.
+func loadBytecode(path string, opts options) (*compiler.Bytecode, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	bytecode, err := compiler.Decode(bytes.NewReader(data))
+	if err == compiler.ErrBadMagic {
+		return compileSource(path, string(data), opts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return bytecode, nil
+}
+
+func compileFile(path string, opts options) (*compiler.Bytecode, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return compileSource(path, string(data), opts)
+}
+
+func parseSource(name, source string) (*ast.Program, error) {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, fmt.Errorf("%s: parser errors:\n\t%s", name, strings.Join(p.Errors(), "\n\t"))
+	}
+	for _, warning := range p.Warnings() {
+		fmt.Fprintf(os.Stderr, "%s: warning: %s\n", name, warning)
+	}
+	return program, nil
+}
+
+func compileSource(name, source string, opts options) (*compiler.Bytecode, error) {
+	program, err := parseSource(name, source)
+	if err != nil {
+		return nil, err
+	}
+
+	comp := compiler.New()
+	if opts.optimize {
+		comp.EnableOptimizations()
+	}
+	modules := opts.loader(name)
+	comp.EnableImports(modules, name)
+	err = comp.Compile(program)
+	if err != nil {
+		return nil, fmt.Errorf("%s: compilation failed: %s", name, err)
+	}
+
+	bytecode := comp.Bytecode()
+	bytecode.Debug = &compiler.DebugInfo{SourceName: name, Source: source, Modules: modules.Sources()}
+	return bytecode, nil
+}
+
+// compileRegisterFile compiles a source file for the register VM. With -O the
+// program goes through the AST optimizer first, the register compiler has no
+// optimizations of its own.
+func compileRegisterFile(path string, opts options) (*regvm.Bytecode, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	program, err := parseSource(path, string(data))
+	if err != nil {
+		return nil, err
+	}
+	if opts.optimize {
+		optimizer.Optimize(program)
+	}
+
+	comp := regvm.NewCompiler()
+	err = comp.Compile(program)
+	if err != nil {
+		return nil, fmt.Errorf("%s: compilation failed: %s", path, err)
+	}
+	return comp.Bytecode(), nil
+}
@@ -0,0 +1,31 @@
+package object
+
+import "fmt"
+
+// Arity is how many arguments a function takes: its required parameters,
+// then those with a default value, then any number more when it has a rest
+// parameter.
+type Arity struct {
+	Required int
+	Optional int
+	Variadic bool
+}
+
+// Check returns the error for calling a function of this arity with got
+// arguments, or nil when that many are accepted.
+func (a Arity) Check(got int) *Error {
+	if got >= a.Required && (a.Variadic || got <= a.Required+a.Optional) {
+		return nil
+	}
+	return &Error{Message: fmt.Sprintf("wrong number of arguments: want=%s, got=%d", a, got)}
+}
+
+func (a Arity) String() string {
+	switch {
+	case a.Variadic:
+		return fmt.Sprintf("at least %d", a.Required)
+	case a.Optional > 0:
+		return fmt.Sprintf("%d to %d", a.Required, a.Required+a.Optional)
+	}
+	return fmt.Sprintf("%d", a.Required)
+}
@@ -0,0 +1,27 @@
+package object
+
+// Small integers come up all the time as loop counters, indices and lengths,
+// so the ones in [MinCachedInteger, MaxCachedInteger] are allocated once and
+// shared. Integers are never modified after they are created, which is what
+// makes sharing them safe.
+const (
+	MinCachedInteger = -128
+	MaxCachedInteger = 1023
+)
+
+var smallIntegers = func() []*Integer {
+	integers := make([]*Integer, MaxCachedInteger-MinCachedInteger+1)
+	for i := range integers {
+		integers[i] = &Integer{Value: int64(i + MinCachedInteger)}
+	}
+	return integers
+}()
+
+// NewInteger returns an Integer holding value, shared with every other use
+// of the same value when it is small.
+func NewInteger(value int64) *Integer {
+	if value >= MinCachedInteger && value <= MaxCachedInteger {
+		return smallIntegers[value-MinCachedInteger]
+	}
+	return &Integer{Value: value}
+}
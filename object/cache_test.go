@@ -0,0 +1,38 @@
+package object
+
+import "testing"
+
+func TestNewInteger(t *testing.T) {
+	for _, value := range []int64{MinCachedInteger, -1, 0, 1, MaxCachedInteger} {
+		if NewInteger(value) != NewInteger(value) {
+			t.Errorf("integer %d is not cached", value)
+		}
+		if NewInteger(value).Value != value {
+			t.Errorf("wrong value. want=%d, got=%d", value, NewInteger(value).Value)
+		}
+	}
+
+	for _, value := range []int64{MinCachedInteger - 1, MaxCachedInteger + 1} {
+		if NewInteger(value) == NewInteger(value) {
+			t.Errorf("integer %d is cached, it should be allocated", value)
+		}
+	}
+}
+
+var sink *Integer
+
+func BenchmarkIntegers(b *testing.B) {
+	b.Run("Allocated", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			sink = &Integer{Value: int64(i % 1000)}
+		}
+	})
+
+	b.Run("Cached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			sink = NewInteger(int64(i % 1000))
+		}
+	})
+}
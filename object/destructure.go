@@ -0,0 +1,49 @@
+package object
+
+import "fmt"
+
+// DestructureArray returns what an array pattern of n elements binds in
+// value: its n elements, followed when rest is set by a new array of the
+// elements left over. The array must have exactly n elements, or at least n
+// with a rest binding.
+func DestructureArray(value Object, n int, rest bool) ([]Object, error) {
+	arr, ok := value.(*Array)
+	if !ok {
+		return nil, fmt.Errorf("cannot destructure %s with an array pattern", value.Type())
+	}
+
+	switch {
+	case rest && len(arr.Elements) < n:
+		return nil, fmt.Errorf("array pattern wants at least %d elements, got %d", n, len(arr.Elements))
+	case !rest && len(arr.Elements) != n:
+		return nil, fmt.Errorf("array pattern wants %d elements, got %d", n, len(arr.Elements))
+	}
+
+	values := make([]Object, n, n+1)
+	copy(values, arr.Elements)
+	if rest {
+		left := make([]Object, len(arr.Elements)-n)
+		copy(left, arr.Elements[n:])
+		values = append(values, &Array{Elements: left})
+	}
+	return values, nil
+}
+
+// DestructureHash returns the values of keys in value, which must be a hash
+// holding every one of them.
+func DestructureHash(value Object, keys []string) ([]Object, error) {
+	hash, ok := value.(*Hash)
+	if !ok {
+		return nil, fmt.Errorf("cannot destructure %s with a hash pattern", value.Type())
+	}
+
+	values := make([]Object, len(keys))
+	for i, key := range keys {
+		pair, ok := hash.Pairs[(&String{Value: key}).HashKey()]
+		if !ok {
+			return nil, fmt.Errorf("hash pattern key %q not found", key)
+		}
+		values[i] = pair.Value
+	}
+	return values, nil
+}
@@ -0,0 +1,162 @@
+package object
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync/atomic"
+)
+
+// EnumType is a tagged union declared by an enum statement. Each variant
+// is either a constructor taking the values of its fields, as Circle(r), or
+// a single value, as Empty.
+type EnumType struct {
+	Name     string
+	Variants []*Variant
+
+	id uint64 //tells apart enums declared with the same name
+}
+
+var enumTypes uint64
+
+// NewEnumType returns the enum called name with the given variants, each
+// one the name of the variant followed by the names of its fields.
+func NewEnumType(name string, variants [][]string) *EnumType {
+	et := &EnumType{
+		Name:     name,
+		Variants: make([]*Variant, len(variants)),
+		id:       atomic.AddUint64(&enumTypes, 1),
+	}
+	for i, variant := range variants {
+		v := &Variant{Enum: et, Name: variant[0], Fields: variant[1:]}
+		if len(v.Fields) == 0 {
+			v.unit = &EnumValue{Variant: v}
+		}
+		et.Variants[i] = v
+	}
+	return et
+}
+
+func (et *EnumType) Type() ObjectType {
+	return ENUM_TYPE_OBJ
+}
+
+func (et *EnumType) Inspect() string {
+	return "<enum " + et.Name + ">"
+}
+
+// Get returns the variant called name: its constructor if it has fields,
+// else its only value.
+func (et *EnumType) Get(name string) (Object, error) {
+	for _, v := range et.Variants {
+		if v.Name != name {
+			continue
+		}
+		if v.unit != nil {
+			return v.unit, nil
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("enum %s has no variant %s", et.Name, name)
+}
+
+// Variant is the constructor of a variant with fields. Calling it makes a
+// value of the variant from the value of each field, in order.
+type Variant struct {
+	Enum   *EnumType
+	Name   string
+	Fields []string
+
+	unit *EnumValue //the value of a variant without fields
+}
+
+func (v *Variant) Type() ObjectType {
+	return VARIANT_OBJ
+}
+
+func (v *Variant) Inspect() string {
+	return "<variant " + v.Enum.Name + "." + v.Name + ">"
+}
+
+// Arity returns how many arguments constructing a value takes, one for
+// each field.
+func (v *Variant) Arity() Arity {
+	return Arity{Required: len(v.Fields)}
+}
+
+// New returns a value of the variant carrying args, or the error for
+// passing the wrong number of them.
+func (v *Variant) New(args []Object) (*EnumValue, *Error) {
+	if err := v.Arity().Check(len(args)); err != nil {
+		return nil, err
+	}
+	payload := make([]Object, len(args))
+	copy(payload, args)
+	return &EnumValue{Variant: v, Payload: payload}, nil
+}
+
+// EnumValue is a value of an enum: the variant it was made with, its tag,
+// and the values of the fields of that variant. Unlike a struct, it cannot
+// be changed once made, and two values are equal when they have the same
+// variant and equal payloads.
+type EnumValue struct {
+	Variant *Variant
+	Payload []Object //value of each field of Variant, in order
+}
+
+func (ev *EnumValue) Type() ObjectType {
+	return ENUM_OBJ
+}
+
+func (ev *EnumValue) Inspect() string {
+	name := ev.Variant.Enum.Name + "." + ev.Variant.Name
+	if len(ev.Payload) == 0 {
+		return name
+	}
+	payload := make([]string, len(ev.Payload))
+	for i, value := range ev.Payload {
+		payload[i] = value.Inspect()
+	}
+	return name + "(" + strings.Join(payload, ", ") + ")"
+}
+
+// Tag returns the name of the variant of ev.
+func (ev *EnumValue) Tag() string {
+	return ev.Variant.Name
+}
+
+// Field returns the value of the field called name.
+func (ev *EnumValue) Field(name string) (Object, bool) {
+	for i, field := range ev.Variant.Fields {
+		if field == name {
+			return ev.Payload[i], true
+		}
+	}
+	return nil, false
+}
+
+// HashKey hashes the enum, the tag and the payload, so equal values have
+// the same key. It is only meaningful for values HashKeyOf accepts.
+func (ev *EnumValue) HashKey() HashKey {
+	key, _ := ev.hashKey()
+	return key
+}
+
+// hashKey returns the key of ev, or false if a value of its payload cannot
+// be a hash key.
+func (ev *EnumValue) hashKey() (HashKey, bool) {
+	h := fnv.New64a()
+	binary.Write(h, binary.LittleEndian, ev.Variant.Enum.id)
+	h.Write([]byte(ev.Variant.Name))
+	for _, value := range ev.Payload {
+		key, ok := HashKeyOf(value)
+		if !ok {
+			return HashKey{}, false
+		}
+		h.Write([]byte{0})
+		h.Write([]byte(key.Type))
+		binary.Write(h, binary.LittleEndian, key.Value)
+	}
+	return HashKey{Type: ev.Type(), Value: h.Sum64()}, true
+}
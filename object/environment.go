@@ -8,6 +8,9 @@ func NewEnvironment() *Environment {
 type Environment struct {
 	store map[string]Object
 	outer *Environment
+
+	meter    *Meter   //nil unless the evaluation is limited
+	importer Importer //nil unless imports are enabled
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
@@ -26,5 +29,29 @@ func (e *Environment) Set(name string, val Object) Object {
 func NewEnclosedEnvironment(outer *Environment) *Environment {
 	env := NewEnvironment()
 	env.outer = outer
+	env.meter = outer.meter
+	env.importer = outer.importer
 	return env
 }
+
+// Meter returns the meter of the limited evaluation running in e, or nil.
+func (e *Environment) Meter() *Meter {
+	return e.meter
+}
+
+// SetMeter makes evaluations in e, and in environments enclosed by it from
+// then on, report to m.
+func (e *Environment) SetMeter(m *Meter) {
+	e.meter = m
+}
+
+// Importer returns what loads the modules imported in e, or nil.
+func (e *Environment) Importer() Importer {
+	return e.importer
+}
+
+// SetImporter makes import statements evaluated in e, and in environments
+// enclosed by it from then on, load modules through i.
+func (e *Environment) SetImporter(i Importer) {
+	e.importer = i
+}
@@ -0,0 +1,83 @@
+package object
+
+// Types of the errors a catch clause sees. Errors raised by the engines and
+// the builtins are runtime errors. throw raises plain errors, unless it is
+// given a hash whose "type" names one of its own.
+const (
+	RuntimeErrorType = "RuntimeError"
+	ThrownErrorType  = "Error"
+)
+
+// NewThrown builds the error raised by throw value. The message is value
+// itself for a string, and the "message" of a hash; anything else is
+// described by its Inspect().
+func NewThrown(value Object) *Error {
+	err := &Error{Message: value.Inspect(), Kind: ThrownErrorType, Value: value}
+
+	hash, ok := value.(*Hash)
+	if !ok {
+		return err
+	}
+	if message, ok := hashString(hash, "message"); ok {
+		err.Message = message
+	}
+	if kind, ok := hashString(hash, "type"); ok {
+		err.Kind = kind
+	}
+	return err
+}
+
+func hashString(hash *Hash, key string) (string, bool) {
+	pair, ok := hash.Pairs[(&String{Value: key}).HashKey()]
+	if !ok {
+		return "", false
+	}
+	str, ok := pair.Value.(*String)
+	if !ok {
+		return "", false
+	}
+	return str.Value, true
+}
+
+// Left returns the frames of the functions the error has left, leaving out
+// the one it is still in.
+func (err *Error) Left() []StackFrame {
+	if err.open {
+		return err.Stack[:len(err.Stack)-1]
+	}
+	return err.Stack
+}
+
+// Caught builds the hash a catch clause binds for the error: its "message",
+// its "type" and, under "stack", the calls it unwound before it was caught,
+// innermost first. A thrown hash is copied with these keys set, any other
+// thrown value is kept under "value".
+func (err *Error) Caught(unwound []StackFrame) *Hash {
+	pairs := map[HashKey]HashPair{}
+	set := func(key string, value Object) {
+		k := &String{Value: key}
+		pairs[k.HashKey()] = HashPair{Key: k, Value: value}
+	}
+
+	if hash, ok := err.Value.(*Hash); ok {
+		for key, pair := range hash.Pairs {
+			pairs[key] = pair
+		}
+	} else if err.Value != nil {
+		set("value", err.Value)
+	}
+
+	kind := err.Kind
+	if kind == "" {
+		kind = RuntimeErrorType
+	}
+	stack := make([]Object, len(unwound))
+	for i, frame := range unwound {
+		stack[i] = &String{Value: frame.String()}
+	}
+
+	set("message", &String{Value: err.Message})
+	set("type", &String{Value: kind})
+	set("stack", &Array{Elements: stack})
+	return &Hash{Pairs: pairs}
+}
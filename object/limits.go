@@ -0,0 +1,171 @@
+package object
+
+import (
+	"context"
+	"fmt"
+)
+
+// Limits bound what a single run of a program may do, so that programs
+// from untrusted sources can be stopped before they hang or exhaust memory.
+// A zero field means no limit.
+type Limits struct {
+	MaxSteps        int64 //nodes evaluated, or instructions executed by the VM
+	MaxCallDepth    int   //nested function calls, tail calls do not nest
+	MaxAllocations  int64 //strings, arrays, hashes and functions created
+	MaxStringLength int
+	MaxArrayLength  int
+}
+
+// LimitError is the error a run stops with when it goes over one of its
+// Limits, or when its context is cancelled or times out. In the latter case
+// Limit is "context" and Err is the context's error.
+type LimitError struct {
+	Limit string
+	Max   int64
+	Err   error
+}
+
+func (e *LimitError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("execution stopped: %s", e.Err)
+	}
+	return fmt.Sprintf("%s limit of %d exceeded", e.Limit, e.Max)
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// contextInterval is how many steps a Meter takes between looks at its
+// context. Asking a context whether it is done costs too much to do on
+// every step.
+const contextInterval = 1024
+
+// Meter keeps count of what a run has used up against its Limits. Engines
+// report to it as they go and stop as soon as it returns an error; the
+// first error is kept and returned from then on.
+//
+// All methods can be called on a nil *Meter, which never stops a run.
+type Meter struct {
+	ctx    context.Context
+	limits Limits
+
+	steps       int64
+	depth       int
+	allocations int64
+
+	err error
+}
+
+func NewMeter(ctx context.Context, limits Limits) *Meter {
+	return &Meter{ctx: ctx, limits: limits}
+}
+
+// Err returns the error that stopped the run, if any.
+func (m *Meter) Err() error {
+	if m == nil {
+		return nil
+	}
+	return m.err
+}
+
+func (m *Meter) stop(err *LimitError) error {
+	if m.err == nil {
+		m.err = err
+	}
+	return m.err
+}
+
+// Step counts one step of the run. The context is looked at on the first
+// step and then every contextInterval steps.
+func (m *Meter) Step() error {
+	if m == nil {
+		return nil
+	}
+	if m.err != nil {
+		return m.err
+	}
+
+	m.steps++
+	if m.limits.MaxSteps > 0 && m.steps > m.limits.MaxSteps {
+		return m.stop(&LimitError{Limit: "steps", Max: m.limits.MaxSteps})
+	}
+	if m.steps%contextInterval == 1 {
+		if err := m.ctx.Err(); err != nil {
+			return m.stop(&LimitError{Limit: "context", Err: err})
+		}
+	}
+	return nil
+}
+
+// Enter counts a function call, Leave its return.
+func (m *Meter) Enter() error {
+	if m == nil {
+		return nil
+	}
+
+	m.depth++
+	if m.limits.MaxCallDepth > 0 && m.depth > m.limits.MaxCallDepth {
+		return m.stop(&LimitError{Limit: "call depth", Max: int64(m.limits.MaxCallDepth)})
+	}
+	return nil
+}
+
+func (m *Meter) Leave() {
+	if m != nil {
+		m.depth--
+	}
+}
+
+// StringLength checks the length of a string before it is built, for
+// builtins that can tell how big their result is going to be.
+func (m *Meter) StringLength(n int) error {
+	if m == nil {
+		return nil
+	}
+	if m.limits.MaxStringLength > 0 && n > m.limits.MaxStringLength {
+		return m.stop(&LimitError{Limit: "string length", Max: int64(m.limits.MaxStringLength)})
+	}
+	return nil
+}
+
+// ArrayLength is StringLength for arrays.
+func (m *Meter) ArrayLength(n int) error {
+	if m == nil {
+		return nil
+	}
+	if m.limits.MaxArrayLength > 0 && n > m.limits.MaxArrayLength {
+		return m.stop(&LimitError{Limit: "array length", Max: int64(m.limits.MaxArrayLength)})
+	}
+	return nil
+}
+
+// Track counts a value the run has just created. Strings, arrays, hashes
+// and functions count against MaxAllocations, and strings and arrays are
+// checked against the length limits. Other values are small or shared and
+// are not counted.
+func (m *Meter) Track(obj Object) error {
+	if m == nil {
+		return nil
+	}
+
+	var err error
+	switch obj := obj.(type) {
+	case *String:
+		err = m.StringLength(len(obj.Value))
+	case *Array:
+		err = m.ArrayLength(len(obj.Elements))
+	case *Hash, *Function, *Closure, *Struct, *EnumValue:
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	m.allocations++
+	if m.limits.MaxAllocations > 0 && m.allocations > m.limits.MaxAllocations {
+		return m.stop(&LimitError{Limit: "allocations", Max: m.limits.MaxAllocations})
+	}
+	return nil
+}
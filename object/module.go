@@ -0,0 +1,38 @@
+package object
+
+import "fmt"
+
+// Module is the namespace an import statement binds: the names the module
+// exports and the values they were bound to once it had run.
+type Module struct {
+	Name    string //the file the module was loaded from
+	Exports map[string]Object
+}
+
+func (m *Module) Type() ObjectType {
+	return MODULE_OBJ
+}
+
+func (m *Module) Inspect() string {
+	return "<module " + m.Name + ">"
+}
+
+// Get returns the value the module exports under the name index holds.
+func (m *Module) Get(index Object) (Object, error) {
+	name, ok := index.(*String)
+	if !ok {
+		return nil, fmt.Errorf("module exports are looked up by name, got %s", index.Type())
+	}
+	value, ok := m.Exports[name.Value]
+	if !ok {
+		return nil, fmt.Errorf("module %s does not export %s", m.Name, name.Value)
+	}
+	return value, nil
+}
+
+// Importer loads the modules imported by the code running in an
+// environment, running each one the first time it is imported. It returns
+// the *Module, or an *Error when the module cannot be loaded or fails.
+type Importer interface {
+	Import(path string, from *Environment) Object
+}
@@ -2,6 +2,7 @@ package object
 
 import (
 	"Hulk/ast"
+	"Hulk/code"
 	"bytes"
 	"fmt"
 	"hash/fnv"
@@ -16,11 +17,30 @@ const (
 	NULL_OBJ         = "NULL"
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
 	ERROR_OBJ        = "ERROR"
+	ERROR_VALUE_OBJ  = "ERROR_VALUE"
 	FUNCTION_OBJ     = "FUNCTION"
 	STRING_OBJ       = "STRING"
 	BUILTIN_OBJ      = "BUILTIN"
 	ARRAY_OBJ        = "ARRAY"
 	HASH_OBJ         = "HASH"
+	MODULE_OBJ       = "MODULE"
+	STRUCT_TYPE_OBJ  = "STRUCT_TYPE"
+	STRUCT_OBJ       = "STRUCT"
+	BOUND_METHOD_OBJ = "BOUND_METHOD"
+	ENUM_TYPE_OBJ    = "ENUM_TYPE"
+	VARIANT_OBJ      = "VARIANT"
+	ENUM_OBJ         = "ENUM"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
+	CLOSURE_OBJ           = "CLOSURE"
+)
+
+// TRUE, FALSE and NULL are shared by both engines and the builtins so that
+// booleans and null can be compared by identity wherever they come from.
+var (
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+	NULL  = &Null{}
 )
 
 type Object interface {
@@ -76,6 +96,17 @@ func (rv *ReturnValue) Inspect() string {
 
 type Error struct {
 	Message string
+	Kind    string //type of a thrown error, see exception.go; empty for runtime errors
+	Value   Object //value given to throw, nil for runtime errors
+
+	//Stack lists the Hulk calls the error went through, innermost first
+	Stack []StackFrame
+	open  bool //the innermost frame of Stack has not been left yet
+}
+
+// Error lets engines hand error objects out as Go errors, stack included.
+func (err *Error) Error() string {
+	return err.Message
 }
 
 func (err *Error) Type() ObjectType {
@@ -86,12 +117,42 @@ func (err *Error) Inspect() string {
 	return "ERROR: " + err.Message
 }
 
+// ErrorValue is a recoverable error made by the error builtin. Unlike an
+// Error it is an ordinary value: it only stops a function when the ?
+// operator is applied to it.
+type ErrorValue struct {
+	Value Object //what went wrong, usually a message
+}
+
+func (ev *ErrorValue) Type() ObjectType {
+	return ERROR_VALUE_OBJ
+}
+
+func (ev *ErrorValue) Inspect() string {
+	return "error(" + ev.Value.Inspect() + ")"
+}
+
 type Function struct {
+	Name       string //binding the function was defined by, if any
 	Parameters []*ast.Identifier
+	Defaults   []ast.Expression //nil, or the default value of each parameter
+	Rest       *ast.Identifier  //rest parameter, if any
+	Patterns   []ast.Pattern    //nil, or the pattern each parameter is destructured with
 	Body       *ast.BlockStatement
 	Env        *Environment
 }
 
+// Arity returns how many arguments fn takes.
+func (fn *Function) Arity() Arity {
+	optional := 0
+	for _, d := range fn.Defaults {
+		if d != nil {
+			optional++
+		}
+	}
+	return Arity{Required: len(fn.Parameters) - optional, Optional: optional, Variadic: fn.Rest != nil}
+}
+
 func (fn *Function) Type() ObjectType {
 	return FUNCTION_OBJ
 }
@@ -100,9 +161,16 @@ func (fn *Function) Inspect() string {
 	var out bytes.Buffer
 
 	params := []string{}
-	for _, p := range fn.Parameters {
+	for i, p := range fn.Parameters {
+		if i < len(fn.Defaults) && fn.Defaults[i] != nil {
+			params = append(params, p.String()+" = "+fn.Defaults[i].String())
+			continue
+		}
 		params = append(params, p.String())
 	}
+	if fn.Rest != nil {
+		params = append(params, "..."+fn.Rest.String())
+	}
 	out.WriteString("fn")
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
@@ -125,7 +193,15 @@ func (str *String) Inspect() string {
 	return str.Value
 }
 
-type BuiltinFunction func(args ...Object) Object
+// Applier lets a builtin call back into the engine running it. Apply calls
+// a Hulk function value, so higher-order builtins like map work in every
+// engine, and Meter returns the meter of a limited run or nil.
+type Applier interface {
+	Apply(fn Object, args ...Object) Object
+	Meter() *Meter
+}
+
+type BuiltinFunction func(apply Applier, args ...Object) Object
 
 type BuiltIn struct {
 	Fn BuiltinFunction
@@ -171,6 +247,20 @@ type HashKey struct {
 	Value uint64
 }
 
+// HashKeyOf returns the key obj is stored under in a hash, or false if obj
+// cannot be a hash key: it is not Hashable, or it is an enum value carrying
+// a value that cannot be one.
+func HashKeyOf(obj Object) (HashKey, bool) {
+	if ev, ok := obj.(*EnumValue); ok {
+		return ev.hashKey()
+	}
+	hashable, ok := obj.(Hashable)
+	if !ok {
+		return HashKey{}, false
+	}
+	return hashable.HashKey(), true
+}
+
 func (b *Boolean) HashKey() HashKey {
 	var value uint64
 
@@ -219,3 +309,85 @@ func (h *Hash) Inspect() string {
 
 	return out.String()
 }
+
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	NumLocals     int
+	NumParameters int
+	NumDefaults   int  //the last NumDefaults parameters have a default value
+	Variadic      bool //a rest parameter follows the others, in local NumParameters
+	Positions     code.PositionTable
+	Handlers      code.HandlerTable
+	Name          string //binding the function was defined by, if any
+	Module        string //file of the module the function is in, empty for the program itself
+}
+
+// Arity returns how many arguments cf takes.
+func (cf *CompiledFunction) Arity() Arity {
+	return Arity{Required: cf.NumParameters - cf.NumDefaults, Optional: cf.NumDefaults, Variadic: cf.Variadic}
+}
+
+func (cf *CompiledFunction) Type() ObjectType {
+	return COMPILED_FUNCTION_OBJ
+}
+
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+}
+
+func (c *Closure) Type() ObjectType {
+	return CLOSURE_OBJ
+}
+
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
+
+// Equals compares two objects by value: integers, strings, booleans and null
+// by their contents, arrays element by element, enum values by variant and
+// payload, everything else by identity.
+func Equals(a, b Object) bool {
+	switch a := a.(type) {
+	case *Integer:
+		other, ok := b.(*Integer)
+		return ok && a.Value == other.Value
+	case *String:
+		other, ok := b.(*String)
+		return ok && a.Value == other.Value
+	case *Boolean:
+		other, ok := b.(*Boolean)
+		return ok && a.Value == other.Value
+	case *Null:
+		_, ok := b.(*Null)
+		return ok
+	case *Array:
+		other, ok := b.(*Array)
+		if !ok || len(a.Elements) != len(other.Elements) {
+			return false
+		}
+		for i := range a.Elements {
+			if !Equals(a.Elements[i], other.Elements[i]) {
+				return false
+			}
+		}
+		return true
+	case *EnumValue:
+		other, ok := b.(*EnumValue)
+		if !ok || a.Variant != other.Variant {
+			return false
+		}
+		for i := range a.Payload {
+			if !Equals(a.Payload[i], other.Payload[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
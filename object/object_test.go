@@ -54,3 +54,92 @@ func TestHashKeysInteger(t *testing.T) {
 		t.Fatalf("different string have same hash keys")
 	}
 }
+
+func TestEnumValues(t *testing.T) {
+	shape := NewEnumType("Shape", [][]string{{"Circle", "r"}, {"Rect", "w", "h"}, {"Empty"}})
+	other := NewEnumType("Other", [][]string{{"Circle", "r"}})
+
+	circle, _ := shape.Get("Circle")
+	rect, _ := shape.Get("Rect")
+	empty, _ := shape.Get("Empty")
+	otherCircle, _ := other.Get("Circle")
+
+	circle1, _ := circle.(*Variant).New([]Object{&Integer{Value: 1}})
+	circle1Again, _ := circle.(*Variant).New([]Object{&Integer{Value: 1}})
+	circle2, _ := circle.(*Variant).New([]Object{&Integer{Value: 2}})
+	rect12, _ := rect.(*Variant).New([]Object{&Integer{Value: 1}, &Integer{Value: 2}})
+	otherCircle1, _ := otherCircle.(*Variant).New([]Object{&Integer{Value: 1}})
+	withArray, _ := circle.(*Variant).New([]Object{&Array{Elements: []Object{&Integer{Value: 1}}}})
+	withArrayAgain, _ := circle.(*Variant).New([]Object{&Array{Elements: []Object{&Integer{Value: 1}}}})
+
+	if circle1.Inspect() != "Shape.Circle(1)" || rect12.Inspect() != "Shape.Rect(1, 2)" || empty.Inspect() != "Shape.Empty" {
+		t.Errorf("wrong Inspect(). got=%s, %s, %s", circle1.Inspect(), rect12.Inspect(), empty.Inspect())
+	}
+	if circle.Inspect() != "<variant Shape.Circle>" || shape.Inspect() != "<enum Shape>" {
+		t.Errorf("wrong Inspect(). got=%s, %s", circle.Inspect(), shape.Inspect())
+	}
+	if empty2, _ := shape.Get("Empty"); empty2 != empty {
+		t.Errorf("variant without fields has more than one value")
+	}
+	if _, err := shape.Get("Square"); err == nil || err.Error() != "enum Shape has no variant Square" {
+		t.Errorf("wrong error for a missing variant. got=%v", err)
+	}
+	if _, err := circle.(*Variant).New(nil); err == nil || err.Message != "wrong number of arguments: want=1, got=0" {
+		t.Errorf("wrong error for a missing field. got=%v", err)
+	}
+	if value, ok := rect12.Field("h"); !ok || value.Inspect() != "2" {
+		t.Errorf("wrong field h. got=%v", value)
+	}
+
+	if !Equals(circle1, circle1Again) || !Equals(withArray, withArrayAgain) {
+		t.Errorf("equal enum values compare different")
+	}
+	if Equals(circle1, circle2) || Equals(circle1, otherCircle1) || Equals(circle1, empty) {
+		t.Errorf("different enum values compare equal")
+	}
+
+	if circle1.HashKey() != circle1Again.HashKey() {
+		t.Errorf("equal enum values have different hash keys")
+	}
+	if circle1.HashKey() == circle2.HashKey() || circle1.HashKey() == empty.(*EnumValue).HashKey() {
+		t.Errorf("different enum values have same hash keys")
+	}
+	if _, ok := HashKeyOf(withArray); ok {
+		t.Errorf("enum value carrying an array is usable as hash key")
+	}
+
+	//an enum declared again, as by a second call of the function declaring
+	//it, is a different enum
+	shapeAgain := NewEnumType("Shape", [][]string{{"Circle", "r"}})
+	circleAgain, _ := shapeAgain.Get("Circle")
+	circle1Other, _ := circleAgain.(*Variant).New([]Object{&Integer{Value: 1}})
+	if Equals(circle1, circle1Other) || circle1.HashKey() == circle1Other.HashKey() {
+		t.Errorf("values of different enums with the same name are the same")
+	}
+}
+
+func TestTraceback(t *testing.T) {
+	err := &Error{Message: "boom"}
+	err.AtLine(3)
+	err.AtLine(2) //an enclosing statement of the same function
+	err.LeaveFunction("inner")
+	for i := 0; i < 5; i++ {
+		err.AtLine(7)
+		err.LeaveFunction("")
+	}
+	err.LeaveFunction("callback") //left without passing a statement
+	err.AtLine(9)
+	err.LeaveFunction(MainFunction)
+
+	expected := "boom\n" +
+		"\tat inner (line 3)\n" +
+		"\tat <anonymous> (line 7)\n" +
+		"\tat <anonymous> (line 7)\n" +
+		"\tat <anonymous> (line 7)\n" +
+		"\t... 2 more calls to <anonymous> (line 7)\n" +
+		"\tat callback\n" +
+		"\tat <main> (line 9)"
+	if got := err.Traceback(); got != expected {
+		t.Errorf("wrong traceback.\nwant=%q\ngot=%q", expected, got)
+	}
+}
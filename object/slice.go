@@ -0,0 +1,125 @@
+package object
+
+import "fmt"
+
+// Slice returns the elements of an array, or the bytes of a string, that
+// seq[start:end:step] selects, as a new object of the same type.
+func Slice(seq, start, end, step Object) (Object, error) {
+	switch seq := seq.(type) {
+	case *Array:
+		indices, err := SliceIndices(len(seq.Elements), start, end, step)
+		if err != nil {
+			return nil, err
+		}
+		elements := make([]Object, len(indices))
+		for i, idx := range indices {
+			elements[i] = seq.Elements[idx]
+		}
+		return &Array{Elements: elements}, nil
+
+	case *String:
+		indices, err := SliceIndices(len(seq.Value), start, end, step)
+		if err != nil {
+			return nil, err
+		}
+		chars := make([]byte, len(indices))
+		for i, idx := range indices {
+			chars[i] = seq.Value[idx]
+		}
+		return &String{Value: string(chars)}, nil
+
+	default:
+		return nil, fmt.Errorf("slice operator not supported: %s", seq.Type())
+	}
+}
+
+// SliceIndices resolves the bounds of seq[start:end:step] against a sequence
+// of the given length and returns the positions it selects, in order.
+// Omitted bounds are passed as nil or *Null. Negative bounds count from the
+// end of the sequence and out of range bounds are clamped, so a slice never
+// fails because of its bounds, only because of their types or a zero step.
+func SliceIndices(length int, start, end, step Object) ([]int, error) {
+	stepVal := int64(1)
+	if !isOmitted(step) {
+		s, ok := step.(*Integer)
+		if !ok {
+			return nil, fmt.Errorf("slice step must be INTEGER, got %s", step.Type())
+		}
+		if s.Value == 0 {
+			return nil, fmt.Errorf("slice step cannot be zero")
+		}
+		stepVal = s.Value
+	}
+
+	n := int64(length)
+
+	//with a negative step the slice walks backwards, so the valid range for
+	//bounds shifts down by one: -1 means "stop before the first element"
+	lower, upper := int64(0), n
+	if stepVal < 0 {
+		lower, upper = -1, n-1
+	}
+
+	startVal, err := sliceBound(start, "start", n, lower, upper)
+	if err != nil {
+		return nil, err
+	}
+	endVal, err := sliceBound(end, "end", n, lower, upper)
+	if err != nil {
+		return nil, err
+	}
+	if isOmitted(start) {
+		startVal = lower
+		if stepVal < 0 {
+			startVal = upper
+		}
+	}
+	if isOmitted(end) {
+		endVal = upper
+		if stepVal < 0 {
+			endVal = lower
+		}
+	}
+
+	indices := []int{}
+	if stepVal > 0 {
+		for i := startVal; i < endVal; i += stepVal {
+			indices = append(indices, int(i))
+		}
+	} else {
+		for i := startVal; i > endVal; i += stepVal {
+			indices = append(indices, int(i))
+		}
+	}
+	return indices, nil
+}
+
+func sliceBound(bound Object, name string, n, lower, upper int64) (int64, error) {
+	if isOmitted(bound) {
+		return 0, nil
+	}
+	b, ok := bound.(*Integer)
+	if !ok {
+		return 0, fmt.Errorf("slice %s must be INTEGER, got %s", name, bound.Type())
+	}
+
+	val := b.Value
+	if val < 0 {
+		val += n
+	}
+	if val < lower {
+		return lower, nil
+	}
+	if val > upper {
+		return upper, nil
+	}
+	return val, nil
+}
+
+func isOmitted(bound Object) bool {
+	if bound == nil {
+		return true
+	}
+	_, ok := bound.(*Null)
+	return ok
+}
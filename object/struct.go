@@ -0,0 +1,132 @@
+package object
+
+import (
+	"fmt"
+	"strings"
+)
+
+// StructType is a record type declared by a struct statement. Calling it
+// constructs an instance from the value of each field, in order.
+type StructType struct {
+	Name   string
+	Fields []string
+	//Methods holds the function of each method, a *Function or a *Closure
+	//whose first parameter is the instance it is called on
+	Methods map[string]Object
+}
+
+func (st *StructType) Type() ObjectType {
+	return STRUCT_TYPE_OBJ
+}
+
+func (st *StructType) Inspect() string {
+	return "<struct " + st.Name + ">"
+}
+
+// Arity returns how many arguments constructing an instance takes, one
+// for each field.
+func (st *StructType) Arity() Arity {
+	return Arity{Required: len(st.Fields)}
+}
+
+// New returns an instance whose fields are set to args, or the error for
+// passing the wrong number of them.
+func (st *StructType) New(args []Object) (*Struct, *Error) {
+	if err := st.Arity().Check(len(args)); err != nil {
+		return nil, err
+	}
+	fields := make([]Object, len(args))
+	copy(fields, args)
+	return &Struct{StructType: st, Fields: fields}, nil
+}
+
+func (st *StructType) field(name string) int {
+	for i, field := range st.Fields {
+		if field == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Struct is an instance of a StructType. Its fields can be assigned, and
+// every reference to the instance sees the new value.
+type Struct struct {
+	StructType *StructType
+	Fields     []Object //value of each field of StructType, in order
+}
+
+func (s *Struct) Type() ObjectType {
+	return STRUCT_OBJ
+}
+
+func (s *Struct) Inspect() string {
+	fields := make([]string, len(s.Fields))
+	for i, value := range s.Fields {
+		fields[i] = s.StructType.Fields[i] + ": " + value.Inspect()
+	}
+	return s.StructType.Name + "{" + strings.Join(fields, ", ") + "}"
+}
+
+// Get returns the field called name, or the method called name bound to s.
+func (s *Struct) Get(name string) (Object, error) {
+	if i := s.StructType.field(name); i >= 0 {
+		return s.Fields[i], nil
+	}
+	if method, ok := s.StructType.Methods[name]; ok {
+		return &BoundMethod{Name: s.StructType.Name + "." + name, Receiver: s, Method: method}, nil
+	}
+	return nil, fmt.Errorf("%s has no field or method %s", s.StructType.Name, name)
+}
+
+// Set assigns value to the field called name.
+func (s *Struct) Set(name string, value Object) error {
+	if i := s.StructType.field(name); i >= 0 {
+		s.Fields[i] = value
+		return nil
+	}
+	if _, ok := s.StructType.Methods[name]; ok {
+		return fmt.Errorf("cannot assign to method %s of %s", name, s.StructType.Name)
+	}
+	return fmt.Errorf("%s has no field %s", s.StructType.Name, name)
+}
+
+// BoundMethod is a method read from an instance, as in p.norm. Calling it
+// calls the method with the instance in front of the arguments.
+type BoundMethod struct {
+	Name     string //Type.method
+	Receiver Object
+	Method   Object
+}
+
+func (bm *BoundMethod) Type() ObjectType {
+	return BOUND_METHOD_OBJ
+}
+
+func (bm *BoundMethod) Inspect() string {
+	return "<method " + bm.Name + ">"
+}
+
+// Arity returns how many arguments the method takes besides the receiver.
+// A builtin method checks its own arguments, so it accepts any number here.
+func (bm *BoundMethod) Arity() Arity {
+	var arity Arity
+	switch method := bm.Method.(type) {
+	case *BuiltIn:
+		return Arity{Variadic: true}
+	case *Function:
+		arity = method.Arity()
+	case *Closure:
+		arity = method.Fn.Arity()
+	}
+	arity.Required--
+	return arity
+}
+
+// SetMember assigns value to obj.name.
+func SetMember(obj Object, name string, value Object) error {
+	if s, ok := obj.(*Struct); ok {
+		return s.Set(name, value)
+	}
+	return fmt.Errorf("cannot assign %s of %s, only structs have fields", name, obj.Type())
+}
@@ -0,0 +1,89 @@
+package object
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MainFunction is the name the top level of a program goes by in a stack
+// trace.
+const MainFunction = "<main>"
+
+// ModuleFunction returns the name the top level of the module loaded from
+// file goes by in a stack trace.
+func ModuleFunction(file string) string {
+	return "<module " + file + ">"
+}
+
+// StackFrame is one Hulk call a runtime error went through.
+type StackFrame struct {
+	Function string //empty for anonymous functions
+	Line     int    //line the call was running when the error went through, 0 if unknown
+}
+
+func (f StackFrame) String() string {
+	name := f.Function
+	if name == "" {
+		name = "<anonymous>"
+	}
+	if f.Line == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s (line %d)", name, f.Line)
+}
+
+// AtLine records line as where the error happened in the function it is
+// in, unless a statement nested deeper in that function already did. The
+// evaluator calls it for each statement the error leaves.
+func (err *Error) AtLine(line int) {
+	if err.open {
+		return
+	}
+	err.Stack = append(err.Stack, StackFrame{Line: line})
+	err.open = true
+}
+
+// LeaveFunction closes the frame of the function the error is leaving,
+// which is named name.
+func (err *Error) LeaveFunction(name string) {
+	if !err.open {
+		err.Stack = append(err.Stack, StackFrame{})
+	}
+	err.Stack[len(err.Stack)-1].Function = name
+	err.open = false
+}
+
+// maxRepeatedFrames is how many times in a row a frame is printed before the
+// rest of the run is folded into one line, so that runaway recursion does
+// not bury the message.
+const maxRepeatedFrames = 3
+
+// Traceback formats the error message, prefixed with its type when it was
+// thrown, followed by its stack, one call per line with the innermost first.
+func (err *Error) Traceback() string {
+	var out strings.Builder
+	if err.Kind != "" {
+		out.WriteString(err.Kind + ": ")
+	}
+	out.WriteString(err.Message)
+
+	for i := 0; i < len(err.Stack); {
+		run := 1
+		for i+run < len(err.Stack) && err.Stack[i+run] == err.Stack[i] {
+			run++
+		}
+
+		shown := run
+		if shown > maxRepeatedFrames {
+			shown = maxRepeatedFrames
+		}
+		for j := 0; j < shown; j++ {
+			out.WriteString("\n\tat " + err.Stack[i].String())
+		}
+		if run > shown {
+			fmt.Fprintf(&out, "\n\t... %d more calls to %s", run-shown, err.Stack[i].String())
+		}
+		i += run
+	}
+	return out.String()
+}
@@ -0,0 +1,141 @@
+package optimizer
+
+import (
+	"Hulk/ast"
+	"Hulk/token"
+	"strconv"
+)
+
+// Fold evaluates an expression built only from literals and returns the
+// literal it evaluates to. It only folds what the evaluator and the VM agree
+// on and gives up on anything that fails at runtime, like a division by
+// zero, so those errors still surface when the program runs. Strings are
+// concatenated but never compared, since the VM compares them by identity.
+func Fold(node ast.Expression) (ast.Expression, bool) {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral, *ast.StringLiteral, *ast.BooleanExpression:
+		return node, true
+
+	case *ast.PrefixExpression:
+		right, ok := Fold(node.Right)
+		if !ok {
+			return nil, false
+		}
+		line := node.Token.Line
+		switch node.Operator {
+		case "!":
+			b, isBool := right.(*ast.BooleanExpression)
+			return booleanLiteral(isBool && !b.Value, line), true
+		case "-":
+			if integer, ok := right.(*ast.IntegerLiteral); ok {
+				return integerLiteral(-integer.Value, line), true
+			}
+		}
+
+	case *ast.InfixExpression:
+		left, ok := Fold(node.LeftExpr)
+		if !ok {
+			return nil, false
+		}
+		right, ok := Fold(node.RightExpr)
+		if !ok {
+			return nil, false
+		}
+		return foldInfix(node.Operator, left, right, node.Token.Line)
+	}
+
+	return nil, false
+}
+
+func foldInfix(operator string, left, right ast.Expression, line int) (ast.Expression, bool) {
+	switch left := left.(type) {
+	case *ast.IntegerLiteral:
+		right, ok := right.(*ast.IntegerLiteral)
+		if !ok {
+			return nil, false
+		}
+		l, r := left.Value, right.Value
+		switch operator {
+		case "+":
+			return integerLiteral(l+r, line), true
+		case "-":
+			return integerLiteral(l-r, line), true
+		case "*":
+			return integerLiteral(l*r, line), true
+		case "/":
+			if r == 0 {
+				return nil, false
+			}
+			return integerLiteral(l/r, line), true
+		case ">":
+			return booleanLiteral(l > r, line), true
+		case "<":
+			return booleanLiteral(l < r, line), true
+		case "==":
+			return booleanLiteral(l == r, line), true
+		case "!=":
+			return booleanLiteral(l != r, line), true
+		}
+
+	case *ast.StringLiteral:
+		right, ok := right.(*ast.StringLiteral)
+		if ok && operator == "+" {
+			return stringLiteral(left.Value+right.Value, line), true
+		}
+
+	case *ast.BooleanExpression:
+		right, ok := right.(*ast.BooleanExpression)
+		if !ok {
+			return nil, false
+		}
+		switch operator {
+		case "==":
+			return booleanLiteral(left.Value == right.Value, line), true
+		case "!=":
+			return booleanLiteral(left.Value != right.Value, line), true
+		}
+	}
+
+	return nil, false
+}
+
+func integerLiteral(value int64, line int) *ast.IntegerLiteral {
+	tok := token.Token{Type: token.INT, Literal: strconv.FormatInt(value, 10), Line: line}
+	return &ast.IntegerLiteral{Token: tok, Value: value}
+}
+
+func stringLiteral(value string, line int) *ast.StringLiteral {
+	tok := token.Token{Type: token.STRING, Literal: value, Line: line}
+	return &ast.StringLiteral{Token: tok, Value: value}
+}
+
+func booleanLiteral(value bool, line int) *ast.BooleanExpression {
+	tok := token.Token{Type: token.FALSE, Literal: "false", Line: line}
+	if value {
+		tok = token.Token{Type: token.TRUE, Literal: "true", Line: line}
+	}
+	return &ast.BooleanExpression{Token: tok, Value: value}
+}
+
+// isLiteral reports whether node is a literal whose value does not depend
+// on where it is evaluated.
+func isLiteral(node ast.Expression) bool {
+	switch node.(type) {
+	case *ast.IntegerLiteral, *ast.StringLiteral, *ast.BooleanExpression:
+		return true
+	}
+	return false
+}
+
+// copyLiteral makes a fresh copy of a literal for a new place in the tree.
+func copyLiteral(node ast.Expression, line int) ast.Expression {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		return integerLiteral(node.Value, line)
+	case *ast.StringLiteral:
+		return stringLiteral(node.Value, line)
+	case *ast.BooleanExpression:
+		return booleanLiteral(node.Value, line)
+	}
+	return node
+}
@@ -0,0 +1,144 @@
+package optimizer
+
+import (
+	"Hulk/ast"
+)
+
+// maxInlineSize is the number of nodes a function body may have to be inlined.
+const maxInlineSize = 16
+
+// inline replaces a call with the body of the function it calls, when that
+// is sure to give the same result. The function must be a literal, or bound
+// to one, without rest or destructured parameters and whose body is a single
+// expression reading nothing but its parameters, each exactly once. Every
+// argument must be a literal or a name, so moving it into the body changes
+// neither how often nor in which scope it is evaluated. Reading only
+// parameters also rules out recursion.
+func inline(call *ast.CallExpression, s *scope) (ast.Expression, bool) {
+	var fn *ast.FunctionLiteral
+	switch callee := call.Function.(type) {
+	case *ast.FunctionLiteral:
+		fn = callee
+	case *ast.Identifier:
+		value, ok := s.lookup(callee.Value)
+		if !ok {
+			return nil, false
+		}
+		fn, _ = value.(*ast.FunctionLiteral)
+	}
+	if fn == nil || fn.Rest != nil || fn.Patterns != nil || len(fn.Parameters) != len(call.Arguments) || len(fn.Block.Statements) != 1 {
+		return nil, false
+	}
+
+	stmt, ok := fn.Block.Statements[0].(*ast.ExpressionStatement)
+	if !ok || stmt.Expression == nil {
+		return nil, false
+	}
+
+	args := map[string]ast.Expression{}
+	for i, p := range fn.Parameters {
+		switch call.Arguments[i].(type) {
+		case *ast.IntegerLiteral, *ast.StringLiteral, *ast.BooleanExpression, *ast.Identifier:
+		default:
+			return nil, false
+		}
+		args[p.Value] = call.Arguments[i]
+	}
+	if len(args) != len(fn.Parameters) {
+		return nil, false
+	}
+
+	uses := map[string]int{}
+	size := 0
+	if !inlinableBody(stmt.Expression, args, uses, &size) || size > maxInlineSize {
+		return nil, false
+	}
+	for name := range args {
+		if uses[name] != 1 {
+			return nil, false
+		}
+	}
+
+	return substitute(stmt.Expression, args), true
+}
+
+func inlinableBody(node ast.Expression, params map[string]ast.Expression, uses map[string]int, size *int) bool {
+	*size++
+
+	switch node := node.(type) {
+	case *ast.IntegerLiteral, *ast.StringLiteral, *ast.BooleanExpression:
+		return true
+	case *ast.Identifier:
+		if _, ok := params[node.Value]; !ok {
+			return false
+		}
+		uses[node.Value]++
+		return true
+	case *ast.PrefixExpression:
+		return inlinableBody(node.Right, params, uses, size)
+	case *ast.InfixExpression:
+		return inlinableBody(node.LeftExpr, params, uses, size) &&
+			inlinableBody(node.RightExpr, params, uses, size)
+	case *ast.IndexExpression:
+		return inlinableBody(node.Left, params, uses, size) &&
+			inlinableBody(node.Index, params, uses, size)
+	case *ast.MemberExpression:
+		return inlinableBody(node.Object, params, uses, size)
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			if !inlinableBody(el, params, uses, size) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// substitute copies a body accepted by inlinableBody, putting the arguments
+// in place of the parameters. The function literal itself stays untouched,
+// it may still be called elsewhere.
+func substitute(node ast.Expression, args map[string]ast.Expression) ast.Expression {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		return copyLiteral(node, node.Token.Line)
+	case *ast.StringLiteral:
+		return copyLiteral(node, node.Token.Line)
+	case *ast.BooleanExpression:
+		return copyLiteral(node, node.Token.Line)
+	case *ast.Identifier:
+		return args[node.Value]
+	case *ast.PrefixExpression:
+		return &ast.PrefixExpression{
+			Token:    node.Token,
+			Operator: node.Operator,
+			Right:    substitute(node.Right, args),
+		}
+	case *ast.InfixExpression:
+		return &ast.InfixExpression{
+			Token:     node.Token,
+			LeftExpr:  substitute(node.LeftExpr, args),
+			Operator:  node.Operator,
+			RightExpr: substitute(node.RightExpr, args),
+		}
+	case *ast.IndexExpression:
+		return &ast.IndexExpression{
+			Token: node.Token,
+			Left:  substitute(node.Left, args),
+			Index: substitute(node.Index, args),
+		}
+	case *ast.MemberExpression:
+		return &ast.MemberExpression{
+			Token:  node.Token,
+			Object: substitute(node.Object, args),
+			Member: node.Member,
+		}
+	case *ast.ArrayLiteral:
+		elements := make([]ast.Expression, len(node.Elements))
+		for i, el := range node.Elements {
+			elements[i] = substitute(el, args)
+		}
+		return &ast.ArrayLiteral{Token: node.Token, Elements: elements}
+	}
+	return node
+}
@@ -0,0 +1,400 @@
+// Package optimizer rewrites a parsed program into a cheaper one with the
+// same behaviour. It works on the AST, so the evaluator and the compiler can
+// both run it before doing their own work.
+package optimizer
+
+import (
+	"Hulk/ast"
+)
+
+// maxPasses bounds how often the rewrites are repeated. Each pass can open
+// up work for the next one, like a call that becomes foldable once inlined.
+const maxPasses = 8
+
+// Optimize rewrites program in place and returns it:
+//
+//   - expressions made of literals are folded
+//   - integer and boolean bindings that are never rebound are propagated
+//   - calls to small non-recursive functions are inlined
+//   - if (true) and if (false) are replaced by the branch that runs
+//   - let bindings that nothing reads and that have no side effects go away
+//
+// A program run in pieces, like the lines of the REPL, must not be
+// optimized, since a binding that looks unused may be read by a later piece.
+func Optimize(program *ast.Program) *ast.Program {
+	for i := 0; i < maxPasses; i++ {
+		o := &optimizer{}
+		s := newScope(nil, nil, program.Statements)
+		program.Statements = o.statements(program.Statements, s, true)
+		program.Statements = o.removeUnusedLets(program.Statements, countReferences(program))
+		if !o.changed {
+			break
+		}
+	}
+	return program
+}
+
+type optimizer struct {
+	changed bool
+}
+
+// scope tracks the bindings of one function body, or of the whole program.
+// Blocks of if expressions share the scope of the function they are in,
+// just like they share its environment in the evaluator.
+type scope struct {
+	outer *scope
+
+	//how often each name is bound in this scope, parameters included
+	declared map[string]int
+	//values of bindings that can be used in place of the name, recorded
+	//once the let statement binding them has been passed
+	values map[string]ast.Expression
+}
+
+func newScope(outer *scope, params []*ast.Identifier, body []ast.Statement) *scope {
+	s := &scope{outer: outer, declared: map[string]int{}, values: map[string]ast.Expression{}}
+	for _, p := range params {
+		s.declared[p.Value]++
+	}
+	for _, stmt := range body {
+		countLets(stmt, s.declared)
+	}
+	return s
+}
+
+// lookup finds the value bound to name by the innermost scope declaring it.
+// A name declared but not bound yet has no value, even if an outer scope
+// binds the same name.
+func (s *scope) lookup(name string) (ast.Expression, bool) {
+	for ; s != nil; s = s.outer {
+		if s.declared[name] > 0 {
+			value, ok := s.values[name]
+			return value, ok
+		}
+	}
+	return nil, false
+}
+
+// statements rewrites a list of statements. Bindings are only recorded at
+// the top level of a scope: a let inside an if block may never run.
+func (o *optimizer) statements(stmts []ast.Statement, s *scope, topLevel bool) []ast.Statement {
+	out := make([]ast.Statement, 0, len(stmts))
+
+	for i, stmt := range stmts {
+		last := i == len(stmts)-1
+
+		switch stmt := stmt.(type) {
+		case *ast.LetStatement:
+			stmt.Value = o.expression(stmt.Value, s)
+			if topLevel && stmt.Name != nil && s.declared[stmt.Name.Value] == 1 && bindable(stmt.Value) {
+				s.values[stmt.Name.Value] = stmt.Value
+			}
+
+		case *ast.ExportStatement:
+			//exported bindings are read by other modules, they are neither
+			//propagated nor removed
+			if stmt.Struct != nil {
+				o.methods(stmt.Struct, s)
+			} else if stmt.Let != nil {
+				stmt.Let.Value = o.expression(stmt.Let.Value, s)
+			}
+
+		case *ast.StructStatement:
+			o.methods(stmt, s)
+
+		case *ast.ReturnStatement:
+			stmt.ReturnValue = o.expression(stmt.ReturnValue, s)
+
+		case *ast.ThrowStatement:
+			stmt.Value = o.expression(stmt.Value, s)
+
+		case *ast.ExpressionStatement:
+			stmt.Expression = o.expression(stmt.Expression, s)
+
+			ifExp, ok := stmt.Expression.(*ast.IfExpression)
+			if !ok {
+				break
+			}
+			taken, known := takenBranch(ifExp)
+			if !known {
+				break
+			}
+			//the value of the last statement is the value of the whole
+			//block, so an if that ends it must leave a value behind
+			if taken == nil && !last {
+				o.changed = true
+				continue
+			}
+			if taken != nil && len(taken.Statements) > 0 {
+				o.changed = true
+				out = append(out, taken.Statements...)
+				continue
+			}
+		}
+
+		out = append(out, stmt)
+	}
+
+	return out
+}
+
+func (o *optimizer) methods(node *ast.StructStatement, s *scope) {
+	for _, method := range node.Methods {
+		o.expression(method.Function, s)
+	}
+}
+
+func (o *optimizer) block(block *ast.BlockStatement, s *scope) {
+	if block != nil {
+		block.Statements = o.statements(block.Statements, s, false)
+	}
+}
+
+func (o *optimizer) expression(node ast.Expression, s *scope) ast.Expression {
+	switch node := node.(type) {
+	case *ast.Identifier:
+		if value, ok := s.lookup(node.Value); ok && isLiteral(value) {
+			o.changed = true
+			return copyLiteral(value, node.Token.Line)
+		}
+
+	case *ast.PrefixExpression:
+		node.Right = o.expression(node.Right, s)
+		return o.fold(node)
+
+	case *ast.InfixExpression:
+		node.LeftExpr = o.expression(node.LeftExpr, s)
+		node.RightExpr = o.expression(node.RightExpr, s)
+		return o.fold(node)
+
+	case *ast.IfExpression:
+		node.Condition = o.expression(node.Condition, s)
+		o.block(node.Consequence, s)
+		o.block(node.Alternative, s)
+
+		//used as a value, an if can only be replaced by a branch that is a
+		//single expression; as a statement it is handled by statements
+		taken, known := takenBranch(node)
+		if known && taken != nil && len(taken.Statements) == 1 {
+			if stmt, ok := taken.Statements[0].(*ast.ExpressionStatement); ok && stmt.Expression != nil {
+				o.changed = true
+				return stmt.Expression
+			}
+		}
+
+	case *ast.PropagateExpression:
+		node.Value = o.expression(node.Value, s)
+
+	case *ast.TryExpression:
+		o.block(node.Block, s)
+		o.block(node.Catch, s)
+		o.block(node.Finally, s)
+
+	case *ast.FunctionLiteral:
+		params := node.Parameters[:len(node.Parameters):len(node.Parameters)]
+		if node.Rest != nil {
+			params = append(params, node.Rest)
+		}
+		for _, pattern := range node.Patterns {
+			params = append(params, ast.PatternNames(pattern)...)
+		}
+		inner := newScope(s, params, node.Block.Statements)
+		for i, d := range node.Defaults {
+			if d != nil {
+				node.Defaults[i] = o.expression(d, inner)
+			}
+		}
+		node.Block.Statements = o.statements(node.Block.Statements, inner, true)
+
+	case *ast.SpreadExpression:
+		node.Value = o.expression(node.Value, s)
+
+	case *ast.MatchExpression:
+		node.Value = o.expression(node.Value, s)
+		for _, arm := range node.Arms {
+			if arm.Guard != nil {
+				arm.Guard = o.expression(arm.Guard, s)
+			}
+			arm.Body = o.expression(arm.Body, s)
+		}
+
+	case *ast.CallExpression:
+		node.Function = o.expression(node.Function, s)
+		for i, arg := range node.Arguments {
+			node.Arguments[i] = o.expression(arg, s)
+		}
+		if inlined, ok := inline(node, s); ok {
+			o.changed = true
+			return inlined
+		}
+
+	case *ast.ArrayLiteral:
+		for i, el := range node.Elements {
+			node.Elements[i] = o.expression(el, s)
+		}
+
+	case *ast.HashLiteral:
+		//keys are left alone: the compiler orders them by their source text,
+		//which folding could make ambiguous
+		for key, value := range node.Pairs {
+			node.Pairs[key] = o.expression(value, s)
+		}
+
+	case *ast.IndexExpression:
+		node.Left = o.expression(node.Left, s)
+		node.Index = o.expression(node.Index, s)
+
+	case *ast.MemberExpression:
+		node.Object = o.expression(node.Object, s)
+
+	case *ast.AssignExpression:
+		node.Target.Object = o.expression(node.Target.Object, s)
+		node.Value = o.expression(node.Value, s)
+
+	case *ast.SliceExpression:
+		node.Left = o.expression(node.Left, s)
+		if node.Start != nil {
+			node.Start = o.expression(node.Start, s)
+		}
+		if node.End != nil {
+			node.End = o.expression(node.End, s)
+		}
+		if node.Step != nil {
+			node.Step = o.expression(node.Step, s)
+		}
+	}
+
+	return node
+}
+
+func (o *optimizer) fold(node ast.Expression) ast.Expression {
+	if folded, ok := Fold(node); ok {
+		o.changed = true
+		return folded
+	}
+	return node
+}
+
+// takenBranch reports which branch of an if with a literal condition runs.
+// The branch is nil when the condition is false and there is no else.
+func takenBranch(node *ast.IfExpression) (*ast.BlockStatement, bool) {
+	if !isLiteral(node.Condition) {
+		return nil, false
+	}
+	if b, ok := node.Condition.(*ast.BooleanExpression); ok && !b.Value {
+		return node.Alternative, true
+	}
+	return node.Consequence, true
+}
+
+// bindable reports whether a bound value may be used in place of its name:
+// integers, booleans and strings are copied to every use, and functions are
+// kept so that calls to them can be inlined.
+func bindable(value ast.Expression) bool {
+	switch value.(type) {
+	case *ast.IntegerLiteral, *ast.BooleanExpression, *ast.StringLiteral, *ast.FunctionLiteral:
+		return true
+	}
+	return false
+}
+
+// countLets counts the let statements in node, looking into the blocks of
+// if and try expressions but not into function literals, which have their
+// own scope. The parameter of a catch clause is bound like a let, and so is
+// every name in a destructuring pattern or the pattern of a match arm, and
+// the name of an import or a struct.
+func countLets(node ast.Node, counts map[string]int) {
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.LetStatement:
+			if n.Pattern == nil {
+				counts[n.Name.Value]++
+			}
+			for _, ident := range ast.PatternNames(n.Pattern) {
+				counts[ident.Value]++
+			}
+		case *ast.TryExpression:
+			if n.CatchParam != nil {
+				counts[n.CatchParam.Value]++
+			}
+		case *ast.ImportStatement:
+			counts[n.Name.Value]++
+		case *ast.StructStatement:
+			counts[n.Name.Value]++
+		case *ast.EnumStatement:
+			counts[n.Name.Value]++
+		case *ast.MatchExpression:
+			for _, arm := range n.Arms {
+				for _, ident := range ast.PatternNames(arm.Pattern) {
+					counts[ident.Value]++
+				}
+			}
+		case *ast.FunctionLiteral:
+			return false
+		}
+		return true
+	})
+}
+
+// countReferences counts how often each name is read anywhere in program.
+// Names are not told apart by scope, which only ever overcounts.
+func countReferences(program *ast.Program) map[string]int {
+	refs := map[string]int{}
+	ast.Inspect(program, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Identifier); ok {
+			refs[ident.Value]++
+		}
+		return true
+	})
+	return refs
+}
+
+// removeUnusedLets drops let statements binding a name nothing reads to a
+// value that is free of side effects, in stmts and every block below them.
+// The last statement of a block is kept, it is the value of the block.
+func (o *optimizer) removeUnusedLets(stmts []ast.Statement, refs map[string]int) []ast.Statement {
+	for _, stmt := range stmts {
+		ast.Inspect(stmt, func(n ast.Node) bool {
+			if block, ok := n.(*ast.BlockStatement); ok {
+				block.Statements = o.removeUnusedLets(block.Statements, refs)
+				return false
+			}
+			return true
+		})
+	}
+
+	out := make([]ast.Statement, 0, len(stmts))
+	for i, stmt := range stmts {
+		let, ok := stmt.(*ast.LetStatement)
+		if ok && let.Name != nil && i < len(stmts)-1 && refs[let.Name.Value] == 0 && pure(let.Value) {
+			o.changed = true
+			continue
+		}
+		out = append(out, stmt)
+	}
+	return out
+}
+
+// pure reports whether evaluating node can neither fail nor have effects.
+func pure(node ast.Expression) bool {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral, *ast.StringLiteral, *ast.BooleanExpression, *ast.FunctionLiteral:
+		return true
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			if !pure(el) {
+				return false
+			}
+		}
+		return true
+	case *ast.HashLiteral:
+		//functions are not usable as keys, so keys must be plain literals
+		for key, value := range node.Pairs {
+			if !isLiteral(key) || !pure(value) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
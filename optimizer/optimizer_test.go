@@ -0,0 +1,107 @@
+package optimizer
+
+import (
+	"Hulk/ast"
+	"Hulk/lexer"
+	"Hulk/parser"
+	"testing"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	return program
+}
+
+func TestFold(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 + 2 * 3", "7"},
+		{"-(4 - 10) / 2", "3"},
+		{`"a" + "b"`, "ab"},
+		{"1 < 2 == !false", "true"},
+		{"!5", "false"},
+		{"true != false", "true"},
+		{"5 / 0", "(5 / 0)"},
+		{`"a" == "a"`, `(a == a)`},
+		{"1 + true", "(1 + true)"},
+		{"x + 1", "(x + 1)"},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+		expr := program.Statements[0].(*ast.ExpressionStatement).Expression
+
+		folded, ok := Fold(expr)
+		if !ok {
+			folded = expr
+		}
+		if folded.String() != tt.expected {
+			t.Errorf("%s: wrong fold. want=%s, got=%s", tt.input, tt.expected, folded.String())
+		}
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		//constants are propagated and the bindings then dropped
+		{"let a = 2; let b = a * 3; b + a", "8"},
+		{"let t = true; if (t) { 1 } else { 2 }", "1"},
+		{`let s = "x"; s == s`, `(x == x)`},
+		//a binding made twice is not a constant
+		{"let a = 1; let a = 2; a", "let a = 1;let a = 2;a"},
+		//a binding made in a branch may not exist
+		{"if (x) { let a = 1; } a", "ifx let a = 1;a"},
+		//a name used before its binding is left alone
+		{"let f = fn() { a }; let a = 1; f()", "let f = fn()a;let a = 1;f()"},
+		//parameters shadow outer constants
+		{"let a = 1; let f = fn(a) { a }; f", "let a = 1;let f = fn(a)a;f"},
+		{"let a = 1; fn(b) { a + b }", "fn(b)(1 + b)"},
+		//small functions are inlined
+		{"let sq = fn(x) { x * x }; sq(3)", "let sq = fn(x)(x * x);sq(3)"},
+		{"let add = fn(x, y) { x + y }; add(3, 4)", "7"},
+		{"let add = fn(x, y) { x + y }; fn(z) { add(z, 1) }", "fn(z)(z + 1)"},
+		{"fn(x) { [x, 1] }(len)", "[len, 1]"},
+		//but not when arguments might be evaluated a different number of times
+		{"let f = fn(x) { 1 }; f(puts(1))", "let f = fn(x)1;f(puts(1))"},
+		{"let f = fn(x) { x }; f(puts(1))", "let f = fn(x)x;f(puts(1))"},
+		//or when the body reads anything but parameters
+		{"let f = fn(x) { x + y }; f(1)", "let f = fn(x)(x + y);f(1)"},
+		{"let f = fn(x) { puts(x) }; f(1)", "let f = fn(x)puts(x);f(1)"},
+		{"let get = fn(p) { p.x }; get(q)", "(q.x)"},
+		//methods are optimized like other functions
+		{"let k = 2; struct P { x, fn f() { self.x * k } }; P(1).f()", "struct P { x, fn f()((self.x) * 2) }(P(1).f)()"},
+		{"p.x = 1 + 2", "(p.x) = 3"},
+		//an enum rebinds its name, which is then not a constant
+		{"let E = 1; enum E { A }; E.A", "let E = 1;enum E { A }(E.A)"},
+		//constant conditions pick their branch
+		{"if (1 > 2) { puts(1) } else { puts(2) }", "puts(2)"},
+		{"if (false) { puts(1) }; puts(2)", "puts(2)"},
+		{"if (false) { puts(1) }", "iffalse puts(1)"},
+		{"let x = if (true) { puts(1) } else { 2 }; x", "let x = puts(1);x"},
+		{`if ("yes") { let a = len; a }`, "let a = len;a"},
+		//unused bindings go unless they might fail or have effects
+		{"let a = [1, fn() { 2 }]; let b = puts(1); let c = d; 3", "let b = puts(1);let c = d;3"},
+		{"let h = {1: 2}; let g = {len: 2}; 3", "let g = {len:2};3"},
+		//the last statement is the value of its block and stays
+		{"let a = 1;", "let a = 1;"},
+		{"fn() { let a = 1; let b = 2; }", "fn()let b = 2;"},
+	}
+
+	for _, tt := range tests {
+		program := Optimize(parse(t, tt.input))
+		if program.String() != tt.expected {
+			t.Errorf("%s: wrong program.\nwant=%s\ngot =%s", tt.input, tt.expected, program.String())
+		}
+	}
+}
@@ -31,6 +31,8 @@ var precedences = map[token.TokenType]int{
 	token.MINUS:     SUM,
 	token.LPAREN:    CALL,
 	token.LBRACKET:  INDEX,
+	token.QUESTION:  INDEX,
+	token.DOT:       INDEX,
 }
 
 func (p *Parser) peekPrecedence() int {
@@ -58,7 +60,8 @@ type Parser struct {
 	currToken token.Token
 	peekToken token.Token
 
-	errors []string
+	errors   []string
+	warnings []string
 
 	infixParsefns  map[token.TokenType]InfixParsefn
 	prefixParsefns map[token.TokenType]PrefixParsefn
@@ -91,6 +94,10 @@ func New(l *lexer.Lexer) *Parser {
 
 	p.RegisterPrefix(token.LBRACE, p.parseHashExpression)
 
+	p.RegisterPrefix(token.TRY, p.parseTryExpression)
+
+	p.RegisterPrefix(token.MATCH, p.parseMatchExpression)
+
 	p.infixParsefns = make(map[token.TokenType]InfixParsefn)
 	p.RegisterInfix(token.PLUS, p.parseInfixExpression)
 	p.RegisterInfix(token.MINUS, p.parseInfixExpression)
@@ -103,6 +110,8 @@ func New(l *lexer.Lexer) *Parser {
 	p.RegisterInfix(token.LPAREN, p.parseCallExpression)
 
 	p.RegisterInfix(token.LBRACKET, p.parseIndexExpression)
+	p.RegisterInfix(token.QUESTION, p.parsePropagateExpression)
+	p.RegisterInfix(token.DOT, p.parseMemberExpression)
 	//read 2 tokens so that curr and peek tokens both are set
 	p.NextToken()
 	p.NextToken()
@@ -123,7 +132,14 @@ func (p *Parser) ParseProgram() *ast.Program {
 	program := &ast.Program{}
 	program.Statements = []ast.Statement{}
 	for p.currToken.Type != token.EOF {
-		stmt := p.parseStatement()
+		var stmt ast.Statement
+		if p.currTokenIs(token.EXPORT) {
+			if export := p.parseExportStatement(); export != nil {
+				stmt = export
+			}
+		} else {
+			stmt = p.parseStatement()
+		}
 		if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
 		}
@@ -138,6 +154,17 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.THROW:
+		return p.parseThrowStatement()
+	case token.IMPORT:
+		return p.parseImportStatement()
+	case token.STRUCT:
+		return p.parseStructStatement()
+	case token.ENUM:
+		return p.parseEnumStatement()
+	case token.EXPORT:
+		p.errors = append(p.errors, "export is only allowed at the top level of a module")
+		return nil
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -145,10 +172,18 @@ func (p *Parser) parseStatement() ast.Statement {
 
 func (p *Parser) parseLetStatement() *ast.LetStatement {
 	stmt := &ast.LetStatement{Token: p.currToken}
-	if !p.expectPeek(token.IDENTIFIER) {
-		return nil
+	if p.peekTokenIs(token.LBRACKET) || p.peekTokenIs(token.LBRACE) {
+		p.NextToken()
+		stmt.Pattern = p.parsePattern()
+		if stmt.Pattern == nil {
+			return nil
+		}
+	} else {
+		if !p.expectPeek(token.IDENTIFIER) {
+			return nil
+		}
+		stmt.Name = &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
 	}
-	stmt.Name = &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
 	}
@@ -156,12 +191,138 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	p.NextToken()
 	stmt.Value = p.parseExpression(LOWEST)
 
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok && stmt.Name != nil {
+		fl.Name = stmt.Name.Value
+	}
+
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.NextToken()
 	}
 	return stmt
 }
 
+// parsePattern parses what a destructuring binding binds to, starting at
+// the current token: a name, _, an array pattern or a hash pattern.
+func (p *Parser) parsePattern() ast.Pattern {
+	return p.parsePatternOf(false)
+}
+
+// parseMatchPattern parses the pattern of a match arm, which unlike the
+// patterns of bindings may also hold literals.
+func (p *Parser) parseMatchPattern() ast.Pattern {
+	return p.parsePatternOf(true)
+}
+
+func (p *Parser) parsePatternOf(literals bool) ast.Pattern {
+	switch p.currToken.Type {
+	case token.IDENTIFIER:
+		if p.currToken.Literal == "_" {
+			return &ast.WildcardPattern{Token: p.currToken}
+		}
+		return &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
+	case token.LBRACKET:
+		return p.parseArrayPattern(literals)
+	case token.LBRACE:
+		return p.parseHashPattern(literals)
+	case token.INT, token.STRING, token.TRUE, token.FALSE, token.MINUS:
+		if literals {
+			return p.parseLiteralPattern()
+		}
+	}
+	p.errors = append(p.errors, fmt.Sprintf("expected a name or a pattern to bind, got %s", p.currToken.Type))
+	return nil
+}
+
+func (p *Parser) parseLiteralPattern() ast.Pattern {
+	pattern := &ast.LiteralPattern{Token: p.currToken}
+
+	if p.currTokenIs(token.MINUS) {
+		prefix := &ast.PrefixExpression{Token: p.currToken, Operator: "-"}
+		if !p.expectPeek(token.INT) {
+			return nil
+		}
+		prefix.Right = p.parseIntegerLiteral()
+		if prefix.Right == nil {
+			return nil
+		}
+		pattern.Value = prefix
+		return pattern
+	}
+
+	pattern.Value = p.prefixParsefns[p.currToken.Type]()
+	if pattern.Value == nil {
+		return nil
+	}
+	return pattern
+}
+
+func (p *Parser) parseArrayPattern(literals bool) ast.Pattern {
+	pattern := &ast.ArrayPattern{Token: p.currToken}
+
+	if p.peekTokenIs(token.RBRACKET) {
+		p.NextToken()
+		return pattern
+	}
+
+	for {
+		p.NextToken()
+		if p.currTokenIs(token.ELLIPSIS) {
+			if !p.expectPeek(token.IDENTIFIER) {
+				return nil
+			}
+			pattern.Rest = &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
+			//the rest binding has to be the last one
+			break
+		}
+
+		el := p.parsePatternOf(literals)
+		if el == nil {
+			return nil
+		}
+		pattern.Elements = append(pattern.Elements, el)
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.NextToken()
+	}
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+	return pattern
+}
+
+func (p *Parser) parseHashPattern(literals bool) ast.Pattern {
+	pattern := &ast.HashPattern{Token: p.currToken}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		if !p.expectPeek(token.IDENTIFIER) {
+			return nil
+		}
+		key := &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
+
+		var value ast.Pattern = key
+		if p.peekTokenIs(token.COLON) {
+			p.NextToken()
+			p.NextToken()
+			value = p.parsePatternOf(literals)
+			if value == nil {
+				return nil
+			}
+		}
+		pattern.Keys = append(pattern.Keys, key)
+		pattern.Values = append(pattern.Values, value)
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	return pattern
+}
+
 func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	stmt := &ast.ReturnStatement{Token: p.currToken}
 
@@ -174,10 +335,252 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
+func (p *Parser) parseThrowStatement() *ast.ThrowStatement {
+	stmt := &ast.ThrowStatement{Token: p.currToken}
+
+	p.NextToken()
+	stmt.Value = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.NextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseImportStatement() *ast.ImportStatement {
+	stmt := &ast.ImportStatement{Token: p.currToken}
+	if !p.expectPeek(token.STRING) {
+		return nil
+	}
+	stmt.Path = p.currToken.Literal
+
+	if !p.expectPeek(token.AS) {
+		return nil
+	}
+	if !p.expectPeek(token.IDENTIFIER) {
+		return nil
+	}
+	stmt.Name = &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.NextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseExportStatement() *ast.ExportStatement {
+	stmt := &ast.ExportStatement{Token: p.currToken}
+	if p.peekTokenIs(token.STRUCT) {
+		p.NextToken()
+		stmt.Struct = p.parseStructStatement()
+		if stmt.Struct == nil {
+			return nil
+		}
+		return stmt
+	}
+	if p.peekTokenIs(token.ENUM) {
+		p.NextToken()
+		stmt.Enum = p.parseEnumStatement()
+		if stmt.Enum == nil {
+			return nil
+		}
+		return stmt
+	}
+	if !p.expectPeek(token.LET) {
+		return nil
+	}
+	stmt.Let = p.parseLetStatement()
+	if stmt.Let == nil {
+		return nil
+	}
+	return stmt
+}
+
+// parseStructStatement parses a struct declaration: its name, then its
+// fields and methods in braces. Members are separated by commas, which may
+// be left out after a method.
+func (p *Parser) parseStructStatement() *ast.StructStatement {
+	stmt := &ast.StructStatement{Token: p.currToken}
+	if !p.expectPeek(token.IDENTIFIER) {
+		return nil
+	}
+	stmt.Name = &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	declared := map[string]bool{}
+	for !p.peekTokenIs(token.RBRACE) {
+		p.NextToken()
+
+		var name *ast.Identifier
+		switch p.currToken.Type {
+		case token.IDENTIFIER:
+			name = &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
+			stmt.Fields = append(stmt.Fields, name)
+		case token.FUNCTION:
+			method := p.parseStructMethod(stmt.Name.Value)
+			if method == nil {
+				return nil
+			}
+			name = method.Name
+			stmt.Methods = append(stmt.Methods, method)
+		default:
+			p.errors = append(p.errors, fmt.Sprintf("expected a field or a method in struct %s, got %s instead", stmt.Name.Value, p.currToken.Type))
+			return nil
+		}
+
+		if declared[name.Value] {
+			p.errors = append(p.errors, fmt.Sprintf("struct %s declares %s twice", stmt.Name.Value, name.Value))
+			return nil
+		}
+		declared[name.Value] = true
+
+		if p.peekTokenIs(token.COMMA) {
+			p.NextToken()
+		} else if !p.currTokenIs(token.RBRACE) && !p.peekTokenIs(token.RBRACE) {
+			p.peekError(token.COMMA)
+			return nil
+		}
+	}
+	p.NextToken()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.NextToken()
+	}
+	return stmt
+}
+
+// parseEnumStatement parses an enum declaration: its name, then its
+// variants in braces separated by commas, each a name optionally followed
+// by the names of its fields in parentheses.
+func (p *Parser) parseEnumStatement() *ast.EnumStatement {
+	stmt := &ast.EnumStatement{Token: p.currToken}
+	if !p.expectPeek(token.IDENTIFIER) {
+		return nil
+	}
+	stmt.Name = &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	declared := map[string]bool{}
+	for !p.peekTokenIs(token.RBRACE) {
+		if !p.expectPeek(token.IDENTIFIER) {
+			return nil
+		}
+		variant := &ast.EnumVariant{Name: &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}}
+		if declared[variant.Name.Value] {
+			p.errors = append(p.errors, fmt.Sprintf("enum %s declares %s twice", stmt.Name.Value, variant.Name.Value))
+			return nil
+		}
+		declared[variant.Name.Value] = true
+
+		if p.peekTokenIs(token.LPAREN) {
+			p.NextToken()
+			if !p.parseVariantFields(variant, stmt.Name.Value) {
+				return nil
+			}
+		}
+		stmt.Variants = append(stmt.Variants, variant)
+
+		if p.peekTokenIs(token.COMMA) {
+			p.NextToken()
+		} else if !p.peekTokenIs(token.RBRACE) {
+			p.peekError(token.COMMA)
+			return nil
+		}
+	}
+	p.NextToken()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.NextToken()
+	}
+	return stmt
+}
+
+// parseVariantFields parses the parenthesized field names of a variant of
+// the enum named enumName, the current token being the (.
+func (p *Parser) parseVariantFields(variant *ast.EnumVariant, enumName string) bool {
+	if p.peekTokenIs(token.RPAREN) {
+		p.errors = append(p.errors, fmt.Sprintf("variant %s of enum %s has no fields, leave out the parentheses", variant.Name.Value, enumName))
+		return false
+	}
+
+	declared := map[string]bool{}
+	for {
+		if !p.expectPeek(token.IDENTIFIER) {
+			return false
+		}
+		field := &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
+		if declared[field.Value] {
+			p.errors = append(p.errors, fmt.Sprintf("variant %s of enum %s declares %s twice", variant.Name.Value, enumName, field.Value))
+			return false
+		}
+		declared[field.Value] = true
+		variant.Fields = append(variant.Fields, field)
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.NextToken()
+	}
+	return p.expectPeek(token.RPAREN)
+}
+
+// parseStructMethod parses fn name(parameters) { body } in the struct
+// named structName, adding the self parameter in front of the others.
+func (p *Parser) parseStructMethod(structName string) *ast.StructMethod {
+	fnExp := &ast.FunctionLiteral{Token: p.currToken}
+	if !p.expectPeek(token.IDENTIFIER) {
+		return nil
+	}
+	method := &ast.StructMethod{Name: &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}, Function: fnExp}
+	fnExp.Name = structName + "." + method.Name.Value
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	if !p.parseFunctionParameters(fnExp) {
+		return nil
+	}
+	for _, param := range fnExp.Parameters {
+		if param.Value == "self" {
+			p.errors = append(p.errors, fmt.Sprintf("method %s cannot have a parameter named self", fnExp.Name))
+			return nil
+		}
+	}
+
+	self := &ast.Identifier{Token: token.Token{Type: token.IDENTIFIER, Literal: "self", Line: fnExp.Token.Line}, Value: "self"}
+	fnExp.Parameters = append([]*ast.Identifier{self}, fnExp.Parameters...)
+	if fnExp.Defaults != nil {
+		fnExp.Defaults = append([]ast.Expression{nil}, fnExp.Defaults...)
+	}
+	if fnExp.Patterns != nil {
+		fnExp.Patterns = append([]ast.Pattern{nil}, fnExp.Patterns...)
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	fnExp.Block = p.parseBlockStatement()
+	return method
+}
+
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	stmt := &ast.ExpressionStatement{Token: p.currToken}
 
 	stmt.Expression = p.parseExpression(LOWEST)
+
+	//fields are the only thing that can be assigned to
+	if target, ok := stmt.Expression.(*ast.MemberExpression); ok && p.peekTokenIs(token.ASSIGN) {
+		p.NextToken()
+		assign := &ast.AssignExpression{Token: p.currToken, Target: target}
+		p.NextToken()
+		assign.Value = p.parseExpression(LOWEST)
+		stmt.Expression = assign
+	}
+
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.NextToken()
 	}
@@ -206,6 +609,12 @@ func (p *Parser) Errors() []string {
 	return p.errors
 }
 
+// Warnings returns what looks wrong in a program that parsed fine, such as
+// match expressions that may find no arm for their value.
+func (p *Parser) Warnings() []string {
+	return p.warnings
+}
+
 func (p *Parser) peekError(t token.TokenType) {
 	msg := fmt.Sprintf("expected next token to be %s, got %s instead",
 		t, p.peekToken.Type)
@@ -345,7 +754,9 @@ func (p *Parser) parseFunctionExpression() ast.Expression {
 		return nil
 	}
 
-	fnExp.Parameters = p.parseFunctionParameters()
+	if !p.parseFunctionParameters(fnExp) {
+		return nil
+	}
 
 	if !p.expectPeek(token.LBRACE) {
 		return nil
@@ -356,36 +767,116 @@ func (p *Parser) parseFunctionExpression() ast.Expression {
 	return fnExp
 }
 
-func (p *Parser) parseFunctionParameters() []*ast.Identifier {
-	params := []*ast.Identifier{}
+// parseFunctionParameters parses the parameter list of fnExp, up to and
+// including the closing parenthesis: plain parameters, then parameters with
+// a default value (b = 2), then optionally a rest parameter (...rest). Any
+// parameter but the rest one may be a destructuring pattern.
+func (p *Parser) parseFunctionParameters(fnExp *ast.FunctionLiteral) bool {
+	fnExp.Parameters = []*ast.Identifier{}
 
 	if p.peekTokenIs(token.RPAREN) {
 		p.NextToken()
-		return params
+		return true
 	}
 
-	p.NextToken()
-	ident := &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
-	params = append(params, ident)
+	for {
+		if p.peekTokenIs(token.ELLIPSIS) {
+			p.NextToken()
+			if !p.expectPeek(token.IDENTIFIER) {
+				return false
+			}
+			fnExp.Rest = &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
+			//the rest parameter has to be the last one
+			return p.expectPeek(token.RPAREN)
+		}
 
-	for p.peekTokenIs(token.COMMA) {
-		p.NextToken()
+		var ident *ast.Identifier
+		var pattern ast.Pattern
+		if p.peekTokenIs(token.LBRACKET) || p.peekTokenIs(token.LBRACE) {
+			p.NextToken()
+			tok := p.currToken
+			pattern = p.parsePattern()
+			if pattern == nil {
+				return false
+			}
+			ident = &ast.Identifier{Token: tok, Value: pattern.String()}
+		} else {
+			if !p.expectPeek(token.IDENTIFIER) {
+				return false
+			}
+			ident = &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
+		}
+
+		var def ast.Expression
+		if p.peekTokenIs(token.ASSIGN) {
+			p.NextToken()
+			p.NextToken()
+			def = p.parseExpression(LOWEST)
+		}
+		switch {
+		case def != nil && fnExp.Defaults == nil:
+			fnExp.Defaults = make([]ast.Expression, len(fnExp.Parameters))
+		case def == nil && fnExp.Defaults != nil:
+			p.errors = append(p.errors, fmt.Sprintf("parameter %s without a default value follows one with a default value", ident.Value))
+			return false
+		}
+		if pattern != nil && fnExp.Patterns == nil {
+			fnExp.Patterns = make([]ast.Pattern, len(fnExp.Parameters))
+		}
+		fnExp.Parameters = append(fnExp.Parameters, ident)
+		if fnExp.Defaults != nil {
+			fnExp.Defaults = append(fnExp.Defaults, def)
+		}
+		if fnExp.Patterns != nil {
+			fnExp.Patterns = append(fnExp.Patterns, pattern)
+		}
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
 		p.NextToken()
-		ident := &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
-		params = append(params, ident)
 	}
-	if !p.expectPeek(token.RPAREN) {
-		return nil
-	}
-	return params
+	return p.expectPeek(token.RPAREN)
 }
 
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	expression := &ast.CallExpression{Token: p.currToken, Function: function}
-	expression.Arguments = p.parseExpressionList(token.RPAREN)
+	expression.Arguments = p.parseCallArguments()
 	return expression
 }
 
+// parseCallArguments parses the arguments of a call, which unlike the
+// elements of other lists may be spread with ...args.
+func (p *Parser) parseCallArguments() []ast.Expression {
+	args := []ast.Expression{}
+
+	if p.peekTokenIs(token.RPAREN) {
+		p.NextToken()
+		return args
+	}
+
+	for {
+		p.NextToken()
+		if p.currTokenIs(token.ELLIPSIS) {
+			spread := &ast.SpreadExpression{Token: p.currToken}
+			p.NextToken()
+			spread.Value = p.parseExpression(LOWEST)
+			args = append(args, spread)
+		} else {
+			args = append(args, p.parseExpression(LOWEST))
+		}
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.NextToken()
+	}
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	return args
+}
+
 func (p *Parser) parseStringExpression() ast.Expression {
 	return &ast.StringLiteral{Token: p.currToken, Value: p.currToken.Literal}
 }
@@ -420,11 +911,60 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 }
 
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
-	exp := &ast.IndexExpression{Token: p.currToken, Left: left}
+	tok := p.currToken
+
+	var start ast.Expression
+	if !p.peekTokenIs(token.COLON) {
+		p.NextToken()
+		start = p.parseExpression(LOWEST)
+	}
+
+	//a colon after the first operand turns arr[i] into a slice arr[i:j:k]
+	if p.peekTokenIs(token.COLON) {
+		return p.parseSliceExpression(tok, left, start)
+	}
+
+	exp := &ast.IndexExpression{Token: tok, Left: left, Index: start}
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+
+	return exp
+}
+
+// parsePropagateExpression parses the postfix ? operator, which binds as
+// tightly as indexing so that f(x)? and xs[0]? apply it to the whole call
+// or index.
+func (p *Parser) parsePropagateExpression(left ast.Expression) ast.Expression {
+	return &ast.PropagateExpression{Token: p.currToken, Value: left}
+}
+
+func (p *Parser) parseMemberExpression(object ast.Expression) ast.Expression {
+	exp := &ast.MemberExpression{Token: p.currToken, Object: object}
+	if !p.expectPeek(token.IDENTIFIER) {
+		return nil
+	}
+	exp.Member = &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
+	return exp
+}
+
+func (p *Parser) parseSliceExpression(tok token.Token, left ast.Expression, start ast.Expression) ast.Expression {
+	exp := &ast.SliceExpression{Token: tok, Left: left, Start: start}
 
 	p.NextToken()
+	if !p.peekTokenIs(token.COLON) && !p.peekTokenIs(token.RBRACKET) {
+		p.NextToken()
+		exp.End = p.parseExpression(LOWEST)
+	}
+
+	if p.peekTokenIs(token.COLON) {
+		p.NextToken()
+		if !p.peekTokenIs(token.RBRACKET) {
+			p.NextToken()
+			exp.Step = p.parseExpression(LOWEST)
+		}
+	}
 
-	exp.Index = p.parseExpression(LOWEST)
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
 	}
@@ -457,3 +997,130 @@ func (p *Parser) parseHashExpression() ast.Expression {
 	}
 	return hashExp
 }
+
+func (p *Parser) parseTryExpression() ast.Expression {
+	expression := &ast.TryExpression{Token: p.currToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	expression.Block = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.CATCH) {
+		p.NextToken()
+		if !p.expectPeek(token.LPAREN) {
+			return nil
+		}
+		if !p.expectPeek(token.IDENTIFIER) {
+			return nil
+		}
+		expression.CatchParam = &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		expression.Catch = p.parseBlockStatement()
+	}
+
+	if p.peekTokenIs(token.FINALLY) {
+		p.NextToken()
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		expression.Finally = p.parseBlockStatement()
+	}
+
+	if expression.Catch == nil && expression.Finally == nil {
+		p.errors = append(p.errors, "expected catch or finally after try block")
+		return nil
+	}
+	return expression
+}
+
+func (p *Parser) parseMatchExpression() ast.Expression {
+	expression := &ast.MatchExpression{Token: p.currToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.NextToken()
+	expression.Value = p.parseExpression(LOWEST)
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.NextToken()
+		arm := &ast.MatchArm{Pattern: p.parseMatchPattern()}
+		if arm.Pattern == nil {
+			return nil
+		}
+		if p.peekTokenIs(token.IF) {
+			p.NextToken()
+			p.NextToken()
+			arm.Guard = p.parseExpression(LOWEST)
+		}
+		if !p.expectPeek(token.ARROW) {
+			return nil
+		}
+		p.NextToken()
+		arm.Body = p.parseExpression(LOWEST)
+		expression.Arms = append(expression.Arms, arm)
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+
+	if len(expression.Arms) == 0 {
+		p.errors = append(p.errors, "expected at least one arm in match expression")
+		return nil
+	}
+	p.checkMatchArms(expression)
+	return expression
+}
+
+// checkMatchArms warns about the arms of a match expression that can never
+// be reached, and about match expressions that may find no arm for their
+// value. Values only get their types when the program runs, so a match is
+// only taken to cover every value when an arm without a guard matches
+// anything, or when its arms match both true and false, which says the
+// value is a boolean; one that may not fails at runtime for the values it
+// misses.
+func (p *Parser) checkMatchArms(me *ast.MatchExpression) {
+	line := me.Token.Line
+	seen := map[string]bool{}
+
+	for i, arm := range me.Arms {
+		if arm.CatchesAll() {
+			if i < len(me.Arms)-1 {
+				p.warnings = append(p.warnings, fmt.Sprintf("line %d: match arms after %s are unreachable", line, arm.Pattern))
+			}
+			return
+		}
+
+		lit, ok := arm.Pattern.(*ast.LiteralPattern)
+		if !ok || arm.Guard != nil {
+			continue
+		}
+		//1 and "1" print the same
+		key := string(lit.Token.Type) + lit.String()
+		if seen[key] {
+			p.warnings = append(p.warnings, fmt.Sprintf("line %d: match arm %s is unreachable, an earlier arm matches it", line, lit))
+		}
+		seen[key] = true
+	}
+	if seen[string(token.TRUE)+"true"] && seen[string(token.FALSE)+"false"] {
+		return
+	}
+
+	p.warnings = append(p.warnings, fmt.Sprintf("line %d: match is not exhaustive, add a _ arm for the values no arm matches", line))
+}
@@ -4,6 +4,7 @@ import (
 	"Hulk/ast"
 	"Hulk/lexer"
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -308,6 +309,18 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a * b[2], b[1], 2 * [1, 2][1])",
 			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
 		},
+		{
+			"f(x)? + 1",
+			"((f(x)?) + 1)",
+		},
+		{
+			"-a[0]?",
+			"(-((a[0])?))",
+		},
+		{
+			"a?[0]",
+			"((a?)[0])",
+		},
 	}
 	for _, tt := range tests {
 		l := lexer.New(tt.input)
@@ -747,6 +760,484 @@ func TestCallExpressionParsing(t *testing.T) {
 	testInfixExpression(t, exp.Arguments[2], 4, "+", 5)
 }
 
+func TestFunctionParameters(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn() {}", "fn()"},
+		{"fn(x, y) {}", "fn(x, y)"},
+		{"fn(x, y = 2, z = x + 1) {}", "fn(x, y = 2, z = (x + 1))"},
+		{"fn(...rest) {}", "fn(...rest)"},
+		{"fn(x, y = 1, ...rest) {}", "fn(x, y = 1, ...rest)"},
+		{"fn([a, b], {name}) {}", "fn([a, b], {name})"},
+		{"fn(x, [a, ...more] = [1]) {}", "fn(x, [a, ...more] = [1])"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		fnExp := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+		if fnExp.String() != tt.expected {
+			t.Errorf("wrong String(). want=%q, got=%q", tt.expected, fnExp.String())
+		}
+	}
+}
+
+func TestFunctionParameterErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn(x = 1, y) {}", "parameter y without a default value follows one with a default value"},
+		{"fn(...rest, x) {}", "expected next token to be ), got , instead"},
+		{"fn(1) {}", "expected next token to be IDENTIFIER, got INT instead"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0] != tt.expected {
+			t.Errorf("%q: wrong parser errors. want first=%q, got=%q", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestDestructuringLetStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		names    []string
+	}{
+		{"let [a, b] = arr;", "let [a, b] = arr;", []string{"a", "b"}},
+		{"let [] = arr;", "let [] = arr;", []string{}},
+		{"let [first, ...rest] = arr;", "let [first, ...rest] = arr;", []string{"first", "rest"}},
+		{"let {name, age} = person;", "let {name, age} = person;", []string{"name", "age"}},
+		{"let {name: n, tags: [t]} = person;", "let {name: n, tags: [t]} = person;", []string{"n", "t"}},
+		{"let [[a, b], {c}] = xs;", "let [[a, b], {c}] = xs;", []string{"a", "b", "c"}},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ast.LetStatement)
+		if !ok {
+			t.Fatalf("statement is not *ast.LetStatement. got=%T", program.Statements[0])
+		}
+		if stmt.Name != nil {
+			t.Errorf("%q: destructuring let has a Name", tt.input)
+		}
+		if stmt.String() != tt.expected {
+			t.Errorf("wrong String(). want=%q, got=%q", tt.expected, stmt.String())
+		}
+		names := []string{}
+		for _, ident := range ast.PatternNames(stmt.Pattern) {
+			names = append(names, ident.Value)
+		}
+		if strings.Join(names, " ") != strings.Join(tt.names, " ") {
+			t.Errorf("%q: wrong names. want=%v, got=%v", tt.input, tt.names, names)
+		}
+	}
+}
+
+func TestPatternErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let [a, 1] = x;", "expected a name or a pattern to bind, got INT"},
+		{"let [...rest, a] = x;", "expected next token to be ], got , instead"},
+		{"let {a b} = x;", "expected next token to be ,, got IDENTIFIER instead"},
+		{`let {"a"} = x;`, "expected next token to be IDENTIFIER, got STRING instead"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0] != tt.expected {
+			t.Errorf("%q: wrong parser errors. want first=%q, got=%q", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestMatchExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`match (x) { 1 => "one", _ => "other" }`, `match (x) { 1 => one, _ => other }`},
+		{`match (x) { -1 => a, "s" => b, true => c, n => n, }`, `match (x) { (-1) => a, s => b, true => c, n => n }`},
+		{`match (xs) { [] => 0, [x, ..._] => x, [_, ...rest] => rest }`, `match (xs) { [] => 0, [x, ..._] => x, [_, ...rest] => rest }`},
+		{`match (p) { {kind: "circle", r} if r > 0 => r, _ => 0 }`, `match (p) { {kind: circle, r} if (r > 0) => r, _ => 0 }`},
+		{`let y = match (x) { n => n + 1 };`, `let y = match (x) { n => (n + 1) };`},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("wrong String(). want=%q, got=%q", tt.expected, program.String())
+		}
+	}
+
+	p := New(lexer.New(`match (x) { [a, 1] if a => a, _ => 0 }`))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	match, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.MatchExpression)
+	if !ok {
+		t.Fatalf("expression is not *ast.MatchExpression. got=%T", program.Statements[0])
+	}
+	testIdentifier(t, match.Value, "x")
+	if len(match.Arms) != 2 {
+		t.Fatalf("wrong number of arms. want=2, got=%d", len(match.Arms))
+	}
+	pattern, ok := match.Arms[0].Pattern.(*ast.ArrayPattern)
+	if !ok {
+		t.Fatalf("arm 0 pattern is not *ast.ArrayPattern. got=%T", match.Arms[0].Pattern)
+	}
+	if _, ok := pattern.Elements[1].(*ast.LiteralPattern); !ok {
+		t.Errorf("element 1 is not *ast.LiteralPattern. got=%T", pattern.Elements[1])
+	}
+	if match.Arms[0].Guard == nil || match.Arms[0].CatchesAll() {
+		t.Errorf("arm 0 should have a guard")
+	}
+	if _, ok := match.Arms[1].Pattern.(*ast.WildcardPattern); !ok || !match.Arms[1].CatchesAll() {
+		t.Errorf("arm 1 should be a catch-all wildcard. got=%T", match.Arms[1].Pattern)
+	}
+}
+
+func TestMatchErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`match (x) { }`, "expected at least one arm in match expression"},
+		{`match (x) { 1 "one" }`, "expected next token to be =>, got STRING instead"},
+		{`match x { _ => 1 }`, "expected next token to be (, got IDENTIFIER instead"},
+		{`match (x) { f(y) => 1 }`, "expected next token to be =>, got ( instead"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0] != tt.expected {
+			t.Errorf("%q: wrong parser errors. want first=%q, got=%q", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestMatchWarnings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{`match (x) { 1 => 1, _ => 0 }`, nil},
+		{`match (x) { 1 => 1, n if n > 1 => n }`, []string{
+			"line 1: match is not exhaustive, add a _ arm for the values no arm matches",
+		}},
+		{`match (x) { n => n, 1 => 1 }`, []string{
+			"line 1: match arms after n are unreachable",
+		}},
+		{`match (x) { 1 => 1, 1 => 2, "1" => 3, _ => 0 }`, []string{
+			"line 1: match arm 1 is unreachable, an earlier arm matches it",
+		}},
+		{`match (x) { 1 if y => 1, 1 => 2, _ => 0 }`, nil},
+		{`match (true) { true => 1, false => 0 }`, nil},
+		{`match (x) { false => 0, 1 => 2, true => 1 }`, nil},
+		{`match (x) { true => 1, false if y => 0 }`, []string{
+			"line 1: match is not exhaustive, add a _ arm for the values no arm matches",
+		}},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if strings.Join(p.Warnings(), "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("%q: wrong warnings. want=%q, got=%q", tt.input, tt.expected, p.Warnings())
+		}
+	}
+}
+
+func TestImportExportStatements(t *testing.T) {
+	p := New(lexer.New(`import "lib/math.hk" as math; export let pi = 3; export let [a, b] = xs;`))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 3 {
+		t.Fatalf("wrong number of statements. want=3, got=%d", len(program.Statements))
+	}
+	imp, ok := program.Statements[0].(*ast.ImportStatement)
+	if !ok {
+		t.Fatalf("statement 0 is not *ast.ImportStatement. got=%T", program.Statements[0])
+	}
+	if imp.Path != "lib/math.hk" || imp.Name.Value != "math" {
+		t.Errorf("wrong import. got Path=%q, Name=%q", imp.Path, imp.Name.Value)
+	}
+
+	tests := []struct {
+		expected string
+		names    []string
+	}{
+		{"export let pi = 3;", []string{"pi"}},
+		{"export let [a, b] = xs;", []string{"a", "b"}},
+	}
+	for i, tt := range tests {
+		export, ok := program.Statements[i+1].(*ast.ExportStatement)
+		if !ok {
+			t.Fatalf("statement %d is not *ast.ExportStatement. got=%T", i+1, program.Statements[i+1])
+		}
+		if export.String() != tt.expected {
+			t.Errorf("wrong String(). want=%q, got=%q", tt.expected, export.String())
+		}
+		names := []string{}
+		for _, ident := range export.Names() {
+			names = append(names, ident.Value)
+		}
+		if strings.Join(names, " ") != strings.Join(tt.names, " ") {
+			t.Errorf("wrong names. want=%v, got=%v", tt.names, names)
+		}
+	}
+
+	if imp.String() != `import "lib/math.hk" as math;` {
+		t.Errorf("wrong String(). got=%q", imp.String())
+	}
+}
+
+func TestImportExportErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`import lib as math;`, "expected next token to be STRING, got IDENTIFIER instead"},
+		{`import "lib.hk" math;`, "expected next token to be AS, got IDENTIFIER instead"},
+		{`import "lib.hk" as "m";`, "expected next token to be IDENTIFIER, got STRING instead"},
+		{`export fn(x) { x };`, "expected next token to be LET, got FUNCTION instead"},
+		{`let f = fn() { export let x = 1; };`, "export is only allowed at the top level of a module"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0] != tt.expected {
+			t.Errorf("%q: wrong parser errors. want first=%q, got=%q", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestStructStatement(t *testing.T) {
+	input := `struct Point {
+	x, y,
+	fn norm() { self.x * self.x + self.y * self.y }
+	fn scale(k, by = 1) { Point(self.x * k, self.y * by) }
+}`
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("wrong number of statements. want=1, got=%d", len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.StructStatement)
+	if !ok {
+		t.Fatalf("statement is not *ast.StructStatement. got=%T", program.Statements[0])
+	}
+	if stmt.Name.Value != "Point" || len(stmt.Fields) != 2 || stmt.Fields[0].Value != "x" || stmt.Fields[1].Value != "y" {
+		t.Fatalf("wrong struct. got=%s", stmt)
+	}
+	if len(stmt.Methods) != 2 {
+		t.Fatalf("wrong number of methods. want=2, got=%d", len(stmt.Methods))
+	}
+
+	scale := stmt.Methods[1]
+	if scale.Name.Value != "scale" || scale.Function.Name != "Point.scale" {
+		t.Errorf("wrong method name. got=%q, function name=%q", scale.Name.Value, scale.Function.Name)
+	}
+	params := []string{}
+	for _, param := range scale.Function.Parameters {
+		params = append(params, param.Value)
+	}
+	if strings.Join(params, " ") != "self k by" {
+		t.Errorf("wrong parameters. want=self k by, got=%v", params)
+	}
+	if scale.Function.Default(0) != nil || scale.Function.Default(2) == nil {
+		t.Errorf("defaults not shifted past self. got=%v", scale.Function.Defaults)
+	}
+
+	expected := "struct Point { x, y, fn norm()(((self.x) * (self.x)) + ((self.y) * (self.y))), " +
+		"fn scale(k, by = 1)Point(((self.x) * k), ((self.y) * by)) }"
+	if stmt.String() != expected {
+		t.Errorf("wrong String(). want=%q, got=%q", expected, stmt.String())
+	}
+}
+
+func TestMemberExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"p.x", "(p.x)"},
+		{"p.x.y", "((p.x).y)"},
+		{"-p.x * 2", "((-(p.x)) * 2)"},
+		{"ps[0].x", "((ps[0]).x)"},
+		{"p.norm()", "(p.norm)()"},
+		{"p.move(1).x", "((p.move)(1).x)"},
+		{"p.x = 1 + 2", "(p.x) = (1 + 2)"},
+		{"self.next.x = f(y)", "((self.next).x) = f(y)"},
+		{"export struct Empty {}", "export struct Empty {}"},
+		{`"abc".upper()`, "(abc.upper)()"},
+		{"[1, 2].map(f).len()", "(([1, 2].map)(f).len)()"},
+		{"5.to_string()", "(5.to_string)()"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("%q: want=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+func TestStructErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`struct { x }`, "expected next token to be IDENTIFIER, got { instead"},
+		{`struct Point { x y }`, "expected next token to be ,, got IDENTIFIER instead"},
+		{`struct Point { x, 1 }`, "expected a field or a method in struct Point, got INT instead"},
+		{`struct Point { x, x }`, "struct Point declares x twice"},
+		{`struct Point { x, fn x() { 1 } }`, "struct Point declares x twice"},
+		{`struct Point { fn (a) { a } }`, "expected next token to be IDENTIFIER, got ( instead"},
+		{`struct Point { fn f(self) { self } }`, "method Point.f cannot have a parameter named self"},
+		{`p.1`, "expected next token to be IDENTIFIER, got INT instead"},
+		//only . reads a member, other stray characters are rejected
+		{`puts(h @ b)`, "expected next token to be ), got ILLEGAL instead"},
+		{`"abc" $ upper()`, "no prefix function found for ILLEGAL"},
+		{`s#len()`, "no prefix function found for ILLEGAL"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0] != tt.expected {
+			t.Errorf("%q: wrong parser errors. want first=%q, got=%q", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestEnumStatement(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		variants []string
+	}{
+		{"enum Shape { Circle(r), Rect(w, h), Empty }", "enum Shape { Circle(r), Rect(w, h), Empty }", []string{"Circle r", "Rect w h", "Empty"}},
+		{"enum Dir {\n\tUp,\n\tDown,\n};", "enum Dir { Up, Down }", []string{"Up", "Down"}},
+		{"enum Never {}", "enum Never {}", []string{}},
+		{"export enum Opt { Some(value), None }", "export enum Opt { Some(value), None }", []string{"Some value", "None"}},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("%q: wrong number of statements. want=1, got=%d", tt.input, len(program.Statements))
+		}
+		if program.String() != tt.expected {
+			t.Errorf("%q: wrong String(). want=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+
+		stmt, ok := program.Statements[0].(*ast.EnumStatement)
+		if export, isExport := program.Statements[0].(*ast.ExportStatement); isExport {
+			stmt, ok = export.Enum, export.Enum != nil
+		}
+		if !ok {
+			t.Fatalf("%q: statement is not *ast.EnumStatement. got=%T", tt.input, program.Statements[0])
+		}
+		variants := []string{}
+		for _, v := range stmt.Variants {
+			names := []string{v.Name.Value}
+			for _, field := range v.Fields {
+				names = append(names, field.Value)
+			}
+			variants = append(variants, strings.Join(names, " "))
+		}
+		if strings.Join(variants, ", ") != strings.Join(tt.variants, ", ") {
+			t.Errorf("%q: wrong variants. want=%v, got=%v", tt.input, tt.variants, variants)
+		}
+	}
+}
+
+func TestEnumErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`enum { A }`, "expected next token to be IDENTIFIER, got { instead"},
+		{`enum E { A B }`, "expected next token to be ,, got IDENTIFIER instead"},
+		{`enum E { A, 1 }`, "expected next token to be IDENTIFIER, got INT instead"},
+		{`enum E { A, A(x) }`, "enum E declares A twice"},
+		{`enum E { A() }`, "variant A of enum E has no fields, leave out the parentheses"},
+		{`enum E { A(x, x) }`, "variant A of enum E declares x twice"},
+		{`enum E { A(x y) }`, "expected next token to be ), got IDENTIFIER instead"},
+		{`enum E { A(1) }`, "expected next token to be IDENTIFIER, got INT instead"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0] != tt.expected {
+			t.Errorf("%q: wrong parser errors. want first=%q, got=%q", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestSpreadArguments(t *testing.T) {
+	p := New(lexer.New("f(1, ...xs, ...g(y))"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	call := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.CallExpression)
+	if len(call.Arguments) != 3 {
+		t.Fatalf("wrong number of arguments. got=%d", len(call.Arguments))
+	}
+	testLiteralExpression(t, call.Arguments[0], 1)
+	spread, ok := call.Arguments[1].(*ast.SpreadExpression)
+	if !ok {
+		t.Fatalf("argument 1 is not ast.SpreadExpression. got=%T", call.Arguments[1])
+	}
+	testIdentifier(t, spread.Value, "xs")
+	if call.String() != "f(1, ...xs, ...g(y))" {
+		t.Errorf("wrong String(). got=%q", call.String())
+	}
+}
+
 func TestStringLiteralExpression(t *testing.T) {
 	input := `"string expression"`
 	l := lexer.New(input)
@@ -810,6 +1301,61 @@ func TestIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestSliceExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"myArray[1:3]", "(myArray[1:3])"},
+		{"myArray[:n]", "(myArray[:n])"},
+		{"myArray[n:]", "(myArray[n:])"},
+		{"myArray[:]", "(myArray[:])"},
+		{"myArray[-2:]", "(myArray[(-2):])"},
+		{"myArray[1:5:2]", "(myArray[1:5:2])"},
+		{"myArray[::-1]", "(myArray[::(-1)])"},
+		{"myArray[1+1:len(myArray)-1]", "(myArray[(1 + 1):(len(myArray) - 1)])"},
+		{`"hello"[1:3]`, "(hello[1:3])"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("stmt is not ast.ExpressionStatement, got=%T", program.Statements[0])
+		}
+		_, ok = stmt.Expression.(*ast.SliceExpression)
+		if !ok {
+			t.Fatalf("got wrong slice expression, got=%T", stmt.Expression)
+		}
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestSliceExpressionParts(t *testing.T) {
+	input := "myArray[1:3:2]"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	sliceExp, ok := stmt.Expression.(*ast.SliceExpression)
+	if !ok {
+		t.Fatalf("got wrong slice expression, got=%T", stmt.Expression)
+	}
+	testIdentifier(t, sliceExp.Left, "myArray")
+	testIntegerLiteral(t, sliceExp.Start, 1)
+	testIntegerLiteral(t, sliceExp.End, 3)
+	testIntegerLiteral(t, sliceExp.Step, 2)
+}
+
 func TestHashLiteralStringKeys(t *testing.T) {
 	input := `{"one":1, "two":2, "three":3}`
 
@@ -907,3 +1453,67 @@ func TestHashLiteralWithExpressions(t *testing.T) {
 		testFunc(value)
 	}
 }
+
+func TestThrowStatement(t *testing.T) {
+	l := lexer.New(`throw x + 1;`)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.ThrowStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ThrowStatement. got=%T", program.Statements[0])
+	}
+	testInfixExpression(t, stmt.Value, "x", "+", 1)
+}
+
+func TestTryExpression(t *testing.T) {
+	tests := []struct {
+		input      string
+		catchParam string //empty when there is no catch clause
+		finally    bool
+		expected   string
+	}{
+		{`try { x } catch (e) { y }`, "e", false, "try x catch (e) y"},
+		{`try { x } finally { z }`, "", true, "try x finally z"},
+		{`try { x } catch (err) { y } finally { z }`, "err", true, "try x catch (err) y finally z"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		tryExp, ok := stmt.Expression.(*ast.TryExpression)
+		if !ok {
+			t.Fatalf("expression is not ast.TryExpression. got=%T", stmt.Expression)
+		}
+		if tt.catchParam == "" && tryExp.Catch != nil {
+			t.Errorf("%q: unexpected catch clause", tt.input)
+		}
+		if tt.catchParam != "" && (tryExp.Catch == nil || tryExp.CatchParam.Value != tt.catchParam) {
+			t.Errorf("%q: expected catch clause binding %s", tt.input, tt.catchParam)
+		}
+		if (tryExp.Finally != nil) != tt.finally {
+			t.Errorf("%q: finally clause present=%t, want=%t", tt.input, tryExp.Finally != nil, tt.finally)
+		}
+		if tryExp.String() != tt.expected {
+			t.Errorf("%q: wrong String(). want=%q, got=%q", tt.input, tt.expected, tryExp.String())
+		}
+	}
+}
+
+func TestTryWithoutHandler(t *testing.T) {
+	p := New(lexer.New(`try { x }`))
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) != 1 || errors[0] != "expected catch or finally after try block" {
+		t.Errorf("wrong parser errors. got=%q", errors)
+	}
+}
@@ -0,0 +1,129 @@
+package regvm
+
+import (
+	"Hulk/compiler"
+	"Hulk/evaluator"
+	"Hulk/object"
+	"Hulk/vm"
+	"testing"
+)
+
+// The benchmarks run the same programs on the tree-walking evaluator, the
+// stack VM and the register VM. Compare them with
+//
+//	go test ./regvm -bench . -benchmem
+var benchmarks = []struct {
+	name  string
+	input string
+}{
+	{"Fib", `
+		let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+		fib(20)`},
+	{"Loop", `
+		let count = fn(i, acc) { if (i == 0) { acc } else { count(i - 1, acc + i * 2) } };
+		reduce(range(200), fn(acc, x) { acc + count(200, x) }, 0)`},
+	{"Strings", `
+		let build = fn(s, i) { if (i == 0) { s } else { build(s + "ab", i - 1) } };
+		len(reduce(range(50), fn(acc, x) { build(acc, 20) }, ""))`},
+}
+
+func BenchmarkEvaluator(b *testing.B) {
+	for _, bm := range benchmarks {
+		program := parse(bm.input)
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				result := evaluator.Eval(program, object.NewEnvironment())
+				if errObj, ok := result.(*object.Error); ok {
+					b.Fatalf("evaluator error: %s", errObj.Message)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkStackVM(b *testing.B) {
+	for _, bm := range benchmarks {
+		comp := compiler.New()
+		err := comp.Compile(parse(bm.input))
+		if err != nil {
+			b.Fatalf("compiler error: %s", err)
+		}
+		bytecode := comp.Bytecode()
+
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				err := vm.New(bytecode).Run()
+				if err != nil {
+					b.Fatalf("vm error: %s", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkOptimizedStackVM(b *testing.B) {
+	for _, bm := range benchmarks {
+		comp := compiler.New()
+		comp.EnableOptimizations()
+		err := comp.Compile(parse(bm.input))
+		if err != nil {
+			b.Fatalf("compiler error: %s", err)
+		}
+		bytecode := comp.Bytecode()
+
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				err := vm.New(bytecode).Run()
+				if err != nil {
+					b.Fatalf("vm error: %s", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkRegisterVM(b *testing.B) {
+	for _, bm := range benchmarks {
+		comp := NewCompiler()
+		err := comp.Compile(parse(bm.input))
+		if err != nil {
+			b.Fatalf("compiler error: %s", err)
+		}
+		bytecode := comp.Bytecode()
+
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				err := New(bytecode).Run()
+				if err != nil {
+					b.Fatalf("vm error: %s", err)
+				}
+			}
+		})
+	}
+}
+
+// TestBenchmarksAgree makes sure the benchmarked programs compute the same
+// value on every engine, so the numbers compare like with like.
+func TestBenchmarksAgree(t *testing.T) {
+	for _, bm := range benchmarks {
+		evaluated := evaluator.Eval(parse(bm.input), object.NewEnvironment())
+
+		stack, err := runStackVM(bm.input)
+		if err != nil {
+			t.Fatalf("%s: stack vm error: %s", bm.name, err)
+		}
+		register, err := run(bm.input)
+		if err != nil {
+			t.Fatalf("%s: register vm error: %s", bm.name, err)
+		}
+
+		if stack.Inspect() != evaluated.Inspect() || register.Inspect() != evaluated.Inspect() {
+			t.Errorf("%s: results differ. evaluator=%s, stack=%s, register=%s",
+				bm.name, evaluated.Inspect(), stack.Inspect(), register.Inspect())
+		}
+	}
+}
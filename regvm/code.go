@@ -0,0 +1,173 @@
+package regvm
+
+import (
+	"Hulk/object"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Instruction packs an opcode and up to three operands into one word:
+//
+//	bits  0-7   opcode
+//	bits  8-23  A, usually the register receiving the result
+//	bits 24-39  B
+//	bits 40-55  C
+//
+// R[x] is register x of the running function, K[x] constant x and G[x]
+// global x. Locals live in the lowest registers, parameters first, and
+// temporaries are stacked above them.
+type Instruction uint64
+
+type Opcode byte
+
+const (
+	OpLoadConst      Opcode = iota // R[A] = K[B]
+	OpLoadTrue                     // R[A] = true
+	OpLoadFalse                    // R[A] = false
+	OpLoadNull                     // R[A] = null
+	OpMove                         // R[A] = R[B]
+	OpGetGlobal                    // R[A] = G[B]
+	OpSetGlobal                    // G[B] = R[A]
+	OpGetBuiltin                   // R[A] = builtin B
+	OpGetFree                      // R[A] = free variable B of the running closure
+	OpCurrentClosure               // R[A] = the running closure
+	OpAdd                          // R[A] = R[B] + R[C]
+	OpSub                          // R[A] = R[B] - R[C]
+	OpMul                          // R[A] = R[B] * R[C]
+	OpDiv                          // R[A] = R[B] / R[C]
+	OpEqual                        // R[A] = R[B] == R[C]
+	OpNotEqual                     // R[A] = R[B] != R[C]
+	OpGreaterThan                  // R[A] = R[B] > R[C]
+	OpNot                          // R[A] = !R[B]
+	OpNeg                          // R[A] = -R[B]
+	OpJump                         // jump to instruction A
+	OpJumpIfFalse                  // jump to instruction B unless R[A] is truthy
+	OpArray                        // R[A] = [R[B], ..., R[B+C-1]]
+	OpHash                         // R[A] = {R[B]: R[B+1], ...} from C registers
+	OpIndex                        // R[A] = R[B][R[C]]
+	OpSlice                        // R[A] = R[B][R[B+1]:R[B+2]:R[B+3]]
+	OpClosure                      // R[A] = closure of K[B] capturing R[A], ..., R[A+C-1]
+	OpCall                         // R[A] = R[A](R[A+1], ..., R[A+B])
+	OpReturn                       // return R[A]
+	OpReturnNull                   // return null
+	OpResult                       // the value of a top level expression statement is R[A]
+	OpTailCall                     // return R[A](R[A+1], ..., R[A+B])
+)
+
+type definition struct {
+	name     string
+	operands int
+}
+
+var definitions = map[Opcode]definition{
+	OpLoadConst:      {"LOADCONST", 2},
+	OpLoadTrue:       {"LOADTRUE", 1},
+	OpLoadFalse:      {"LOADFALSE", 1},
+	OpLoadNull:       {"LOADNULL", 1},
+	OpMove:           {"MOVE", 2},
+	OpGetGlobal:      {"GETGLOBAL", 2},
+	OpSetGlobal:      {"SETGLOBAL", 2},
+	OpGetBuiltin:     {"GETBUILTIN", 2},
+	OpGetFree:        {"GETFREE", 2},
+	OpCurrentClosure: {"CURRENTCLOSURE", 1},
+	OpAdd:            {"ADD", 3},
+	OpSub:            {"SUB", 3},
+	OpMul:            {"MUL", 3},
+	OpDiv:            {"DIV", 3},
+	OpEqual:          {"EQUAL", 3},
+	OpNotEqual:       {"NOTEQUAL", 3},
+	OpGreaterThan:    {"GREATERTHAN", 3},
+	OpNot:            {"NOT", 2},
+	OpNeg:            {"NEG", 2},
+	OpJump:           {"JUMP", 1},
+	OpJumpIfFalse:    {"JUMPIFFALSE", 2},
+	OpArray:          {"ARRAY", 3},
+	OpHash:           {"HASH", 3},
+	OpIndex:          {"INDEX", 3},
+	OpSlice:          {"SLICE", 2},
+	OpClosure:        {"CLOSURE", 3},
+	OpCall:           {"CALL", 2},
+	OpReturn:         {"RETURN", 1},
+	OpReturnNull:     {"RETURNNULL", 0},
+	OpResult:         {"RESULT", 1},
+	OpTailCall:       {"TAILCALL", 2},
+}
+
+// MaxOperand is the largest register, constant, global or jump target an
+// instruction can refer to.
+const MaxOperand = 1<<16 - 1
+
+func Make(op Opcode, operands ...int) Instruction {
+	ins := Instruction(op)
+	for i, operand := range operands {
+		ins |= Instruction(uint16(operand)) << (8 + 16*uint(i))
+	}
+	return ins
+}
+
+func (ins Instruction) Op() Opcode { return Opcode(ins) }
+func (ins Instruction) A() int     { return int(uint16(ins >> 8)) }
+func (ins Instruction) B() int     { return int(uint16(ins >> 24)) }
+func (ins Instruction) C() int     { return int(uint16(ins >> 40)) }
+
+func (ins Instruction) String() string {
+	def, ok := definitions[ins.Op()]
+	if !ok {
+		return fmt.Sprintf("ERROR: opcode %d undefined", ins.Op())
+	}
+
+	parts := []string{def.name}
+	for _, operand := range []int{ins.A(), ins.B(), ins.C()}[:def.operands] {
+		parts = append(parts, fmt.Sprint(operand))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Function is a compiled function in register form.
+type Function struct {
+	Instructions  []Instruction
+	NumRegisters  int
+	NumParameters int
+}
+
+func (f *Function) Type() object.ObjectType {
+	return object.COMPILED_FUNCTION_OBJ
+}
+
+func (f *Function) Inspect() string {
+	return fmt.Sprintf("Function[%p]", f)
+}
+
+func (f *Function) String() string {
+	var out bytes.Buffer
+	for i, ins := range f.Instructions {
+		fmt.Fprintf(&out, "%04d %s\n", i, ins)
+	}
+	return out.String()
+}
+
+type Closure struct {
+	Fn   *Function
+	Free []object.Object
+}
+
+func (c *Closure) Type() object.ObjectType {
+	return object.CLOSURE_OBJ
+}
+
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
+
+// Disassemble writes the instructions of the main program and of every
+// function in the constant pool to w.
+func (b *Bytecode) Disassemble(w io.Writer) {
+	fmt.Fprintf(w, "main (registers=%d):\n%s", b.Main.NumRegisters, b.Main)
+	for i, c := range b.Constants {
+		if fn, ok := c.(*Function); ok {
+			fmt.Fprintf(w, "\nfn#%d (parameters=%d, registers=%d):\n%s", i, fn.NumParameters, fn.NumRegisters, fn)
+		}
+	}
+}
@@ -0,0 +1,530 @@
+package regvm
+
+import (
+	"Hulk/ast"
+	"Hulk/builtins"
+	"Hulk/compiler"
+	"Hulk/object"
+	"fmt"
+	"sort"
+)
+
+type Bytecode struct {
+	Main      *Function
+	Constants []object.Object
+}
+
+// scope is the function being compiled. Registers below numLocals belong to
+// its parameters and let bindings; temporaries are handed out above them,
+// last in first out, and maxRegisters remembers how high they went.
+type scope struct {
+	instructions []Instruction
+	numLocals    int
+	nextTemp     int
+	maxRegisters int
+
+	//the main program reports the value of its expression statements
+	main bool
+}
+
+type Compiler struct {
+	constants   []object.Object
+	strings     map[string]int
+	symbolTable *compiler.SymbolTable
+	scope       *scope
+}
+
+// NewCompiler returns a compiler producing register bytecode. It shares the
+// symbol table of the stack compiler, so names resolve the same way in both.
+func NewCompiler() *Compiler {
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range builtins.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+
+	return &Compiler{
+		constants:   []object.Object{},
+		strings:     map[string]int{},
+		symbolTable: symbolTable,
+		scope:       &scope{main: true},
+	}
+}
+
+func (c *Compiler) Compile(program *ast.Program) error {
+	for _, stmt := range program.Statements {
+		err := c.statement(stmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	return &Bytecode{
+		Main: &Function{
+			Instructions: c.scope.instructions,
+			NumRegisters: c.scope.maxRegisters,
+		},
+		Constants: c.constants,
+	}
+}
+
+func (c *Compiler) statement(stmt ast.Statement) error {
+	switch stmt := stmt.(type) {
+	case *ast.ExpressionStatement:
+		mark := c.scope.nextTemp
+		defer c.freeTemps(mark)
+
+		reg, err := c.operand(stmt.Expression)
+		if err != nil {
+			return err
+		}
+		if c.scope.main {
+			c.emit(OpResult, reg)
+		}
+
+	case *ast.LetStatement:
+		if stmt.Pattern != nil {
+			return fmt.Errorf("unsupported destructuring in %s", stmt)
+		}
+		mark := c.scope.nextTemp
+		defer c.freeTemps(mark)
+
+		//the value is compiled before the name is defined, so that it reads
+		//the binding the let shadows and not the register of the new one
+		reg, err := c.operand(stmt.Value)
+		if err != nil {
+			return err
+		}
+		symbol := c.symbolTable.Define(stmt.Name.Value)
+		if symbol.Scope == compiler.LocalScope {
+			c.move(symbol.Index, reg)
+			return nil
+		}
+		c.emit(OpSetGlobal, reg, symbol.Index)
+
+	case *ast.ReturnStatement:
+		mark := c.scope.nextTemp
+		defer c.freeTemps(mark)
+
+		reg, err := c.operand(stmt.ReturnValue)
+		if err != nil {
+			return err
+		}
+		c.emit(OpReturn, reg)
+
+	default:
+		return fmt.Errorf("unsupported statement %T", stmt)
+	}
+
+	return nil
+}
+
+// block compiles the statements of an if branch, leaving the value of the
+// branch in dst.
+func (c *Compiler) block(block *ast.BlockStatement, dst int) error {
+	stmts := block.Statements
+	for i, stmt := range stmts {
+		if exp, ok := stmt.(*ast.ExpressionStatement); ok && i == len(stmts)-1 {
+			return c.expression(exp.Expression, dst)
+		}
+		err := c.statement(stmt)
+		if err != nil {
+			return err
+		}
+	}
+	c.emit(OpLoadNull, dst)
+	return nil
+}
+
+// operand returns a register holding the value of node. Locals are used
+// where they live, anything else is computed into a new temporary, which
+// the caller frees once the value has been used.
+func (c *Compiler) operand(node ast.Expression) (int, error) {
+	if ident, ok := node.(*ast.Identifier); ok {
+		symbol, ok := c.symbolTable.Resolve(ident.Value)
+		if ok && symbol.Scope == compiler.LocalScope {
+			return symbol.Index, nil
+		}
+	}
+
+	reg, err := c.allocTemps(1)
+	if err != nil {
+		return 0, err
+	}
+	return reg, c.expression(node, reg)
+}
+
+// expression compiles node so that its value ends up in register dst.
+func (c *Compiler) expression(node ast.Expression, dst int) error {
+	mark := c.scope.nextTemp
+	defer c.freeTemps(mark)
+
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		c.emit(OpLoadConst, dst, c.addConstant(object.NewInteger(node.Value)))
+
+	case *ast.StringLiteral:
+		//equal literals share one constant, like in the stack compiler
+		index, ok := c.strings[node.Value]
+		if !ok {
+			index = c.addConstant(&object.String{Value: node.Value})
+			c.strings[node.Value] = index
+		}
+		c.emit(OpLoadConst, dst, index)
+
+	case *ast.BooleanExpression:
+		if node.Value {
+			c.emit(OpLoadTrue, dst)
+		} else {
+			c.emit(OpLoadFalse, dst)
+		}
+
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(node.Value)
+		if !ok {
+			return fmt.Errorf("undefined variable %s", node.Value)
+		}
+		c.loadSymbol(symbol, dst)
+
+	case *ast.PrefixExpression:
+		right, err := c.operand(node.Right)
+		if err != nil {
+			return err
+		}
+		switch node.Operator {
+		case "-":
+			c.emit(OpNeg, dst, right)
+		case "!":
+			c.emit(OpNot, dst, right)
+		default:
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+
+	case *ast.InfixExpression:
+		left, err := c.operand(node.LeftExpr)
+		if err != nil {
+			return err
+		}
+		right, err := c.operand(node.RightExpr)
+		if err != nil {
+			return err
+		}
+		switch node.Operator {
+		case "+":
+			c.emit(OpAdd, dst, left, right)
+		case "-":
+			c.emit(OpSub, dst, left, right)
+		case "*":
+			c.emit(OpMul, dst, left, right)
+		case "/":
+			c.emit(OpDiv, dst, left, right)
+		case ">":
+			c.emit(OpGreaterThan, dst, left, right)
+		case "<":
+			c.emit(OpGreaterThan, dst, right, left)
+		case "==":
+			c.emit(OpEqual, dst, left, right)
+		case "!=":
+			c.emit(OpNotEqual, dst, left, right)
+		default:
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+
+	case *ast.IfExpression:
+		condition, err := c.operand(node.Condition)
+		if err != nil {
+			return err
+		}
+		c.freeTemps(mark)
+		jumpIfFalse := c.emit(OpJumpIfFalse, condition, 0)
+
+		err = c.block(node.Consequence, dst)
+		if err != nil {
+			return err
+		}
+		jump := c.emit(OpJump, 0)
+		c.patchJump(jumpIfFalse, condition)
+
+		if node.Alternative == nil {
+			c.emit(OpLoadNull, dst)
+		} else {
+			err := c.block(node.Alternative, dst)
+			if err != nil {
+				return err
+			}
+		}
+		c.patchJump(jump, -1)
+
+	case *ast.ArrayLiteral:
+		base, err := c.allocTemps(len(node.Elements))
+		if err != nil {
+			return err
+		}
+		for i, el := range node.Elements {
+			err := c.expression(el, base+i)
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(OpArray, dst, base, len(node.Elements))
+
+	case *ast.HashLiteral:
+		keys := []ast.Expression{}
+		for k := range node.Pairs {
+			keys = append(keys, k)
+		}
+		//same order as the stack compiler, so duplicate keys resolve alike
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+
+		base, err := c.allocTemps(len(keys) * 2)
+		if err != nil {
+			return err
+		}
+		for i, k := range keys {
+			err := c.expression(k, base+2*i)
+			if err != nil {
+				return err
+			}
+			err = c.expression(node.Pairs[k], base+2*i+1)
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(OpHash, dst, base, len(keys)*2)
+
+	case *ast.IndexExpression:
+		left, err := c.operand(node.Left)
+		if err != nil {
+			return err
+		}
+		index, err := c.operand(node.Index)
+		if err != nil {
+			return err
+		}
+		c.emit(OpIndex, dst, left, index)
+
+	case *ast.SliceExpression:
+		base, err := c.allocTemps(4)
+		if err != nil {
+			return err
+		}
+		for i, part := range []ast.Expression{node.Left, node.Start, node.End, node.Step} {
+			if part == nil {
+				c.emit(OpLoadNull, base+i)
+				continue
+			}
+			err := c.expression(part, base+i)
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(OpSlice, dst, base)
+
+	case *ast.FunctionLiteral:
+		return c.function(node, dst)
+
+	case *ast.CallExpression:
+		//the callee and its arguments go into consecutive registers, which
+		//become the first registers of the called function
+		base, err := c.allocTemps(1 + len(node.Arguments))
+		if err != nil {
+			return err
+		}
+		err = c.expression(node.Function, base)
+		if err != nil {
+			return err
+		}
+		for i, arg := range node.Arguments {
+			err := c.expression(arg, base+1+i)
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(OpCall, base, len(node.Arguments))
+		c.move(dst, base)
+
+	default:
+		return fmt.Errorf("unsupported expression %T", node)
+	}
+
+	return nil
+}
+
+func (c *Compiler) function(node *ast.FunctionLiteral, dst int) error {
+	if node.Defaults != nil || node.Rest != nil || node.Patterns != nil {
+		return fmt.Errorf("unsupported parameters in %s", node)
+	}
+	outer := c.scope
+	c.symbolTable = compiler.NewEnclosedSymbolTable(c.symbolTable)
+	if node.Name != "" {
+		c.symbolTable.DefineFunctionName(node.Name)
+	}
+	for _, p := range node.Parameters {
+		c.symbolTable.Define(p.Value)
+	}
+
+	//every let in the body gets a register of its own, so temporaries
+	//start above all of them
+	numLocals := len(node.Parameters) + countLets(node.Block)
+	c.scope = &scope{numLocals: numLocals, nextTemp: numLocals, maxRegisters: numLocals}
+
+	err := c.functionBody(node.Block.Statements)
+	if err != nil {
+		return err
+	}
+
+	markTailCalls(c.scope.instructions)
+	fn := &Function{
+		Instructions:  c.scope.instructions,
+		NumRegisters:  c.scope.maxRegisters,
+		NumParameters: len(node.Parameters),
+	}
+	freeSymbols := c.symbolTable.FreeSymbols
+	c.symbolTable = c.symbolTable.Outer
+	c.scope = outer
+
+	fnIndex := c.addConstant(fn)
+	if len(freeSymbols) == 0 {
+		c.emit(OpClosure, dst, fnIndex, 0)
+		return nil
+	}
+
+	base, err := c.allocTemps(len(freeSymbols))
+	if err != nil {
+		return err
+	}
+	for i, s := range freeSymbols {
+		c.loadSymbol(s, base+i)
+	}
+	c.emit(OpClosure, base, fnIndex, len(freeSymbols))
+	c.move(dst, base)
+	return nil
+}
+
+func (c *Compiler) functionBody(stmts []ast.Statement) error {
+	for i, stmt := range stmts {
+		exp, ok := stmt.(*ast.ExpressionStatement)
+		if !ok || i < len(stmts)-1 {
+			err := c.statement(stmt)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		reg, err := c.operand(exp.Expression)
+		if err != nil {
+			return err
+		}
+		c.emit(OpReturn, reg)
+		return nil
+	}
+
+	c.emit(OpReturnNull)
+	return nil
+}
+
+// markTailCalls turns every call whose result is returned right away into a
+// tail call. A call leaves its result in its base register, which may then
+// be moved to the register of the enclosing expression, and jumps may lead
+// from there to the return.
+func markTailCalls(ins []Instruction) {
+	for i, in := range ins {
+		if in.Op() != OpCall {
+			continue
+		}
+
+		result, next := in.A(), i+1
+		if next < len(ins) && ins[next].Op() == OpMove && ins[next].B() == result {
+			result = ins[next].A()
+			next++
+		}
+		//bounded in case the jumps form a cycle
+		for n := 0; n < len(ins) && next < len(ins) && ins[next].Op() == OpJump; n++ {
+			next = ins[next].A()
+		}
+
+		if next < len(ins) && ins[next].Op() == OpReturn && ins[next].A() == result {
+			ins[i] = Make(OpTailCall, in.A(), in.B())
+		}
+	}
+}
+
+// countLets counts the let statements of a function body, not those of the
+// functions nested in it.
+func countLets(body *ast.BlockStatement) int {
+	n := 0
+	ast.Inspect(body, func(node ast.Node) bool {
+		switch node.(type) {
+		case *ast.LetStatement:
+			n++
+		case *ast.FunctionLiteral:
+			return false
+		}
+		return true
+	})
+	return n
+}
+
+func (c *Compiler) loadSymbol(s compiler.Symbol, dst int) {
+	switch s.Scope {
+	case compiler.GlobalScope:
+		c.emit(OpGetGlobal, dst, s.Index)
+	case compiler.LocalScope:
+		c.move(dst, s.Index)
+	case compiler.BuiltinScope:
+		c.emit(OpGetBuiltin, dst, s.Index)
+	case compiler.FreeScope:
+		c.emit(OpGetFree, dst, s.Index)
+	case compiler.FunctionScope:
+		c.emit(OpCurrentClosure, dst)
+	}
+}
+
+func (c *Compiler) move(dst, src int) {
+	if dst != src {
+		c.emit(OpMove, dst, src)
+	}
+}
+
+func (c *Compiler) allocTemps(n int) (int, error) {
+	reg := c.scope.nextTemp
+	c.scope.nextTemp += n
+	if c.scope.nextTemp > MaxOperand {
+		return 0, fmt.Errorf("function needs more than %d registers", MaxOperand)
+	}
+	if c.scope.nextTemp > c.scope.maxRegisters {
+		c.scope.maxRegisters = c.scope.nextTemp
+	}
+	return reg, nil
+}
+
+func (c *Compiler) freeTemps(mark int) {
+	c.scope.nextTemp = mark
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+func (c *Compiler) emit(op Opcode, operands ...int) int {
+	c.scope.instructions = append(c.scope.instructions, Make(op, operands...))
+	return len(c.scope.instructions) - 1
+}
+
+// patchJump points the jump at pos to the next instruction to be emitted.
+// OpJumpIfFalse keeps its condition register in A and takes the target in B.
+func (c *Compiler) patchJump(pos int, condition int) {
+	target := len(c.scope.instructions)
+	ins := c.scope.instructions[pos]
+	if ins.Op() == OpJumpIfFalse {
+		c.scope.instructions[pos] = Make(OpJumpIfFalse, condition, target)
+		return
+	}
+	c.scope.instructions[pos] = Make(OpJump, target)
+}
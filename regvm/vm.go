@@ -0,0 +1,489 @@
+package regvm
+
+import (
+	"Hulk/builtins"
+	"Hulk/object"
+	"fmt"
+)
+
+const GlobalsSize = 65536
+const MaxFrames = 1024
+
+// MaxRegisters bounds the register file shared by all frames.
+const MaxRegisters = 1 << 20
+
+var (
+	True  = object.TRUE
+	False = object.FALSE
+	Null  = object.NULL
+)
+
+// frame is a running function. Its registers start at base in the register
+// file; the register right below base holds the callee and receives the
+// return value.
+type frame struct {
+	cl   *Closure
+	ip   int
+	base int
+}
+
+type VM struct {
+	constants []object.Object
+	globals   []object.Object
+
+	regs []object.Object
+
+	frames      []frame
+	framesIndex int
+
+	result object.Object
+}
+
+func New(bytecode *Bytecode) *VM {
+	mainClosure := &Closure{Fn: bytecode.Main}
+
+	frames := make([]frame, MaxFrames)
+	//register 0 is left free for the main closure, like for any callee
+	frames[0] = frame{cl: mainClosure, base: 1}
+
+	regs := make([]object.Object, 1+bytecode.Main.NumRegisters, 1024)
+	regs[0] = mainClosure
+
+	return &VM{
+		constants:   bytecode.Constants,
+		globals:     make([]object.Object, GlobalsSize),
+		regs:        regs,
+		frames:      frames,
+		framesIndex: 1,
+	}
+}
+
+// Result returns the value of the last expression statement of the main
+// program, or of its top level return.
+func (vm *VM) Result() object.Object {
+	return vm.result
+}
+
+func (vm *VM) Run() error {
+	return vm.run(0)
+}
+
+// run executes instructions until the main function runs out of them or,
+// when a builtin calls back into Hulk code, until the frame count drops back
+// to returnDepth.
+func (vm *VM) run(returnDepth int) error {
+	f := &vm.frames[vm.framesIndex-1]
+	ins := f.cl.Fn.Instructions
+	regs := vm.regs[f.base:]
+
+	for f.ip < len(ins) {
+		in := ins[f.ip]
+		f.ip++
+
+		switch in.Op() {
+		case OpLoadConst:
+			regs[in.A()] = vm.constants[in.B()]
+
+		case OpLoadTrue:
+			regs[in.A()] = True
+
+		case OpLoadFalse:
+			regs[in.A()] = False
+
+		case OpLoadNull:
+			regs[in.A()] = Null
+
+		case OpMove:
+			regs[in.A()] = regs[in.B()]
+
+		case OpGetGlobal:
+			regs[in.A()] = vm.globals[in.B()]
+
+		case OpSetGlobal:
+			vm.globals[in.B()] = regs[in.A()]
+
+		case OpGetBuiltin:
+			regs[in.A()] = builtins.Builtins[in.B()].Builtin
+
+		case OpGetFree:
+			regs[in.A()] = f.cl.Free[in.B()]
+
+		case OpCurrentClosure:
+			regs[in.A()] = f.cl
+
+		case OpAdd, OpSub, OpMul, OpDiv:
+			result, err := binaryOperation(in.Op(), regs[in.B()], regs[in.C()])
+			if err != nil {
+				return err
+			}
+			regs[in.A()] = result
+
+		case OpEqual, OpNotEqual, OpGreaterThan:
+			result, err := comparison(in.Op(), regs[in.B()], regs[in.C()])
+			if err != nil {
+				return err
+			}
+			regs[in.A()] = result
+
+		case OpNot:
+			regs[in.A()] = nativeBoolToBooleanObject(!isTruthy(regs[in.B()]))
+
+		case OpNeg:
+			operand := regs[in.B()]
+			integer, ok := operand.(*object.Integer)
+			if !ok {
+				return fmt.Errorf("unsupported type for negation: %s", operand.Type())
+			}
+			regs[in.A()] = object.NewInteger(-integer.Value)
+
+		case OpJump:
+			f.ip = in.A()
+
+		case OpJumpIfFalse:
+			if !isTruthy(regs[in.A()]) {
+				f.ip = in.B()
+			}
+
+		case OpArray:
+			elements := make([]object.Object, in.C())
+			copy(elements, regs[in.B():in.B()+in.C()])
+			regs[in.A()] = &object.Array{Elements: elements}
+
+		case OpHash:
+			hash, err := buildHash(regs[in.B() : in.B()+in.C()])
+			if err != nil {
+				return err
+			}
+			regs[in.A()] = hash
+
+		case OpIndex:
+			result, err := indexExpression(regs[in.B()], regs[in.C()])
+			if err != nil {
+				return err
+			}
+			regs[in.A()] = result
+
+		case OpSlice:
+			b := in.B()
+			result, err := object.Slice(regs[b], regs[b+1], regs[b+2], regs[b+3])
+			if err != nil {
+				return err
+			}
+			regs[in.A()] = result
+
+		case OpClosure:
+			fn, ok := vm.constants[in.B()].(*Function)
+			if !ok {
+				return fmt.Errorf("not a function: %+v", vm.constants[in.B()])
+			}
+			free := make([]object.Object, in.C())
+			copy(free, regs[in.A():in.A()+in.C()])
+			regs[in.A()] = &Closure{Fn: fn, Free: free}
+
+		case OpCall, OpTailCall:
+			switch callee := regs[in.A()].(type) {
+			case *Closure:
+				var err error
+				if in.Op() == OpTailCall {
+					//the callee takes over the running frame, its result
+					//goes straight to our caller
+					copy(vm.regs[f.base-1:], regs[in.A():in.A()+1+in.B()])
+					err = vm.prepareFrame(callee, f.base, in.B())
+					f.cl, f.ip = callee, 0
+				} else {
+					err = vm.callClosure(callee, f.base+in.A()+1, in.B())
+					f = &vm.frames[vm.framesIndex-1]
+				}
+				if err != nil {
+					return err
+				}
+				ins = f.cl.Fn.Instructions
+				regs = vm.regs[f.base:]
+
+			case *object.BuiltIn:
+				//builtins take no frame, for a tail call the instructions
+				//after the call return the result as usual
+				result, err := vm.callBuiltin(callee, regs[in.A()+1:in.A()+1+in.B()])
+				if err != nil {
+					return err
+				}
+				//the builtin may have called back into the VM and grown
+				//the register file
+				regs = vm.regs[f.base:]
+				regs[in.A()] = result
+
+			default:
+				return fmt.Errorf("calling non-function and non-built-in")
+			}
+
+		case OpReturn, OpReturnNull:
+			var value object.Object = Null
+			if in.Op() == OpReturn {
+				value = regs[in.A()]
+			}
+
+			if vm.framesIndex == 1 {
+				vm.result = value
+				return nil
+			}
+
+			vm.framesIndex--
+			vm.regs[f.base-1] = value
+			if vm.framesIndex == returnDepth {
+				return nil
+			}
+			f = &vm.frames[vm.framesIndex-1]
+			ins = f.cl.Fn.Instructions
+			regs = vm.regs[f.base:]
+
+		case OpResult:
+			vm.result = regs[in.A()]
+
+		default:
+			return fmt.Errorf("opcode %d undefined", in.Op())
+		}
+	}
+	return nil
+}
+
+// callClosure pushes a frame for cl whose registers start at base, where
+// the caller has already put the arguments.
+func (vm *VM) callClosure(cl *Closure, base int, numArgs int) error {
+	if vm.framesIndex >= MaxFrames {
+		return fmt.Errorf("frame overflow")
+	}
+	err := vm.prepareFrame(cl, base, numArgs)
+	if err != nil {
+		return err
+	}
+
+	vm.frames[vm.framesIndex] = frame{cl: cl, base: base}
+	vm.framesIndex++
+	return nil
+}
+
+// prepareFrame makes room for the registers of cl starting at base, where
+// the caller has already put the arguments.
+func (vm *VM) prepareFrame(cl *Closure, base int, numArgs int) error {
+	if numArgs != cl.Fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, numArgs)
+	}
+
+	top := base + cl.Fn.NumRegisters
+	if top > MaxRegisters {
+		return fmt.Errorf("stack overflow")
+	}
+	if top > len(vm.regs) {
+		if top > cap(vm.regs) {
+			regs := make([]object.Object, top, 2*top)
+			copy(regs, vm.regs)
+			vm.regs = regs
+		}
+		vm.regs = vm.regs[:top]
+	}
+	//stale values above the arguments would keep garbage alive and make
+	//unset locals visible, start the callee from a clean slate
+	clear(vm.regs[base+numArgs : top])
+	return nil
+}
+
+func (vm *VM) callBuiltin(builtin *object.BuiltIn, args []object.Object) (object.Object, error) {
+	//the builtin gets its own copy, the registers may be reused under it
+	args = append([]object.Object(nil), args...)
+
+	result := builtin.Fn(applier{vm}, args...)
+	if errObj, ok := result.(*object.Error); ok {
+		return nil, fmt.Errorf("%s", errObj.Message)
+	}
+	if result == nil {
+		return Null, nil
+	}
+	return result, nil
+}
+
+// applier is the object.Applier the VM hands to builtins. Runs of the
+// register VM are not limited, so it has no meter.
+type applier struct {
+	vm *VM
+}
+
+func (a applier) Apply(fn object.Object, args ...object.Object) object.Object {
+	return a.vm.applyFunction(fn, args...)
+}
+
+func (a applier) Meter() *object.Meter {
+	return nil
+}
+
+// applyFunction runs a closure to completion above the registers of the
+// running frame and returns its result, or an *object.Error if the call
+// fails.
+func (vm *VM) applyFunction(fn object.Object, args ...object.Object) object.Object {
+	switch fn := fn.(type) {
+	case *Closure:
+		depth := vm.framesIndex
+		top := vm.frames[depth-1]
+		base := top.base + top.cl.Fn.NumRegisters + 1
+
+		err := vm.callClosure(fn, base, len(args))
+		if err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+		vm.regs[base-1] = fn
+		copy(vm.regs[base:], args)
+
+		err = vm.run(depth)
+		if err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+		return vm.regs[base-1]
+
+	case *object.BuiltIn:
+		return fn.Fn(applier{vm}, args...)
+
+	default:
+		return &object.Error{Message: fmt.Sprintf("not a function: %s", fn.Type())}
+	}
+}
+
+// operators names the operator of each arithmetic and comparison opcode
+// in error messages.
+var operators = map[Opcode]string{
+	OpAdd:         "+",
+	OpSub:         "-",
+	OpMul:         "*",
+	OpDiv:         "/",
+	OpEqual:       "==",
+	OpNotEqual:    "!=",
+	OpGreaterThan: ">",
+}
+
+func binaryOperation(op Opcode, left, right object.Object) (object.Object, error) {
+	if left == nil || right == nil {
+		return nil, fmt.Errorf("operand of %s is not set", operators[op])
+	}
+	switch left := left.(type) {
+	case *object.Integer:
+		if right, ok := right.(*object.Integer); ok {
+			return binaryIntegerOperation(op, left.Value, right.Value)
+		}
+	case *object.String:
+		if right, ok := right.(*object.String); ok {
+			if op != OpAdd {
+				return nil, fmt.Errorf("unknown string operator: %s", operators[op])
+			}
+			return &object.String{Value: left.Value + right.Value}, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported types for binary operation: %s %s", left.Type(), right.Type())
+}
+
+func binaryIntegerOperation(op Opcode, left, right int64) (object.Object, error) {
+	var result int64
+
+	switch op {
+	case OpAdd:
+		result = left + right
+	case OpSub:
+		result = left - right
+	case OpMul:
+		result = left * right
+	case OpDiv:
+		if right == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		result = left / right
+	}
+
+	return object.NewInteger(result), nil
+}
+
+func comparison(op Opcode, left, right object.Object) (object.Object, error) {
+	if left, ok := left.(*object.Integer); ok {
+		if right, ok := right.(*object.Integer); ok {
+			switch op {
+			case OpEqual:
+				return nativeBoolToBooleanObject(left.Value == right.Value), nil
+			case OpNotEqual:
+				return nativeBoolToBooleanObject(left.Value != right.Value), nil
+			default:
+				return nativeBoolToBooleanObject(left.Value > right.Value), nil
+			}
+		}
+	}
+	//strings built apart are still equal when they hold the same value
+	equal := left == right
+	if left.Type() == object.STRING_OBJ {
+		equal = object.Equals(left, right)
+	}
+
+	switch op {
+	case OpEqual:
+		return nativeBoolToBooleanObject(equal), nil
+	case OpNotEqual:
+		return nativeBoolToBooleanObject(!equal), nil
+	default:
+		return nil, fmt.Errorf("unknown operator: %s %s %s", left.Type(), operators[op], right.Type())
+	}
+}
+
+func buildHash(regs []object.Object) (object.Object, error) {
+	hashedPairs := make(map[object.HashKey]object.HashPair)
+
+	for i := 0; i < len(regs); i += 2 {
+		key, value := regs[i], regs[i+1]
+
+		hashKey, ok := object.HashKeyOf(key)
+		if !ok {
+			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+		}
+		hashedPairs[hashKey] = object.HashPair{Key: key, Value: value}
+	}
+
+	return &object.Hash{Pairs: hashedPairs}, nil
+}
+
+func indexExpression(left, index object.Object) (object.Object, error) {
+	switch left := left.(type) {
+	case *object.Array:
+		i, ok := index.(*object.Integer)
+		if !ok {
+			break
+		}
+		if i.Value < 0 || i.Value >= int64(len(left.Elements)) {
+			return Null, nil
+		}
+		return left.Elements[i.Value], nil
+
+	case *object.Hash:
+		key, ok := object.HashKeyOf(index)
+		if !ok {
+			return nil, fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+		pair, ok := left.Pairs[key]
+		if !ok {
+			return Null, nil
+		}
+		return pair.Value, nil
+	}
+
+	return nil, fmt.Errorf("index operator not supported: %s", left.Type())
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return True
+	}
+	return False
+}
+
+func isTruthy(obj object.Object) bool {
+	switch obj := obj.(type) {
+	case *object.Boolean:
+		return obj.Value
+	case *object.Null:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,235 @@
+package regvm
+
+import (
+	"Hulk/ast"
+	"Hulk/compiler"
+	"Hulk/evaluator"
+	"Hulk/lexer"
+	"Hulk/object"
+	"Hulk/parser"
+	"Hulk/vm"
+	"strings"
+	"testing"
+)
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func run(input string) (object.Object, error) {
+	comp := NewCompiler()
+	err := comp.Compile(parse(input))
+	if err != nil {
+		return nil, err
+	}
+
+	machine := New(comp.Bytecode())
+	err = machine.Run()
+	if err != nil {
+		return nil, err
+	}
+	return machine.Result(), nil
+}
+
+func runStackVM(input string) (object.Object, error) {
+	comp := compiler.New()
+	err := comp.Compile(parse(input))
+	if err != nil {
+		return nil, err
+	}
+
+	machine := vm.New(comp.Bytecode())
+	err = machine.Run()
+	if err != nil {
+		return nil, err
+	}
+	return machine.LastPoppedStackElem(), nil
+}
+
+// TestMatchesStackVM runs every program on both backends, which have to
+// agree on results and on errors.
+func TestMatchesStackVM(t *testing.T) {
+	inputs := []string{
+		"1",
+		"1 + 2 * 3 - 4 / 2",
+		"-(1 - 10) * 2",
+		`"foo" + "bar" + "baz"`,
+		`"a" == "a"`,
+		`let s = "a"; s == s`,
+		`let s = "a"; [s + "b" == "ab", "ab" != s + "b", s == "a" + ""]`,
+		"!5",
+		"!!true == true",
+		"1 < 2 == 2 > 1",
+		"true != false",
+		"1; 2; 3",
+		"let a = 5; a; a * (2 + 3)",
+		"if (1 > 2) { 10 } else { 20 }",
+		"if (1 < 2) { 10 }",
+		"if (false) { 10 }",
+		"if (true) { if (false) { 1 } } else { 2 }",
+		"if (true) { let x = 3; x * 2 }",
+		"let f = fn(x) { let x = x + 1; x }; f(1)",
+		"let x = 1; let g = fn() { let x = x + 1; x }; g() + x",
+		"let f = fn(a) { let a = [a]; a }; f(1)",
+		"let x = if (true) { 1 } else { 2 }; x",
+		"[1, 2 + 3, [4]]",
+		"[]",
+		`{"a": 1, "b": [2], 3: true}["b"]`,
+		`{"a" + "b": 1 + 1}["ab"]`,
+		"{}[1]",
+		"[1, 2, 3][1 + 1]",
+		"[1, 2, 3][5]",
+		"[1, 2, 3, 4, 5][1:4:2]",
+		`"hulk"[::-1]`,
+		"[1, 2, 3][:2]",
+		"let f = fn(x) { if (x > 0) { return x * 2; x; 99 } 0 }; [f(3), f(-3)]",
+		"let f = fn() { return 1; return 2; }; f()",
+		"let f = fn() { }; f()",
+		"let f = fn() { let a = 1; }; f()",
+		"let sum = fn(a, b) { let c = a + b; c; }; sum(1, 2) + sum(3, 4);",
+		"let f = fn(a) { let d = a * 2; let b = d + 1; [d, b] }; f(5)",
+		"let f = fn(x) { if (x > 0) { let y = x * 10; y } else { let z = -x; z } }; [f(2), f(-3)]",
+		"let fib = fn(n) { if (n < 2) { return n; } fib(n - 1) + fib(n - 2) }; fib(15)",
+		"let newAdder = fn(a) { fn(b) { a + b }; }; let addTwo = newAdder(2); addTwo(3);",
+		"let f = fn(a) { fn(b) { fn(c) { a + b + c } } }; f(1)(2)(3)",
+		"let wrapper = fn() { let countDown = fn(x) { if (x == 0) { return 0; } else { countDown(x - 1); } }; countDown(1); }; wrapper();",
+		"let g = 10; let f = fn(x) { x + g }; f(1)",
+		"let f = fn(x, y) { [x, y, x + y] }; f(f(1, 2)[2], 4)",
+		"map([1, 2, 3], fn(x) { x * 2 })",
+		"let k = 10; map([1, 2], fn(x) { x + k })",
+		"reduce([1, 2, 3, 4], fn(acc, x) { acc + x })",
+		"sort([3, 1, 2], fn(a, b) { a > b })",
+		"map(map([1, 2], fn(x) { [x] }), first)",
+		"let sumAll = fn(arr) { reduce(map(arr, fn(x) { x * x }), fn(a, b) { a + b }, 0) }; sumAll(range(4))",
+		"let deep = fn(n) { if (n == 0) { 0 } else { map([n], fn(x) { deep(x - 1) })[0] + 1 } }; deep(20)",
+		`join(map(split("a,b,c", ","), upper), "-")`,
+		`json_stringify({"a": [1, true, "x"]})`,
+		"let count = fn(n) { if (n == 0) { return 0; } return count(n - 1); }; count(100000)",
+		"let sum = fn(arr, i, acc) { if (i == len(arr)) { acc } else { sum(arr, i + 1, acc + arr[i]) } }; sum(range(100000), 0, 0)",
+		"let loop = fn(n) { if (n == 0) { 0 } else { loop(n - 1) } }; map([100000], loop)",
+		"let wrap = fn(f) { fn(n) { f(n) } }; wrap(len)([1, 2])",
+		"let f = fn(a, b) { b }; let g = fn(x) { f(x, x + 1) }; g(1) + g(2)",
+		"let grow = fn(n, acc) { if (n == 0) { acc } else { let more = [n, acc]; grow(n - 1, more) } }; grow(3, [])",
+		"puts()",
+		"let f = fn() { 1 }; f(1)",
+		"1(2)",
+		"1 / 0",
+		"-true",
+		"[1][true]",
+		"{[1]: 2}",
+		"len(1)",
+		"map([1], fn(x) { x + true })",
+		"[1, 2][1:2:0]",
+	}
+
+	for _, input := range inputs {
+		want, wantErr := runStackVM(input)
+		got, gotErr := run(input)
+
+		if wantErr != nil || gotErr != nil {
+			if wantErr == nil || gotErr == nil || wantErr.Error() != gotErr.Error() {
+				t.Errorf("%s: errors differ. stack=%v, register=%v", input, wantErr, gotErr)
+			}
+			continue
+		}
+		if got.Inspect() != want.Inspect() {
+			t.Errorf("%s: results differ. stack=%s, register=%s", input, want.Inspect(), got.Inspect())
+		}
+	}
+}
+
+// TestEmptyBlocks checks that empty blocks are null on every
+// engine, also when they are passed on to a builtin.
+func TestEmptyBlocks(t *testing.T) {
+	inputs := []string{
+		"fn() {}()",
+		"puts(fn() {}())",
+		"len([fn() {}()])",
+		"[if (true) {}, if (false) { 1 } else {}]",
+	}
+
+	for _, input := range inputs {
+		evaluated := evaluator.Eval(parse(input), object.NewEnvironment())
+
+		stack, err := runStackVM(input)
+		if err != nil {
+			t.Fatalf("%s: stack vm error: %s", input, err)
+		}
+		register, err := run(input)
+		if err != nil {
+			t.Fatalf("%s: register vm error: %s", input, err)
+		}
+
+		if evaluated == nil {
+			t.Fatalf("%s: evaluator returned nil", input)
+		}
+		if stack.Inspect() != evaluated.Inspect() || register.Inspect() != evaluated.Inspect() {
+			t.Errorf("%s: results differ. evaluator=%s, stack=%s, register=%s",
+				input, evaluated.Inspect(), stack.Inspect(), register.Inspect())
+		}
+	}
+}
+
+func TestTopLevelReturn(t *testing.T) {
+	result, err := run("1; return 2; 3")
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if result.Inspect() != "2" {
+		t.Errorf("wrong result. want=2, got=%s", result.Inspect())
+	}
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"a" - "b"`, "unknown string operator: -"},
+		{"true > false", "unknown operator: BOOLEAN > BOOLEAN"},
+		{"let inf = fn(n) { 1 + inf(n + 1) }; inf(0)", "frame overflow"},
+	}
+
+	for _, tt := range tests {
+		_, err := run(tt.input)
+		if err == nil {
+			t.Fatalf("expected vm error for %q, got none", tt.input)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong vm error. want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestUnsetOperand(t *testing.T) {
+	_, err := binaryOperation(OpAdd, nil, object.NewInteger(1))
+	if err == nil || err.Error() != "operand of + is not set" {
+		t.Errorf("wrong error for an unset operand. got=%v", err)
+	}
+}
+
+func TestRegisterAllocation(t *testing.T) {
+	comp := NewCompiler()
+	err := comp.Compile(parse("fn(a, b) { let c = a + b; c * 2 }"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	fn := comp.Bytecode().Constants[1].(*Function)
+	expected := strings.Join([]string{
+		"0000 ADD 3 0 1",
+		"0001 MOVE 2 3",
+		"0002 LOADCONST 4 0",
+		"0003 MUL 3 2 4",
+		"0004 RETURN 3",
+		"",
+	}, "\n")
+	if fn.String() != expected {
+		t.Errorf("wrong instructions.\nwant=\n%s\ngot=\n%s", expected, fn.String())
+	}
+	if fn.NumRegisters != 5 {
+		t.Errorf("wrong number of registers. want=5, got=%d", fn.NumRegisters)
+	}
+}
@@ -1,8 +1,11 @@
 package repl
 
 import (
+	"Hulk/builtins"
 	"Hulk/compiler"
 	"Hulk/lexer"
+	"Hulk/loader"
+	"Hulk/object"
 	"Hulk/parser"
 	"Hulk/vm"
 	"bufio"
@@ -15,6 +18,18 @@ const PROMPT = "#>"
 func Start(in io.Reader, out io.Writer) {
 	scanner := bufio.NewScanner(in)
 	// env := object.NewEnvironment()
+
+	constants := []object.Object{}
+	globals := make([]object.Object, vm.GlobalsSize)
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range builtins.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+	//imports are looked up in the working directory, then in $HULKPATH
+	modules := loader.New(append([]string{"."}, loader.EnvSearchPath()...)...)
+	modules.Warn = func(file, warning string) {
+		io.WriteString(out, "warning: "+file+": "+warning+"\n")
+	}
 	for {
 		fmt.Printf(PROMPT)
 		scanned := scanner.Scan()
@@ -31,27 +46,39 @@ func Start(in io.Reader, out io.Writer) {
 			printParseErrors(out, p.Errors())
 			continue
 		}
+		for _, warning := range p.Warnings() {
+			io.WriteString(out, "warning: "+warning+"\n")
+		}
 		// evaluated := evaluator.Eval(program, env)
 		// if evaluated != nil {
 		// 	io.WriteString(out, evaluated.Inspect())
 		// 	io.WriteString(out, "\n")
 		// }
 
-		comp := compiler.New()
+		comp := compiler.NewWithState(symbolTable, constants)
+		comp.EnableImports(modules, "")
 		err := comp.Compile(program)
 		if err != nil {
 			fmt.Fprintf(out, "Woops! Compilation failed: \n %s\n", err)
 			continue
 		}
 
-		machine := vm.New(comp.Bytecode())
+		code := comp.Bytecode()
+		constants = code.Constants
+
+		machine := vm.NewWithGlobalsStore(code, globals)
 		err = machine.Run()
+		if errObj, ok := err.(*object.Error); ok {
+			fmt.Fprintf(out, "Woops! Bytecode failed: \n %s\n", errObj.Traceback())
+			continue
+		}
 		if err != nil {
 			fmt.Fprintf(out, "Woops! Bytecode failed: \n %s\n", err)
+			continue
 		}
 
-		stackTop := machine.StackTop()
-		io.WriteString(out, stackTop.Inspect())
+		lastPopped := machine.LastPoppedStackElem()
+		io.WriteString(out, lastPopped.Inspect())
 		io.WriteString(out, "\n")
 	}
 }
@@ -5,6 +5,7 @@ type TokenType string
 type Token struct {
 	Type    TokenType
 	Literal string
+	Line    int //1-based line the token starts on
 }
 
 var keywords = map[string]TokenType{
@@ -15,6 +16,20 @@ var keywords = map[string]TokenType{
 	"else":   ELSE,
 	"true":   TRUE,
 	"false":  FALSE,
+
+	"throw":   THROW,
+	"try":     TRY,
+	"catch":   CATCH,
+	"finally": FINALLY,
+
+	"match": MATCH,
+
+	"import": IMPORT,
+	"export": EXPORT,
+	"as":     AS,
+
+	"struct": STRUCT,
+	"enum":   ENUM,
 }
 
 const (
@@ -35,6 +50,7 @@ const (
 
 	EQUALS    = "=="
 	NOTEQUALS = "!="
+	ARROW     = "=>"
 
 	COMMA     = ","
 	SEMICOLON = ";"
@@ -45,6 +61,9 @@ const (
 	LBRACKET  = "["
 	RBRACKET  = "]"
 	COLON     = ":"
+	QUESTION  = "?"
+	ELLIPSIS  = "..."
+	DOT       = "."
 	FUNCTION  = "FUNCTION"
 	LET       = "LET"
 	TRUE      = "TRUE"
@@ -53,6 +72,16 @@ const (
 	IF        = "IF"
 	RETURN    = "RETURN"
 	STRING    = "STRING"
+	THROW     = "THROW"
+	TRY       = "TRY"
+	CATCH     = "CATCH"
+	FINALLY   = "FINALLY"
+	MATCH     = "MATCH"
+	IMPORT    = "IMPORT"
+	EXPORT    = "EXPORT"
+	AS        = "AS"
+	STRUCT    = "STRUCT"
+	ENUM      = "ENUM"
 )
 
 func LookupIdent(ident string) TokenType {
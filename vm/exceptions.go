@@ -0,0 +1,64 @@
+package vm
+
+import "Hulk/object"
+
+// catch unwinds the frames run is responsible for, innermost first, until
+// one of them has a handler covering the instruction it was running. The
+// stack of that frame is cut back to the depth of the handler and the error
+// pushed, as the hash a catch clause binds or as itself for a finally block,
+// and execution carries on at the handler, in which case catch returns nil.
+//
+// Otherwise the error is returned with the frames as they were when it was
+// raised recorded in its stack. The main frame is never popped, and neither
+// are the frames of the run a builtin called back into Hulk code from, since
+// the error goes back through the builtin first. Errors from going over the
+// limits of the run are never caught.
+func (vm *VM) catch(err error, returnDepth int) error {
+	if vm.meter.Err() != nil {
+		return err
+	}
+
+	errObj, ok := err.(*object.Error)
+	if !ok {
+		errObj = &object.Error{Message: err.Error()}
+	}
+	if errObj.Stack == nil {
+		errObj.Stack = vm.callStack()
+	}
+
+	for vm.framesIndex > returnDepth {
+		frame := vm.currentFrame()
+		handler, ok := frame.cl.Fn.Handlers.HandlerFor(frame.ip)
+		if ok {
+			vm.sp = frame.basePointer + frame.cl.Fn.NumLocals + handler.Depth
+			if handler.Finally {
+				vm.stack[vm.sp] = errObj
+			} else {
+				vm.stack[vm.sp] = errObj.Caught(errObj.Stack[:len(errObj.Stack)-vm.framesIndex])
+			}
+			vm.sp++
+			frame.ip = handler.Target - 1
+			return nil
+		}
+
+		if vm.framesIndex == 1 {
+			break
+		}
+		vm.popFrame()
+	}
+	return errObj
+}
+
+// callStack returns the Hulk calls being run, innermost first.
+func (vm *VM) callStack() []object.StackFrame {
+	stack := make([]object.StackFrame, 0, vm.framesIndex)
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+		name := frame.cl.Fn.Name
+		if i == 0 {
+			name = object.MainFunction
+		}
+		stack = append(stack, object.StackFrame{Function: name, Line: frame.cl.Fn.Positions.LineFor(frame.ip)})
+	}
+	return stack
+}
@@ -0,0 +1,78 @@
+package vm
+
+import (
+	"Hulk/code"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// OpcodePair counts how often Second ran right after First. Pairs that run
+// very often are the candidates for new superinstructions.
+type OpcodePair struct {
+	First, Second code.Opcode
+	Count         int
+}
+
+type profile struct {
+	pairs  map[[2]code.Opcode]int
+	last   code.Opcode
+	primed bool
+}
+
+// EnableProfiling makes the VM count the opcode pairs it executes. It slows
+// the VM down and is meant for finding out where the time goes.
+func (vm *VM) EnableProfiling() {
+	vm.profile = &profile{pairs: map[[2]code.Opcode]int{}}
+}
+
+func (p *profile) record(op code.Opcode) {
+	if p.primed {
+		p.pairs[[2]code.Opcode{p.last, op}]++
+	}
+	p.last, p.primed = op, true
+}
+
+// OpcodePairs returns the pairs counted since profiling was enabled, the
+// most frequent first.
+func (vm *VM) OpcodePairs() []OpcodePair {
+	if vm.profile == nil {
+		return nil
+	}
+
+	pairs := make([]OpcodePair, 0, len(vm.profile.pairs))
+	for pair, count := range vm.profile.pairs {
+		pairs = append(pairs, OpcodePair{First: pair[0], Second: pair[1], Count: count})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].Count != pairs[j].Count {
+			return pairs[i].Count > pairs[j].Count
+		}
+		if pairs[i].First != pairs[j].First {
+			return pairs[i].First < pairs[j].First
+		}
+		return pairs[i].Second < pairs[j].Second
+	})
+	return pairs
+}
+
+// WriteProfile writes the limit most frequent opcode pairs to w.
+func (vm *VM) WriteProfile(w io.Writer, limit int) {
+	pairs := vm.OpcodePairs()
+	if len(pairs) > limit {
+		pairs = pairs[:limit]
+	}
+
+	fmt.Fprintf(w, "%10s  %s\n", "count", "opcode pair")
+	for _, pair := range pairs {
+		fmt.Fprintf(w, "%10d  %s %s\n", pair.Count, opcodeName(pair.First), opcodeName(pair.Second))
+	}
+}
+
+func opcodeName(op code.Opcode) string {
+	def, err := code.Lookup(byte(op))
+	if err != nil {
+		return fmt.Sprintf("Op%d", op)
+	}
+	return def.Name
+}
@@ -1,32 +1,93 @@
 package vm
 
 import (
+	"Hulk/builtins"
 	"Hulk/code"
 	"Hulk/compiler"
 	"Hulk/object"
+	"context"
 	"fmt"
 )
 
 const StackSize = 2048
+const GlobalsSize = 65536
+const MaxFrames = 1024
+
+var (
+	True  = object.TRUE
+	False = object.FALSE
+	Null  = object.NULL
+)
 
 type VM struct {
-	constants    []object.Object
-	instructions code.Instructions
+	constants []object.Object
 
 	stack []object.Object
-	sp    int
+	sp    int //always points to the next free slot, the top of the stack is stack[sp-1]
+
+	globals []object.Object
+
+	frames      []*Frame
+	framesIndex int
+
+	profile *profile      //nil unless profiling is enabled
+	meter   *object.Meter //nil unless the run is limited
 }
 
 func New(bytecode *compiler.Bytecode) *VM {
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		Positions:    bytecode.Positions,
+		Handlers:     bytecode.Handlers,
+	}
+	mainClosure := &object.Closure{Fn: mainFn}
+	mainFrame := NewFrame(mainClosure, 0)
+
+	frames := make([]*Frame, MaxFrames)
+	frames[0] = mainFrame
+
 	return &VM{
-		instructions: bytecode.Instructions,
-		constants:    bytecode.Constants,
+		constants: bytecode.Constants,
 
 		stack: make([]object.Object, StackSize),
 		sp:    0,
+
+		globals: make([]object.Object, GlobalsSize),
+
+		frames:      frames,
+		framesIndex: 1,
 	}
 }
 
+// NewWithGlobalsStore lets the REPL carry global bindings from one line to the next.
+func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
+	vm := New(bytecode)
+	vm.globals = s
+	return vm
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) pushFrame(f *Frame) error {
+	if vm.framesIndex >= MaxFrames {
+		return fmt.Errorf("frame overflow")
+	}
+	if err := vm.meter.Enter(); err != nil {
+		return err
+	}
+	vm.frames[vm.framesIndex] = f
+	vm.framesIndex++
+	return nil
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.meter.Leave()
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
 func (vm *VM) StackTop() object.Object {
 	if vm.sp == 0 {
 		return nil
@@ -34,26 +95,570 @@ func (vm *VM) StackTop() object.Object {
 	return vm.stack[vm.sp-1]
 }
 
+func (vm *VM) LastPoppedStackElem() object.Object {
+	return vm.stack[vm.sp]
+}
+
+// Run runs the program. Runtime errors come back as an *object.Error holding
+// the Hulk call stack at the point of failure.
 func (vm *VM) Run() error {
-	for ip := 0; ip < len(vm.instructions); ip++ {
-		op := code.Opcode(vm.instructions[ip])
+	return vm.run(0)
+}
+
+// RunContext runs the program like Run, but stops it with an
+// *object.LimitError as soon as it goes over limits or ctx is done.
+func (vm *VM) RunContext(ctx context.Context, limits object.Limits) error {
+	vm.meter = object.NewMeter(ctx, limits)
+
+	err := vm.run(0)
+	//the limit error may have reached run as an error object from a
+	//builtin, which only kept its message
+	if limitErr := vm.meter.Err(); limitErr != nil {
+		return limitErr
+	}
+	return err
+}
+
+// run executes instructions until the main function runs out of them or,
+// when a builtin calls back into Hulk code, until the frame count drops back
+// to returnDepth. Errors are handed to catch, and execution carries on at
+// the handler that catches them.
+func (vm *VM) run(returnDepth int) error {
+	for {
+		err := vm.execute(returnDepth)
+		if err == nil {
+			return nil
+		}
+		err = vm.catch(err, returnDepth)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// execute runs instructions like run, up to the first error.
+func (vm *VM) execute(returnDepth int) error {
+	var ip int
+	var ins code.Instructions
+	var op code.Opcode
+
+	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		vm.currentFrame().ip++
+
+		ip = vm.currentFrame().ip
+		ins = vm.currentFrame().Instructions()
+		op = code.Opcode(ins[ip])
+		if vm.profile != nil {
+			vm.profile.record(op)
+		}
+		if vm.meter != nil {
+			if err := vm.meter.Step(); err != nil {
+				return err
+			}
+		}
 
 		switch op {
 		case code.OpConstant:
-			constIndex := code.ReadUint16(vm.instructions[ip+1:])
-			ip += 2
+			constIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
 			err := vm.push(vm.constants[constIndex])
 			if err != nil {
 				return err
 			}
-		case code.OpAdd:
-			right := vm.pop()
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
+			err := vm.executeBinaryOperation(op)
+			if err != nil {
+				return err
+			}
+
+		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
+			err := vm.executeComparison(op)
+			if err != nil {
+				return err
+			}
+
+		case code.OpAddInt, code.OpSubInt, code.OpGreaterThanInt:
+			err := vm.executeIntegerOperation(op)
+			if err != nil {
+				return err
+			}
+
+		case code.OpConstantAdd, code.OpConstantSub:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			left, leftOk := vm.stack[vm.sp-1].(*object.Integer)
+			right, rightOk := vm.constants[constIndex].(*object.Integer)
+			if leftOk && rightOk {
+				if op == code.OpConstantAdd {
+					vm.stack[vm.sp-1] = object.NewInteger(left.Value + right.Value)
+				} else {
+					vm.stack[vm.sp-1] = object.NewInteger(left.Value - right.Value)
+				}
+				continue
+			}
+
+			err := vm.push(vm.constants[constIndex])
+			if err != nil {
+				return err
+			}
+			if op == code.OpConstantAdd {
+				err = vm.executeBinaryOperation(code.OpAdd)
+			} else {
+				err = vm.executeBinaryOperation(code.OpSub)
+			}
+			if err != nil {
+				return err
+			}
+
+		case code.OpGetLocalGetLocalAdd:
+			base := vm.currentFrame().basePointer
+			left := vm.stack[base+int(code.ReadUint8(ins[ip+1:]))]
+			right := vm.stack[base+int(code.ReadUint8(ins[ip+2:]))]
+			vm.currentFrame().ip += 2
+
+			err := vm.push(left)
+			if err != nil {
+				return err
+			}
+			err = vm.push(right)
+			if err != nil {
+				return err
+			}
+			err = vm.executeIntegerOperation(code.OpAddInt)
+			if err != nil {
+				return err
+			}
+
+		case code.OpJumpNotGreater, code.OpJumpNotEqual:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			var condition bool
+			right, rightOk := vm.stack[vm.sp-1].(*object.Integer)
+			left, leftOk := vm.stack[vm.sp-2].(*object.Integer)
+			if leftOk && rightOk {
+				vm.sp -= 2
+				if op == code.OpJumpNotGreater {
+					condition = left.Value > right.Value
+				} else {
+					condition = left.Value == right.Value
+				}
+			} else {
+				var err error
+				if op == code.OpJumpNotGreater {
+					err = vm.executeComparison(code.OpGreaterThan)
+				} else {
+					err = vm.executeComparison(code.OpEqual)
+				}
+				if err != nil {
+					return err
+				}
+				condition = isTruthy(vm.pop())
+			}
+
+			if !condition {
+				vm.currentFrame().ip = pos - 1
+			}
+
+		case code.OpBang:
+			err := vm.executeBangOperator()
+			if err != nil {
+				return err
+			}
+
+		case code.OpMinus:
+			err := vm.executeMinusOperator()
+			if err != nil {
+				return err
+			}
+
+		case code.OpTrue:
+			err := vm.push(True)
+			if err != nil {
+				return err
+			}
+
+		case code.OpFalse:
+			err := vm.push(False)
+			if err != nil {
+				return err
+			}
+
+		case code.OpNull:
+			err := vm.push(Null)
+			if err != nil {
+				return err
+			}
+
+		case code.OpPop:
+			vm.pop()
+
+		case code.OpJump:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip = pos - 1
+
+		case code.OpJumpNotError:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			if _, ok := vm.stack[vm.sp-1].(*object.ErrorValue); !ok {
+				vm.currentFrame().ip = pos - 1
+			}
+
+		case code.OpJumpArgGiven:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			param := int(code.ReadUint8(ins[ip+3:]))
+			vm.currentFrame().ip += 3
+
+			if vm.currentFrame().numArgs > param {
+				vm.currentFrame().ip = pos - 1
+			}
+
+		case code.OpJumpNotTruthy:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			condition := vm.pop()
+			if !isTruthy(condition) {
+				vm.currentFrame().ip = pos - 1
+			}
+
+		case code.OpSetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			vm.globals[globalIndex] = vm.pop()
+
+		case code.OpGetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			err := vm.push(vm.globals[globalIndex])
+			if err != nil {
+				return err
+			}
+
+		case code.OpSetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+
+		case code.OpGetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			err := vm.push(vm.stack[frame.basePointer+int(localIndex)])
+			if err != nil {
+				return err
+			}
+
+		case code.OpGetBuiltin:
+			builtinIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			definition := builtins.Builtins[builtinIndex]
+			err := vm.push(definition.Builtin)
+			if err != nil {
+				return err
+			}
+
+		case code.OpGetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			currentClosure := vm.currentFrame().cl
+			err := vm.push(currentClosure.Free[freeIndex])
+			if err != nil {
+				return err
+			}
+
+		case code.OpCurrentClosure:
+			currentClosure := vm.currentFrame().cl
+			err := vm.push(currentClosure)
+			if err != nil {
+				return err
+			}
+
+		case code.OpArray:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			array := vm.buildArray(vm.sp-numElements, vm.sp)
+			vm.sp = vm.sp - numElements
+
+			err := vm.pushNew(array)
+			if err != nil {
+				return err
+			}
+
+		case code.OpHash:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
+			if err != nil {
+				return err
+			}
+			vm.sp = vm.sp - numElements
+
+			err = vm.pushNew(hash)
+			if err != nil {
+				return err
+			}
+
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+
+			err := vm.executeIndexExpression(left, index)
+			if err != nil {
+				return err
+			}
+
+		case code.OpSlice:
+			step := vm.pop()
+			end := vm.pop()
+			start := vm.pop()
 			left := vm.pop()
-			leftVal := left.(*object.Integer).Value
-			rightVal := right.(*object.Integer).Value
 
-			result := leftVal + rightVal
-			vm.push(&object.Integer{Value: result})
+			result, err := object.Slice(left, start, end, step)
+			if err != nil {
+				return err
+			}
+			err = vm.pushNew(result)
+			if err != nil {
+				return err
+			}
+
+		case code.OpCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			err := vm.executeCall(int(numArgs))
+			if err != nil {
+				return err
+			}
+
+		case code.OpCallSpread:
+			numArrays := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			err := vm.executeSpreadCall(int(numArrays))
+			if err != nil {
+				return err
+			}
+
+		case code.OpDestructureArray:
+			n := int(code.ReadUint8(ins[ip+1:]))
+			rest := code.ReadUint8(ins[ip+2:]) == 1
+			vm.currentFrame().ip += 2
+
+			err := vm.executeDestructureArray(n, rest)
+			if err != nil {
+				return err
+			}
+
+		case code.OpDestructureHash:
+			n := int(code.ReadUint8(ins[ip+1:]))
+			vm.currentFrame().ip += 1
+
+			err := vm.executeDestructureHash(n)
+			if err != nil {
+				return err
+			}
+
+		case code.OpMatchEqual:
+			literal := vm.pop()
+			value := vm.pop()
+			err := vm.push(nativeBoolToBooleanObject(object.Equals(value, literal)))
+			if err != nil {
+				return err
+			}
+
+		case code.OpMatchArray:
+			n := int(code.ReadUint8(ins[ip+1:]))
+			rest := code.ReadUint8(ins[ip+2:]) == 1
+			vm.currentFrame().ip += 2
+
+			arr, ok := vm.pop().(*object.Array)
+			matches := ok && (len(arr.Elements) == n || rest && len(arr.Elements) > n)
+			err := vm.push(nativeBoolToBooleanObject(matches))
+			if err != nil {
+				return err
+			}
+
+		case code.OpMatchHash:
+			n := int(code.ReadUint8(ins[ip+1:]))
+			vm.currentFrame().ip += 1
+
+			keys := vm.stack[vm.sp-n : vm.sp]
+			hash, ok := vm.stack[vm.sp-n-1].(*object.Hash)
+			for _, key := range keys {
+				str, isName := key.(*object.String)
+				if !isName {
+					return fmt.Errorf("hash pattern key must be STRING, got %s", key.Type())
+				}
+				if ok {
+					_, ok = hash.Pairs[str.HashKey()]
+				}
+			}
+			vm.sp -= n + 1
+			err := vm.push(nativeBoolToBooleanObject(ok))
+			if err != nil {
+				return err
+			}
+
+		case code.OpNoMatch:
+			return fmt.Errorf("no match arm matches %s", vm.pop().Inspect())
+
+		case code.OpImport:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			globalIndex := code.ReadUint16(ins[ip+3:])
+			constIndex := code.ReadUint16(ins[ip+5:])
+			vm.currentFrame().ip += 6
+
+			var err error
+			if module := vm.globals[globalIndex]; module != nil {
+				err = vm.push(module)
+				vm.currentFrame().ip = pos - 1
+			} else {
+				err = vm.pushClosure(int(constIndex), 0)
+			}
+			if err != nil {
+				return err
+			}
+
+		case code.OpModule:
+			nameIndex := code.ReadUint16(ins[ip+1:])
+			n := int(code.ReadUint16(ins[ip+3:]))
+			vm.currentFrame().ip += 4
+
+			err := vm.executeModule(vm.constants[nameIndex].(*object.String).Value, n)
+			if err != nil {
+				return err
+			}
+
+		case code.OpStruct:
+			nameIndex := code.ReadUint16(ins[ip+1:])
+			n := int(code.ReadUint16(ins[ip+3:]))
+			vm.currentFrame().ip += 4
+
+			err := vm.executeStruct(vm.constants[nameIndex].(*object.String).Value, n)
+			if err != nil {
+				return err
+			}
+
+		case code.OpEnum:
+			nameIndex := code.ReadUint16(ins[ip+1:])
+			n := int(code.ReadUint16(ins[ip+3:]))
+			vm.currentFrame().ip += 4
+
+			err := vm.executeEnum(vm.constants[nameIndex].(*object.String).Value, n)
+			if err != nil {
+				return err
+			}
+
+		case code.OpMethod:
+			nameIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			method := vm.pop()
+			structType, ok := vm.pop().(*object.StructType)
+			if !ok {
+				return fmt.Errorf("methods can only be added to structs")
+			}
+			structType.Methods[vm.constants[nameIndex].(*object.String).Value] = method
+
+		case code.OpGetMember:
+			nameIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			member, err := builtins.GetMember(vm.pop(), vm.constants[nameIndex].(*object.String).Value)
+			if err != nil {
+				return err
+			}
+			err = vm.push(member)
+			if err != nil {
+				return err
+			}
+
+		case code.OpSetMember:
+			nameIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			value := vm.pop()
+			err := object.SetMember(vm.pop(), vm.constants[nameIndex].(*object.String).Value, value)
+			if err != nil {
+				return err
+			}
+			err = vm.push(value)
+			if err != nil {
+				return err
+			}
+
+		case code.OpTailCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			err := vm.executeTailCall(int(numArgs))
+			if err != nil {
+				return err
+			}
+
+		case code.OpClosure:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			numFree := code.ReadUint8(ins[ip+3:])
+			vm.currentFrame().ip += 3
+
+			err := vm.pushClosure(int(constIndex), int(numFree))
+			if err != nil {
+				return err
+			}
+
+		case code.OpReturnValue:
+			//a return at the top level ends the program with its value,
+			//which is where the last popped element is looked up
+			if vm.framesIndex == 1 {
+				vm.pop()
+				return nil
+			}
+			returnValue := vm.pop()
+
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+
+			err := vm.push(returnValue)
+			if err != nil {
+				return err
+			}
+			if vm.framesIndex == returnDepth {
+				return nil
+			}
+
+		case code.OpReturn:
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+
+			err := vm.push(Null)
+			if err != nil {
+				return err
+			}
+			if vm.framesIndex == returnDepth {
+				return nil
+			}
+
+		case code.OpThrow:
+			//finally blocks throw again the error they were entered with
+			if errObj, ok := vm.pop().(*object.Error); ok {
+				return errObj
+			}
+			return object.NewThrown(vm.stack[vm.sp])
 		}
 	}
 	return nil
@@ -70,8 +675,661 @@ func (vm *VM) push(o object.Object) error {
 	return nil
 }
 
+// pushNew pushes a value the program has just created, counting it against
+// the limits of the run.
+func (vm *VM) pushNew(o object.Object) error {
+	if err := vm.meter.Track(o); err != nil {
+		return err
+	}
+	return vm.push(o)
+}
+
 func (vm *VM) pop() object.Object {
 	o := vm.stack[vm.sp-1]
 	vm.sp--
 	return o
 }
+
+func (vm *VM) executeBinaryOperation(op code.Opcode) error {
+	right := vm.pop()
+	left := vm.pop()
+
+	leftType := left.Type()
+	rightType := right.Type()
+
+	switch {
+	case leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ:
+		return vm.executeBinaryIntegerOperation(op, left, right)
+	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
+		return vm.executeBinaryStringOperation(op, left, right)
+	default:
+		return fmt.Errorf("unsupported types for binary operation: %s %s", leftType, rightType)
+	}
+}
+
+func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.Object) error {
+	leftValue := left.(*object.Integer).Value
+	rightValue := right.(*object.Integer).Value
+
+	var result int64
+
+	switch op {
+	case code.OpAdd:
+		result = leftValue + rightValue
+	case code.OpSub:
+		result = leftValue - rightValue
+	case code.OpMul:
+		result = leftValue * rightValue
+	case code.OpDiv:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		result = leftValue / rightValue
+	default:
+		return fmt.Errorf("unknown integer operator: %d", op)
+	}
+
+	return vm.push(object.NewInteger(result))
+}
+
+// genericOps maps the integer variants of opcodes back to the opcode that
+// handles any type.
+var genericOps = map[code.Opcode]code.Opcode{
+	code.OpAddInt:         code.OpAdd,
+	code.OpSubInt:         code.OpSub,
+	code.OpGreaterThanInt: code.OpGreaterThan,
+}
+
+// executeIntegerOperation takes a shortcut for the two integers the compiler
+// expects on top of the stack, and leaves anything else to the generic
+// operation, so that the result and the errors do not depend on the guess.
+func (vm *VM) executeIntegerOperation(op code.Opcode) error {
+	right, rightOk := vm.stack[vm.sp-1].(*object.Integer)
+	left, leftOk := vm.stack[vm.sp-2].(*object.Integer)
+	if !leftOk || !rightOk {
+		if op == code.OpGreaterThanInt {
+			return vm.executeComparison(genericOps[op])
+		}
+		return vm.executeBinaryOperation(genericOps[op])
+	}
+	vm.sp -= 2
+
+	switch op {
+	case code.OpAddInt:
+		return vm.push(object.NewInteger(left.Value + right.Value))
+	case code.OpSubInt:
+		return vm.push(object.NewInteger(left.Value - right.Value))
+	default:
+		return vm.push(nativeBoolToBooleanObject(left.Value > right.Value))
+	}
+}
+
+func (vm *VM) executeBinaryStringOperation(op code.Opcode, left, right object.Object) error {
+	if op != code.OpAdd {
+		return fmt.Errorf("unknown string operator: %d", op)
+	}
+
+	leftValue := left.(*object.String).Value
+	rightValue := right.(*object.String).Value
+
+	return vm.pushNew(&object.String{Value: leftValue + rightValue})
+}
+
+func (vm *VM) executeComparison(op code.Opcode) error {
+	right := vm.pop()
+	left := vm.pop()
+
+	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
+		return vm.executeIntegerComparison(op, left, right)
+	}
+	if left.Type() == right.Type() && (left.Type() == object.STRING_OBJ || left.Type() == object.ENUM_OBJ) {
+		return vm.executeValueComparison(op, left, right)
+	}
+
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(right == left))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(right != left))
+	default:
+		return fmt.Errorf("unknown operator: %d (%s %s)", op, left.Type(), right.Type())
+	}
+}
+
+// executeValueComparison compares strings and enum values by what they hold,
+// not by identity, so that equal values built apart compare equal.
+func (vm *VM) executeValueComparison(op code.Opcode, left, right object.Object) error {
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(object.Equals(left, right)))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(!object.Equals(left, right)))
+	default:
+		return fmt.Errorf("unknown operator: %d (%s %s)", op, left.Type(), right.Type())
+	}
+}
+
+func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object) error {
+	leftValue := left.(*object.Integer).Value
+	rightValue := right.(*object.Integer).Value
+
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(rightValue == leftValue))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(rightValue != leftValue))
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	default:
+		return fmt.Errorf("unknown operator: %d", op)
+	}
+}
+
+func (vm *VM) executeBangOperator() error {
+	operand := vm.pop()
+
+	switch operand {
+	case True:
+		return vm.push(False)
+	case False:
+		return vm.push(True)
+	case Null:
+		return vm.push(True)
+	default:
+		return vm.push(False)
+	}
+}
+
+func (vm *VM) executeMinusOperator() error {
+	operand := vm.pop()
+
+	if operand.Type() != object.INTEGER_OBJ {
+		return fmt.Errorf("unsupported type for negation: %s", operand.Type())
+	}
+
+	value := operand.(*object.Integer).Value
+	return vm.push(object.NewInteger(-value))
+}
+
+func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
+	elements := make([]object.Object, endIndex-startIndex)
+
+	for i := startIndex; i < endIndex; i++ {
+		elements[i-startIndex] = vm.stack[i]
+	}
+
+	return &object.Array{Elements: elements}
+}
+
+func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
+	hashedPairs := make(map[object.HashKey]object.HashPair)
+
+	for i := startIndex; i < endIndex; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+
+		pair := object.HashPair{Key: key, Value: value}
+
+		hashKey, ok := object.HashKeyOf(key)
+		if !ok {
+			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+		}
+
+		hashedPairs[hashKey] = pair
+	}
+
+	return &object.Hash{Pairs: hashedPairs}, nil
+}
+
+func (vm *VM) executeIndexExpression(left, index object.Object) error {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.executeArrayIndex(left, index)
+	case left.Type() == object.HASH_OBJ:
+		return vm.executeHashIndex(left, index)
+	case left.Type() == object.MODULE_OBJ:
+		value, err := left.(*object.Module).Get(index)
+		if err != nil {
+			return err
+		}
+		return vm.push(value)
+	default:
+		return fmt.Errorf("index operator not supported: %s", left.Type())
+	}
+}
+
+func (vm *VM) executeArrayIndex(array, index object.Object) error {
+	arrayObject := array.(*object.Array)
+	i := index.(*object.Integer).Value
+	max := int64(len(arrayObject.Elements) - 1)
+
+	if i < 0 || i > max {
+		return vm.push(Null)
+	}
+
+	return vm.push(arrayObject.Elements[i])
+}
+
+func (vm *VM) executeHashIndex(hash, index object.Object) error {
+	hashObject := hash.(*object.Hash)
+
+	key, ok := object.HashKeyOf(index)
+	if !ok {
+		return fmt.Errorf("unusable as hash key: %s", index.Type())
+	}
+
+	pair, ok := hashObject.Pairs[key]
+	if !ok {
+		return vm.push(Null)
+	}
+
+	return vm.push(pair.Value)
+}
+
+func (vm *VM) executeCall(numArgs int) error {
+	numArgs, err := vm.unbindMethod(numArgs)
+	if err != nil {
+		return err
+	}
+
+	callee := vm.stack[vm.sp-1-numArgs]
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+	case *object.BuiltIn:
+		return vm.callBuiltin(callee, numArgs)
+	case *object.StructType:
+		return vm.construct(callee, numArgs)
+	case *object.Variant:
+		return vm.constructVariant(callee, numArgs)
+	default:
+		return fmt.Errorf("calling non-function and non-built-in")
+	}
+}
+
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
+	if err := cl.Fn.Arity().Check(numArgs); err != nil {
+		return err
+	}
+
+	frame := NewFrame(cl, vm.sp-numArgs)
+	frame.numArgs = numArgs
+	err := vm.pushFrame(frame)
+	if err != nil {
+		return err
+	}
+
+	vm.sp = frame.basePointer + cl.Fn.NumLocals
+	if vm.sp >= StackSize {
+		return fmt.Errorf("stack overflow")
+	}
+
+	return vm.bindArguments(cl.Fn, frame.basePointer, numArgs)
+}
+
+// executeTailCall calls a closure in place of the running one: the callee
+// and its arguments are moved down over those of the current frame, which
+// is then reused. The caller of the current frame gets the callee's result
+// directly, and recursion in tail position never runs out of frames.
+func (vm *VM) executeTailCall(numArgs int) error {
+	numArgs, err := vm.unbindMethod(numArgs)
+	if err != nil {
+		return err
+	}
+
+	cl, ok := vm.stack[vm.sp-1-numArgs].(*object.Closure)
+	if !ok {
+		//builtins take no frame, the OpReturnValue after the call returns
+		//their result as usual
+		return vm.executeCall(numArgs)
+	}
+	if err := cl.Fn.Arity().Check(numArgs); err != nil {
+		return err
+	}
+
+	base := vm.currentFrame().basePointer
+	copy(vm.stack[base-1:], vm.stack[vm.sp-1-numArgs:vm.sp])
+	frame := NewFrame(cl, base)
+	frame.numArgs = numArgs
+	vm.frames[vm.framesIndex-1] = frame
+
+	vm.sp = base + cl.Fn.NumLocals
+	if vm.sp >= StackSize {
+		return fmt.Errorf("stack overflow")
+	}
+	return vm.bindArguments(cl.Fn, base, numArgs)
+}
+
+// unbindMethod replaces a bound method called with numArgs arguments by
+// its method, with the receiver inserted in front of the arguments, and
+// returns the number of arguments the method is called with.
+func (vm *VM) unbindMethod(numArgs int) (int, error) {
+	bound, ok := vm.stack[vm.sp-1-numArgs].(*object.BoundMethod)
+	if !ok {
+		return numArgs, nil
+	}
+	if err := bound.Arity().Check(numArgs); err != nil {
+		return 0, err
+	}
+	if vm.sp >= StackSize {
+		return 0, fmt.Errorf("stack overflow")
+	}
+
+	args := vm.stack[vm.sp-numArgs : vm.sp+1]
+	copy(args[1:], args)
+	args[0] = bound.Receiver
+	vm.stack[vm.sp-1-numArgs] = bound.Method
+	vm.sp++
+	return numArgs + 1, nil
+}
+
+// construct replaces a struct type and the numArgs arguments it is called
+// with by the instance they make.
+func (vm *VM) construct(structType *object.StructType, numArgs int) error {
+	args := make([]object.Object, numArgs)
+	copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+	vm.sp = vm.sp - numArgs - 1
+
+	instance, err := structType.New(args)
+	if err != nil {
+		return err
+	}
+	return vm.pushNew(instance)
+}
+
+// constructVariant replaces a variant and the numArgs arguments it is
+// called with by the enum value they make.
+func (vm *VM) constructVariant(variant *object.Variant, numArgs int) error {
+	args := make([]object.Object, numArgs)
+	copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+	vm.sp = vm.sp - numArgs - 1
+
+	value, err := variant.New(args)
+	if err != nil {
+		return err
+	}
+	return vm.pushNew(value)
+}
+
+// bindArguments readies the parameters of a function just called with
+// numArgs arguments from base on. Parameters without an argument are null
+// until the start of the function sets their default value, and arguments
+// past the other parameters are gathered into the rest parameter.
+func (vm *VM) bindArguments(fn *object.CompiledFunction, base, numArgs int) error {
+	for i := numArgs; i < fn.NumParameters; i++ {
+		vm.stack[base+i] = Null
+	}
+	if !fn.Variadic {
+		return nil
+	}
+
+	rest := []object.Object{}
+	if numArgs > fn.NumParameters {
+		rest = make([]object.Object, numArgs-fn.NumParameters)
+		copy(rest, vm.stack[base+fn.NumParameters:base+numArgs])
+	}
+	arr := &object.Array{Elements: rest}
+	if err := vm.meter.Track(arr); err != nil {
+		return err
+	}
+	vm.stack[base+fn.NumParameters] = arr
+	return nil
+}
+
+// executeSpreadCall calls the callee below the numArrays arrays on top of
+// the stack with their elements as arguments.
+func (vm *VM) executeSpreadCall(numArrays int) error {
+	arrays := make([]object.Object, numArrays)
+	copy(arrays, vm.stack[vm.sp-numArrays:vm.sp])
+	vm.sp -= numArrays
+
+	numArgs := 0
+	for _, a := range arrays {
+		arr, ok := a.(*object.Array)
+		if !ok {
+			return fmt.Errorf("spread argument must be an array, got %s", a.Type())
+		}
+		for _, el := range arr.Elements {
+			err := vm.push(el)
+			if err != nil {
+				return err
+			}
+		}
+		numArgs += len(arr.Elements)
+	}
+	return vm.executeCall(numArgs)
+}
+
+// executeDestructureArray replaces the array on top of the stack with its
+// n elements, the first on top, and the rest of it below them when rest is
+// set.
+func (vm *VM) executeDestructureArray(n int, rest bool) error {
+	values, err := object.DestructureArray(vm.pop(), n, rest)
+	if err != nil {
+		return err
+	}
+	if rest {
+		if err := vm.meter.Track(values[n]); err != nil {
+			return err
+		}
+	}
+	return vm.pushReversed(values)
+}
+
+// executeDestructureHash replaces the hash below the n keys on top of the
+// stack with their values, the value of the first key on top.
+func (vm *VM) executeDestructureHash(n int) error {
+	keys := make([]string, n)
+	for i := n - 1; i >= 0; i-- {
+		key, err := nameOf(vm.pop(), "hash pattern key")
+		if err != nil {
+			return err
+		}
+		keys[i] = key
+	}
+	values, err := object.DestructureHash(vm.pop(), keys)
+	if err != nil {
+		return err
+	}
+	return vm.pushReversed(values)
+}
+
+func (vm *VM) pushReversed(values []object.Object) error {
+	for i := len(values) - 1; i >= 0; i-- {
+		err := vm.push(values[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (vm *VM) callBuiltin(builtin *object.BuiltIn, numArgs int) error {
+	args := make([]object.Object, numArgs)
+	copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+
+	result := builtin.Fn(applier{vm}, args...)
+	vm.sp = vm.sp - numArgs - 1
+
+	//builtins report failures as error objects, the VM raises them so both
+	//engines stop on the same conditions
+	if errObj, ok := result.(*object.Error); ok {
+		return errObj
+	}
+
+	if result != nil {
+		return vm.pushNew(result)
+	}
+	return vm.push(Null)
+}
+
+// applier is the object.Applier the VM hands to builtins.
+type applier struct {
+	vm *VM
+}
+
+func (a applier) Apply(fn object.Object, args ...object.Object) object.Object {
+	return a.vm.applyFunction(fn, args...)
+}
+
+func (a applier) Meter() *object.Meter {
+	return a.vm.meter
+}
+
+// applyFunction runs a closure to completion on the same stack and returns
+// its result, or an *object.Error if the call fails.
+func (vm *VM) applyFunction(fn object.Object, args ...object.Object) object.Object {
+	switch fn := fn.(type) {
+	case *object.Closure:
+		depth := vm.framesIndex
+
+		err := vm.push(fn)
+		if err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+		for _, arg := range args {
+			err := vm.push(arg)
+			if err != nil {
+				return &object.Error{Message: err.Error()}
+			}
+		}
+
+		err = vm.callClosure(fn, len(args))
+		if err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+
+		err = vm.run(depth)
+		if errObj, ok := err.(*object.Error); ok {
+			return errObj
+		}
+		if err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+		return vm.pop()
+
+	case *object.BuiltIn:
+		return fn.Fn(applier{vm}, args...)
+
+	case *object.BoundMethod:
+		if err := fn.Arity().Check(len(args)); err != nil {
+			return err
+		}
+		return vm.applyFunction(fn.Method, append([]object.Object{fn.Receiver}, args...)...)
+
+	case *object.StructType:
+		instance, err := fn.New(args)
+		if err != nil {
+			return err
+		}
+		return instance
+
+	case *object.Variant:
+		value, err := fn.New(args)
+		if err != nil {
+			return err
+		}
+		return value
+
+	default:
+		return &object.Error{Message: fmt.Sprintf("not a function: %s", fn.Type())}
+	}
+}
+
+// executeStruct replaces the names of the n fields of the struct on top of
+// the stack with its type, which has no methods yet.
+func (vm *VM) executeStruct(name string, n int) error {
+	fields := make([]string, n)
+	for i := range fields {
+		field, err := nameOf(vm.stack[vm.sp-n+i], "struct field")
+		if err != nil {
+			return err
+		}
+		fields[i] = field
+	}
+	vm.sp -= n
+	return vm.push(&object.StructType{Name: name, Fields: fields, Methods: map[string]object.Object{}})
+}
+
+// executeEnum replaces the n variants of the enum on top of the stack with
+// its type.
+func (vm *VM) executeEnum(name string, n int) error {
+	variants := make([][]string, n)
+	for i := range variants {
+		array, ok := vm.stack[vm.sp-n+i].(*object.Array)
+		if !ok || len(array.Elements) == 0 {
+			return fmt.Errorf("enum variant must be a non-empty ARRAY of names")
+		}
+		names := make([]string, len(array.Elements))
+		for j, el := range array.Elements {
+			name, err := nameOf(el, "enum variant")
+			if err != nil {
+				return err
+			}
+			names[j] = name
+		}
+		variants[i] = names
+	}
+	vm.sp -= n
+	return vm.push(object.NewEnumType(name, variants))
+}
+
+// executeModule replaces the n names and values of the exports of the module
+// in file on top of the stack with the module.
+func (vm *VM) executeModule(file string, n int) error {
+	module := &object.Module{Name: file, Exports: make(map[string]object.Object, n)}
+	for i := vm.sp - 2*n; i < vm.sp; i += 2 {
+		name, err := nameOf(vm.stack[i], "export")
+		if err != nil {
+			return err
+		}
+		module.Exports[name] = vm.stack[i+1]
+	}
+	vm.sp -= 2 * n
+	return vm.push(module)
+}
+
+// nameOf returns the name obj holds. The compiler only ever puts strings
+// where names are expected, but bytecode read from a file may not.
+func nameOf(obj object.Object, what string) (string, error) {
+	str, ok := obj.(*object.String)
+	if !ok {
+		return "", fmt.Errorf("%s must be STRING, got %s", what, obj.Type())
+	}
+	return str.Value, nil
+}
+
+func (vm *VM) pushClosure(constIndex int, numFree int) error {
+	constant := vm.constants[constIndex]
+	function, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a function: %+v", constant)
+	}
+
+	free := make([]object.Object, numFree)
+	for i := 0; i < numFree; i++ {
+		free[i] = vm.stack[vm.sp-numFree+i]
+	}
+	vm.sp = vm.sp - numFree
+
+	closure := &object.Closure{Fn: function, Free: free}
+	return vm.pushNew(closure)
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return True
+	}
+	return False
+}
+
+func isTruthy(obj object.Object) bool {
+	switch obj := obj.(type) {
+	case *object.Boolean:
+		return obj.Value
+	case *object.Null:
+		return false
+	default:
+		return true
+	}
+}
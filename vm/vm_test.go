@@ -2,12 +2,21 @@ package vm
 
 import (
 	"Hulk/ast"
+	"Hulk/code"
 	"Hulk/compiler"
 	"Hulk/lexer"
+	"Hulk/loader"
 	"Hulk/object"
 	"Hulk/parser"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"reflect"
+	"strings"
 	"testing"
+	"testing/fstest"
 )
 
 func parse(input string) *ast.Program {
@@ -52,7 +61,7 @@ func runVmTest(t *testing.T, tests []vmTestCase) {
 			t.Fatalf("vm error: %s", err)
 		}
 
-		stackElm := vm.StackTop()
+		stackElm := vm.LastPoppedStackElem()
 
 		testExpectedObject(t, tt.expected, stackElm)
 	}
@@ -67,7 +76,69 @@ func testExpectedObject(t *testing.T, expected interface{}, actual object.Object
 		if err != nil {
 			t.Errorf("testIntegerObject failed: %s", err)
 		}
+
+	case string:
+		err := testStringObject(expected, actual)
+		if err != nil {
+			t.Errorf("testStringObject failed: %s", err)
+		}
+
+	case []int:
+		array, ok := actual.(*object.Array)
+		if !ok {
+			t.Errorf("object not Array: %T (%+v)", actual, actual)
+			return
+		}
+
+		if len(array.Elements) != len(expected) {
+			t.Errorf("wrong num of elements. want=%d, got=%d", len(expected), len(array.Elements))
+			return
+		}
+
+		for i, expectedElem := range expected {
+			err := testIntegerObject(int64(expectedElem), array.Elements[i])
+			if err != nil {
+				t.Errorf("testIntegerObject failed: %s", err)
+			}
+		}
+
+	case bool:
+		err := testBooleanObject(expected, actual)
+		if err != nil {
+			t.Errorf("testBooleanObject failed: %s", err)
+		}
+
+	case *object.Null:
+		if actual != Null {
+			t.Errorf("object is not Null: %T (%+v)", actual, actual)
+		}
+	}
+}
+
+func testBooleanObject(expected bool, actual object.Object) error {
+	result, ok := actual.(*object.Boolean)
+	if !ok {
+		return fmt.Errorf("object is not Boolean. got=%T (%+v)", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%t, want=%t", result.Value, expected)
 	}
+
+	return nil
+}
+
+func testStringObject(expected string, actual object.Object) error {
+	result, ok := actual.(*object.String)
+	if !ok {
+		return fmt.Errorf("object is not String. got=%T (%+v)", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%q, want=%q", result.Value, expected)
+	}
+
+	return nil
 }
 
 func TestIntegerArithmetic(t *testing.T) {
@@ -75,7 +146,1046 @@ func TestIntegerArithmetic(t *testing.T) {
 		{"1", 1},
 		{"2", 2},
 		{"1+2", 3},
+		{"-5", -5},
+		{"-5+10", 5},
+		{"5 * (2 + 10)", 60},
+		{"50 / 2 * 2 + 10 - 5", 55},
+	}
+
+	runVmTest(t, tests)
+}
+
+func TestStringExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{`"hulk"`, "hulk"},
+		{`"hu" + "lk"`, "hulk"},
+	}
+
+	runVmTest(t, tests)
+}
+
+func TestArrayLiterals(t *testing.T) {
+	tests := []vmTestCase{
+		{"[]", []int{}},
+		{"[1, 2, 3]", []int{1, 2, 3}},
+		{"[1 + 2, 3 + 4]", []int{3, 7}},
+	}
+
+	runVmTest(t, tests)
+}
+
+func TestIndexExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"[1, 2, 3][1]", 2},
+		{"[1, 2, 3][0 + 2]", 3},
+		{"[[1, 1, 1]][0][0]", 1},
+		{"[][0]", Null},
+		{"[1, 2, 3][99]", Null},
+		{"[1][-1]", Null},
+	}
+
+	runVmTest(t, tests)
+}
+
+func TestSliceExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"[1, 2, 3, 4, 5][1:3]", []int{2, 3}},
+		{"[1, 2, 3, 4, 5][:2]", []int{1, 2}},
+		{"[1, 2, 3, 4, 5][3:]", []int{4, 5}},
+		{"[1, 2, 3, 4, 5][-2:]", []int{4, 5}},
+		{"[1, 2, 3, 4, 5][::2]", []int{1, 3, 5}},
+		{"[1, 2, 3, 4, 5][::-1]", []int{5, 4, 3, 2, 1}},
+		{"[1, 2, 3][10:]", []int{}},
+		{`"hello"[1:3]`, "el"},
+		{`"hello"[:-1]`, "hell"},
+		{`"hello"[::-1]`, "olleh"},
+	}
+
+	runVmTest(t, tests)
+}
+
+func TestSliceErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"[1, 2, 3][::0]", "slice step cannot be zero"},
+		{`[1, 2, 3][:"a"]`, "slice end must be INTEGER, got STRING"},
+		{"5[1:2]", "slice operator not supported: INTEGER"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected vm error for %q, got none", tt.input)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong vm error. want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestBooleanExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"true", true},
+		{"1 < 2", true},
+		{"1 > 2", false},
+		{"1 == 1", true},
+		{"1 != 2", true},
+		{"true != false", true},
+		{"(1 < 2) == true", true},
+		{"!5", false},
+		{"!!true", true},
+		{"!(if (false) { 5; })", true},
+	}
+
+	runVmTest(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []vmTestCase{
+		{"if (true) { 10 }", 10},
+		{"if (1 < 2) { 10 } else { 20 }", 10},
+		{"if (1 > 2) { 10 } else { 20 }", 20},
+		{"if (1 > 2) { 10 }", Null},
+		{"if ((if (false) { 10 })) { 10 } else { 20 }", 20},
 	}
 
 	runVmTest(t, tests)
 }
+
+func TestGlobalLetStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"let one = 1; one", 1},
+		{"let one = 1; let two = one + one; one + two", 3},
+	}
+
+	runVmTest(t, tests)
+}
+
+func TestLetShadowing(t *testing.T) {
+	tests := []vmTestCase{
+		{"let f = fn(x) { let x = x + 1; x }; f(1)", 2},
+		{"let x = 1; let g = fn() { let x = x + 1; x }; g() + x", 3},
+		{"let x = 1; let x = x + 1; x", 2},
+		{"let f = fn(a) { let a = [a]; a }; f(1)", []int{1}},
+	}
+
+	runVmTest(t, tests)
+
+	//a let cannot read the name it is defining
+	for _, input := range []string{"let y = y;", "let f = fn() { let a = [a]; a }; f()"} {
+		_, err := runCompiled(input, false)
+		if err == nil || !strings.Contains(err.Error(), "undefined variable") {
+			t.Errorf("%s: expected an undefined variable error, got=%v", input, err)
+		}
+	}
+}
+
+func TestHashIndexExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{`{"one": 1, "two": 2}["two"]`, 2},
+		{"{1: 1}[0]", Null},
+		{"{}[0]", Null},
+	}
+
+	runVmTest(t, tests)
+}
+
+func TestCallingFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let fivePlusTen = fn() { 5 + 10; }; fivePlusTen();", 15},
+		{"let earlyExit = fn() { return 99; 100; }; earlyExit();", 99},
+		{"let noReturn = fn() { }; noReturn();", Null},
+		{"let sum = fn(a, b) { let c = a + b; c; }; sum(1, 2) + sum(3, 4);", 10},
+		{"let globalNum = 10; let sum = fn(a, b) { let c = a + b; c + globalNum; }; sum(1, 2);", 13},
+	}
+
+	runVmTest(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []vmTestCase{
+		{"let newAdder = fn(a) { fn(b) { a + b }; }; let addTwo = newAdder(2); addTwo(3);", 5},
+		{`
+		let fibonacci = fn(x) {
+			if (x < 2) { return x; }
+			fibonacci(x - 1) + fibonacci(x - 2);
+		};
+		fibonacci(15);`, 610},
+		{`
+		let wrapper = fn() {
+			let countDown = fn(x) { if (x == 0) { return 0; } else { countDown(x - 1); } };
+			countDown(1);
+		};
+		wrapper();`, 0},
+	}
+
+	runVmTest(t, tests)
+}
+
+func TestCollectionBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{"len([1, 2, 3])", 3},
+		{"map([1, 2, 3], fn(x) { x * 2 })", []int{2, 4, 6}},
+		{"let k = 10; map([1, 2], fn(x) { x + k })", []int{11, 12}},
+		{"filter([1, 2, 3, 4], fn(x) { x > 2 })", []int{3, 4}},
+		{"reduce([1, 2, 3, 4], fn(acc, x) { acc + x })", 10},
+		{"reduce([1, 2, 3], fn(acc, x) { acc * x }, 10)", 60},
+		{"sort([3, 1, 2])", []int{1, 2, 3}},
+		{"sort([3, 1, 2], fn(a, b) { a > b })", []int{3, 2, 1}},
+		{"reverse([1, 2, 3])", []int{3, 2, 1}},
+		{"contains([1, 2, 3], 2)", true},
+		{"index_of([1, 2, 3], 4)", -1},
+		{"len(zip([1, 2, 3], [4, 5]))", 2},
+		{"range(2, 10, 3)", []int{2, 5, 8}},
+		{"flatten([1, [2, [3]], []])", []int{1, 2, 3}},
+		{"unique([1, 2, 1, 3, 2])", []int{1, 2, 3}},
+		{"concat([1], [], [2, 3])", []int{1, 2, 3}},
+		{"map(map([1, 2], fn(x) { [x] }), first)", []int{1, 2}},
+		{"let sumAll = fn(arr) { reduce(map(arr, fn(x) { x * x }), fn(a, b) { a + b }, 0) }; sumAll(range(4))", 14},
+	}
+
+	runVmTest(t, tests)
+}
+
+func TestBuiltinErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"len(1)", "argument to len() not supported, got INTEGER"},
+		{"map([1], fn(a, b) { a })", "wrong number of arguments: want=2, got=1"},
+		{"map([1], fn(x) { x + true })", "unsupported types for binary operation: INTEGER BOOLEAN"},
+		{`sort([1, "a"])`, "cannot compare STRING and INTEGER, pass a comparator to sort"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected vm error for %q, got none", tt.input)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong vm error. want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestStringBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`join(map(split("a,b,c", ","), upper), "-")`, "A-B-C"},
+		{`trim("  hulk  ")`, "hulk"},
+		{`replace("a.b", ".", "/")`, "a/b"},
+		{`contains("smash", "ash")`, true},
+		{`index_of("smash", "h")`, 4},
+		{`repeat("ab", 2)`, "abab"},
+		{`len(chars("hulk"))`, 4},
+		{`format("%s=%d", "x", 1)`, "x=1"},
+		{`parse_int(to_string(12)) + 1`, 13},
+	}
+
+	runVmTest(t, tests)
+}
+
+func TestArrayBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{"push([1, 2], 3)", []int{1, 2, 3}},
+		{"rest([])", []int{}},
+		{"let a = [1, 2]; push(a, 3); a", []int{1, 2}},
+		{"let a = [1, 2]; push_mut(a, 3); a", []int{1, 2, 3}},
+		{"let a = [1, 2, 3]; remove_mut(a, 1); a", []int{1, 3}},
+		{"insert(pop([1, 2, 9]), 0, 0)", []int{0, 1, 2}},
+	}
+
+	runVmTest(t, tests)
+}
+
+func TestJSONBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`json_parse("[1, 2, 3]")`, []int{1, 2, 3}},
+		{`json_parse("{\"port\": 8080}")["port"]`, 8080},
+		{`json_stringify({"a": [1, true, "x"]})`, `{"a":[1,true,"x"]}`},
+	}
+
+	runVmTest(t, tests)
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []vmTestCase{
+		{`let sum = fn(arr, i, acc) { if (i == len(arr)) { acc } else { sum(arr, i + 1, acc + arr[i]) } };
+		sum(range(100000), 0, 0)`, 4999950000},
+		{`let count = fn(n) { if (n == 0) { return 0; } return count(n - 1); }; count(100000)`, 0},
+		{`let even = fn(n, odd) { if (n == 0) { true } else { odd(n - 1, even) } };
+		let odd = fn(n, even) { if (n == 0) { false } else { even(n - 1, odd) } };
+		even(100001, odd)`, false},
+		{`let down = fn(n) { if (n > 0) { return down(n - 1); } 7 }; down(100000)`, 7},
+		{`let wrap = fn(f) { fn(n) { f(n) } }; wrap(len)([1, 2])`, 2},
+		{`let loop = fn(n) { if (n == 0) { "done" } else { loop(n - 1) } }; map([100000], loop)[0]`, "done"},
+		{`let f = fn(a, b) { b }; let g = fn(x) { f(x, x + 1) }; g(1) + g(2)`, 5},
+	}
+
+	runVmTest(t, tests)
+}
+
+func TestStackTraces(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []object.StackFrame
+	}{
+		{"let inner = fn(x) {\n  x + true\n};\nlet outer = fn(x) {\n  let y = inner(x);\n  y\n};\nouter(1);",
+			[]object.StackFrame{{Function: "inner", Line: 2}, {Function: "outer", Line: 5}, {Function: object.MainFunction, Line: 8}}},
+		{"let f = fn(x) {\n  if (x > 0) {\n    -true\n  }\n};\nlet y = f(1);",
+			[]object.StackFrame{{Function: "f", Line: 3}, {Function: object.MainFunction, Line: 6}}},
+		//the anonymous callback is called by map, which has no frame of its own
+		{"map([1, 2],\n  fn(x) { len(x) });",
+			[]object.StackFrame{{Line: 2}, {Function: object.MainFunction, Line: 1}}},
+		//a tail call replaces the frame of its caller
+		{"let f = fn() { [1][true] };\nlet g = fn() { f() };\n1;\ng()",
+			[]object.StackFrame{{Function: "f", Line: 1}, {Function: object.MainFunction, Line: 4}}},
+		{"struct P {\n  x,\n  fn bad() {\n    len(self.x)\n  }\n}\nP(1).bad();",
+			[]object.StackFrame{{Function: "P.bad", Line: 4}, {Function: object.MainFunction, Line: 7}}},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		err = New(comp.Bytecode()).Run()
+		var errObj *object.Error
+		if !errors.As(err, &errObj) {
+			t.Errorf("%q: expected a runtime error, got=%v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(errObj.Stack, tt.expected) {
+			t.Errorf("%q: wrong stack.\nwant=%v\ngot=%v", tt.input, tt.expected, errObj.Stack)
+		}
+	}
+}
+
+func TestExceptions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`try { throw "oops" } catch (e) { [e["type"], e["message"], e["value"]] }`, "[Error, oops, oops]"},
+		{`try { throw {"type": "ValueError", "message": "bad", "code": 3} } catch (e) { [e["type"], e["message"], e["code"]] }`, "[ValueError, bad, 3]"},
+		{`try { [1][true] } catch (e) { e["type"] }`, "RuntimeError"},
+		{`try { len(1) } catch (e) { e["message"] }`, "argument to len() not supported, got INTEGER"},
+		{"let f = fn(x) { if (x > 1) { throw x } x };\nlet g = fn(x) { f(x) + 1 };\ntry { g(5) } catch (e) { e[\"stack\"] }", "[f (line 1), g (line 2)]"},
+		{`try { 1 } catch (e) { 2 }`, "1"},
+		{`try { } catch (e) { 2 }`, "null"},
+		{`try { 1 } finally { 2 }`, "1"},
+		{`1 + try { 2 + [1][true] } catch (e) { 10 }`, "11"},
+		{`[1, 2, try { throw 3 } catch (e) { e["value"] }, 4]`, "[1, 2, 3, 4]"},
+		{`let f = fn(a, b) { a + b }; f(1, try { throw 2 } catch (e) { e["value"] })`, "3"},
+		{`let f = fn(a) { let b = 2; a + b + try { throw 1 } catch (e) { b } }; f(1)`, "5"},
+		{`let f = fn() { try { return 1 } finally { 2 } }; f()`, "1"},
+		{`let f = fn() { try { return 1 } finally { return 2 } }; f()`, "2"},
+		{`let f = fn() { try { throw "a" } catch (e) { return 1 } finally { return 2 } }; f()`, "2"},
+		{`let f = fn() { try { try { return 1 } finally { 2 } } finally { return 3 } }; f()`, "3"},
+		{`try { try { throw "a" } finally { 1 } } catch (e) { e["message"] }`, "a"},
+		{`try { try { throw "a" } catch (e) { throw e["message"] + "b" } } catch (e) { e["message"] }`, "ab"},
+		{`try { try { throw "a" } finally { throw "b" } } catch (e) { e["message"] }`, "b"},
+		{`let f = fn(n) { if (n == 0) { throw "bottom" } 1 + f(n - 1) }; try { f(50) } catch (e) { len(e["stack"]) }`, "51"},
+		{`let f = fn(n) { try { if (n == 0) { throw n } f(n - 1) } catch (e) { e["value"] + 1 } }; f(3)`, "1"},
+		{`try { map([1, 2], fn(x) { throw x }) } catch (e) { e["value"] }`, "1"},
+		{`map([1, 2], fn(x) { try { throw x } catch (e) { e["value"] * 10 } })`, "[10, 20]"},
+		{`let e = 1; try { throw 2 } catch (e) { 3 }; e["value"]`, "2"},
+	}
+
+	for _, tt := range tests {
+		for _, optimize := range []bool{false, true} {
+			result, err := runCompiled(tt.input, optimize)
+			if err != nil {
+				t.Errorf("%q (optimize=%t): vm error: %s", tt.input, optimize, err)
+				continue
+			}
+			if result.Inspect() != tt.expected {
+				t.Errorf("%q (optimize=%t): want=%s, got=%s", tt.input, optimize, tt.expected, result.Inspect())
+			}
+		}
+	}
+
+	//uncaught errors keep their type and the stack from where they were thrown
+	_, err := runCompiled("let f = fn() {\n  throw {\"type\": \"ValueError\", \"message\": \"bad\"}\n};\ntry { f() } finally { 1 }", false)
+	var errObj *object.Error
+	if !errors.As(err, &errObj) {
+		t.Fatalf("expected a runtime error, got=%v", err)
+	}
+	want := "ValueError: bad\n\tat f (line 2)\n\tat <main> (line 4)"
+	if errObj.Traceback() != want {
+		t.Errorf("wrong traceback.\nwant=%q\ngot=%q", want, errObj.Traceback())
+	}
+}
+
+func TestErrorValues(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let e = error("bad"); [is_error(e), is_error(1), is_error("bad")]`, "[true, false, false]"},
+		{`error({"code": 404})`, "error({code: 404})"},
+		{`unwrap(5)`, "5"},
+		{`try { unwrap(error("bad")) } catch (e) { e["message"] }`, "unwrap of error(bad)"},
+		{`let f = fn(x) { x? + 1 }; [f(1), f(error("bad"))]`, "[2, error(bad)]"},
+		{`let f = fn(x) { let y = x?; y * 2 }; [f(2), f(error("bad"))]`, "[4, error(bad)]"},
+		{`let f = fn(a, b) { [a?, b?] }; [f(1, 2), f(error("a"), error("b")), f(1, error("b"))]`, "[[1, 2], error(a), error(b)]"},
+		{`let inner = fn(x) { if (x > 0) { x } else { error("negative") } };
+		let outer = fn(x) { inner(x)? * 10 };
+		[outer(2), outer(-2)]`, "[20, error(negative)]"},
+		{`let f = fn(x) { try { x? } finally { 0 }; 1 }; [f(2), f(error("bad"))]`, "[1, error(bad)]"},
+		{`let f = fn(x) { try { x? } catch (e) { 2 } }; f(error("bad"))`, "error(bad)"},
+		{`map([1, error("bad"), 3], fn(x) { x? * 2 })`, "[2, error(bad), 6]"},
+		{`let f = fn(x) { let y = if (x) { return 1 } else { 2 }; y + 10 }; [f(true), f(false)]`, "[1, 12]"},
+		{`let x = error("top")?; 5`, "error(top)"},
+	}
+
+	for _, tt := range tests {
+		for _, optimize := range []bool{false, true} {
+			result, err := runCompiled(tt.input, optimize)
+			if err != nil {
+				t.Errorf("%q (optimize=%t): vm error: %s", tt.input, optimize, err)
+				continue
+			}
+			if result.Inspect() != tt.expected {
+				t.Errorf("%q (optimize=%t): want=%s, got=%s", tt.input, optimize, tt.expected, result.Inspect())
+			}
+		}
+	}
+}
+
+func TestFunctionArguments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let f = fn(a, b) { a + b }; try { f(1) } catch (e) { e["message"] }`, "wrong number of arguments: want=2, got=1"},
+		{`let f = fn(a) { a }; try { f(1, 2) } catch (e) { e["message"] }`, "wrong number of arguments: want=1, got=2"},
+		{`let f = fn(a, b = 2) { a }; try { f() } catch (e) { e["message"] }`, "wrong number of arguments: want=1 to 2, got=0"},
+		{`let f = fn(a, ...rest) { a }; try { f() } catch (e) { e["message"] }`, "wrong number of arguments: want=at least 1, got=0"},
+		{`let f = fn(a, b = 10) { a + b }; [f(1), f(1, 2)]`, "[11, 3]"},
+		{`let f = fn(a, b = a * 2, c = a + b) { [a, b, c] }; [f(1), f(1, 5), f(1, 5, 0)]`, "[[1, 2, 3], [1, 5, 6], [1, 5, 0]]"},
+		{`let n = 5; let f = fn(a = n) { a }; f()`, "5"},
+		{`let y = 5; let f = fn(x = y, y = 1) { [x, y] }; [f(), f(2)]`, "[[5, 1], [2, 1]]"},
+		{`let a = 7; let f = fn(a = a) { a }; [f(), f(2)]`, "[7, 2]"},
+		{`let f = fn(a = error("none")?) { a }; [f(1), f()]`, "[1, error(none)]"},
+		{`let f = fn(a, ...rest) { [a, rest] }; [f(1), f(1, 2, 3)]`, "[[1, []], [1, [2, 3]]]"},
+		{`let f = fn(a, b = 2, ...rest) { [a, b, rest] }; [f(1), f(1, 3), f(1, 3, 4, 5)]`, "[[1, 2, []], [1, 3, []], [1, 3, [4, 5]]]"},
+		{`let f = fn(...xs) { len(xs) }; let xs = [1, 2, 3]; [f(...xs), f(0, ...xs, 4), f(...xs, ...xs), f(...[])]`, "[3, 5, 6, 0]"},
+		{`let add = fn(a, b) { a + b }; add(...[1, 2])`, "3"},
+		{`len(...[[1, 2, 3]])`, "3"},
+		{`let f = fn(a) { a }; try { f(...5) } catch (e) { e["message"] }`, "spread argument must be an array, got INTEGER"},
+		{`let sum = fn(acc, ...xs) { if (len(xs) == 0) { acc } else { sum(acc + xs[0], ...rest(xs)) } }; sum(0, 1, 2, 3, 4)`, "10"},
+		{`let count = fn(n, acc = 0) { if (n == 0) { acc } else { count(n - 1, acc + 1) } }; count(500)`, "500"},
+		{`let count = fn(n, ...xs) { if (n == 0) { len(xs) } else { count(n - 1, n, n) } }; count(500)`, "2"},
+	}
+
+	for _, tt := range tests {
+		for _, optimize := range []bool{false, true} {
+			result, err := runCompiled(tt.input, optimize)
+			if err != nil {
+				t.Errorf("%q (optimize=%t): vm error: %s", tt.input, optimize, err)
+				continue
+			}
+			if result.Inspect() != tt.expected {
+				t.Errorf("%q (optimize=%t): want=%s, got=%s", tt.input, optimize, tt.expected, result.Inspect())
+			}
+		}
+	}
+
+	//a default cannot read the parameters after it, which are not set yet
+	_, err := runCompiled("fn(x = y, y = 1) { x }()", false)
+	if err == nil || err.Error() != "undefined variable y" {
+		t.Errorf("wrong error for a default reading a later parameter. got=%v", err)
+	}
+}
+
+func TestDestructuring(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let [a, b] = [1, 2]; a + b`, "3"},
+		{`let [] = []; 1`, "1"},
+		{`let [first, ...rest] = [1, 2, 3]; [first, rest]`, "[1, [2, 3]]"},
+		{`let [x, ...xs] = [1]; xs`, "[]"},
+		{`let {name, age} = {"name": "hulk", "age": 30}; [name, age]`, "[hulk, 30]"},
+		{`let {name: n, tags: [t, ...ts]} = {"name": "a", "tags": [1, 2, 3]}; [n, t, ts]`, "[a, 1, [2, 3]]"},
+		{`let [[a, b], {c}] = [[1, 2], {"c": 3}]; a + b + c`, "6"},
+		{`let f = fn() { let [a, b] = [1, 2]; let {c} = {"c": a + b}; c * 10 }; f()`, "30"},
+		{`let swap = fn([a, b]) { [b, a] }; swap([1, 2])`, "[2, 1]"},
+		{`let f = fn({x, y}, [z] = [x + y]) { [x, y, z] }; [f({"x": 1, "y": 2}), f({"x": 1, "y": 2}, [0])]`, "[[1, 2, 3], [1, 2, 0]]"},
+		{`let f = fn(n, [a, ...more]) { if (len(more) == 0) { n + a } else { f(n + a, more) } }; f(0, [1, 2, 3, 4])`, "10"},
+		{`let adder = fn({by}) { fn(x) { x + by } }; adder({"by": 2})(3)`, "5"},
+		{`try { let [a, b] = [1]; a } catch (e) { e["message"] }`, "array pattern wants 2 elements, got 1"},
+		{`try { let [a, ...b] = []; a } catch (e) { e["message"] }`, "array pattern wants at least 1 elements, got 0"},
+		{`try { let [a] = 5; a } catch (e) { e["message"] }`, "cannot destructure INTEGER with an array pattern"},
+		{`try { let {a} = [1]; a } catch (e) { e["message"] }`, "cannot destructure ARRAY with a hash pattern"},
+		{`try { let {a} = {"b": 1}; a } catch (e) { e["message"] }`, `hash pattern key "a" not found`},
+		{`let f = fn([a]) { a }; try { f([1, 2]) } catch (e) { e["message"] }`, "array pattern wants 1 elements, got 2"},
+	}
+
+	for _, tt := range tests {
+		for _, optimize := range []bool{false, true} {
+			result, err := runCompiled(tt.input, optimize)
+			if err != nil {
+				t.Errorf("%q (optimize=%t): vm error: %s", tt.input, optimize, err)
+				continue
+			}
+			if result.Inspect() != tt.expected {
+				t.Errorf("%q (optimize=%t): want=%s, got=%s", tt.input, optimize, tt.expected, result.Inspect())
+			}
+		}
+	}
+}
+
+func TestMatchExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`match (2) { 1 => "one", 2 => "two", _ => "many" }`, "two"},
+		{`match (7) { 1 => "one", 2 => "two", _ => "many" }`, "many"},
+		{`match ("b") { "a" => 1, "b" => 2, _ => 3 }`, "2"},
+		{`match (-1) { -1 => "minus one", _ => "other" }`, "minus one"},
+		{`match (true) { false => 0, true => 1 }`, "1"},
+		{`match ("1") { 1 => "int", _ => "string" }`, "string"},
+		{`match (5) { n => n * 2 }`, "10"},
+		{`match ([1, 2, 3]) { [] => "empty", [x] => x, [x, ...rest] => rest }`, "[2, 3]"},
+		{`match ([]) { [] => "empty", [x, ...rest] => rest }`, "empty"},
+		{`match ([1, [2, 3]]) { [1, [a, b]] => a + b, _ => 0 }`, "5"},
+		{`match ([1, 2]) { [_, _, _, ..._] => "long", [_, _] => "pair", _ => "other" }`, "pair"},
+		{`match ([1, 2]) { [_, _, ..._] => "two or more", _ => "other" }`, "two or more"},
+		{`match ({"kind": "circle", "r": 2}) { {kind: "square", side} => side * side, {kind: "circle", r} => 3 * r * r }`, "12"},
+		{`match ({"a": 1}) { {b} => b, {a} => a }`, "1"},
+		{`match (5) { n if n > 10 => "big", n if n > 0 => "small", _ => "other" }`, "small"},
+		{`match ([3, 1]) { [a, b] if a < b => "up", [a, b] => "down" }`, "down"},
+		{`match (5) { "5" => 0, [a] => a, {a} => a, _ => 1 }`, "1"},
+		{`let size = fn(xs) { match (xs) { [] => 0, [_, ...rest] => 1 + size(rest) } }; size([1, 2, 3])`, "3"},
+		{`let count = fn(n, acc) { match (n) { 0 => acc, _ => count(n - 1, acc + 1) } }; count(2000, 0)`, "2000"},
+		{`let f = fn(x) { match (x) { [a] => match (a) { 0 => "zero", _ => "one" }, _ => "none" } }; [f([0]), f([1]), f(2)]`, "[zero, one, none]"},
+		{`let x = 1; let y = match (5) { x => x }; [x, y]`, "[5, 5]"},
+		{`let x = 10; let r = match (5) { x if x > 100 => 1, _ => x }; [r, x]`, "[10, 10]"},
+		{`let f = fn() { let y = 1; match (2) { y if false => 0, _ => y } }; f()`, "1"},
+		{`let x = 10; let r = match ([1]) { [x, y] => 1, _ => x }; [r, x]`, "[10, 10]"},
+		{`let x = 1; let r = match (5) { x if x > 1 => x, _ => 0 }; [r, x]`, "[5, 5]"},
+		{`let f = fn(x) { match (x) { 1 => error("early")?, _ => 0 }; "late" }; [f(1), f(2)]`, "[error(early), late]"},
+		{`try { match (3) { 1 => 1, 2 => 2 } } catch (e) { e["message"] }`, "no match arm matches 3"},
+		{`try { match ([1]) { [a] if a > 1 => a } } catch (e) { e["message"] }`, "no match arm matches [1]"},
+	}
+
+	for _, tt := range tests {
+		for _, optimize := range []bool{false, true} {
+			result, err := runCompiled(tt.input, optimize)
+			if err != nil {
+				t.Errorf("%q (optimize=%t): vm error: %s", tt.input, optimize, err)
+				continue
+			}
+			if result.Inspect() != tt.expected {
+				t.Errorf("%q (optimize=%t): want=%s, got=%s", tt.input, optimize, tt.expected, result.Inspect())
+			}
+		}
+	}
+}
+
+func TestStructs(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`struct Point { x, y }; Point(1, 2)`, "Point{x: 1, y: 2}"},
+		{`struct Point { x, y }; Point`, "<struct Point>"},
+		{`struct Empty {}; Empty()`, "Empty{}"},
+		{`struct Point { x, y }; let p = Point(1, 2); p.x + p.y`, "3"},
+		{`struct Pair { a, b }; Pair("x", [1, Pair(2, 3)])`, "Pair{a: x, b: [1, Pair{a: 2, b: 3}]}"},
+		{`struct Point { x, y }; let p = Point(1, 2); let q = p; p.x = 10; let old = q.x; p.x = 20; [old, q]`, "[10, Point{x: 20, y: 2}]"},
+		{`struct Point { x, y }; let p = Point(1, 2); let set = fn(v) { p.y = v }; set(5)`, "5"},
+		{`struct Point { x, y, fn norm() { self.x * self.x + self.y * self.y } }; Point(3, 4).norm()`, "25"},
+		{`struct Point { x, y, fn add(o) { Point(self.x + o.x, self.y + o.y) } }; Point(1, 2).add(Point(10, 20))`, "Point{x: 11, y: 22}"},
+		{`struct Counter { n, fn inc(by = 1) { self.n = self.n + by; self } }; let c = Counter(0); c.inc().inc(5).n`, "6"},
+		{`struct Box { v, fn get() { self.v } }; let get = Box(7).get; [get, get()]`, "[<method Box.get>, 7]"},
+		{`struct Box { v, fn get() { self.v } }; map([Box(1), Box(2)], fn(b) { b.get() })`, "[1, 2]"},
+		{`struct List { items, fn all(...more) { [self.items, more] } }; List(1).all(2, 3)`, "[1, [2, 3]]"},
+		{`let make = fn(v) { struct Node { v, fn double() { Node(self.v * 2) } }; Node(v).double() }; make(4)`, "Node{v: 8}"},
+		{`struct Node { v, next, fn sum() { match (self.next) { false => self.v, n => self.v + n.sum() } } }; Node(1, Node(2, Node(3, false))).sum()`, "6"},
+		{`struct C { n, fn down() { if (self.n == 0) { "done" } else { self.n = self.n - 1; self.down() } } }; C(2000).down()`, "done"},
+		{`try { struct P { x }; P(1).y } catch (e) { e["message"] }`, "P has no field or method y"},
+		{`try { struct P { x }; let p = P(1); p.y = 2 } catch (e) { e["message"] }`, "P has no field y"},
+		{`try { struct P { x, fn f() { 1 } }; let p = P(1); p.f = 2 } catch (e) { e["message"] }`, "cannot assign to method f of P"},
+		{`try { struct P { x, y }; P(1) } catch (e) { e["message"] }`, "wrong number of arguments: want=2, got=1"},
+		{`try { struct P { x, fn f(a) { a } }; P(1).f() } catch (e) { e["message"] }`, "wrong number of arguments: want=1, got=0"},
+		{`try { 5.x } catch (e) { e["message"] }`, "INTEGER has no method x"},
+		{`try { let h = {}; h.x = 1 } catch (e) { e["message"] }`, "cannot assign x of HASH, only structs have fields"},
+	}
+
+	for _, tt := range tests {
+		for _, optimize := range []bool{false, true} {
+			result, err := runCompiled(tt.input, optimize)
+			if err != nil {
+				t.Errorf("%q (optimize=%t): vm error: %s", tt.input, optimize, err)
+				continue
+			}
+			if result.Inspect() != tt.expected {
+				t.Errorf("%q (optimize=%t): want=%s, got=%s", tt.input, optimize, tt.expected, result.Inspect())
+			}
+		}
+	}
+}
+
+func TestMethods(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"abc".upper()`, "ABC"},
+		{`"a,b,c".split(",").reverse().join("-")`, "c-b-a"},
+		{`[3, 1, 2].map(fn(x) { x * 2 }).sort()`, "[2, 4, 6]"},
+		{`[1, 2, 3, 4].filter(fn(x) { x > 2 }).reduce(fn(a, b) { a + b }, 0)`, "7"},
+		{`let s = "hulk"; [s.len(), [1, 2].len(), {"a": 1}.len()]`, "[4, 2, 1]"},
+		{`5.to_string().repeat(2)`, "55"},
+		{`"%d smash".format(3)`, "3 smash"},
+		{`let h = {"b": 2, "a": 1, "c": 3}; [h.keys(), h.values()]`, "[[a, b, c], [1, 2, 3]]"},
+		{`let h = {"name": "hulk"}; [h.name, h.has("name"), h.has("age")]`, "[hulk, true, false]"},
+		{`let h = {"keys": 1}; h.keys`, "1"},
+		{`{"a": 1}.missing`, "null"},
+		{`let arr = [1]; arr.push_mut(2); arr`, "[1, 2]"},
+		{`let upper = "abc".upper; upper()`, "ABC"},
+		{`"abc".upper`, "<method STRING.upper>"},
+		{`map(["a", "b"], fn(s) { s.upper() })`, "[A, B]"},
+		{`try { "abc".upper(1) } catch (e) { e["message"] }`, "wrong number of argument. got=1 want=0"},
+		{`try { [1].sort(1, 2) } catch (e) { e["message"] }`, "wrong number of argument. got=2 want=0 or 1"},
+		{`try { [1].join(1) } catch (e) { e["message"] }`, "expected a string for function JOIN, got=INTEGER"},
+		{`try { "abc".nope() } catch (e) { e["message"] }`, "STRING has no method nope"},
+		{`try { true.upper() } catch (e) { e["message"] }`, "BOOLEAN has no method upper"},
+	}
+
+	for _, tt := range tests {
+		for _, optimize := range []bool{false, true} {
+			result, err := runCompiled(tt.input, optimize)
+			if err != nil {
+				t.Errorf("%q (optimize=%t): vm error: %s", tt.input, optimize, err)
+				continue
+			}
+			if result.Inspect() != tt.expected {
+				t.Errorf("%q (optimize=%t): want=%s, got=%s", tt.input, optimize, tt.expected, result.Inspect())
+			}
+		}
+	}
+}
+
+func TestEnums(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`enum Shape { Circle(r), Rect(w, h), Empty }; Shape`, "<enum Shape>"},
+		{`enum Shape { Circle(r), Rect(w, h), Empty }; [Shape.Circle, Shape.Empty, Shape.Rect(2, 3)]`, "[<variant Shape.Circle>, Shape.Empty, Shape.Rect(2, 3)]"},
+		{`enum Shape { Circle(r), Rect(w, h) }; let s = Shape.Rect(2, 3); s.w * s.h`, "6"},
+		{`enum Shape { Circle(r), Empty }; [tag_of(Shape.Circle(1)), Shape.Empty.tag_of()]`, "[Circle, Empty]"},
+		{`enum Shape { Circle(r), Empty }; [Shape.Circle(1) == Shape.Circle(1), Shape.Circle(1) == Shape.Circle(2), Shape.Empty == Shape.Empty, Shape.Empty != Shape.Circle(1)]`, "[true, false, true, true]"},
+		{`enum A { X }; enum B { X }; A.X == B.X`, "false"},
+		{`enum Opt { Some(value), None }; let h = {Opt.Some(1): "one", Opt.None: "none"}; [h[Opt.Some(1)], h[Opt.None], h[Opt.Some(2)]]`, "[one, none, null]"},
+		{`try { enum E { A(x) }; {E.A([1]): 1} } catch (e) { e["message"] }`, "unusable as hash key: ENUM"},
+		{`let mk = fn() { enum E { A }; E.A }; let h = {mk(): 1}; [h[mk()], mk() == mk()]`, "[null, false]"},
+		{`enum Opt { Some(value), None }; let s = Opt.Some; map([1, 2], s)`, "[Opt.Some(1), Opt.Some(2)]"},
+		{`enum Shape { Circle(r), Rect(w, h), Empty };
+		  let area = fn(s) { match (tag_of(s)) { "Circle" => 3 * s.r * s.r, "Rect" => s.w * s.h, _ => 0 } };
+		  map([Shape.Circle(2), Shape.Rect(2, 3), Shape.Empty], area)`, "[12, 6, 0]"},
+		{`let f = fn() { enum Dir { Up, Down }; Dir.Down }; f()`, "Dir.Down"},
+		{`try { enum E { A }; E.B } catch (e) { e["message"] }`, "enum E has no variant B"},
+		{`try { enum E { A(x) }; E.A(1).y } catch (e) { e["message"] }`, "E.A has no field or method y"},
+		{`try { enum E { A(x) }; E.A() } catch (e) { e["message"] }`, "wrong number of arguments: want=1, got=0"},
+		{`try { tag_of(1) } catch (e) { e["message"] }`, "expected an enum value for function TAG_OF, got=INTEGER"},
+	}
+
+	for _, tt := range tests {
+		for _, optimize := range []bool{false, true} {
+			result, err := runCompiled(tt.input, optimize)
+			if err != nil {
+				t.Errorf("%q (optimize=%t): vm error: %s", tt.input, optimize, err)
+				continue
+			}
+			if result.Inspect() != tt.expected {
+				t.Errorf("%q (optimize=%t): want=%s, got=%s", tt.input, optimize, tt.expected, result.Inspect())
+			}
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	loop := `let loop = fn(n) { loop(n + 1) }; loop(0)`
+	deep := `let deep = fn(n) { 1 + deep(n + 1) }; deep(0)`
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		input  string
+		ctx    context.Context
+		limits object.Limits
+		limit  string
+	}{
+		{loop, context.Background(), object.Limits{MaxSteps: 10000}, "steps"},
+		{loop, cancelled, object.Limits{}, "context"},
+		{deep, context.Background(), object.Limits{MaxCallDepth: 100}, "call depth"},
+		{`map(range(100), fn(x) { [x] })`, context.Background(), object.Limits{MaxAllocations: 50}, "allocations"},
+		{`let s = "ab"; s + s + s`, context.Background(), object.Limits{MaxStringLength: 4}, "string length"},
+		{`repeat("ab", 1000000000000)`, context.Background(), object.Limits{MaxStringLength: 1000}, "string length"},
+		{`range(1000000000000)`, context.Background(), object.Limits{MaxArrayLength: 1000}, "array length"},
+		{`[1, 2, 3][0:2]`, context.Background(), object.Limits{MaxArrayLength: 1}, "array length"},
+		{`try { ` + loop + ` } catch (e) { 0 }`, context.Background(), object.Limits{MaxSteps: 10000}, "steps"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		err = New(comp.Bytecode()).RunContext(tt.ctx, tt.limits)
+
+		var limitErr *object.LimitError
+		if !errors.As(err, &limitErr) {
+			t.Errorf("%q: expected a limit error, got=%v", tt.input, err)
+			continue
+		}
+		if limitErr.Limit != tt.limit {
+			t.Errorf("%q: wrong limit. want=%q, got=%q", tt.input, tt.limit, limitErr.Limit)
+		}
+	}
+
+	//tail calls do not count against the call depth, and programs within
+	//their limits run as usual
+	comp := compiler.New()
+	err := comp.Compile(parse(`let f = fn(n) { if (n == 0) { 5 } else { f(n - 1) } }; f(1000)`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	err = vm.RunContext(context.Background(), object.Limits{MaxCallDepth: 10, MaxSteps: 100000})
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 5, vm.LastPoppedStackElem())
+}
+
+// testModules are the files the programs of TestImports can import.
+var testModules = fstest.MapFS{
+	"lib/math.hk":    {Data: []byte(`import "./helpers.hk" as h; export let square = fn(x) { h["times"](x, x) }; export let [one, two] = [1, 2]; let hidden = 3;`)},
+	"lib/helpers.hk": {Data: []byte(`export let times = fn(a, b) { a * b };`)},
+	"lib/x.hk":       {Data: []byte(`let x = 2; export let get = fn() { x };`)},
+	"lib/broken.hk":  {Data: []byte(`export let x = 1; let y = len(x);`)},
+	"vendor/pkg.hk":  {Data: []byte(`export let name = "vendored";`)},
+	"lib/shapes.hk":  {Data: []byte(`export struct Square { side, fn area() { self.side * self.side } }; export enum Kind { Flat, Solid(faces) }`)},
+}
+
+func compileImporting(input string, optimize bool) (*compiler.Bytecode, error) {
+	modules := loader.New(".", "vendor")
+	modules.ReadFile = func(name string) ([]byte, error) { return fs.ReadFile(testModules, name) }
+
+	comp := compiler.New()
+	if optimize {
+		comp.EnableOptimizations()
+	}
+	comp.EnableImports(modules, "")
+	err := comp.Compile(parse(input))
+	if err != nil {
+		return nil, err
+	}
+	return comp.Bytecode(), nil
+}
+
+func TestImports(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`import "lib/math.hk" as m; m["square"](4) + m["two"]`, "18"},
+		{`import "lib/math.hk" as m; m`, "<module lib/math.hk>"},
+		{`import "lib/math.hk" as m; m.square(m.two)`, "4"},
+		{`import "lib/math.hk" as a; import "./lib/math.hk" as b; import "lib/helpers.hk" as h; [a == b, h == a]`, "[true, false]"},
+		{`let f = fn() { import "lib/helpers.hk" as h; h["times"](3, 3) }; f() + f()`, "18"},
+		{`import "pkg.hk" as p; p["name"]`, "vendored"},
+		{`import "lib/shapes.hk" as s; let sq = s["Square"](3); [sq, sq.area()]`, "[Square{side: 3}, 9]"},
+		{`import "lib/shapes.hk" as s; [s.Kind.Flat, s.Kind.Solid(6)]`, "[Kind.Flat, Kind.Solid(6)]"},
+		{`let x = 1; import "lib/x.hk" as m; [x, m["get"]()]`, "[1, 2]"},
+		{`import "lib/math.hk" as m; try { m["hidden"] } catch (e) { e["message"] }`, "module lib/math.hk does not export hidden"},
+		{`import "lib/math.hk" as m; try { m[1] } catch (e) { e["message"] }`, "module exports are looked up by name, got INTEGER"},
+		{`import "lib/math.hk" as m; try { m.hidden } catch (e) { e["message"] }`, "module lib/math.hk does not export hidden"},
+		{`try { import "lib/broken.hk" as b; b } catch (e) { e["message"] }`, "argument to len() not supported, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		for _, optimize := range []bool{false, true} {
+			bytecode, err := compileImporting(tt.input, optimize)
+			if err != nil {
+				t.Errorf("%q (optimize=%t): compiler error: %s", tt.input, optimize, err)
+				continue
+			}
+
+			//the modules are part of the bytecode, a decoded program does
+			//not need their files
+			var buf bytes.Buffer
+			err = bytecode.Encode(&buf)
+			if err != nil {
+				t.Fatalf("encode error: %s", err)
+			}
+			decoded, err := compiler.Decode(&buf)
+			if err != nil {
+				t.Fatalf("decode error: %s", err)
+			}
+
+			for _, bytecode := range []*compiler.Bytecode{bytecode, decoded} {
+				vm := New(bytecode)
+				err = vm.Run()
+				if err != nil {
+					t.Errorf("%q (optimize=%t): vm error: %s", tt.input, optimize, err)
+					continue
+				}
+				if got := vm.LastPoppedStackElem().Inspect(); got != tt.expected {
+					t.Errorf("%q (optimize=%t): want=%s, got=%s", tt.input, optimize, tt.expected, got)
+				}
+			}
+		}
+	}
+
+	//the top level of a module shows up in stack traces
+	bytecode, err := compileImporting("let a = 1;\nimport \"lib/broken.hk\" as b;", false)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	err = New(bytecode).Run()
+	var errObj *object.Error
+	if !errors.As(err, &errObj) {
+		t.Fatalf("expected a runtime error, got=%v", err)
+	}
+	want := "argument to len() not supported, got INTEGER\n\tat <module lib/broken.hk> (line 1)\n\tat <main> (line 2)"
+	if errObj.Traceback() != want {
+		t.Errorf("wrong traceback.\nwant=%q\ngot=%q", want, errObj.Traceback())
+	}
+}
+
+func TestRunDecodedBytecode(t *testing.T) {
+	tests := []vmTestCase{
+		{`let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(15)`, 610},
+		{`let wrap = fn(s) { fn(t) { s + t } }; wrap("by")("tes")`, "bytes"},
+		{`map([1, 2, 3], fn(x) { x * 2 })`, []int{2, 4, 6}},
+		{`let f = fn() { try { [1][true] } catch (e) { 5 } }; try { f() } finally { 1 }`, 5},
+		{`let f = fn(a, b = 2, ...rest) { a + b + len(rest) }; f(1) + f(...[1, 1, 1, 1])`, 7},
+		{`let f = fn({x}, [y, ...z]) { x + y + len(z) }; f({"x": 1}, [2, 3, 4])`, 5},
+		{`let f = fn(v) { match (v) { [1, x] => x, {k: "a"} => 2, _ => 3 } }; f([1, 4]) + f({"k": "a"}) + f(0)`, 9},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		var buf bytes.Buffer
+		err = comp.Bytecode().Encode(&buf)
+		if err != nil {
+			t.Fatalf("encode error: %s", err)
+		}
+		bytecode, err := compiler.Decode(&buf)
+		if err != nil {
+			t.Fatalf("decode error: %s", err)
+		}
+
+		vm := New(bytecode)
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+	}
+}
+
+// TestRunCraftedBytecode runs files that pass verification but hold values
+// of the wrong type where the compiler would have put names or a struct.
+func TestRunCraftedBytecode(t *testing.T) {
+	concat := func(ins ...[]byte) code.Instructions {
+		out := code.Instructions{}
+		for _, i := range ins {
+			out = append(out, i...)
+		}
+		return out
+	}
+	constants := []object.Object{object.NewInteger(1), &object.String{Value: "m"}}
+
+	tests := []struct {
+		instructions code.Instructions
+		expected     string
+	}{
+		{concat(code.Make(code.OpConstant, 0), code.Make(code.OpConstant, 0), code.Make(code.OpMethod, 1)),
+			"methods can only be added to structs"},
+		{concat(code.Make(code.OpHash, 0), code.Make(code.OpConstant, 0), code.Make(code.OpDestructureHash, 1), code.Make(code.OpPop)),
+			"hash pattern key must be STRING, got INTEGER"},
+		{concat(code.Make(code.OpHash, 0), code.Make(code.OpConstant, 0), code.Make(code.OpMatchHash, 1), code.Make(code.OpPop)),
+			"hash pattern key must be STRING, got INTEGER"},
+		{concat(code.Make(code.OpConstant, 0), code.Make(code.OpStruct, 1, 1), code.Make(code.OpPop)),
+			"struct field must be STRING, got INTEGER"},
+		{concat(code.Make(code.OpConstant, 0), code.Make(code.OpEnum, 1, 1), code.Make(code.OpPop)),
+			"enum variant must be a non-empty ARRAY of names"},
+		{concat(code.Make(code.OpArray, 0), code.Make(code.OpEnum, 1, 1), code.Make(code.OpPop)),
+			"enum variant must be a non-empty ARRAY of names"},
+		{concat(code.Make(code.OpConstant, 0), code.Make(code.OpConstant, 0), code.Make(code.OpModule, 1, 1), code.Make(code.OpPop)),
+			"export must be STRING, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		crafted := &compiler.Bytecode{Instructions: tt.instructions, Constants: constants}
+		err := crafted.Encode(&buf)
+		if err != nil {
+			t.Fatalf("encode error: %s", err)
+		}
+		bytecode, err := compiler.Decode(&buf)
+		if err != nil {
+			t.Fatalf("%s: decode error: %s", tt.expected, err)
+		}
+
+		err = New(bytecode).Run()
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong vm error. want=%q, got=%v", tt.expected, err)
+		}
+	}
+}
+
+func runCompiled(input string, optimize bool) (object.Object, error) {
+	comp := compiler.New()
+	if optimize {
+		comp.EnableOptimizations()
+	}
+	err := comp.Compile(parse(input))
+	if err != nil {
+		return nil, err
+	}
+
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	if err != nil {
+		return nil, err
+	}
+	return vm.LastPoppedStackElem(), nil
+}
+
+func TestOptimizedMatchesUnoptimized(t *testing.T) {
+	inputs := []string{
+		"1 + 2 * 3 - 4 / 2",
+		"-(1 - 10) * 2",
+		`"foo" + "bar" + "baz"`,
+		`"a" == "a"`,
+		`"a" != "a"`,
+		"!5",
+		"!!true == true",
+		"1 < 2 == 2 > 1",
+		"true != false",
+		"1; 2; 3",
+		"let a = 5; a; a * (2 + 3)",
+		"if (1 > 2) { 10 } else { 20 }",
+		"if (1 < 2) { 10 }",
+		"if (false) { 10 }",
+		"if (true) { if (false) { 1 } } else { 2 }",
+		"let f = fn(x) { if (x > 0) { return x * 2; x; 99 } 0 }; [f(3), f(-3)]",
+		"let f = fn() { return 1; return 2; }; f()",
+		"let f = fn() { }; f()",
+		"let fib = fn(n) { if (n < 2) { return n; } fib(n - 1) + fib(n - 2) }; fib(12)",
+		`let adder = fn(a) { fn(b) { a + b + (1 + 1) } }; adder(2)(3)`,
+		`map([1, 2, 3], fn(x) { x * (1 + 1) })`,
+		`{"a" + "b": 1 + 1}["ab"]`,
+		`[1, 2, 3][1 + 1]`,
+		"1 / 0",
+		`"a" - "b"`,
+		"-true",
+		"true > false",
+		`let s = "a"; s == s`,
+		`let s = "a"; [s + "b" == "ab", "ab" != s + "b", s == "a" + ""]`,
+		"let a = 2; let b = a * 3; b + a",
+		"let a = 1; let f = fn(a) { a * 10 }; f(5) + a",
+		"let add = fn(x, y) { x + y }; let z = 4; add(z, 3)",
+		"let sq = fn(x) { x * x }; sq(sq(3))",
+		"let pick = fn(x, y) { [y, x][0] }; pick(1, 2)",
+		"if (true) { let x = 3; x * 2 }",
+		"let x = if (true) { 1 } else { 2 }; x",
+		"if (false) { 10 }; 5",
+		"let f = fn() { let a = 1; return a + 1; 3 }; f()",
+		"let g = fn(x) { if (true) { return x; } 0 }; g(9)",
+		"let a = 3; map([1, 2], fn(x) { x * a })",
+		`let add = fn(a, b) { a + b }; [add(1, 2), add("x", "y")]`,
+		`let inc = fn(a) { a + 1 }; inc("s")`,
+		`let dec = fn(a) { a - 1 }; dec(true)`,
+		"let big = fn(a) { if (a > 1) { 1 } else { 2 } }; [big(5), big(0)]",
+		"let big = fn(a) { if (a > 1) { 1 } else { 2 } }; big(true)",
+		`let same = fn(a, b) { if (a == b) { 1 } else { 2 } }; [same(1, 1), same(1, 2), same(true, true), same("a", "b")]`,
+		"let sum = fn(a, b) { a + b }; sum(1000, 24) + sum(-200, 1)",
+		`let half = fn(x) { if (x > 1) { x / 2 } else { error("too small") } }; let f = fn(x) { half(x)? + 1 }; [f(8), f(1)]`,
+		`let f = fn(x) { try { x? } catch (e) { 1 } finally { 2 } }; [f(3), f(error(4))]`,
+	}
+
+	for _, input := range inputs {
+		want, wantErr := runCompiled(input, false)
+		got, gotErr := runCompiled(input, true)
+
+		if wantErr != nil || gotErr != nil {
+			if wantErr == nil || gotErr == nil || wantErr.Error() != gotErr.Error() {
+				t.Errorf("%s: errors differ. unoptimized=%v, optimized=%v", input, wantErr, gotErr)
+			}
+			continue
+		}
+		if got.Inspect() != want.Inspect() {
+			t.Errorf("%s: results differ. unoptimized=%s, optimized=%s", input, want.Inspect(), got.Inspect())
+		}
+	}
+}
+
+func TestOpcodePairProfile(t *testing.T) {
+	comp := compiler.New()
+	err := comp.Compile(parse("let f = fn(x) { x + 1 }; f(1); f(2)"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	vm.EnableProfiling()
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	pairs := vm.OpcodePairs()
+	if len(pairs) == 0 {
+		t.Fatalf("no opcode pairs recorded")
+	}
+	top := pairs[0]
+	//every pair around the calls of f runs twice, ties go by opcode
+	if top.Count != 2 || top.First != code.OpConstant || top.Second != code.OpAdd {
+		t.Errorf("wrong most frequent pair. got=%+v", top)
+	}
+
+	var out bytes.Buffer
+	vm.WriteProfile(&out, 1)
+	expected := "     count  opcode pair\n         2  OpConstant OpAdd\n"
+	if out.String() != expected {
+		t.Errorf("wrong profile output. want=%q, got=%q", expected, out.String())
+	}
+}